@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the audit log has not been tampered with",
+	Long: `Check the hash chain of the audit log file (including rotated files) and
+the audit database. Every entry contains the digest of the entry before it, so
+modified, removed, inserted or reordered entries are detected.
+
+Exits with a non-zero status if any problem is found.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		auditFile, _ := cmd.Flags().GetString("file")
+		auditDB, _ := cmd.Flags().GetString("db")
+
+		var err error
+		if !cmd.Flags().Changed("file") {
+			if auditFile, err = defaultDataPath("audit.jsonl"); err != nil {
+				return err
+			}
+		}
+		if !cmd.Flags().Changed("db") {
+			if auditDB, err = defaultDataPath("audit.db"); err != nil {
+				return err
+			}
+		}
+
+		checked := 0
+		failed := false
+
+		if auditFile != "" {
+			files, err := audit.RotatedFiles(auditFile)
+			if err != nil {
+				return fmt.Errorf("failed to list audit files: %w", err)
+			}
+			if len(files) > 0 {
+				checked++
+				result, err := audit.VerifyFiles(files...)
+				if !reportVerify(auditFile, result, err) {
+					failed = true
+				}
+			}
+		}
+
+		if auditDB != "" {
+			if _, err := os.Stat(auditDB); err == nil {
+				checked++
+				result, err := audit.VerifyDatabase(context.Background(), auditDB)
+				if !reportVerify(auditDB, result, err) {
+					failed = true
+				}
+			}
+		}
+
+		if checked == 0 {
+			return fmt.Errorf("no audit log found (use --file or --db)")
+		}
+		if failed {
+			return fmt.Errorf("audit log verification failed")
+		}
+		return nil
+	},
+}
+
+// reportVerify prints the result of verifying one audit log and reports whether it passed
+func reportVerify(source string, result audit.VerifyResult, err error) bool {
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", source, err)
+		if result.Entries > 0 {
+			fmt.Printf("     %d entries before the problem were intact (sequence %d-%d)\n", result.Entries, result.FirstSeq, result.LastSeq)
+		}
+		return false
+	}
+	if result.Entries == 0 {
+		fmt.Printf("OK   %s: empty\n", source)
+		return true
+	}
+	fmt.Printf("OK   %s: %d entries verified (sequence %d-%d)\n", source, result.Entries, result.FirstSeq, result.LastSeq)
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditVerifyCmd.Flags().String("file", "", "JSONL audit log (default: ~/.stratusshell/audit.jsonl)")
+	auditVerifyCmd.Flags().String("db", "", "SQLite audit database (default: ~/.stratusshell/audit.db)")
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the StratusShell database",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Long: `Apply the schema migrations the database is missing, each in its own
+transaction. The server also does this when it starts.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		applied, err := database.Migrate(context.Background())
+		for _, m := range applied {
+			fmt.Printf("applied %s\n", m)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return nil
+	},
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which schema migrations have been applied",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		states, err := database.MigrationStatus(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tSTATUS")
+		for _, s := range states {
+			status := "pending"
+			switch {
+			case s.Unknown:
+				status = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05") + " (unknown to this version)"
+			case s.Applied():
+				status = "applied " + s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", s.Migration, status)
+		}
+		return w.Flush()
+	},
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the database",
+	Long: `Write a consistent copy of the database to a timestamped file. This is
+safe while the server is running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		keep, _ := cmd.Flags().GetInt("keep")
+		if dir == "" {
+			var err error
+			if dir, err = defaultDataPath("backups"); err != nil {
+				return err
+			}
+		}
+
+		dbPath, err := databasePath(cmd)
+		if err != nil {
+			return err
+		}
+		database, err := openSQLite(dbPath)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		path := db.BackupPath(dir, time.Now())
+		if err := database.Backup(context.Background(), path); err != nil {
+			return err
+		}
+		fmt.Printf("backed up to %s\n", path)
+
+		if keep > 0 {
+			removed, err := db.PruneBackups(dir, keep)
+			for _, old := range removed {
+				fmt.Printf("removed %s\n", old)
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <backup>",
+	Short: "Restore the database from a backup",
+	Long: `Replace the database with a backup after checking the backup's integrity.
+The current database is backed up first. Stop the server before restoring.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" {
+			var err error
+			if dir, err = defaultDataPath("backups"); err != nil {
+				return err
+			}
+		}
+		dbPath, err := databasePath(cmd)
+		if err != nil {
+			return err
+		}
+		if db.IsPostgresDSN(dbPath) {
+			return errPostgresUnsupported
+		}
+
+		// Keep what is being replaced
+		if _, err := os.Stat(dbPath); err == nil {
+			database, err := openSQLite(dbPath)
+			if err != nil {
+				return err
+			}
+			path := db.BackupPath(dir, time.Now())
+			err = database.Backup(context.Background(), path)
+			database.Close()
+			if err != nil {
+				return fmt.Errorf("failed to back up the current database: %w", err)
+			}
+			fmt.Printf("backed up the current database to %s\n", path)
+		}
+
+		if err := db.Restore(context.Background(), args[0], dbPath); err != nil {
+			return err
+		}
+		fmt.Printf("restored %s from %s\n", dbPath, args[0])
+		return nil
+	},
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the database for corruption",
+	Long: `Run SQLite's integrity check and foreign key check. Exits with a non-zero
+status if any problem is found.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dbPath, err := databasePath(cmd)
+		if err != nil {
+			return err
+		}
+		database, err := openSQLite(dbPath)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		report, err := database.Check(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, problem := range report.Problems {
+			fmt.Printf("integrity: %s\n", problem)
+		}
+		for _, v := range report.ForeignKeyViolations {
+			fmt.Printf("foreign key: %s row %d refers to a missing %s\n", v.Table, v.RowID, v.Parent)
+		}
+		if !report.OK() {
+			return fmt.Errorf("database check failed")
+		}
+		fmt.Println("OK")
+		return nil
+	},
+}
+
+// errPostgresUnsupported is returned by the commands that work on SQLite files
+var errPostgresUnsupported = errors.New("only SQLite databases are supported; use pg_dump and pg_restore for PostgreSQL")
+
+// databasePath returns the database path or URL given with --db, or the default
+func databasePath(cmd *cobra.Command) (string, error) {
+	dbPath, _ := cmd.Flags().GetString("db")
+	if dbPath == "" {
+		return defaultDataPath("data.db")
+	}
+	return dbPath, nil
+}
+
+// openDatabase opens the database named by the --db flag without migrating it
+func openDatabase(cmd *cobra.Command) (db.Store, error) {
+	dsn, err := databasePath(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if db.IsPostgresDSN(dsn) {
+		return db.OpenPostgresUnmigrated(dsn)
+	}
+	return openSQLite(dsn)
+}
+
+// openSQLite opens an existing SQLite database without migrating it
+func openSQLite(dbPath string) (*db.DB, error) {
+	if db.IsPostgresDSN(dbPath) {
+		return nil, errPostgresUnsupported
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db.OpenUnmigrated(dbPath)
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd, dbStatusCmd, dbBackupCmd, dbRestoreCmd, dbCheckCmd)
+	dbCmd.PersistentFlags().String("db", "", "SQLite database path or postgres:// URL (default: ~/.stratusshell/data.db)")
+	dbBackupCmd.Flags().String("dir", "", "Backup directory (default: ~/.stratusshell/backups)")
+	dbBackupCmd.Flags().Int("keep", 0, "Delete all but this many of the newest backups (0 keeps all)")
+	dbRestoreCmd.Flags().String("dir", "", "Where the current database is backed up first (default: ~/.stratusshell/backups)")
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/corymacd/StratusShell/internal/audit"
 	"github.com/corymacd/StratusShell/internal/provision"
 	"github.com/spf13/cobra"
 )
@@ -36,6 +37,9 @@ var initCmd = &cobra.Command{
 			return fmt.Errorf("failed to get skip-tools flag: %w", err)
 		}
 
+		// Record who ran init, and where, on every provisioning entry
+		provision.SetAuditSource(audit.ProcessSource())
+
 		log.Printf("Provisioning user: %s", username)
 
 		// Load config
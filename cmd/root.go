@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"github.com/corymacd/StratusShell/internal/logging"
 	"github.com/spf13/cobra"
 )
 
@@ -12,6 +14,11 @@ var rootCmd = &cobra.Command{
 	Short: "Cloud development environment provisioning tool",
 	Long: `StratusShell provisions complete cloud development environments with
 user creation, tool installation, and web-based terminal management.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		level, _ := cmd.Flags().GetString("log-level")
+		format, _ := cmd.Flags().GetString("log-format")
+		return logging.Setup(os.Stderr, format, level)
+	},
 }
 
 func Execute() {
@@ -23,4 +30,15 @@ func Execute() {
 
 func init() {
 	// Subcommands will be added here
+	rootCmd.PersistentFlags().String("log-level", "info", "Minimum level to log: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "Log format: text or json")
+}
+
+// defaultDataPath returns the path of name in the user's ~/.stratusshell directory
+func defaultDataPath(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".stratusshell", name), nil
 }
@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"os/user"
+	"time"
 
+	"github.com/corymacd/StratusShell/internal/middleware"
 	"github.com/corymacd/StratusShell/internal/server"
+	"github.com/corymacd/StratusShell/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -16,18 +18,150 @@ var serveCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		port, _ := cmd.Flags().GetInt("port")
 		dbPath, _ := cmd.Flags().GetString("db")
+		allowedEnv, _ := cmd.Flags().GetStringSlice("allow-env")
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		maxLifetime, _ := cmd.Flags().GetDuration("max-lifetime")
+		maxPerUser, _ := cmd.Flags().GetInt("max-terminals-per-user")
+		cpuLimit, _ := cmd.Flags().GetFloat64("cpu-limit")
+		memoryLimitFlag, _ := cmd.Flags().GetString("memory-limit")
+
+		memoryLimit, err := server.ParseByteSize(memoryLimitFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --memory-limit: %w", err)
+		}
+
+		auditFile, _ := cmd.Flags().GetString("audit-file")
+		auditMaxSizeFlag, _ := cmd.Flags().GetString("audit-max-size")
+		auditMaxBackups, _ := cmd.Flags().GetInt("audit-max-backups")
+		auditDB, _ := cmd.Flags().GetString("audit-db")
+		auditSyslog, _ := cmd.Flags().GetBool("audit-syslog")
+		auditCommands, _ := cmd.Flags().GetBool("audit-commands")
+		auditRedact, _ := cmd.Flags().GetStringArray("audit-redact")
+		admins, _ := cmd.Flags().GetStringSlice("admin")
+		defaultRole, _ := cmd.Flags().GetString("default-role")
+		require2FA, _ := cmd.Flags().GetBool("require-2fa")
+		webAuthnOrigins, _ := cmd.Flags().GetStringSlice("webauthn-origin")
+		webAuthnRPID, _ := cmd.Flags().GetString("webauthn-rp-id")
+		trustedProxies, _ := cmd.Flags().GetStringSlice("trusted-proxy")
+		basePath, _ := cmd.Flags().GetString("base-path")
+		authMode, _ := cmd.Flags().GetString("auth-mode")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		clientCA, _ := cmd.Flags().GetString("client-ca")
+		clientCertUser, _ := cmd.Flags().GetString("client-cert-user")
+		allowCIDRs, _ := cmd.Flags().GetStringSlice("allow-cidr")
+		denyCIDRs, _ := cmd.Flags().GetStringSlice("deny-cidr")
+		traceExporter, _ := cmd.Flags().GetString("trace-exporter")
+		traceEndpoint, _ := cmd.Flags().GetString("trace-endpoint")
+		traceInsecure, _ := cmd.Flags().GetBool("trace-insecure")
+		traceFile, _ := cmd.Flags().GetString("trace-file")
+		traceSample, _ := cmd.Flags().GetFloat64("trace-sample")
+		backupDir, _ := cmd.Flags().GetString("backup-dir")
+		backupInterval, _ := cmd.Flags().GetDuration("backup-interval")
+		backupKeep, _ := cmd.Flags().GetInt("backup-keep")
+		lockoutThreshold, _ := cmd.Flags().GetInt("lockout-threshold")
+		lockoutDuration, _ := cmd.Flags().GetDuration("lockout-duration")
+		lockoutMax, _ := cmd.Flags().GetDuration("lockout-max")
+		securityHeaders, _ := cmd.Flags().GetBool("security-headers")
+		frameAncestors, _ := cmd.Flags().GetStringSlice("frame-ancestors")
+		hstsMaxAge, _ := cmd.Flags().GetDuration("hsts-max-age")
+
+		auditMaxSize, err := server.ParseByteSize(auditMaxSizeFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --audit-max-size: %w", err)
+		}
 
 		// Default DB path if not specified
 		if dbPath == "" {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				return fmt.Errorf("failed to get home directory: %w", err)
+			if dbPath, err = defaultDataPath("data.db"); err != nil {
+				return err
+			}
+		}
+		if backupDir == "" {
+			if backupDir, err = defaultDataPath("backups"); err != nil {
+				return err
+			}
+		}
+		if !cmd.Flags().Changed("audit-file") {
+			if auditFile, err = defaultDataPath("audit.jsonl"); err != nil {
+				return err
+			}
+		}
+		if !cmd.Flags().Changed("audit-db") {
+			if auditDB, err = defaultDataPath("audit.db"); err != nil {
+				return err
+			}
+		}
+
+		// The user running the server administers it unless told otherwise
+		if !cmd.Flags().Changed("admin") {
+			if u, err := user.Current(); err == nil {
+				admins = []string{u.Username}
 			}
-			dbPath = filepath.Join(homeDir, ".stratusshell", "data.db")
 		}
 
 		// Create and run server
-		srv, err := server.NewServer(port, dbPath)
+		srv, err := server.NewServer(server.Config{
+			Port:       port,
+			DBPath:     dbPath,
+			AllowedEnv: allowedEnv,
+			Limits: server.ResourceLimits{
+				IdleTimeout:         idleTimeout,
+				MaxLifetime:         maxLifetime,
+				MaxTerminalsPerUser: maxPerUser,
+				CPULimit:            cpuLimit,
+				MemoryLimit:         memoryLimit,
+			},
+			Audit: server.AuditConfig{
+				File:       auditFile,
+				MaxSize:    auditMaxSize,
+				MaxBackups: auditMaxBackups,
+				DBPath:     auditDB,
+				Syslog:     auditSyslog,
+				Commands:   auditCommands,
+				Redact:     auditRedact,
+			},
+			Backup: server.BackupConfig{
+				Dir:      backupDir,
+				Interval: backupInterval,
+				Keep:     backupKeep,
+			},
+			Admins:      admins,
+			DefaultRole: defaultRole,
+			Require2FA:  require2FA,
+			WebAuthn: server.WebAuthnConfig{
+				Origins: webAuthnOrigins,
+				RPID:    webAuthnRPID,
+			},
+			Lockout: server.LockoutPolicy{
+				Threshold: lockoutThreshold,
+				Duration:  lockoutDuration,
+				Max:       lockoutMax,
+			},
+			AuthMode: authMode,
+			TLS: server.TLSConfig{
+				CertFile:       tlsCert,
+				KeyFile:        tlsKey,
+				ClientCAFile:   clientCA,
+				ClientCertUser: clientCertUser,
+			},
+			AllowCIDRs:     allowCIDRs,
+			DenyCIDRs:      denyCIDRs,
+			TrustedProxies: trustedProxies,
+			BasePath:       basePath,
+			Security: middleware.SecurityConfig{
+				Disabled:       !securityHeaders,
+				FrameAncestors: frameAncestors,
+				HSTSMaxAge:     hstsMaxAge,
+			},
+			Tracing: tracing.Config{
+				Exporter:    traceExporter,
+				Endpoint:    traceEndpoint,
+				Insecure:    traceInsecure,
+				File:        traceFile,
+				SampleRatio: traceSample,
+			},
+		})
 		if err != nil {
 			return fmt.Errorf("failed to create server: %w", err)
 		}
@@ -39,5 +173,46 @@ var serveCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.Flags().IntP("port", "p", 8080, "HTTP port")
-	serveCmd.Flags().String("db", "", "Database path (default: ~/.stratusshell/data.db)")
+	serveCmd.Flags().String("db", "", "SQLite database path or postgres:// URL (default: ~/.stratusshell/data.db)")
+	serveCmd.Flags().StringSlice("allow-env", nil, "Normally blocked environment variables terminals may set (e.g. LD_PRELOAD)")
+	serveCmd.Flags().Duration("idle-timeout", 0, "Close terminals with no input or output for this long (e.g. 30m; 0 disables)")
+	serveCmd.Flags().Duration("max-lifetime", 0, "Close terminals this long after they start (e.g. 24h; 0 disables)")
+	serveCmd.Flags().Int("max-terminals-per-user", 0, "Maximum terminals each user may have open (0 disables)")
+	serveCmd.Flags().Float64("cpu-limit", 0, "CPUs each user's terminals may use together, via cgroups v2 (e.g. 1.5; 0 disables)")
+	serveCmd.Flags().String("memory-limit", "", "Memory each user's terminals may use together, via cgroups v2 (e.g. 2G)")
+	serveCmd.Flags().Duration("backup-interval", 0, "Back up the database this often (e.g. 24h; 0 disables)")
+	serveCmd.Flags().String("backup-dir", "", "Directory for scheduled database backups (default: ~/.stratusshell/backups)")
+	serveCmd.Flags().Int("backup-keep", 7, "Number of scheduled database backups to keep (0 keeps all)")
+	serveCmd.Flags().String("audit-file", "", "Append-only JSONL audit log (default: ~/.stratusshell/audit.jsonl; empty disables)")
+	serveCmd.Flags().String("audit-max-size", "100M", "Size at which the audit log file is rotated")
+	serveCmd.Flags().Int("audit-max-backups", 10, "Number of rotated audit log files to keep")
+	serveCmd.Flags().String("audit-db", "", "SQLite audit database (default: ~/.stratusshell/audit.db; empty disables)")
+	serveCmd.Flags().Bool("audit-syslog", false, "Also send audit entries to the local syslog daemon")
+	serveCmd.Flags().Bool("audit-commands", false, "Record the command lines run in every terminal, not only those that opt in")
+	serveCmd.Flags().StringArray("audit-redact", nil, "Regular expression for secrets to remove from recorded command lines (repeatable)")
+	serveCmd.Flags().Int("lockout-threshold", 5, "Failed logins from one user or IP before it is locked out (0 disables)")
+	serveCmd.Flags().Duration("lockout-duration", time.Minute, "First lockout, doubling with each further failed login")
+	serveCmd.Flags().Duration("lockout-max", time.Hour, "Longest lockout")
+	serveCmd.Flags().String("auth-mode", server.AuthModeSession, "How users sign in: session (at /login) or client-cert (requires --tls-cert, --tls-key and --client-ca)")
+	serveCmd.Flags().String("tls-cert", "", "Serve HTTPS with this PEM certificate")
+	serveCmd.Flags().String("tls-key", "", "PEM private key of --tls-cert")
+	serveCmd.Flags().String("client-ca", "", "PEM CA certificates that issue client certificates, for --auth-mode client-cert")
+	serveCmd.Flags().String("client-cert-user", server.CertUserCommonName, "Client certificate field naming the user: cn or email")
+	serveCmd.Flags().StringSlice("allow-cidr", nil, "Only allow clients from these IP addresses or CIDR ranges (e.g. 10.0.0.0/8)")
+	serveCmd.Flags().StringSlice("deny-cidr", nil, "Refuse clients from these IP addresses or CIDR ranges, even if allowed")
+	serveCmd.Flags().StringSlice("trusted-proxy", nil, "Reverse proxy addresses or CIDR ranges whose X-Forwarded-* headers are trusted (e.g. 127.0.0.1,10.0.0.0/8)")
+	serveCmd.Flags().String("base-path", "", "URL path prefix to serve under behind a reverse proxy (e.g. /shell)")
+	serveCmd.Flags().Bool("security-headers", true, "Set Content-Security-Policy and other security headers (disable only if a reverse proxy sets them)")
+	serveCmd.Flags().StringSlice("frame-ancestors", nil, "Other origins allowed to embed the UI in a frame (e.g. https://portal.example.com)")
+	serveCmd.Flags().Duration("hsts-max-age", 0, "Send Strict-Transport-Security with this max-age on HTTPS requests (e.g. 8760h; 0 disables)")
+	serveCmd.Flags().String("trace-exporter", "none", "Where to send OpenTelemetry traces: none, otlp, stdout or file")
+	serveCmd.Flags().String("trace-endpoint", "", "OTLP/HTTP collector host:port (default: OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318)")
+	serveCmd.Flags().Bool("trace-insecure", false, "Send OTLP traces over plain HTTP")
+	serveCmd.Flags().String("trace-file", "", "File the file trace exporter appends JSON spans to")
+	serveCmd.Flags().Float64("trace-sample", 1, "Fraction of traces to record (0-1]")
+	serveCmd.Flags().StringSlice("admin", nil, "Users who are always admins (default: the user running the server)")
+	serveCmd.Flags().String("default-role", "developer", "Role of users with none assigned: admin, developer or viewer")
+	serveCmd.Flags().Bool("require-2fa", false, "Make users set up two-factor authentication before using anything else")
+	serveCmd.Flags().StringSlice("webauthn-origin", nil, "URLs the UI is reached at, for passkeys (default: http://localhost on --port, or https with TLS)")
+	serveCmd.Flags().String("webauthn-rp-id", "", "Domain passkeys are registered for (default: the host of the first --webauthn-origin)")
 }
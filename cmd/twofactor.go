@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var twoFactorCmd = &cobra.Command{
+	Use:   "2fa",
+	Short: "Manage two-factor authentication",
+}
+
+var twoFactorTokenCmd = &cobra.Command{
+	Use:   "token <user>",
+	Short: "Give a user a one-time token to set up two-factor authentication",
+	Long: `Give a user a one-time enrollment token, replacing any they had. Users who
+only named themselves at /login must enter one on the Two-Factor page before
+they can add an authenticator or passkey. Admins can also issue tokens from
+/api/2fa/enroll-token; this command is for the first admin. Pending schema
+migrations are applied first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		database, err := openDatabase(cmd)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		ctx := context.Background()
+		if _, err := database.Migrate(ctx); err != nil {
+			return err
+		}
+
+		issuedBy := os.Getenv("USER")
+		if issuedBy == "" {
+			issuedBy = "unknown"
+		}
+		token, expires, err := server.IssueEnrollmentToken(ctx, database, args[0], issuedBy, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("enrollment token for %s: %s\n", args[0], token)
+		fmt.Printf("expires %s\n", expires.Local().Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(twoFactorCmd)
+	twoFactorCmd.AddCommand(twoFactorTokenCmd)
+	twoFactorCmd.PersistentFlags().String("db", "", "SQLite database path or postgres:// URL (default: ~/.stratusshell/data.db)")
+}
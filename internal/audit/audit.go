@@ -1,9 +1,9 @@
 package audit
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -12,9 +12,13 @@ type ActionType string
 
 const (
 	// Terminal actions
-	ActionTerminalSpawn  ActionType = "terminal.spawn"
-	ActionTerminalKill   ActionType = "terminal.kill"
-	ActionTerminalRename ActionType = "terminal.rename"
+	ActionTerminalSpawn   ActionType = "terminal.spawn"
+	ActionTerminalKill    ActionType = "terminal.kill"
+	ActionTerminalRename  ActionType = "terminal.rename"
+	ActionTerminalExit    ActionType = "terminal.exit"
+	ActionTerminalRestart ActionType = "terminal.restart"
+	ActionTerminalReap    ActionType = "terminal.reap"
+	ActionTerminalCommand ActionType = "terminal.command"
 
 	// Session actions
 	ActionSessionCreate ActionType = "session.create"
@@ -25,18 +29,35 @@ const (
 	ActionLayoutChange ActionType = "layout.change"
 
 	// Auth actions
-	ActionAuthLogin  ActionType = "auth.login"
-	ActionAuthLogout ActionType = "auth.logout"
+	ActionAuthLogin   ActionType = "auth.login"
+	ActionAuthLogout  ActionType = "auth.logout"
+	ActionAuthLockout ActionType = "auth.lockout"
+	ActionAuthUnlock  ActionType = "auth.unlock"
+	ActionAuthDenied  ActionType = "auth.denied"
+	ActionAuthRole    ActionType = "auth.role"
+
+	// Two-factor authentication actions
+	ActionAuth2FAEnroll        ActionType = "auth.2fa.enroll"
+	ActionAuth2FAEnable        ActionType = "auth.2fa.enable"
+	ActionAuth2FAVerify        ActionType = "auth.2fa.verify"
+	ActionAuth2FARecoveryCodes ActionType = "auth.2fa.recovery_codes"
+	ActionAuth2FAReset         ActionType = "auth.2fa.reset"
+	ActionAuth2FAEnrollToken   ActionType = "auth.2fa.enroll_token"
+
+	// WebAuthn (passkey and security key) actions
+	ActionAuthWebAuthnRegister ActionType = "auth.webauthn.register"
+	ActionAuthWebAuthnLogin    ActionType = "auth.webauthn.login"
+	ActionAuthWebAuthnRevoke   ActionType = "auth.webauthn.revoke"
 
 	// Provisioning actions
-	ActionUserCreate       ActionType = "provision.user.create"
-	ActionUserDelete       ActionType = "provision.user.delete"
-	ActionUserShellChange  ActionType = "provision.user.shell_change"
-	ActionUserGroupAdd     ActionType = "provision.user.group_add"
-	ActionSudoersConfig    ActionType = "provision.sudoers.config"
-	ActionSudoersRemove    ActionType = "provision.sudoers.remove"
-	ActionChownRecursive   ActionType = "provision.chown_recursive"
-	ActionToolInstall      ActionType = "provision.tool.install"
+	ActionUserCreate      ActionType = "provision.user.create"
+	ActionUserDelete      ActionType = "provision.user.delete"
+	ActionUserShellChange ActionType = "provision.user.shell_change"
+	ActionUserGroupAdd    ActionType = "provision.user.group_add"
+	ActionSudoersConfig   ActionType = "provision.sudoers.config"
+	ActionSudoersRemove   ActionType = "provision.sudoers.remove"
+	ActionChownRecursive  ActionType = "provision.chown_recursive"
+	ActionToolInstall     ActionType = "provision.tool.install"
 )
 
 // Outcome represents the result of an action
@@ -56,35 +77,121 @@ type Entry struct {
 	Outcome   Outcome                `json:"outcome"`
 	Error     string                 `json:"error,omitempty"`
 	Details   map[string]interface{} `json:"details,omitempty"`
+	Source    *Source                `json:"source,omitempty"`
+
+	// Hash chain: every entry includes the digest of the one before it, so
+	// modified, removed or reordered entries are detected by Verify
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
-// Logger provides structured audit logging
+// Logger provides structured audit logging. Entries are hash-chained and
+// written to every sink.
 type Logger struct {
-	// In production, this would write to a dedicated audit log file or service
-	// For now, we use the standard logger with structured JSON
+	*chain
+	source *Source // Attached to entries that do not set their own
+}
+
+// chain is the state shared by a logger and the loggers derived from it
+type chain struct {
+	sinks    []Sink
+	mu       sync.Mutex
+	seq      uint64
+	prevHash string
+}
+
+// NewLogger creates a new audit logger writing to sinks. Without sinks,
+// entries go to the standard logger. The hash chain continues from the most
+// recent entry found in any of the sinks.
+func NewLogger(sinks ...Sink) *Logger {
+	if len(sinks) == 0 {
+		sinks = []Sink{NewLogSink()}
+	}
+
+	l := &Logger{chain: &chain{sinks: sinks}}
+	for _, sink := range sinks {
+		r, ok := sink.(chainResumer)
+		if !ok {
+			continue
+		}
+		last, found, err := r.lastEntry()
+		if err != nil {
+			log.Printf("AUDIT ERROR: failed to read last entry from %s: %v", sink.Name(), err)
+			continue
+		}
+		if found && last.Seq > l.seq {
+			l.seq = last.Seq
+			l.prevHash = last.Hash
+		}
+	}
+
+	return l
 }
 
-// NewLogger creates a new audit logger
-func NewLogger() *Logger {
-	return &Logger{}
+// WithSource returns a logger that attaches src to the entries it writes.
+// It shares the original logger's sinks and hash chain.
+func (l *Logger) WithSource(src *Source) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{chain: l.chain, source: src}
 }
 
 // Log writes an audit entry
 func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+	if entry.Source == nil {
+		entry.Source = l.source
+	}
+
 	// Set timestamp if not provided
 	if entry.Timestamp.IsZero() {
 		entry.Timestamp = time.Now()
 	}
+	entry.Timestamp = entry.Timestamp.UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	// Serialize to JSON
-	data, err := json.Marshal(entry)
+	entry.Seq = l.seq + 1
+	entry.PrevHash = l.prevHash
+	hash, err := computeHash(entry)
 	if err != nil {
 		log.Printf("AUDIT ERROR: Failed to serialize audit entry: %v", err)
 		return
 	}
+	entry.Hash = hash
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(entry); err != nil {
+			// Never lose an entry silently: fall back to the process log
+			log.Printf("AUDIT ERROR: failed to write to %s: %v (entry seq %d, action %s)", sink.Name(), err, entry.Seq, entry.Action)
+		}
+	}
+
+	l.seq = entry.Seq
+	l.prevHash = entry.Hash
+}
+
+// Close closes all sinks
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	// Write to log
-	log.Printf("AUDIT: %s", string(data))
+	var firstErr error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // LogTerminalSpawn logs terminal creation
@@ -142,6 +249,114 @@ func (l *Logger) LogTerminalRename(actor string, terminalID int, oldTitle, newTi
 	l.Log(entry)
 }
 
+// LogTerminalExit logs the exit of a terminal's command
+func (l *Logger) LogTerminalExit(terminalID int, exitCode int, signal string, crashes int, err error) {
+	entry := Entry{
+		Action:  ActionTerminalExit,
+		Actor:   "system",
+		Target:  fmt.Sprintf("terminal:%d", terminalID),
+		Outcome: OutcomeSuccess,
+		Details: map[string]interface{}{
+			"exit_code": exitCode,
+			"crashes":   crashes,
+		},
+	}
+
+	if signal != "" {
+		entry.Details["signal"] = signal
+	}
+	if exitCode != 0 {
+		entry.Outcome = OutcomeFailure
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// LogTerminalRestart logs a restart of a terminal's command, either scheduled
+// by its restart policy (actor "system") or requested by a user
+func (l *Logger) LogTerminalRestart(actor string, terminalID int, policy string, delay time.Duration, outcome Outcome, err error) {
+	entry := Entry{
+		Action:  ActionTerminalRestart,
+		Actor:   actor,
+		Target:  fmt.Sprintf("terminal:%d", terminalID),
+		Outcome: outcome,
+		Details: map[string]interface{}{
+			"policy":   policy,
+			"delay_ms": delay.Milliseconds(),
+		},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// LogTerminalReap logs a terminal closed for being idle, exceeding its maximum
+// lifetime or no longer accepting connections
+func (l *Logger) LogTerminalReap(terminalID int, owner, reason string, age, idle time.Duration, err error) {
+	entry := Entry{
+		Action:  ActionTerminalReap,
+		Actor:   "system",
+		Target:  fmt.Sprintf("terminal:%d", terminalID),
+		Outcome: OutcomeFromError(err),
+		Details: map[string]interface{}{
+			"owner":  owner,
+			"reason": reason,
+			"age_s":  int64(age.Seconds()),
+			"idle_s": int64(idle.Seconds()),
+		},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// Ways a terminal command line can be captured
+const (
+	CaptureShellIntegration = "shell-integration" // Reported by the shell through OSC 133
+	CaptureInput            = "input"             // Reconstructed from keystrokes
+)
+
+// LogTerminalCommand logs a command line run in a terminal. exitCode is -1
+// when the exit status is unknown, and inexact marks input reconstruction
+// that could not follow line editing (such as tab completion).
+func (l *Logger) LogTerminalCommand(actor string, terminalID int, command, cwd string, exitCode int, duration time.Duration, capture string, inexact bool) {
+	entry := Entry{
+		Action:  ActionTerminalCommand,
+		Actor:   actor,
+		Target:  fmt.Sprintf("terminal:%d", terminalID),
+		Outcome: OutcomeSuccess,
+		Details: map[string]interface{}{
+			"command": command,
+			"capture": capture,
+		},
+	}
+
+	if cwd != "" {
+		entry.Details["cwd"] = cwd
+	}
+	if exitCode >= 0 {
+		entry.Details["exit_code"] = exitCode
+		entry.Details["duration_ms"] = duration.Milliseconds()
+		if exitCode != 0 {
+			entry.Outcome = OutcomeFailure
+		}
+	}
+	if inexact {
+		entry.Details["inexact"] = true
+	}
+
+	l.Log(entry)
+}
+
 // LogSessionCreate logs session creation
 func (l *Logger) LogSessionCreate(actor string, sessionID int, name string, outcome Outcome, err error) {
 	entry := Entry{
@@ -222,6 +437,141 @@ func (l *Logger) LogAuthLogout(actor string, outcome Outcome) {
 	l.Log(entry)
 }
 
+// LogAuthLockout logs a username or client IP being locked out after
+// repeated failed logins. target is "user:<name>" or "ip:<address>".
+func (l *Logger) LogAuthLockout(target string, failures int, until time.Time) {
+	l.Log(Entry{
+		Action:  ActionAuthLockout,
+		Actor:   "system",
+		Target:  target,
+		Outcome: OutcomeSuccess,
+		Details: map[string]interface{}{
+			"failures":     failures,
+			"locked_until": until.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// LogAuthUnlock logs an admin lifting a lockout
+func (l *Logger) LogAuthUnlock(actor, target string, outcome Outcome, err error) {
+	entry := Entry{
+		Action:  ActionAuthUnlock,
+		Actor:   actor,
+		Target:  target,
+		Outcome: outcome,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// LogAuthDenied logs a request refused because the actor's role lacks the
+// permission it needs. target is what was acted on, such as "terminal:3".
+func (l *Logger) LogAuthDenied(actor, role, permission, target string) {
+	l.Log(Entry{
+		Action:  ActionAuthDenied,
+		Actor:   actor,
+		Target:  target,
+		Outcome: OutcomeFailure,
+		Details: map[string]interface{}{
+			"role":       role,
+			"permission": permission,
+		},
+	})
+}
+
+// LogAuthRole logs an admin changing a user's role. An empty newRole means
+// the role was removed, leaving the default.
+func (l *Logger) LogAuthRole(actor, user, oldRole, newRole string, outcome Outcome, err error) {
+	entry := Entry{
+		Action:  ActionAuthRole,
+		Actor:   actor,
+		Target:  user,
+		Outcome: outcome,
+		Details: map[string]interface{}{
+			"old_role": oldRole,
+			"new_role": newRole,
+		},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// LogAuth2FAEnroll logs a user starting TOTP enrollment
+func (l *Logger) LogAuth2FAEnroll(actor string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FAEnroll, actor, actor, nil, outcome, err)
+}
+
+// LogAuth2FAEnable logs a user confirming TOTP enrollment with a valid code
+func (l *Logger) LogAuth2FAEnable(actor string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FAEnable, actor, actor, nil, outcome, err)
+}
+
+// LogAuth2FAVerify logs a second factor given at login. method is "totp",
+// "recovery_code" or "enrollment_token".
+func (l *Logger) LogAuth2FAVerify(actor, method string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FAVerify, actor, actor, map[string]interface{}{"method": method}, outcome, err)
+}
+
+// LogAuth2FARecoveryCodes logs a user replacing their recovery codes
+func (l *Logger) LogAuth2FARecoveryCodes(actor string, count int, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FARecoveryCodes, actor, actor, map[string]interface{}{"count": count}, outcome, err)
+}
+
+// LogAuth2FAReset logs an admin removing a user's second factor
+func (l *Logger) LogAuth2FAReset(actor, user string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FAReset, actor, user, nil, outcome, err)
+}
+
+// LogAuth2FAEnrollToken logs an admin giving a user a one-time token to
+// set up their first second factor
+func (l *Logger) LogAuth2FAEnrollToken(actor, user string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuth2FAEnrollToken, actor, user, nil, outcome, err)
+}
+
+func (l *Logger) logAuth2FA(action ActionType, actor, user string, details map[string]interface{}, outcome Outcome, err error) {
+	entry := Entry{
+		Action:  action,
+		Actor:   actor,
+		Target:  "user:" + user,
+		Outcome: outcome,
+		Details: details,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.Log(entry)
+}
+
+// LogAuthWebAuthnRegister logs a user registering a passkey or security key
+func (l *Logger) LogAuthWebAuthnRegister(actor, name string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuthWebAuthnRegister, actor, actor, map[string]interface{}{"name": name}, outcome, err)
+}
+
+// LogAuthWebAuthnLogin logs a user signing in with a passkey or security key.
+// name is empty if the assertion failed before the key was identified.
+func (l *Logger) LogAuthWebAuthnLogin(actor, name string, outcome Outcome, err error) {
+	var details map[string]interface{}
+	if name != "" {
+		details = map[string]interface{}{"name": name}
+	}
+	l.logAuth2FA(ActionAuthWebAuthnLogin, actor, actor, details, outcome, err)
+}
+
+// LogAuthWebAuthnRevoke logs a passkey or security key being removed
+func (l *Logger) LogAuthWebAuthnRevoke(actor, user, name string, outcome Outcome, err error) {
+	l.logAuth2FA(ActionAuthWebAuthnRevoke, actor, user, map[string]interface{}{"name": name}, outcome, err)
+}
+
 // OutcomeFromError returns OutcomeSuccess if err is nil, otherwise OutcomeFailure
 func OutcomeFromError(err error) Outcome {
 	if err == nil {
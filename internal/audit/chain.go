@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// computeHash returns the SHA-256 digest of an entry's JSON encoding with
+// the Hash field left empty. PrevHash is included, which links the chain.
+func computeHash(entry Entry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// TamperError describes where an audit log's hash chain is broken
+type TamperError struct {
+	Source string // File or database the entry came from
+	Line   int    // Line number for files
+	Seq    uint64
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	location := e.Source
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", e.Source, e.Line)
+	}
+	return fmt.Sprintf("%s: entry %d: %s", location, e.Seq, e.Reason)
+}
+
+// VerifyResult summarizes a verified audit log
+type VerifyResult struct {
+	Entries  int
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string
+}
+
+// Verifier checks a sequence of entries against their hash chain
+type Verifier struct {
+	result VerifyResult
+}
+
+// Add checks the next entry in the log. The first entry anchors the chain,
+// since older entries may have been rotated away.
+func (v *Verifier) Add(entry Entry) error {
+	hash, err := computeHash(entry)
+	if err != nil {
+		return err
+	}
+	if hash != entry.Hash {
+		return &TamperError{Seq: entry.Seq, Reason: "content does not match its hash (entry was modified)"}
+	}
+
+	if v.result.Entries > 0 {
+		if entry.Seq != v.result.LastSeq+1 {
+			return &TamperError{Seq: entry.Seq, Reason: fmt.Sprintf("expected sequence %d (entries were removed or reordered)", v.result.LastSeq+1)}
+		}
+		if entry.PrevHash != v.result.LastHash {
+			return &TamperError{Seq: entry.Seq, Reason: "previous hash does not match the preceding entry (entries were removed, inserted or replaced)"}
+		}
+	} else {
+		if entry.Seq == 1 && entry.PrevHash != "" {
+			return &TamperError{Seq: entry.Seq, Reason: "first entry must not have a previous hash"}
+		}
+		v.result.FirstSeq = entry.Seq
+	}
+
+	v.result.Entries++
+	v.result.LastSeq = entry.Seq
+	v.result.LastHash = entry.Hash
+	return nil
+}
+
+// Result returns a summary of the entries verified so far
+func (v *Verifier) Result() VerifyResult {
+	return v.result
+}
+
+// VerifyFiles verifies JSONL audit files, oldest first, as one chain
+func VerifyFiles(paths ...string) (VerifyResult, error) {
+	var v Verifier
+	for _, path := range paths {
+		if err := verifyFile(&v, path); err != nil {
+			return v.Result(), err
+		}
+	}
+	return v.Result(), nil
+}
+
+func verifyFile(v *Verifier, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := newLineScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return &TamperError{Source: path, Line: line, Reason: "not a valid audit entry: " + err.Error()}
+		}
+		if err := v.Add(entry); err != nil {
+			if te, ok := err.(*TamperError); ok {
+				te.Source, te.Line = path, line
+			}
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// VerifyDatabase verifies the audit_log table of a SQLite audit database
+func VerifyDatabase(ctx context.Context, path string) (VerifyResult, error) {
+	if _, err := os.Stat(path); err != nil {
+		return VerifyResult{}, err
+	}
+	sink, err := NewSQLiteSink(path)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer sink.Close()
+
+	var v Verifier
+	err = sink.Entries(ctx, func(entry Entry) error {
+		if err := v.Add(entry); err != nil {
+			if te, ok := err.(*TamperError); ok {
+				te.Source = path
+			}
+			return err
+		}
+		return nil
+	})
+	return v.Result(), err
+}
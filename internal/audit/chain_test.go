@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEntries(t *testing.T, l *Logger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		l.LogTerminalSpawn("alice", i, "Terminal", OutcomeSuccess, nil)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestFileSinkVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open sink: %v", err)
+	}
+	l := NewLogger(sink)
+	writeEntries(t, l, 5)
+	l.Close()
+
+	result, err := VerifyFiles(path)
+	if err != nil {
+		t.Fatalf("verify failed on an untouched log: %v", err)
+	}
+	if result.Entries != 5 || result.FirstSeq != 1 || result.LastSeq != 5 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestFileSinkDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([]string) []string
+	}{
+		{"modified entry", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `"actor":"alice"`, `"actor":"mallory"`, 1)
+			return lines
+		}},
+		{"removed entry", func(lines []string) []string {
+			return append(lines[:2], lines[3:]...)
+		}},
+		{"reordered entries", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+		{"garbage line", func(lines []string) []string {
+			return append(lines, "not json")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			sink, err := NewFileSink(path, 0, 0)
+			if err != nil {
+				t.Fatalf("failed to open sink: %v", err)
+			}
+			l := NewLogger(sink)
+			writeEntries(t, l, 5)
+			l.Close()
+
+			writeLines(t, path, tt.tamper(readLines(t, path)))
+
+			_, err = VerifyFiles(path)
+			var te *TamperError
+			if !errors.As(err, &te) {
+				t.Fatalf("expected tampering to be detected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileSinkRotationAndResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Small enough that every couple of entries rotates the file
+	sink, err := NewFileSink(path, 400, 3)
+	if err != nil {
+		t.Fatalf("failed to open sink: %v", err)
+	}
+	l := NewLogger(sink)
+	writeEntries(t, l, 4)
+	l.Close()
+
+	// A restarted logger continues the same chain
+	sink, err = NewFileSink(path, 400, 3)
+	if err != nil {
+		t.Fatalf("failed to reopen sink: %v", err)
+	}
+	l = NewLogger(sink)
+	writeEntries(t, l, 2)
+	l.Close()
+
+	files, err := RotatedFiles(path)
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	if len(files) < 2 || files[len(files)-1] != path {
+		t.Fatalf("expected rotated files ending with %s, got %v", path, files)
+	}
+
+	result, err := VerifyFiles(files...)
+	if err != nil {
+		t.Fatalf("verify failed across rotation: %v", err)
+	}
+	if result.LastSeq != 6 {
+		t.Errorf("expected chain to reach sequence 6, got %+v", result)
+	}
+}
+
+func TestSQLiteSinkVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	sink, err := NewSQLiteSink(path)
+	if err != nil {
+		t.Fatalf("failed to open sink: %v", err)
+	}
+	l := NewLogger(sink)
+	writeEntries(t, l, 3)
+	l.LogTerminalExit(2, 1, "SIGKILL", 1, errors.New("boom"))
+	l.Close()
+
+	result, err := VerifyDatabase(context.Background(), path)
+	if err != nil {
+		t.Fatalf("verify failed on an untouched database: %v", err)
+	}
+	if result.Entries != 4 {
+		t.Errorf("expected 4 entries, got %+v", result)
+	}
+
+	// The table refuses changes...
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`UPDATE audit_log SET actor = 'mallory' WHERE seq = 2`); err == nil {
+		t.Fatal("expected update to be rejected")
+	}
+
+	// ...and changes made around that are detected
+	if _, err := conn.Exec(`DROP TRIGGER audit_log_no_update`); err != nil {
+		t.Fatalf("failed to drop trigger: %v", err)
+	}
+	if _, err := conn.Exec(`UPDATE audit_log SET actor = 'mallory' WHERE seq = 2`); err != nil {
+		t.Fatalf("failed to tamper: %v", err)
+	}
+
+	_, err = VerifyDatabase(context.Background(), path)
+	var te *TamperError
+	if !errors.As(err, &te) || te.Seq != 2 {
+		t.Fatalf("expected tampering at entry 2 to be detected, got %v", err)
+	}
+}
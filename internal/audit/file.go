@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultMaxFileSize is the size at which the audit file is rotated
+	DefaultMaxFileSize = 100 * 1024 * 1024
+	// DefaultMaxBackups is how many rotated audit files are kept
+	DefaultMaxBackups = 10
+)
+
+// FileSink appends entries as JSON lines to a file, rotating it to path.1,
+// path.2, ... when it grows beyond maxSize. Every write is synced to disk.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens (or creates) the audit file at path. A maxSize or
+// maxBackups of zero uses the defaults.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) Name() string { return "file " + s.path }
+
+func (s *FileSink) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and starts a new file
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// lastEntry returns the newest entry, from the current file or the most
+// recent backup if the current file is empty
+func (s *FileSink) lastEntry() (Entry, bool, error) {
+	for _, path := range []string{s.path, s.path + ".1"} {
+		entry, found, err := lastEntryInFile(path)
+		if err != nil || found {
+			return entry, found, err
+		}
+	}
+	return Entry{}, false, nil
+}
+
+func lastEntryInFile(path string) (Entry, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	defer f.Close()
+
+	var last string
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Entry{}, false, err
+	}
+	if last == "" {
+		return Entry{}, false, nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(last), &entry); err != nil {
+		return Entry{}, false, fmt.Errorf("failed to parse last audit entry: %w", err)
+	}
+	return entry, true, nil
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
+
+// RotatedFiles returns the audit file at path and its backups, oldest first
+func RotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	type backup struct {
+		path string
+		n    int
+	}
+	var backups []backup
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(m, path+"."))
+		if err == nil && n > 0 {
+			backups = append(backups, backup{m, n})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].n > backups[j].n })
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultQueryLimit is the page size when a query does not set one
+	DefaultQueryLimit = 50
+	// MaxQueryLimit is the largest page a query may request
+	MaxQueryLimit = 500
+)
+
+// AllActions lists every action type, in the order they are declared
+func AllActions() []ActionType {
+	return []ActionType{
+		ActionTerminalSpawn,
+		ActionTerminalKill,
+		ActionTerminalRename,
+		ActionTerminalExit,
+		ActionTerminalRestart,
+		ActionTerminalReap,
+		ActionTerminalCommand,
+		ActionSessionCreate,
+		ActionSessionLoad,
+		ActionSessionDelete,
+		ActionLayoutChange,
+		ActionAuthLogin,
+		ActionAuthLogout,
+		ActionAuthLockout,
+		ActionAuthUnlock,
+		ActionAuthDenied,
+		ActionAuthRole,
+		ActionAuth2FAEnroll,
+		ActionAuth2FAEnable,
+		ActionAuth2FAVerify,
+		ActionAuth2FARecoveryCodes,
+		ActionAuth2FAReset,
+		ActionAuth2FAEnrollToken,
+		ActionAuthWebAuthnRegister,
+		ActionAuthWebAuthnLogin,
+		ActionAuthWebAuthnRevoke,
+		ActionUserCreate,
+		ActionUserDelete,
+		ActionUserShellChange,
+		ActionUserGroupAdd,
+		ActionSudoersConfig,
+		ActionSudoersRemove,
+		ActionChownRecursive,
+		ActionToolInstall,
+	}
+}
+
+// ActionCategories returns the distinct prefixes of the action types
+// ("terminal", "session", ...), which filters accept in place of an action
+func ActionCategories() []string {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, action := range AllActions() {
+		category, _, _ := strings.Cut(string(action), ".")
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// Filter selects audit entries. Empty fields match everything.
+type Filter struct {
+	Action  string // An ActionType, or a category such as "terminal" matching all its actions
+	Actor   string
+	Target  string
+	Outcome Outcome
+	Since   time.Time // Inclusive
+	Until   time.Time // Exclusive
+
+	// Match the entry's source
+	IP        string
+	RequestID string
+	TraceID   string
+
+	Limit  int // Page size, DefaultQueryLimit if zero
+	Offset int
+}
+
+// Validate checks the filter only uses known actions and outcomes
+func (f Filter) Validate() error {
+	if f.Action != "" && !isKnownAction(f.Action) {
+		return fmt.Errorf("unknown action %q", f.Action)
+	}
+	if f.Outcome != "" && f.Outcome != OutcomeSuccess && f.Outcome != OutcomeFailure {
+		return fmt.Errorf("unknown outcome %q", f.Outcome)
+	}
+	if f.Limit < 0 || f.Limit > MaxQueryLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxQueryLimit)
+	}
+	if f.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+	return nil
+}
+
+func isKnownAction(action string) bool {
+	for _, a := range AllActions() {
+		if string(a) == action {
+			return true
+		}
+	}
+	for _, c := range ActionCategories() {
+		if c == action {
+			return true
+		}
+	}
+	return false
+}
+
+// where builds the WHERE clause and arguments for a filter
+func (f Filter) where() (string, []interface{}) {
+	var (
+		clauses []string
+		args    []interface{}
+	)
+
+	if f.Action != "" {
+		if strings.Contains(f.Action, ".") {
+			clauses = append(clauses, "action = ?")
+			args = append(args, f.Action)
+		} else {
+			clauses = append(clauses, "action LIKE ?")
+			args = append(args, f.Action+".%")
+		}
+	}
+	if f.Actor != "" {
+		clauses = append(clauses, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Target != "" {
+		clauses = append(clauses, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.Outcome != "" {
+		clauses = append(clauses, "outcome = ?")
+		args = append(args, string(f.Outcome))
+	}
+	if f.IP != "" {
+		clauses = append(clauses, "json_extract(NULLIF(source, ''), '$.ip') = ?")
+		args = append(args, f.IP)
+	}
+	if f.RequestID != "" {
+		clauses = append(clauses, "json_extract(NULLIF(source, ''), '$.request_id') = ?")
+		args = append(args, f.RequestID)
+	}
+	if f.TraceID != "" {
+		clauses = append(clauses, "json_extract(NULLIF(source, ''), '$.trace_id') = ?")
+		args = append(args, f.TraceID)
+	}
+	if !f.Since.IsZero() {
+		clauses = append(clauses, "timestamp >= ?")
+		args = append(args, f.Since.UTC().Format(sqliteTimeFormat))
+	}
+	if !f.Until.IsZero() {
+		clauses = append(clauses, "timestamp < ?")
+		args = append(args, f.Until.UTC().Format(sqliteTimeFormat))
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// Query returns one page of matching entries, newest first, and the total
+// number of matches
+func (s *SQLiteSink) Query(ctx context.Context, f Filter) ([]Entry, int, error) {
+	if err := f.Validate(); err != nil {
+		return nil, 0, err
+	}
+	limit := f.Limit
+	if limit == 0 {
+		limit = DefaultQueryLimit
+	}
+
+	where, args := f.where()
+
+	var total int
+	if err := s.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	rows, err := s.conn.QueryContext(ctx,
+		`SELECT `+sqliteColumns+` FROM audit_log`+where+` ORDER BY seq DESC LIMIT ? OFFSET ?`,
+		append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// Export calls fn for every matching entry, oldest first, ignoring the
+// filter's pagination
+func (s *SQLiteSink) Export(ctx context.Context, f Filter, fn func(Entry) error) error {
+	f.Limit, f.Offset = 0, 0
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	where, args := f.where()
+	rows, err := s.conn.QueryContext(ctx, `SELECT `+sqliteColumns+` FROM audit_log`+where+` ORDER BY seq`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
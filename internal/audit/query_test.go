@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteSinkQuery(t *testing.T) {
+	sink, err := NewSQLiteSink(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open sink: %v", err)
+	}
+	defer sink.Close()
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLogger(sink)
+	entries := []Entry{
+		{Action: ActionAuthLogin, Actor: "alice", Outcome: OutcomeSuccess},
+		{Action: ActionTerminalSpawn, Actor: "alice", Target: "terminal:1", Outcome: OutcomeSuccess},
+		{Action: ActionTerminalKill, Actor: "bob", Target: "terminal:1", Outcome: OutcomeFailure, Error: "denied"},
+		{Action: ActionTerminalSpawn, Actor: "bob", Target: "terminal:2", Outcome: OutcomeSuccess},
+		{Action: ActionSessionCreate, Actor: "alice", Target: "session:dev", Outcome: OutcomeSuccess},
+	}
+	for i, entry := range entries {
+		entry.Timestamp = start.Add(time.Duration(i) * time.Hour)
+		l.Log(entry)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64 // Sequence numbers, newest first
+	}{
+		{"everything", Filter{}, []uint64{5, 4, 3, 2, 1}},
+		{"action", Filter{Action: string(ActionTerminalSpawn)}, []uint64{4, 2}},
+		{"category", Filter{Action: "terminal"}, []uint64{4, 3, 2}},
+		{"actor", Filter{Actor: "bob"}, []uint64{4, 3}},
+		{"target", Filter{Target: "terminal:1"}, []uint64{3, 2}},
+		{"outcome", Filter{Outcome: OutcomeFailure}, []uint64{3}},
+		{"time range", Filter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []uint64{3, 2}},
+		{"combined", Filter{Action: "terminal", Actor: "alice"}, []uint64{2}},
+		{"page", Filter{Limit: 2, Offset: 1}, []uint64{4, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := sink.Query(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if tt.filter.Limit == 0 && total != len(tt.want) {
+				t.Errorf("expected total %d, got %d", len(tt.want), total)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d entries, got %d", len(tt.want), len(got))
+			}
+			for i, entry := range got {
+				if entry.Seq != tt.want[i] {
+					t.Errorf("entry %d: expected seq %d, got %d", i, tt.want[i], entry.Seq)
+				}
+			}
+		})
+	}
+
+	// Pagination still reports the total number of matches
+	if _, total, _ := sink.Query(context.Background(), Filter{Limit: 2}); total != 5 {
+		t.Errorf("expected total 5 for a paged query, got %d", total)
+	}
+
+	// Exports ignore pagination and run oldest first
+	var exported []uint64
+	err = sink.Export(context.Background(), Filter{Actor: "alice", Limit: 1}, func(e Entry) error {
+		exported = append(exported, e.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if len(exported) != 3 || exported[0] != 1 || exported[2] != 5 {
+		t.Errorf("unexpected export: %v", exported)
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{"empty", Filter{}, false},
+		{"action", Filter{Action: string(ActionAuthLogin)}, false},
+		{"category", Filter{Action: "provision"}, false},
+		{"unknown action", Filter{Action: "auth.sudo"}, true},
+		{"sql in action", Filter{Action: "auth' OR 1=1 --"}, true},
+		{"unknown outcome", Filter{Outcome: "maybe"}, true},
+		{"limit too large", Filter{Limit: MaxQueryLimit + 1}, true},
+		{"negative offset", Filter{Offset: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSourceRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	sink, err := NewSQLiteSink(path)
+	if err != nil {
+		t.Fatalf("failed to open sink: %v", err)
+	}
+
+	l := NewLogger(sink)
+	l.LogAuthLogin("alice", OutcomeSuccess, nil)
+	web := l.WithSource(&Source{IP: "203.0.113.7", UserAgent: "curl/8", RequestID: "abc123", SessionID: "s1"})
+	web.LogTerminalSpawn("alice", 1, "Terminal", OutcomeSuccess, nil)
+	cli := l.WithSource(&Source{Host: "devbox", SudoUser: "bob"})
+	cli.LogAuthLogout("alice", OutcomeSuccess)
+	l.Close()
+
+	// Derived loggers share one chain
+	if _, err := VerifyDatabase(context.Background(), path); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	sink, err = NewSQLiteSink(path)
+	if err != nil {
+		t.Fatalf("failed to reopen sink: %v", err)
+	}
+	defer sink.Close()
+
+	entries, _, err := sink.Query(context.Background(), Filter{RequestID: "abc123"})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Seq != 2 {
+		t.Fatalf("expected entry 2 for the request, got %+v", entries)
+	}
+	if src := entries[0].Source; src == nil || src.IP != "203.0.113.7" || src.SessionID != "s1" || src.UserAgent != "curl/8" {
+		t.Errorf("source not recorded: %+v", src)
+	}
+
+	entries, _, err = sink.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if src := entries[0].Source; src == nil || src.Host != "devbox" || src.SudoUser != "bob" {
+		t.Errorf("expected host and sudo user on entry 3, got %+v", src)
+	}
+	if entries[2].Source != nil {
+		t.Errorf("expected no source on entry 1, got %+v", entries[2].Source)
+	}
+}
+
+func TestSQLiteSinkAddsSourceColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+
+	// A database from before entries had a source
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = conn.Exec(`CREATE TABLE audit_log (
+		seq INTEGER PRIMARY KEY, timestamp TEXT NOT NULL, action TEXT NOT NULL, actor TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '', outcome TEXT NOT NULL, error TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT '', prev_hash TEXT NOT NULL DEFAULT '', hash TEXT NOT NULL)`)
+	conn.Close()
+	if err != nil {
+		t.Fatalf("failed to create old schema: %v", err)
+	}
+
+	sink, err := NewSQLiteSink(path)
+	if err != nil {
+		t.Fatalf("failed to upgrade database: %v", err)
+	}
+	l := NewLogger(sink)
+	l.WithSource(&Source{IP: "203.0.113.7"}).LogAuthLogin("alice", OutcomeSuccess, nil)
+	l.Close()
+
+	if _, err := VerifyDatabase(context.Background(), path); err != nil {
+		t.Fatalf("verify failed after upgrade: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Redacted replaces secrets removed from audited text
+const Redacted = "[REDACTED]"
+
+// redactRule replaces matches of re with repl, which may refer to groups
+type redactRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// defaultRedactRules catch the common ways secrets end up on a command line.
+// Each keeps enough context to show what was redacted.
+var defaultRedactRules = []redactRule{
+	// password=..., --api-key ..., export GITHUB_TOKEN=...
+	{regexp.MustCompile(`(?i)(\b[\w-]*(?:passw(?:or)?d|passphrase|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credentials?)[\w-]*(?:\s*[=:]\s*|\s+))("[^"]*"|'[^']*'|[^\s"';|&]+)`), "${1}" + Redacted},
+	// Authorization: Bearer ... headers passed to curl and friends
+	{regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic|token)\s+)[^\s"']+`), "${1}" + Redacted},
+	// user:password@ in URLs
+	{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:)[^@\s/]+@`), "${1}" + Redacted + "@"},
+	// curl -u user:password
+	{regexp.MustCompile(`((?:^|\s)(?:-u|--user)\s+[^\s:]+:)\S+`), "${1}" + Redacted},
+	// Well-known token formats that need no surrounding context
+	{regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`), Redacted},
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), Redacted},
+	{regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`), Redacted},
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`), Redacted},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}\b`), Redacted},
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`), Redacted},
+}
+
+// Redactor removes secrets from text before it is audited
+type Redactor struct {
+	rules []redactRule
+}
+
+// NewRedactor returns a redactor using the built-in rules plus extra
+// patterns, whose whole match is replaced
+func NewRedactor(extra []string) (*Redactor, error) {
+	r := &Redactor{rules: append([]redactRule(nil), defaultRedactRules...)}
+	for _, pattern := range extra {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, redactRule{re: re, repl: Redacted})
+	}
+	return r, nil
+}
+
+// Redact returns s with every secret replaced by Redacted
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
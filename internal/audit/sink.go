@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+)
+
+// Sink is a destination for audit entries
+type Sink interface {
+	// Name identifies the sink in error messages
+	Name() string
+	Write(entry Entry) error
+	Close() error
+}
+
+// chainResumer is implemented by durable sinks so the hash chain continues
+// across restarts
+type chainResumer interface {
+	lastEntry() (Entry, bool, error)
+}
+
+// LogSink writes entries to the standard logger
+type LogSink struct{}
+
+// NewLogSink creates a sink that writes to the standard logger
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+func (s *LogSink) Name() string { return "log" }
+
+func (s *LogSink) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	log.Printf("AUDIT: %s", string(data))
+	return nil
+}
+
+func (s *LogSink) Close() error { return nil }
+
+// SyslogSink sends entries to the local syslog daemon
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog socket. Entries are logged to
+// the authpriv facility, which syslog daemons usually keep in a file only
+// readable by root.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_AUTHPRIV|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &SyslogSink{writer: w}, nil
+}
+
+func (s *SyslogSink) Name() string { return "syslog" }
+
+func (s *SyslogSink) Write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if entry.Outcome == OutcomeFailure {
+		return s.writer.Warning(string(data))
+	}
+	return s.writer.Info(string(data))
+}
+
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+)
+
+// Source describes where an audited action came from: the web request that
+// caused it, or the machine and sudo user for command-line actions
+type Source struct {
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"` // OpenTelemetry trace of the request
+	Host      string `json:"host,omitempty"`
+	SudoUser  string `json:"sudo_user,omitempty"`
+}
+
+type sourceContextKey struct{}
+
+// NewContext returns a copy of ctx carrying src
+func NewContext(ctx context.Context, src *Source) context.Context {
+	return context.WithValue(ctx, sourceContextKey{}, src)
+}
+
+// SourceFromContext returns the source stored in ctx by NewContext
+func SourceFromContext(ctx context.Context) (*Source, bool) {
+	src, ok := ctx.Value(sourceContextKey{}).(*Source)
+	return src, ok && src != nil
+}
+
+// ProcessSource describes the current process: its host and, when run
+// through sudo, the user who invoked it
+func ProcessSource() *Source {
+	host, _ := os.Hostname()
+	return &Source{
+		Host:     host,
+		SudoUser: os.Getenv("SUDO_USER"),
+	}
+}
+
+// NewRequestID returns a random identifier for correlating the entries
+// logged while handling one request
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
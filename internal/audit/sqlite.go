@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteTimeFormat is fixed-width so timestamps sort and compare as text
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq INTEGER PRIMARY KEY,
+    timestamp TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target);
+
+-- The table is append-only; the hash chain detects changes made by bypassing this
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+`
+
+// SQLiteSink stores entries in the audit_log table of a SQLite database
+type SQLiteSink struct {
+	conn *sql.DB
+	path string
+}
+
+// NewSQLiteSink opens (or creates) the audit database at path
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit db directory: %w", err)
+	}
+
+	conn, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit database: %w", err)
+	}
+	if _, err := conn.Exec(sqliteSchema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create audit schema: %w", err)
+	}
+	if err := addSourceColumn(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to upgrade audit schema: %w", err)
+	}
+
+	return &SQLiteSink{conn: conn, path: path}, nil
+}
+
+// addSourceColumn adds the source column to databases created before
+// entries recorded where they came from
+func addSourceColumn(conn *sql.DB) error {
+	var count int
+	err := conn.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('audit_log') WHERE name = 'source'`).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = conn.Exec(`ALTER TABLE audit_log ADD COLUMN source TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+func (s *SQLiteSink) Name() string { return "sqlite " + s.path }
+
+func (s *SQLiteSink) Write(entry Entry) error {
+	details := ""
+	if len(entry.Details) > 0 {
+		data, err := json.Marshal(entry.Details)
+		if err != nil {
+			return err
+		}
+		details = string(data)
+	}
+	source := ""
+	if entry.Source != nil {
+		data, err := json.Marshal(entry.Source)
+		if err != nil {
+			return err
+		}
+		source = string(data)
+	}
+
+	_, err := s.conn.Exec(`
+		INSERT INTO audit_log (seq, timestamp, action, actor, target, outcome, error, details, source, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Seq, entry.Timestamp.UTC().Format(sqliteTimeFormat), string(entry.Action), entry.Actor,
+		entry.Target, string(entry.Outcome), entry.Error, details, source, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *SQLiteSink) lastEntry() (Entry, bool, error) {
+	rows, err := s.conn.Query(`SELECT ` + sqliteColumns + ` FROM audit_log ORDER BY seq DESC LIMIT 1`)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return Entry{}, false, rows.Err()
+	}
+	entry, err := scanEntry(rows)
+	return entry, err == nil, err
+}
+
+// Entries calls fn for every entry in sequence order
+func (s *SQLiteSink) Entries(ctx context.Context, fn func(Entry) error) error {
+	rows, err := s.conn.QueryContext(ctx, `SELECT `+sqliteColumns+` FROM audit_log ORDER BY seq`)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const sqliteColumns = `seq, timestamp, action, actor, target, outcome, error, details, source, prev_hash, hash`
+
+func scanEntry(rows *sql.Rows) (Entry, error) {
+	var (
+		entry     Entry
+		timestamp string
+		action    string
+		outcome   string
+		details   string
+		source    string
+	)
+	err := rows.Scan(&entry.Seq, &timestamp, &action, &entry.Actor, &entry.Target, &outcome,
+		&entry.Error, &details, &source, &entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to scan audit entry: %w", err)
+	}
+
+	entry.Timestamp, err = time.Parse(sqliteTimeFormat, timestamp)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid timestamp in audit entry %d: %w", entry.Seq, err)
+	}
+	entry.Action = ActionType(action)
+	entry.Outcome = Outcome(outcome)
+	if details != "" {
+		if err := json.Unmarshal([]byte(details), &entry.Details); err != nil {
+			return Entry{}, fmt.Errorf("invalid details in audit entry %d: %w", entry.Seq, err)
+		}
+	}
+	if source != "" {
+		entry.Source = &Source{}
+		if err := json.Unmarshal([]byte(source), entry.Source); err != nil {
+			return Entry{}, fmt.Errorf("invalid source in audit entry %d: %w", entry.Seq, err)
+		}
+	}
+	return entry, nil
+}
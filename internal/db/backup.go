@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupTimeFormat names backup files so they sort by age
+const backupTimeFormat = "20060102T150405.000Z"
+
+// BackupPath returns a timestamped path in dir for a backup taken at t
+func BackupPath(dir string, t time.Time) string {
+	return filepath.Join(dir, "data-"+t.UTC().Format(backupTimeFormat)+".db")
+}
+
+// Backups lists the backups in dir, oldest first
+func Backups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, "data-")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, ".db")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// PruneBackups deletes all but the newest keep backups in dir and returns
+// the paths it deleted
+func PruneBackups(dir string, keep int) ([]string, error) {
+	backups, err := Backups(dir)
+	if err != nil || len(backups) <= keep {
+		return nil, err
+	}
+
+	var removed []string
+	for _, path := range backups[:len(backups)-keep] {
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove backup: %w", err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// Backup writes a consistent copy of the database to dest with SQLite's
+// online backup API, so it is safe while the database is in use. dest must
+// not exist; the copy is a single self-contained file.
+func (db *DB) Backup(ctx context.Context, dest string) error {
+	defer db.instrument(ctx, "backup")()
+
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup %s already exists", dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	target, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	err = copyDatabase(ctx, target, db.conn.DB)
+	if err == nil {
+		// The copy inherits WAL mode; switch it back so it is one file
+		_, err = target.ExecContext(ctx, `PRAGMA journal_mode = DELETE`)
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return os.Chmod(dest, 0600)
+}
+
+// Restore replaces the contents of the database at dest with the backup at
+// src, after checking the backup's integrity. The server must not be running.
+func Restore(ctx context.Context, src, dest string) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	backup, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer backup.Close()
+
+	report, err := check(ctx, backup)
+	if err != nil {
+		return fmt.Errorf("failed to check backup: %w", err)
+	}
+	if !report.OK() {
+		return fmt.Errorf("backup %s failed its integrity check", src)
+	}
+
+	target, err := OpenUnmigrated(dest)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	if err := copyDatabase(ctx, target.conn.DB, backup); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	return nil
+}
+
+// copyDatabase copies every page of src's main database over dst's
+func copyDatabase(ctx context.Context, dst, src *sql.DB) error {
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			backup, err := dstDriver.(*sqlite3.SQLiteConn).Backup("main", srcDriver.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Step returns false without an error while either side is busy
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				select {
+				case <-ctx.Done():
+					backup.Finish()
+					return ctx.Err()
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		})
+	})
+}
+
+// ForeignKeyViolation is a row referring to a parent row that does not exist
+type ForeignKeyViolation struct {
+	Table  string
+	RowID  int64
+	Parent string
+}
+
+// IntegrityReport is the result of checking a database
+type IntegrityReport struct {
+	Problems             []string // From PRAGMA integrity_check
+	ForeignKeyViolations []ForeignKeyViolation
+}
+
+// OK reports whether the check found nothing wrong
+func (r IntegrityReport) OK() bool {
+	return len(r.Problems) == 0 && len(r.ForeignKeyViolations) == 0
+}
+
+// Check runs SQLite's integrity and foreign key checks
+func (db *DB) Check(ctx context.Context) (IntegrityReport, error) {
+	defer db.instrument(ctx, "check")()
+	return check(ctx, db.conn.DB)
+}
+
+func check(ctx context.Context, conn *sql.DB) (IntegrityReport, error) {
+	var report IntegrityReport
+
+	rows, err := conn.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return report, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return report, err
+		}
+		if result != "ok" {
+			report.Problems = append(report.Problems, result)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+
+	rows, err = conn.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return report, fmt.Errorf("failed to run foreign key check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v ForeignKeyViolation
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&v.Table, &rowID, &v.Parent, &fkID); err != nil {
+			return report, err
+		}
+		v.RowID = rowID.Int64
+		report.ForeignKeyViolations = append(report.ForeignKeyViolations, v)
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("failed to run foreign key check: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenEnablesWALAndForeignKeys(t *testing.T) {
+	database, err := Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	var mode string
+	var fk int
+	if err := database.conn.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("expected WAL mode, got %q, %v", mode, err)
+	}
+	if err := database.conn.QueryRow(`PRAGMA foreign_keys`).Scan(&fk); err != nil || fk != 1 {
+		t.Errorf("expected foreign keys to be enforced, got %d, %v", fk, err)
+	}
+	if err := database.SaveSessionTerminal(context.Background(), &SessionTerminal{SessionID: 42, Title: "orphan", Shell: "/bin/bash"}); err == nil {
+		t.Error("expected a terminal for a missing session to be refused")
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := database.CreateSession(ctx, "alice", "before", "", "grid"); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	// Backed up while open
+	backup := BackupPath(filepath.Join(dir, "backups"), time.Now())
+	if err := database.Backup(ctx, backup); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if err := database.Backup(ctx, backup); err == nil {
+		t.Error("expected an existing backup not to be overwritten")
+	}
+	if _, err := os.Stat(backup + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("expected the backup to be a single file, got %v", err)
+	}
+
+	if _, err := database.CreateSession(ctx, "alice", "after", "", ""); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	database.Close()
+
+	if err := Restore(ctx, backup, path); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+
+	database, err = Open(path)
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer database.Close()
+	sessions, err := database.GetAllSessions(ctx)
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Name != "before" || sessions[0].LayoutType != "grid" {
+		t.Errorf("expected only the backed up session, got %+v", sessions)
+	}
+
+	// A file that is not a database is refused
+	bogus := filepath.Join(dir, "bogus.db")
+	os.WriteFile(bogus, []byte("not a database at all, just some text to fill a page"), 0600)
+	if err := Restore(ctx, bogus, path); err == nil {
+		t.Error("expected a corrupt backup to be refused")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := BackupPath(dir, start.Add(time.Duration(i)*time.Hour))
+		os.WriteFile(path, nil, 0600)
+		paths = append(paths, path)
+	}
+	// Other files are left alone
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600)
+	os.WriteFile(filepath.Join(dir, "data-latest.db"), nil, 0600)
+
+	removed, err := PruneBackups(dir, 2)
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != paths[0] || removed[1] != paths[1] {
+		t.Errorf("expected the two oldest to be removed, got %v", removed)
+	}
+	left, _ := Backups(dir)
+	if len(left) != 2 || left[0] != paths[2] || left[1] != paths[3] {
+		t.Errorf("expected the two newest to be kept, got %v", left)
+	}
+	for _, name := range []string{"notes.txt", "data-latest.db"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	database, err := Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	report, err := database.Check(ctx)
+	if err != nil || !report.OK() {
+		t.Fatalf("expected a new database to pass, got %+v, %v", report, err)
+	}
+
+	// Orphaned rows from before foreign keys were enforced
+	conn, err := database.conn.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	for _, stmt := range []string{
+		`PRAGMA foreign_keys = OFF`,
+		`INSERT INTO session_terminals (session_id, terminal_index, title) VALUES (42, 0, 'orphan')`,
+		`PRAGMA foreign_keys = ON`,
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	conn.Close()
+
+	report, err = database.Check(ctx)
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	want := ForeignKeyViolation{Table: "session_terminals", RowID: 1, Parent: "sessions"}
+	if report.OK() || len(report.ForeignKeyViolations) != 1 || report.ForeignKeyViolations[0] != want {
+		t.Errorf("expected %+v, got %+v", want, report)
+	}
+}
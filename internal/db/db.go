@@ -1,8 +1,8 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,63 +10,45 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-//go:embed schema.sql
-var schemaSQL string
-
+// DB is a Store backed by a SQLite file, the default
 type DB struct {
-	conn *sql.DB
+	*sqlStore
 	path string
 }
 
-// Open opens or creates the SQLite database
+// Open opens or creates the SQLite database and applies any pending migrations
 func Open(dbPath string) (*DB, error) {
+	db, err := OpenUnmigrated(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.prepare(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenUnmigrated opens or creates the SQLite database without applying
+// migrations, so they can be inspected or applied explicitly
+func OpenUnmigrated(dbPath string) (*DB, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create db directory: %w", err)
 	}
 
-	// Open database
-	conn, err := sql.Open("sqlite3", dbPath)
+	// Open database. WAL lets backups and readers run alongside writes;
+	// foreign keys must be enabled on every connection.
+	conn, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db := &DB{conn: conn, path: dbPath}
-
-	// Run migrations
-	if err := db.migrate(); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("migration failed: %w", err)
-	}
-
-	// Initialize singleton active_layout if not exists
-	if err := db.initializeActiveLayout(); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("failed to initialize active layout: %w", err)
-	}
-
-	return db, nil
-}
-
-func (db *DB) migrate() error {
-	_, err := db.conn.Exec(schemaSQL)
-	return err
-}
-
-func (db *DB) initializeActiveLayout() error {
-	// Insert default layout if table is empty
-	_, err := db.conn.Exec(`
-		INSERT OR IGNORE INTO active_layout (id, layout_type, terminal_count)
-		VALUES (1, 'horizontal', 2)
-	`)
-	return err
-}
-
-func (db *DB) Close() error {
-	return db.conn.Close()
+	return &DB{sqlStore: newSQLStore(conn, sqliteDialect), path: dbPath}, nil
 }
 
-func (db *DB) Ping() error {
-	return db.conn.Ping()
+// Path returns the database file's path
+func (db *DB) Path() string {
+	return db.path
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Scopes of login failure counters
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// LoginFailure counts recent failed logins for a username or client IP
+type LoginFailure struct {
+	Scope       string // LoginScopeUser or LoginScopeIP
+	Subject     string // The username or IP
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time // Zero if never locked out
+}
+
+// Locked reports whether logins are refused at now
+func (f *LoginFailure) Locked(now time.Time) bool {
+	return now.Before(f.LockedUntil)
+}
+
+// RecordLoginFailure counts a failed login at now and returns the number of
+// failures so far. A counter whose last failure was before since starts again.
+func (db *sqlStore) RecordLoginFailure(ctx context.Context, scope, subject string, now, since time.Time) (int, error) {
+	defer db.instrument(ctx, "record_login_failure")()
+
+	var failures int
+	err := db.conn.QueryRowContext(ctx, `
+		INSERT INTO login_failures (scope, subject, failures, last_failure) VALUES (?, ?, 1, ?)
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure < ? THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures
+	`, scope, subject, now.UTC(), since.UTC()).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin refuses logins for a username or IP until the given time
+func (db *sqlStore) LockLogin(ctx context.Context, scope, subject string, until time.Time) error {
+	defer db.instrument(ctx, "lock_login")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE login_failures SET locked_until = ? WHERE scope = ? AND subject = ?
+	`, until.UTC(), scope, subject)
+	return err
+}
+
+// GetLoginFailure returns the failure counter for a username or IP, or nil
+// if there have been no failures
+func (db *sqlStore) GetLoginFailure(ctx context.Context, scope, subject string) (*LoginFailure, error) {
+	defer db.instrument(ctx, "get_login_failure")()
+
+	f, err := scanLoginFailure(db.conn.QueryRowContext(ctx, `
+		SELECT scope, subject, failures, last_failure, locked_until
+		FROM login_failures WHERE scope = ? AND subject = ?
+	`, scope, subject))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return f, err
+}
+
+// GetLoginFailures returns every failure counter, most recent first
+func (db *sqlStore) GetLoginFailures(ctx context.Context) ([]*LoginFailure, error) {
+	defer db.instrument(ctx, "get_login_failures")()
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT scope, subject, failures, last_failure, locked_until
+		FROM login_failures ORDER BY last_failure DESC, scope, subject
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []*LoginFailure
+	for rows.Next() {
+		f, err := scanLoginFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// ClearLoginFailures forgets the failures of a username or IP, lifting any
+// lockout
+func (db *sqlStore) ClearLoginFailures(ctx context.Context, scope, subject string) error {
+	defer db.instrument(ctx, "clear_login_failures")()
+
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM login_failures WHERE scope = ? AND subject = ?", scope, subject)
+	return err
+}
+
+func scanLoginFailure(row interface{ Scan(...any) error }) (*LoginFailure, error) {
+	f := &LoginFailure{}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&f.Scope, &f.Subject, &f.Failures, &f.LastFailure, &lockedUntil); err != nil {
+		return nil, err
+	}
+	f.LockedUntil = lockedUntil.Time
+	return f, nil
+}
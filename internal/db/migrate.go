@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFiles holds a directory of migrations for each dialect
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change. Migrations are applied in version
+// order, each at most once and in its own transaction.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// String returns the migration's file name without the extension
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationState is whether a migration has been applied to a database
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time // Zero while pending
+	Unknown   bool      // Applied by a newer version of StratusShell
+}
+
+// Applied reports whether the migration has been applied
+func (s MigrationState) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// migrationFile matches migration file names: 0001_description.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// migrations returns the dialect's migrations in version order
+func (d *dialect) loadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, d.migrations)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	seen := make(map[int]string)
+	var migrations []Migration
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q (want 0001_description.sql)", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version < 1 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: match[2], SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies the migrations the database is missing and returns them.
+// It refuses to touch a database migrated by a newer version of StratusShell.
+func (db *sqlStore) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := db.conn.dialect.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return db.migrate(ctx, migrations)
+}
+
+func (db *sqlStore) migrate(ctx context.Context, migrations []Migration) ([]Migration, error) {
+	defer db.instrument(ctx, "migrate")()
+
+	if _, err := db.conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at `+db.conn.dialect.timestamp+` DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	states, err := db.migrationStates(ctx, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, state := range states {
+		if state.Unknown {
+			return applied, fmt.Errorf("database has migration %s, which this version of StratusShell does not know; upgrade StratusShell", state.Migration)
+		}
+	}
+	for _, state := range states {
+		if state.Applied() {
+			continue
+		}
+		ran, err := db.applyMigration(ctx, state.Migration)
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied = append(applied, state.Migration)
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration runs a migration and records it in one transaction, so a
+// failed migration leaves no trace. It reports false if another server
+// sharing the database applied the migration first.
+func (db *sqlStore) applyMigration(ctx context.Context, m Migration) (bool, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rebind := db.conn.dialect.rebind
+	if lock := db.conn.dialect.migrationLock; lock != "" {
+		if _, err := tx.ExecContext(ctx, lock); err != nil {
+			return false, fmt.Errorf("failed to lock migrations: %w", err)
+		}
+	}
+	var done int
+	if err := tx.QueryRowContext(ctx, rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), m.Version).Scan(&done); err != nil {
+		return false, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	if done > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, fmt.Errorf("migration %s failed: %w", m, err)
+	}
+	if _, err := tx.ExecContext(ctx, rebind(`
+		INSERT INTO schema_migrations (version, name) VALUES (?, ?)
+	`), m.Version, m.Name); err != nil {
+		return false, fmt.Errorf("failed to record migration %s: %w", m, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %s: %w", m, err)
+	}
+	return true, nil
+}
+
+// MigrationStatus lists the built-in migrations and any unknown ones the
+// database has, with when each was applied
+func (db *sqlStore) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := db.conn.dialect.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return db.migrationStates(ctx, migrations)
+}
+
+func (db *sqlStore) migrationStates(ctx context.Context, migrations []Migration) ([]MigrationState, error) {
+	// Databases from before migrations were tracked have no table yet
+	var tables int
+	if err := db.conn.QueryRowContext(ctx, db.conn.dialect.tableExists, "schema_migrations").Scan(&tables); err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	applied := make(map[int]MigrationState)
+	if tables > 0 {
+		if err := db.readAppliedMigrations(ctx, applied); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Migration: m}
+		if s, ok := applied[m.Version]; ok {
+			state.AppliedAt = s.AppliedAt
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+	for _, s := range applied {
+		s.Unknown = true
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+func (db *sqlStore) readAppliedMigrations(ctx context.Context, applied map[int]MigrationState) error {
+	rows, err := db.conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s MigrationState
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return err
+		}
+		applied[s.Version] = s
+	}
+	return rows.Err()
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// openBaseline creates a database with the schema StratusShell created
+// before migrations were tracked, holding a saved session
+func openBaseline(t *testing.T) string {
+	t.Helper()
+
+	schema, err := os.ReadFile("testdata/baseline_schema.sql")
+	if err != nil {
+		t.Fatalf("failed to read baseline schema: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "data.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer conn.Close()
+
+	for _, stmt := range []string{
+		string(schema),
+		`INSERT INTO sessions (name, description) VALUES ('dev', 'old session')`,
+		`INSERT INTO session_terminals (session_id, terminal_index, title, working_dir) VALUES (1, 0, 'shell', '/tmp')`,
+		`INSERT INTO active_layout (id, layout_type, terminal_count) VALUES (1, 'grid', 4)`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("failed to set up baseline: %v", err)
+		}
+	}
+	return path
+}
+
+func TestMigrateFromBaseline(t *testing.T) {
+	ctx := context.Background()
+	path := openBaseline(t)
+
+	database, err := OpenUnmigrated(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	states, err := database.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, s := range states {
+		if s.Applied() {
+			t.Errorf("expected %s to be pending", s.Migration)
+		}
+	}
+	database.Close()
+
+	// Open applies everything and keeps existing data
+	database, err = Open(path)
+	if err != nil {
+		t.Fatalf("failed to migrate baseline: %v", err)
+	}
+	defer database.Close()
+
+	states, err = database.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	migrations, _ := sqliteDialect.loadMigrations()
+	if len(states) != len(migrations) {
+		t.Fatalf("expected %d migrations, got %d", len(migrations), len(states))
+	}
+	for _, s := range states {
+		if !s.Applied() || s.Unknown {
+			t.Errorf("expected %s to be applied, got %+v", s.Migration, s)
+		}
+	}
+
+	session, err := database.GetSession(ctx, 1)
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if session.Name != "dev" || session.LayoutType != "" {
+		t.Errorf("unexpected migrated session: %+v", session)
+	}
+	terminals, err := database.GetSessionTerminals(ctx, 1)
+	if err != nil || len(terminals) != 1 || terminals[0].Title != "shell" {
+		t.Errorf("expected the session's terminal to survive, got %v, %v", terminals, err)
+	}
+	layout, err := database.GetActiveLayout(ctx)
+	if err != nil || layout.LayoutType != "grid" {
+		t.Errorf("expected the active layout to survive, got %+v, %v", layout, err)
+	}
+
+	// Tables added after the baseline exist, as do new columns
+	if _, err := database.conn.Exec(`SELECT COUNT(*) FROM terminal_exits`); err != nil {
+		t.Errorf("expected terminal_exits to be created: %v", err)
+	}
+	id, err := database.CreateSession(ctx, "alice", "grid", "", "grid")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if layoutType, err := database.GetSessionLayoutType(ctx, id); err != nil || layoutType != "grid" {
+		t.Errorf("expected grid layout, got %q, %v", layoutType, err)
+	}
+
+	// Nothing is left to apply
+	applied, err := database.Migrate(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected no migrations to apply, got %v, %v", applied, err)
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	database, err := OpenUnmigrated(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	migrations := []Migration{
+		{Version: 1, Name: "create", SQL: `CREATE TABLE things (id INTEGER PRIMARY KEY)`},
+		{Version: 2, Name: "broken", SQL: `ALTER TABLE things ADD COLUMN name TEXT; INSERT INTO missing VALUES (1)`},
+	}
+	applied, err := database.migrate(ctx, migrations)
+	if err == nil || !strings.Contains(err.Error(), "0002_broken") {
+		t.Fatalf("expected migration 0002_broken to fail, got %v", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("expected only the first migration to be applied, got %v", applied)
+	}
+
+	// The column added before the failure was rolled back with it
+	if _, err := database.conn.Exec(`SELECT name FROM things`); err == nil {
+		t.Error("expected the failed migration's changes to be rolled back")
+	}
+	states, err := database.migrationStates(ctx, migrations)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if !states[0].Applied() || states[1].Applied() {
+		t.Errorf("unexpected states: %+v", states)
+	}
+
+	// Once fixed, it applies
+	migrations[1].SQL = `ALTER TABLE things ADD COLUMN name TEXT`
+	if applied, err := database.migrate(ctx, migrations); err != nil || len(applied) != 1 {
+		t.Errorf("expected the fixed migration to apply, got %v, %v", applied, err)
+	}
+}
+
+func TestMigrateRejectsNewerDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.db")
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := database.conn.Exec(`INSERT INTO schema_migrations (version, name) VALUES (9999, 'from_the_future')`); err != nil {
+		t.Fatalf("failed to record migration: %v", err)
+	}
+	states, err := database.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if last := states[len(states)-1]; !last.Unknown || last.String() != "9999_from_the_future" {
+		t.Errorf("expected the unknown migration to be listed, got %+v", last)
+	}
+	database.Close()
+
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "9999_from_the_future") {
+		t.Errorf("expected a newer database to be refused, got %v", err)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"m/0010_later.sql":  {Data: []byte("SELECT 1")},
+				"m/0002_second.sql": {Data: []byte("SELECT 1")},
+				"m/0001_first.sql":  {Data: []byte("SELECT 1")},
+			},
+			want: []string{"0001_first", "0002_second", "0010_later"},
+		},
+		{
+			name:    "bad name",
+			files:   fstest.MapFS{"m/first.sql": {}},
+			wantErr: "invalid migration file name",
+		},
+		{
+			name:    "version zero",
+			files:   fstest.MapFS{"m/0000_zero.sql": {}},
+			wantErr: "invalid migration version",
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"m/0001_a.sql": {},
+				"m/01_b.sql":   {},
+			},
+			wantErr: "same version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "m")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, m := range migrations {
+				got = append(got, m.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The built-in migrations load, with the same versions for every dialect
+	sqlite, err := sqliteDialect.loadMigrations()
+	if err != nil {
+		t.Fatalf("failed to load SQLite migrations: %v", err)
+	}
+	postgres, err := postgresDialect.loadMigrations()
+	if err != nil {
+		t.Fatalf("failed to load PostgreSQL migrations: %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("expected as many PostgreSQL migrations as SQLite ones, got %d and %d", len(postgres), len(sqlite))
+	}
+	for i := range sqlite {
+		if sqlite[i].String() != postgres[i].String() {
+			t.Errorf("migration %d is %s for SQLite but %s for PostgreSQL", i, sqlite[i], postgres[i])
+		}
+	}
+}
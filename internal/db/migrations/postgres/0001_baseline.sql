@@ -0,0 +1,62 @@
+-- Baseline schema, matching the SQLite baseline
+
+-- User preferences
+CREATE TABLE preferences (
+    id SERIAL PRIMARY KEY,
+    key TEXT UNIQUE NOT NULL,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Saved sessions
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Terminal configurations within a session
+CREATE TABLE session_terminals (
+    id SERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    terminal_index INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    shell TEXT DEFAULT '/bin/bash',
+    working_dir TEXT
+);
+
+-- Current active layout (singleton)
+CREATE TABLE active_layout (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    layout_type TEXT NOT NULL CHECK (layout_type IN ('horizontal', 'vertical', 'grid')),
+    terminal_count INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Active terminals (current running state)
+CREATE TABLE active_terminals (
+    id SERIAL PRIMARY KEY,
+    port INTEGER UNIQUE NOT NULL,
+    title TEXT NOT NULL,
+    pid INTEGER NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Exit history of supervised terminal commands (exit codes, crash and restart counts)
+CREATE TABLE terminal_exits (
+    id SERIAL PRIMARY KEY,
+    terminal_id INTEGER NOT NULL,
+    command TEXT NOT NULL,
+    exit_code INTEGER NOT NULL,
+    signal TEXT,
+    crashed BOOLEAN NOT NULL,
+    restarting BOOLEAN NOT NULL,
+    runtime_ms BIGINT NOT NULL,
+    crash_count INTEGER NOT NULL,
+    restart_count INTEGER NOT NULL,
+    exited_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_terminal_exits_terminal ON terminal_exits(terminal_id);
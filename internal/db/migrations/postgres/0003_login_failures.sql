@@ -0,0 +1,10 @@
+-- Failed login attempts, counted per username and per client IP so that
+-- lockouts survive a restart. locked_until is NULL while not locked out.
+CREATE TABLE login_failures (
+    scope TEXT NOT NULL CHECK (scope IN ('user', 'ip')),
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
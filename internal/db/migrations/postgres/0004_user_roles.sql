@@ -0,0 +1,8 @@
+-- Roles of StratusShell users: admin, developer or viewer. Users without a
+-- row get the server's default role.
+CREATE TABLE user_roles (
+    username TEXT PRIMARY KEY,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'developer', 'viewer')),
+    updated_by TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
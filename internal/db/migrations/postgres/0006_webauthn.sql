@@ -0,0 +1,26 @@
+-- WebAuthn user handles: the random ID authenticators give back to say
+-- which user a credential belongs to.
+CREATE TABLE webauthn_users (
+    username TEXT PRIMARY KEY,
+    user_handle BYTEA NOT NULL UNIQUE
+);
+
+-- Registered passkeys and security keys. sign_count is the authenticator's
+-- signature counter, which must increase with every login unless it is
+-- always zero.
+CREATE TABLE webauthn_credentials (
+    id BYTEA PRIMARY KEY,
+    username TEXT NOT NULL,
+    name TEXT NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL DEFAULT '',
+    transports TEXT NOT NULL DEFAULT '',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_webauthn_credentials_username ON webauthn_credentials(username);
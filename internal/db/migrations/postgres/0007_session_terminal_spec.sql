@@ -0,0 +1,5 @@
+-- Environment (KEY=VALUE lines) and restart policy of saved terminals.
+-- Empty for terminals saved before they were recorded, which load with none
+-- and the default policy.
+ALTER TABLE session_terminals ADD COLUMN env TEXT NOT NULL DEFAULT '';
+ALTER TABLE session_terminals ADD COLUMN restart_policy TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- Users who saved each session and started each running terminal. Sessions
+-- saved before owners were recorded have none and are left to admins;
+-- terminals without one are shared.
+ALTER TABLE sessions ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE active_terminals ADD COLUMN owner TEXT NOT NULL DEFAULT '';
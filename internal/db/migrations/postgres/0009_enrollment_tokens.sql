@@ -0,0 +1,8 @@
+-- One-time tokens admins give users to set up their first second factor,
+-- stored as SHA-256 hashes. A user has at most one; redeeming it deletes it.
+CREATE TABLE enrollment_tokens (
+    username TEXT PRIMARY KEY,
+    token_hash TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Layout a session was saved with. NULL for sessions saved before layouts
+-- were recorded, which are given one based on their terminal count.
+ALTER TABLE sessions ADD COLUMN layout_type TEXT
+    CHECK (layout_type IS NULL OR layout_type IN ('horizontal', 'vertical', 'grid'));
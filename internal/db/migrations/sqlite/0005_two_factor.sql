@@ -0,0 +1,19 @@
+-- TOTP second factors. secret is NULL until the user confirms an enrollment,
+-- which is held in pending_secret meanwhile. last_step is the last time step
+-- accepted, so that codes cannot be replayed.
+CREATE TABLE totp_secrets (
+    username TEXT PRIMARY KEY,
+    secret TEXT,
+    pending_secret TEXT,
+    confirmed_at DATETIME,
+    last_step INTEGER NOT NULL DEFAULT 0
+);
+
+-- One-time recovery codes, stored as SHA-256 hashes. used_at is set once a
+-- code has been spent.
+CREATE TABLE recovery_codes (
+    username TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at DATETIME,
+    PRIMARY KEY (username, code_hash)
+);
@@ -0,0 +1,26 @@
+-- WebAuthn user handles: the random ID authenticators give back to say
+-- which user a credential belongs to.
+CREATE TABLE webauthn_users (
+    username TEXT PRIMARY KEY,
+    user_handle BLOB NOT NULL UNIQUE
+);
+
+-- Registered passkeys and security keys. sign_count is the authenticator's
+-- signature counter, which must increase with every login unless it is
+-- always zero.
+CREATE TABLE webauthn_credentials (
+    id BLOB PRIMARY KEY,
+    username TEXT NOT NULL,
+    name TEXT NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type TEXT NOT NULL DEFAULT '',
+    transports TEXT NOT NULL DEFAULT '',
+    aaguid BLOB,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    backup_eligible BOOLEAN NOT NULL DEFAULT 0,
+    backup_state BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME
+);
+
+CREATE INDEX idx_webauthn_credentials_username ON webauthn_credentials(username);
@@ -0,0 +1,9 @@
+-- Command, working directory, environment (KEY=VALUE lines), restart policy
+-- and command recording of running terminals, so that they are restarted as
+-- they were. Terminals recorded before have none and restart as the default
+-- shell.
+ALTER TABLE active_terminals ADD COLUMN shell TEXT NOT NULL DEFAULT '';
+ALTER TABLE active_terminals ADD COLUMN working_dir TEXT NOT NULL DEFAULT '';
+ALTER TABLE active_terminals ADD COLUMN env TEXT NOT NULL DEFAULT '';
+ALTER TABLE active_terminals ADD COLUMN restart_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE active_terminals ADD COLUMN record_commands BOOLEAN NOT NULL DEFAULT 0;
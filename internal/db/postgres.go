@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Postgres is a Store backed by PostgreSQL, which several servers can share
+type Postgres struct {
+	*sqlStore
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn (a postgres:// URL)
+// and applies any pending migrations
+func OpenPostgres(dsn string) (*Postgres, error) {
+	db, err := OpenPostgresUnmigrated(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.prepare(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenPostgresUnmigrated connects to the PostgreSQL database at dsn without
+// applying migrations
+func OpenPostgresUnmigrated(dsn string) (*Postgres, error) {
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &Postgres{sqlStore: newSQLStore(conn, postgresDialect)}, nil
+}
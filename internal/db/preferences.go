@@ -5,7 +5,9 @@ import (
 	"database/sql"
 )
 
-func (db *DB) GetPreference(ctx context.Context, key string) (string, error) {
+func (db *sqlStore) GetPreference(ctx context.Context, key string) (string, error) {
+	defer db.instrument(ctx, "get_preference")()
+
 	var value string
 	err := db.conn.QueryRowContext(ctx, "SELECT value FROM preferences WHERE key = ?", key).Scan(&value)
 	if err == sql.ErrNoRows {
@@ -14,15 +16,19 @@ func (db *DB) GetPreference(ctx context.Context, key string) (string, error) {
 	return value, err
 }
 
-func (db *DB) SetPreference(ctx context.Context, key, value string) error {
+func (db *sqlStore) SetPreference(ctx context.Context, key, value string) error {
+	defer db.instrument(ctx, "set_preference")()
+
 	_, err := db.conn.ExecContext(ctx, `
 		INSERT INTO preferences (key, value) VALUES (?, ?)
-		ON CONFLICT(key) DO UPDATE SET value = ?, updated_at = CURRENT_TIMESTAMP
-	`, key, value, value)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, key, value)
 	return err
 }
 
-func (db *DB) GetAllPreferences(ctx context.Context) (map[string]string, error) {
+func (db *sqlStore) GetAllPreferences(ctx context.Context) (map[string]string, error) {
+	defer db.instrument(ctx, "get_all_preferences")()
+
 	rows, err := db.conn.QueryContext(ctx, "SELECT key, value FROM preferences")
 	if err != nil {
 		return nil, err
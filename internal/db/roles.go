@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// UserRole is the role assigned to a user
+type UserRole struct {
+	Username  string
+	Role      string
+	UpdatedBy string // Who assigned the role
+	UpdatedAt time.Time
+}
+
+// SetUserRole assigns role to username, replacing any earlier role
+func (db *sqlStore) SetUserRole(ctx context.Context, username, role, updatedBy string, now time.Time) error {
+	defer db.instrument(ctx, "set_user_role")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO user_roles (username, role, updated_by, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET
+			role = excluded.role,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, username, role, updatedBy, now.UTC())
+	return err
+}
+
+// GetUserRole returns the role assigned to username, or "" if none is
+func (db *sqlStore) GetUserRole(ctx context.Context, username string) (string, error) {
+	defer db.instrument(ctx, "get_user_role")()
+
+	var role string
+	err := db.conn.QueryRowContext(ctx, "SELECT role FROM user_roles WHERE username = ?", username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetUserRoles returns every assigned role, by username
+func (db *sqlStore) GetUserRoles(ctx context.Context) ([]*UserRole, error) {
+	defer db.instrument(ctx, "get_user_roles")()
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT username, role, updated_by, updated_at FROM user_roles ORDER BY username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*UserRole
+	for rows.Next() {
+		r := &UserRole{}
+		if err := rows.Scan(&r.Username, &r.Role, &r.UpdatedBy, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// DeleteUserRole removes the role assigned to username, leaving the user
+// with the default role
+func (db *sqlStore) DeleteUserRole(ctx context.Context, username string) error {
+	defer db.instrument(ctx, "delete_user_role")()
+
+	_, err := db.conn.ExecContext(ctx, "DELETE FROM user_roles WHERE username = ?", username)
+	return err
+}
@@ -2,13 +2,16 @@ package db
 
 import (
 	"context"
+	"strings"
 	"time"
 )
 
 type Session struct {
 	ID          int
+	Owner       string // User who saved it, empty for sessions saved before owners were recorded
 	Name        string
 	Description string
+	LayoutType  string // Empty for sessions saved before layouts were recorded
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
@@ -18,36 +21,41 @@ type SessionTerminal struct {
 	SessionID     int
 	TerminalIndex int
 	Title         string
-	Shell         string
+	Shell         string // Command line the terminal runs
 	WorkingDir    string
+	Env           []string // Extra KEY=VALUE environment entries
+	RestartPolicy string   // Empty for terminals saved before policies were recorded
 }
 
-func (db *DB) CreateSession(ctx context.Context, name, description string) (int, error) {
-	result, err := db.conn.ExecContext(ctx, `
-		INSERT INTO sessions (name, description) VALUES (?, ?)
-	`, name, description)
-	if err != nil {
-		return 0, err
-	}
-	id, err := result.LastInsertId()
-	return int(id), err
+func (db *sqlStore) CreateSession(ctx context.Context, owner, name, description, layoutType string) (int, error) {
+	defer db.instrument(ctx, "create_session")()
+
+	var id int
+	err := db.conn.QueryRowContext(ctx, `
+		INSERT INTO sessions (owner, name, description, layout_type) VALUES (?, ?, ?, ?) RETURNING id
+	`, owner, name, description, nullString(layoutType)).Scan(&id)
+	return id, err
 }
 
-func (db *DB) GetSession(ctx context.Context, id int) (*Session, error) {
+func (db *sqlStore) GetSession(ctx context.Context, id int) (*Session, error) {
+	defer db.instrument(ctx, "get_session")()
+
 	s := &Session{}
 	err := db.conn.QueryRowContext(ctx, `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, owner, name, description, COALESCE(layout_type, ''), created_at, updated_at
 		FROM sessions WHERE id = ?
-	`, id).Scan(&s.ID, &s.Name, &s.Description, &s.CreatedAt, &s.UpdatedAt)
+	`, id).Scan(&s.ID, &s.Owner, &s.Name, &s.Description, &s.LayoutType, &s.CreatedAt, &s.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-func (db *DB) GetAllSessions(ctx context.Context) ([]*Session, error) {
+func (db *sqlStore) GetAllSessions(ctx context.Context) ([]*Session, error) {
+	defer db.instrument(ctx, "get_all_sessions")()
+
 	rows, err := db.conn.QueryContext(ctx, `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, owner, name, description, COALESCE(layout_type, ''), created_at, updated_at
 		FROM sessions ORDER BY updated_at DESC
 	`)
 	if err != nil {
@@ -58,7 +66,7 @@ func (db *DB) GetAllSessions(ctx context.Context) ([]*Session, error) {
 	var sessions []*Session
 	for rows.Next() {
 		s := &Session{}
-		if err := rows.Scan(&s.ID, &s.Name, &s.Description, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.Owner, &s.Name, &s.Description, &s.LayoutType, &s.CreatedAt, &s.UpdatedAt); err != nil {
 			return nil, err
 		}
 		sessions = append(sessions, s)
@@ -66,17 +74,31 @@ func (db *DB) GetAllSessions(ctx context.Context) ([]*Session, error) {
 	return sessions, rows.Err()
 }
 
-func (db *DB) SaveSessionTerminal(ctx context.Context, sessionID, index int, title, shell, workingDir string) error {
+// CountSessions returns the number of saved sessions
+func (db *sqlStore) CountSessions(ctx context.Context) (int, error) {
+	defer db.instrument(ctx, "count_sessions")()
+
+	var n int
+	err := db.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sessions`).Scan(&n)
+	return n, err
+}
+
+// SaveSessionTerminal adds a terminal to t.SessionID at t.TerminalIndex
+func (db *sqlStore) SaveSessionTerminal(ctx context.Context, t *SessionTerminal) error {
+	defer db.instrument(ctx, "save_session_terminal")()
+
 	_, err := db.conn.ExecContext(ctx, `
-		INSERT INTO session_terminals (session_id, terminal_index, title, shell, working_dir)
-		VALUES (?, ?, ?, ?, ?)
-	`, sessionID, index, title, shell, workingDir)
+		INSERT INTO session_terminals (session_id, terminal_index, title, shell, working_dir, env, restart_policy)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.SessionID, t.TerminalIndex, t.Title, t.Shell, t.WorkingDir, strings.Join(t.Env, "\n"), t.RestartPolicy)
 	return err
 }
 
-func (db *DB) GetSessionTerminals(ctx context.Context, sessionID int) ([]*SessionTerminal, error) {
+func (db *sqlStore) GetSessionTerminals(ctx context.Context, sessionID int) ([]*SessionTerminal, error) {
+	defer db.instrument(ctx, "get_session_terminals")()
+
 	rows, err := db.conn.QueryContext(ctx, `
-		SELECT id, session_id, terminal_index, title, shell, working_dir
+		SELECT id, session_id, terminal_index, title, shell, COALESCE(working_dir, ''), env, restart_policy
 		FROM session_terminals WHERE session_id = ? ORDER BY terminal_index
 	`, sessionID)
 	if err != nil {
@@ -87,19 +109,27 @@ func (db *DB) GetSessionTerminals(ctx context.Context, sessionID int) ([]*Sessio
 	var terminals []*SessionTerminal
 	for rows.Next() {
 		t := &SessionTerminal{}
-		if err := rows.Scan(&t.ID, &t.SessionID, &t.TerminalIndex, &t.Title, &t.Shell, &t.WorkingDir); err != nil {
+		var env string
+		if err := rows.Scan(&t.ID, &t.SessionID, &t.TerminalIndex, &t.Title, &t.Shell, &t.WorkingDir, &env, &t.RestartPolicy); err != nil {
 			return nil, err
 		}
+		if env != "" {
+			t.Env = strings.Split(env, "\n")
+		}
 		terminals = append(terminals, t)
 	}
 	return terminals, rows.Err()
 }
 
-// GetSessionLayoutType retrieves the layout type for a session
-// Currently returns empty string as sessions table doesn't store layout type
-// This causes fallback to default layout logic in handlers
-// TODO: Add layout_type column to sessions table if session-specific layouts are needed
-func (db *DB) GetSessionLayoutType(_ int) (string, error) {
-	// sessionID parameter will be used when the feature is fully implemented
-	return "", nil
+// GetSessionLayoutType retrieves the layout type a session was saved with.
+// It returns an empty string for sessions saved before layouts were
+// recorded, which callers give a layout based on their terminal count.
+func (db *sqlStore) GetSessionLayoutType(ctx context.Context, sessionID int) (string, error) {
+	defer db.instrument(ctx, "get_session_layout_type")()
+
+	var layoutType string
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COALESCE(layout_type, '') FROM sessions WHERE id = ?
+	`, sessionID).Scan(&layoutType)
+	return layoutType, err
 }
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SessionStore persists saved sessions and the terminals in them
+type SessionStore interface {
+	CreateSession(ctx context.Context, owner, name, description, layoutType string) (int, error)
+	GetSession(ctx context.Context, id int) (*Session, error)
+	GetAllSessions(ctx context.Context) ([]*Session, error)
+	CountSessions(ctx context.Context) (int, error)
+	SaveSessionTerminal(ctx context.Context, t *SessionTerminal) error
+	GetSessionTerminals(ctx context.Context, sessionID int) ([]*SessionTerminal, error)
+	GetSessionLayoutType(ctx context.Context, sessionID int) (string, error)
+}
+
+// PreferenceStore persists user preferences
+type PreferenceStore interface {
+	GetPreference(ctx context.Context, key string) (string, error)
+	SetPreference(ctx context.Context, key, value string) error
+	GetAllPreferences(ctx context.Context) (map[string]string, error)
+}
+
+// TerminalStore persists running terminals and their exit history
+type TerminalStore interface {
+	SaveActiveTerminal(ctx context.Context, t *ActiveTerminal) (int, error)
+	GetActiveTerminals(ctx context.Context) ([]*ActiveTerminal, error)
+	UpdateActiveTerminalTitle(ctx context.Context, id int, title string) error
+	DeleteActiveTerminal(ctx context.Context, id int) error
+	ClearActiveTerminals(ctx context.Context) error
+	RecordTerminalExit(ctx context.Context, e TerminalExit) error
+	GetTerminalExits(ctx context.Context, terminalID int) ([]*TerminalExit, error)
+}
+
+// LayoutStore persists the active terminal layout
+type LayoutStore interface {
+	GetActiveLayout(ctx context.Context) (*ActiveLayout, error)
+	UpdateActiveLayout(ctx context.Context, layoutType string, terminalCount int) error
+}
+
+// LoginFailureStore persists failed login counters and lockouts
+type LoginFailureStore interface {
+	RecordLoginFailure(ctx context.Context, scope, subject string, now, since time.Time) (int, error)
+	LockLogin(ctx context.Context, scope, subject string, until time.Time) error
+	GetLoginFailure(ctx context.Context, scope, subject string) (*LoginFailure, error)
+	GetLoginFailures(ctx context.Context) ([]*LoginFailure, error)
+	ClearLoginFailures(ctx context.Context, scope, subject string) error
+}
+
+// RoleStore persists the roles assigned to users
+type RoleStore interface {
+	SetUserRole(ctx context.Context, username, role, updatedBy string, now time.Time) error
+	GetUserRole(ctx context.Context, username string) (string, error)
+	GetUserRoles(ctx context.Context) ([]*UserRole, error)
+	DeleteUserRole(ctx context.Context, username string) error
+}
+
+// TwoFactorStore persists TOTP secrets, recovery codes and enrollment tokens
+type TwoFactorStore interface {
+	SetPendingTOTP(ctx context.Context, username, secret string) error
+	ConfirmTOTP(ctx context.Context, username string, now time.Time) error
+	GetTOTP(ctx context.Context, username string) (*TOTPSecret, error)
+	UseTOTPStep(ctx context.Context, username string, step int64) (bool, error)
+	DeleteTOTP(ctx context.Context, username string) error
+	SetRecoveryCodes(ctx context.Context, username string, hashes []string) error
+	UseRecoveryCode(ctx context.Context, username, hash string, now time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, username string) (int, error)
+	SetEnrollmentToken(ctx context.Context, username, hash, createdBy string, expiresAt time.Time) error
+	UseEnrollmentToken(ctx context.Context, username, hash string, now time.Time) (bool, error)
+}
+
+// WebAuthnStore persists WebAuthn user handles and registered keys
+type WebAuthnStore interface {
+	GetWebAuthnUser(ctx context.Context, username string) ([]byte, error)
+	EnsureWebAuthnUser(ctx context.Context, username string, handle []byte) ([]byte, error)
+	AddWebAuthnCredential(ctx context.Context, c *WebAuthnCredential) error
+	ListWebAuthnCredentials(ctx context.Context, username string) ([]*WebAuthnCredential, error)
+	UseWebAuthnCredential(ctx context.Context, id []byte, signCount uint32, backupState bool, now time.Time) error
+	DeleteWebAuthnCredential(ctx context.Context, username string, id []byte) error
+}
+
+// Store is a storage backend: SQLite (*DB) or PostgreSQL (*Postgres)
+type Store interface {
+	SessionStore
+	PreferenceStore
+	TerminalStore
+	LayoutStore
+	LoginFailureStore
+	RoleStore
+	TwoFactorStore
+	WebAuthnStore
+
+	Migrate(ctx context.Context) ([]Migration, error)
+	MigrationStatus(ctx context.Context) ([]MigrationState, error)
+	CheckWritable(ctx context.Context) error
+	Ping() error
+	Close() error
+}
+
+// OpenStore opens the backend dsn names, applying pending migrations: a
+// postgres:// or postgresql:// URL for PostgreSQL, otherwise a SQLite file path
+func OpenStore(dsn string) (Store, error) {
+	if IsPostgresDSN(dsn) {
+		return OpenPostgres(dsn)
+	}
+	return Open(dsn)
+}
+
+// OpenStoreUnmigrated is OpenStore without applying migrations
+func OpenStoreUnmigrated(dsn string) (Store, error) {
+	if IsPostgresDSN(dsn) {
+		return OpenPostgresUnmigrated(dsn)
+	}
+	return OpenUnmigrated(dsn)
+}
+
+// IsPostgresDSN reports whether dsn names a PostgreSQL database
+func IsPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// dialect holds what differs between the SQL databases behind a Store
+type dialect struct {
+	name          string // OpenTelemetry db.system.name
+	migrations    string // Directory of migrations in migrationFiles
+	numbered      bool   // Placeholders are $1, $2, ... rather than ?
+	tableExists   string // Counts the tables named by its one parameter
+	timestamp     string // Column type for timestamps
+	migrationLock string // Serializes migrations between servers, if needed
+}
+
+var (
+	sqliteDialect = &dialect{
+		name:        "sqlite",
+		migrations:  "migrations/sqlite",
+		tableExists: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		timestamp:   "TIMESTAMP",
+	}
+	postgresDialect = &dialect{
+		name:          "postgresql",
+		migrations:    "migrations/postgres",
+		numbered:      true,
+		tableExists:   `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`,
+		timestamp:     "TIMESTAMPTZ",
+		migrationLock: `SELECT pg_advisory_xact_lock(7265706f)`,
+	}
+)
+
+// rebind rewrites the ? placeholders in query for the dialect
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conn rebinds placeholders before running queries, so queries are written
+// once with ? for every dialect
+type conn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+// sqlStore implements Store with queries that are portable between the
+// dialects; *DB and *Postgres add what is specific to each
+type sqlStore struct {
+	conn *conn
+}
+
+func newSQLStore(db *sql.DB, d *dialect) *sqlStore {
+	return &sqlStore{conn: &conn{DB: db, dialect: d}}
+}
+
+// prepare applies pending migrations and seeds the active layout
+func (db *sqlStore) prepare(ctx context.Context) error {
+	if _, err := db.Migrate(ctx); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	// Initialize singleton active_layout if not exists
+	if _, err := db.conn.ExecContext(ctx, `
+		INSERT INTO active_layout (id, layout_type, terminal_count)
+		VALUES (1, 'horizontal', 2)
+		ON CONFLICT (id) DO NOTHING
+	`); err != nil {
+		return fmt.Errorf("failed to initialize active layout: %w", err)
+	}
+	return nil
+}
+
+func (db *sqlStore) Close() error {
+	return db.conn.Close()
+}
+
+func (db *sqlStore) Ping() error {
+	return db.conn.Ping()
+}
+
+// CheckWritable confirms the database accepts writes by committing a no-op
+// update; a read-only file or full disk fails here where Ping would not
+func (db *sqlStore) CheckWritable(ctx context.Context) error {
+	defer db.instrument(ctx, "check_writable")()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE active_layout SET id = id WHERE id = 1`); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to write to database: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit to database: %w", err)
+	}
+	return nil
+}
+
+// instrument times a database operation and traces it as a child of ctx's
+// span; call the returned function when the operation completes
+func (db *sqlStore) instrument(ctx context.Context, operation string) func() {
+	observe := metrics.ObserveQuery(operation)
+	_, span := tracing.Tracer().Start(ctx, "db."+operation, trace.WithAttributes(
+		attribute.String("db.system.name", db.conn.dialect.name),
+		attribute.String("db.operation.name", operation),
+	))
+	return func() {
+		span.End()
+		observe()
+	}
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
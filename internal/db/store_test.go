@@ -0,0 +1,558 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// defaultTestPostgres is tried when STRATUSSHELL_TEST_POSTGRES is not set
+const defaultTestPostgres = "postgres://postgres@localhost:5432/postgres?sslmode=disable&connect_timeout=2"
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		store, err := OpenStore(filepath.Join(t.TempDir(), "data.db"))
+		if err != nil {
+			t.Fatalf("failed to open SQLite store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+// TestPostgresStore runs against STRATUSSHELL_TEST_POSTGRES, or a local
+// server, and is skipped if neither is reachable. Each test gets its own schema.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("STRATUSSHELL_TEST_POSTGRES")
+	if dsn == "" {
+		dsn = defaultTestPostgres
+	}
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("invalid PostgreSQL DSN: %v", err)
+	}
+	defer admin.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := admin.PingContext(ctx); err != nil {
+		t.Skipf("no PostgreSQL server available (set STRATUSSHELL_TEST_POSTGRES): %v", err)
+	}
+
+	n := 0
+	testStore(t, func(t *testing.T) Store {
+		n++
+		schema := fmt.Sprintf("stratusshell_test_%d_%d", time.Now().UnixNano(), n)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("failed to create schema: %v", err)
+		}
+		t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("invalid PostgreSQL URL: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+
+		store, err := OpenStore(u.String())
+		if err != nil {
+			t.Fatalf("failed to open PostgreSQL store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+// testStore is the conformance suite every Store must pass
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	t.Run("Migrations", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		if len(states) == 0 {
+			t.Fatal("expected migrations")
+		}
+		for _, s := range states {
+			if !s.Applied() || s.Unknown {
+				t.Errorf("expected %s to be applied, got %+v", s.Migration, s)
+			}
+		}
+		if applied, err := store.Migrate(ctx); err != nil || len(applied) != 0 {
+			t.Errorf("expected nothing to apply, got %v, %v", applied, err)
+		}
+		if err := store.Ping(); err != nil {
+			t.Errorf("ping failed: %v", err)
+		}
+		if err := store.CheckWritable(ctx); err != nil {
+			t.Errorf("expected the store to be writable: %v", err)
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		gridID, err := store.CreateSession(ctx, "alice", "grid", "four terminals", "grid")
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		plainID, err := store.CreateSession(ctx, "", "plain", "", "")
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		if gridID == plainID {
+			t.Fatalf("expected distinct IDs, got %d twice", gridID)
+		}
+
+		session, err := store.GetSession(ctx, gridID)
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		if session.Owner != "alice" || session.Name != "grid" || session.Description != "four terminals" || session.LayoutType != "grid" {
+			t.Errorf("unexpected session: %+v", session)
+		}
+		if session.CreatedAt.IsZero() {
+			t.Error("expected the creation time to be set")
+		}
+		if _, err := store.GetSession(ctx, plainID+100); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for a missing session, got %v", err)
+		}
+
+		for id, want := range map[int]string{gridID: "grid", plainID: ""} {
+			if got, err := store.GetSessionLayoutType(ctx, id); err != nil || got != want {
+				t.Errorf("session %d: expected layout %q, got %q, %v", id, want, got, err)
+			}
+		}
+
+		sessions, err := store.GetAllSessions(ctx)
+		if err != nil || len(sessions) != 2 {
+			t.Errorf("expected 2 sessions, got %d, %v", len(sessions), err)
+		}
+		for _, s := range sessions {
+			if want := map[int]string{gridID: "alice", plainID: ""}[s.ID]; s.Owner != want {
+				t.Errorf("session %d: expected owner %q, got %q", s.ID, want, s.Owner)
+			}
+		}
+		if n, err := store.CountSessions(ctx); err != nil || n != 2 {
+			t.Errorf("expected a count of 2, got %d, %v", n, err)
+		}
+
+		// Terminals come back in index order
+		for i, title := range map[int]string{1: "logs", 0: "editor"} {
+			st := &SessionTerminal{SessionID: gridID, TerminalIndex: i, Title: title, Shell: "vim", WorkingDir: "/src"}
+			if i == 0 {
+				st.Env = []string{"EDITOR=vim", "TERM=xterm-256color"}
+				st.RestartPolicy = "on-failure"
+			}
+			if err := store.SaveSessionTerminal(ctx, st); err != nil {
+				t.Fatalf("failed to save terminal: %v", err)
+			}
+		}
+		terminals, err := store.GetSessionTerminals(ctx, gridID)
+		if err != nil {
+			t.Fatalf("failed to get terminals: %v", err)
+		}
+		if len(terminals) != 2 || terminals[0].Title != "editor" || terminals[1].Title != "logs" {
+			t.Fatalf("unexpected terminals: %+v", terminals)
+		}
+		if terminals[0].SessionID != gridID || terminals[0].Shell != "vim" || terminals[0].WorkingDir != "/src" {
+			t.Errorf("unexpected terminal: %+v", terminals[0])
+		}
+		if len(terminals[0].Env) != 2 || terminals[0].Env[1] != "TERM=xterm-256color" || terminals[0].RestartPolicy != "on-failure" {
+			t.Errorf("expected the environment and restart policy to be kept, got %+v", terminals[0])
+		}
+		if terminals[1].Env != nil || terminals[1].RestartPolicy != "" {
+			t.Errorf("expected no environment or restart policy, got %+v", terminals[1])
+		}
+
+		// Foreign keys are enforced
+		if err := store.SaveSessionTerminal(ctx, &SessionTerminal{SessionID: plainID + 100, Title: "orphan", Shell: "bash"}); err == nil {
+			t.Error("expected a terminal for a missing session to be refused")
+		}
+	})
+
+	t.Run("Preferences", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		if value, err := store.GetPreference(ctx, "theme"); err != nil || value != "" {
+			t.Errorf("expected no value, got %q, %v", value, err)
+		}
+		for _, value := range []string{"light", "dark"} {
+			if err := store.SetPreference(ctx, "theme", value); err != nil {
+				t.Fatalf("failed to set preference: %v", err)
+			}
+		}
+		if err := store.SetPreference(ctx, "font", "mono"); err != nil {
+			t.Fatalf("failed to set preference: %v", err)
+		}
+		if value, err := store.GetPreference(ctx, "theme"); err != nil || value != "dark" {
+			t.Errorf("expected the value to be replaced, got %q, %v", value, err)
+		}
+		prefs, err := store.GetAllPreferences(ctx)
+		if err != nil || len(prefs) != 2 || prefs["theme"] != "dark" || prefs["font"] != "mono" {
+			t.Errorf("unexpected preferences: %v, %v", prefs, err)
+		}
+	})
+
+	t.Run("ActiveTerminals", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		first, err := store.SaveActiveTerminal(ctx, &ActiveTerminal{
+			Port: 9001, Title: "one", Owner: "alice", PID: 100,
+			Shell: "htop -d 10", WorkingDir: "/srv", Env: []string{"A=1", "B=2"}, RestartPolicy: "always", RecordCommands: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to save terminal: %v", err)
+		}
+		second, err := store.SaveActiveTerminal(ctx, &ActiveTerminal{Port: 9002, Title: "two", Owner: "system", PID: 200})
+		if err != nil {
+			t.Fatalf("failed to save terminal: %v", err)
+		}
+		if _, err := store.SaveActiveTerminal(ctx, &ActiveTerminal{Port: 9001, Title: "clash", Owner: "bob", PID: 300}); err == nil {
+			t.Error("expected a duplicate port to be refused")
+		}
+
+		if err := store.UpdateActiveTerminalTitle(ctx, second, "renamed"); err != nil {
+			t.Fatalf("failed to rename terminal: %v", err)
+		}
+		terminals, err := store.GetActiveTerminals(ctx)
+		if err != nil {
+			t.Fatalf("failed to get terminals: %v", err)
+		}
+		if len(terminals) != 2 || terminals[0].ID != first || terminals[0].Owner != "alice" || terminals[1].Title != "renamed" || terminals[1].PID != 200 || terminals[1].Port != 9002 {
+			t.Errorf("unexpected terminals: %+v", terminals)
+		}
+		if got := terminals[0]; got.Shell != "htop -d 10" || got.WorkingDir != "/srv" || strings.Join(got.Env, ",") != "A=1,B=2" || got.RestartPolicy != "always" || !got.RecordCommands {
+			t.Errorf("expected the command spec to be kept, got %+v", got)
+		}
+		if got := terminals[1]; got.Shell != "" || got.Env != nil || got.RecordCommands {
+			t.Errorf("expected no command spec, got %+v", got)
+		}
+
+		if err := store.DeleteActiveTerminal(ctx, first); err != nil {
+			t.Fatalf("failed to delete terminal: %v", err)
+		}
+		if terminals, _ := store.GetActiveTerminals(ctx); len(terminals) != 1 || terminals[0].ID != second {
+			t.Errorf("expected only the second terminal, got %+v", terminals)
+		}
+		if err := store.ClearActiveTerminals(ctx); err != nil {
+			t.Fatalf("failed to clear terminals: %v", err)
+		}
+		if terminals, _ := store.GetActiveTerminals(ctx); len(terminals) != 0 {
+			t.Errorf("expected no terminals, got %+v", terminals)
+		}
+	})
+
+	t.Run("Layout", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		layout, err := store.GetActiveLayout(ctx)
+		if err != nil || layout.LayoutType != "horizontal" || layout.TerminalCount != 2 {
+			t.Errorf("expected the default layout, got %+v, %v", layout, err)
+		}
+		if err := store.UpdateActiveLayout(ctx, "grid", 4); err != nil {
+			t.Fatalf("failed to update layout: %v", err)
+		}
+		if err := store.UpdateActiveLayout(ctx, "diagonal", 3); err == nil {
+			t.Error("expected an unknown layout to be refused")
+		}
+		layout, err = store.GetActiveLayout(ctx)
+		if err != nil || layout.LayoutType != "grid" || layout.TerminalCount != 4 {
+			t.Errorf("expected the grid layout, got %+v, %v", layout, err)
+		}
+	})
+
+	t.Run("TerminalExits", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+
+		exits := []TerminalExit{
+			{TerminalID: 7, Command: "make", ExitCode: 2, Runtime: 1500 * time.Millisecond, Crashes: 1},
+			{TerminalID: 7, Command: "make", ExitCode: -1, Signal: "killed", Crashed: true, Restarting: true, Runtime: time.Second, Crashes: 2, Restarts: 1},
+			{TerminalID: 8, Command: "bash"},
+		}
+		for _, e := range exits {
+			if err := store.RecordTerminalExit(ctx, e); err != nil {
+				t.Fatalf("failed to record exit: %v", err)
+			}
+		}
+
+		got, err := store.GetTerminalExits(ctx, 7)
+		if err != nil {
+			t.Fatalf("failed to get exits: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("expected 2 exits, got %d", len(got))
+		}
+		latest := got[0]
+		if latest.Signal != "killed" || !latest.Crashed || !latest.Restarting || latest.Crashes != 2 || latest.Restarts != 1 || latest.Runtime != time.Second {
+			t.Errorf("unexpected latest exit: %+v", latest)
+		}
+		if got[1].ExitCode != 2 || got[1].Signal != "" || got[1].Crashed || got[1].Runtime != 1500*time.Millisecond {
+			t.Errorf("unexpected earlier exit: %+v", got[1])
+		}
+		if got[1].ExitedAt.IsZero() {
+			t.Error("expected the exit time to be set")
+		}
+	})
+
+	t.Run("LoginFailures", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		if f, err := store.GetLoginFailure(ctx, LoginScopeUser, "alice"); err != nil || f != nil {
+			t.Fatalf("expected no failures, got %+v, %v", f, err)
+		}
+
+		// Failures count up within the window, and start again after it
+		for i, tt := range []struct {
+			at   time.Duration
+			want int
+		}{
+			{0, 1},
+			{time.Minute, 2},
+			{2 * time.Minute, 3},
+			{3 * time.Hour, 1},
+		} {
+			now := start.Add(tt.at)
+			got, err := store.RecordLoginFailure(ctx, LoginScopeUser, "alice", now, now.Add(-time.Hour))
+			if err != nil || got != tt.want {
+				t.Errorf("failure %d: expected count %d, got %d, %v", i, tt.want, got, err)
+			}
+		}
+		if _, err := store.RecordLoginFailure(ctx, LoginScopeIP, "192.0.2.1", start, start); err != nil {
+			t.Fatalf("failed to record failure: %v", err)
+		}
+
+		until := start.Add(4 * time.Hour)
+		if err := store.LockLogin(ctx, LoginScopeUser, "alice", until); err != nil {
+			t.Fatalf("failed to lock: %v", err)
+		}
+		f, err := store.GetLoginFailure(ctx, LoginScopeUser, "alice")
+		if err != nil || f == nil {
+			t.Fatalf("failed to get failures: %+v, %v", f, err)
+		}
+		if f.Failures != 1 || !f.LastFailure.Equal(start.Add(3*time.Hour)) || !f.LockedUntil.Equal(until) {
+			t.Errorf("unexpected failures: %+v", f)
+		}
+		if !f.Locked(until.Add(-time.Second)) || f.Locked(until) {
+			t.Error("expected the lockout to end at LockedUntil")
+		}
+
+		all, err := store.GetLoginFailures(ctx)
+		if err != nil || len(all) != 2 || all[0].Subject != "alice" || !all[1].LockedUntil.IsZero() {
+			t.Errorf("unexpected failures: %+v, %v", all, err)
+		}
+
+		if err := store.ClearLoginFailures(ctx, LoginScopeUser, "alice"); err != nil {
+			t.Fatalf("failed to clear failures: %v", err)
+		}
+		if f, _ := store.GetLoginFailure(ctx, LoginScopeUser, "alice"); f != nil {
+			t.Errorf("expected the failures to be cleared, got %+v", f)
+		}
+		if f, _ := store.GetLoginFailure(ctx, LoginScopeIP, "192.0.2.1"); f == nil {
+			t.Error("expected other counters to be kept")
+		}
+	})
+
+	t.Run("UserRoles", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		if role, err := store.GetUserRole(ctx, "alice"); err != nil || role != "" {
+			t.Fatalf("expected no role, got %q, %v", role, err)
+		}
+		if err := store.SetUserRole(ctx, "alice", "viewer", "root", now); err != nil {
+			t.Fatalf("failed to set role: %v", err)
+		}
+		if err := store.SetUserRole(ctx, "alice", "admin", "bob", now.Add(time.Hour)); err != nil {
+			t.Fatalf("failed to replace role: %v", err)
+		}
+		if err := store.SetUserRole(ctx, "bob", "developer", "root", now); err != nil {
+			t.Fatalf("failed to set role: %v", err)
+		}
+		if err := store.SetUserRole(ctx, "carol", "superuser", "root", now); err == nil {
+			t.Error("expected an unknown role to be rejected")
+		}
+
+		if role, err := store.GetUserRole(ctx, "alice"); err != nil || role != "admin" {
+			t.Errorf("expected admin, got %q, %v", role, err)
+		}
+		roles, err := store.GetUserRoles(ctx)
+		if err != nil || len(roles) != 2 {
+			t.Fatalf("expected 2 roles, got %+v, %v", roles, err)
+		}
+		if r := roles[0]; r.Username != "alice" || r.Role != "admin" || r.UpdatedBy != "bob" || !r.UpdatedAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("unexpected role: %+v", r)
+		}
+
+		if err := store.DeleteUserRole(ctx, "alice"); err != nil {
+			t.Fatalf("failed to delete role: %v", err)
+		}
+		if role, _ := store.GetUserRole(ctx, "alice"); role != "" {
+			t.Errorf("expected the role to be deleted, got %q", role)
+		}
+	})
+
+	t.Run("TwoFactor", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		if secret, err := store.GetTOTP(ctx, "alice"); err != nil || secret.Enabled() {
+			t.Fatalf("expected no secret, got %+v, %v", secret, err)
+		}
+		if err := store.ConfirmTOTP(ctx, "alice", now); err == nil {
+			t.Error("expected confirming without an enrollment to fail")
+		}
+
+		if err := store.SetPendingTOTP(ctx, "alice", "FIRST"); err != nil {
+			t.Fatalf("failed to enroll: %v", err)
+		}
+		if secret, _ := store.GetTOTP(ctx, "alice"); secret.Enabled() || secret.PendingSecret != "FIRST" {
+			t.Errorf("expected a pending enrollment, got %+v", secret)
+		}
+		if err := store.ConfirmTOTP(ctx, "alice", now); err != nil {
+			t.Fatalf("failed to confirm: %v", err)
+		}
+
+		// Enrolling again keeps the confirmed secret until confirmed
+		store.SetPendingTOTP(ctx, "alice", "SECOND")
+		secret, err := store.GetTOTP(ctx, "alice")
+		if err != nil || secret.Secret != "FIRST" || secret.PendingSecret != "SECOND" || !secret.ConfirmedAt.Equal(now) {
+			t.Fatalf("unexpected secret: %+v, %v", secret, err)
+		}
+
+		if ok, err := store.UseTOTPStep(ctx, "alice", 100); !ok || err != nil {
+			t.Errorf("expected step 100 to be accepted, got %v, %v", ok, err)
+		}
+		for _, step := range []int64{100, 99} {
+			if ok, _ := store.UseTOTPStep(ctx, "alice", step); ok {
+				t.Errorf("expected step %d to be refused as a replay", step)
+			}
+		}
+
+		if err := store.SetRecoveryCodes(ctx, "alice", []string{"h1", "h2", "h3"}); err != nil {
+			t.Fatalf("failed to set recovery codes: %v", err)
+		}
+		if ok, err := store.UseRecoveryCode(ctx, "alice", "h2", now); !ok || err != nil {
+			t.Errorf("expected the code to be accepted, got %v, %v", ok, err)
+		}
+		if ok, _ := store.UseRecoveryCode(ctx, "alice", "h2", now); ok {
+			t.Error("expected a spent code to be refused")
+		}
+		if ok, _ := store.UseRecoveryCode(ctx, "bob", "h1", now); ok {
+			t.Error("expected another user's code to be refused")
+		}
+		if n, err := store.CountRecoveryCodes(ctx, "alice"); err != nil || n != 2 {
+			t.Errorf("expected 2 unused codes, got %d, %v", n, err)
+		}
+
+		if err := store.DeleteTOTP(ctx, "alice"); err != nil {
+			t.Fatalf("failed to reset: %v", err)
+		}
+		if secret, _ := store.GetTOTP(ctx, "alice"); secret != nil {
+			t.Errorf("expected the secret to be deleted, got %+v", secret)
+		}
+		if n, _ := store.CountRecoveryCodes(ctx, "alice"); n != 0 {
+			t.Errorf("expected the recovery codes to be deleted, got %d", n)
+		}
+
+		// A new enrollment token replaces the old one, and works once until
+		// it expires
+		expires := now.Add(time.Hour)
+		for _, hash := range []string{"old", "new"} {
+			if err := store.SetEnrollmentToken(ctx, "alice", hash, "root", expires); err != nil {
+				t.Fatalf("failed to set enrollment token: %v", err)
+			}
+		}
+		for _, tt := range []struct {
+			user, hash string
+			at         time.Time
+			want       bool
+		}{
+			{"alice", "old", now, false},
+			{"bob", "new", now, false},
+			{"alice", "new", expires, false},
+			{"alice", "new", now, true},
+			{"alice", "new", now, false},
+		} {
+			if ok, err := store.UseEnrollmentToken(ctx, tt.user, tt.hash, tt.at); ok != tt.want || err != nil {
+				t.Errorf("%s's %q token at %s: expected %v, got %v, %v", tt.user, tt.hash, tt.at, tt.want, ok, err)
+			}
+		}
+	})
+
+	t.Run("WebAuthn", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		if handle, err := store.GetWebAuthnUser(ctx, "alice"); err != nil || handle != nil {
+			t.Fatalf("expected no handle, got %x, %v", handle, err)
+		}
+		handle, err := store.EnsureWebAuthnUser(ctx, "alice", []byte("first"))
+		if err != nil || string(handle) != "first" {
+			t.Fatalf("expected the new handle, got %q, %v", handle, err)
+		}
+		if handle, _ := store.EnsureWebAuthnUser(ctx, "alice", []byte("second")); string(handle) != "first" {
+			t.Errorf("expected the handle to be kept, got %q", handle)
+		}
+
+		for i, name := range []string{"Laptop", "YubiKey"} {
+			err := store.AddWebAuthnCredential(ctx, &WebAuthnCredential{
+				ID:         []byte{byte(i), 0xff},
+				Username:   "alice",
+				Name:       name,
+				PublicKey:  []byte("key"),
+				Transports: []string{"usb", "nfc"},
+				SignCount:  uint32(i),
+				CreatedAt:  now.Add(time.Duration(i) * time.Minute),
+			})
+			if err != nil {
+				t.Fatalf("failed to add %s: %v", name, err)
+			}
+		}
+		if err := store.UseWebAuthnCredential(ctx, []byte{1, 0xff}, 7, true, now.Add(time.Hour)); err != nil {
+			t.Fatalf("failed to record use: %v", err)
+		}
+
+		creds, err := store.ListWebAuthnCredentials(ctx, "alice")
+		if err != nil || len(creds) != 2 {
+			t.Fatalf("expected two keys, got %+v, %v", creds, err)
+		}
+		if c := creds[1]; c.Name != "YubiKey" || c.SignCount != 7 || !c.BackupState || !c.LastUsedAt.Equal(now.Add(time.Hour)) || len(c.Transports) != 2 {
+			t.Errorf("unexpected key: %+v", c)
+		}
+		if !creds[0].LastUsedAt.IsZero() {
+			t.Errorf("expected an unused key, got %+v", creds[0])
+		}
+
+		if err := store.DeleteWebAuthnCredential(ctx, "bob", []byte{0, 0xff}); err != sql.ErrNoRows {
+			t.Errorf("expected another user's key to be left alone, got %v", err)
+		}
+		if err := store.DeleteWebAuthnCredential(ctx, "alice", []byte{0, 0xff}); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		if creds, _ := store.ListWebAuthnCredentials(ctx, "alice"); len(creds) != 1 {
+			t.Errorf("expected one key left, got %d", len(creds))
+		}
+	})
+}
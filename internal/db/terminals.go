@@ -2,15 +2,37 @@ package db
 
 import (
 	"context"
+	"strings"
 	"time"
 )
 
 type ActiveTerminal struct {
-	ID        int
-	Port      int
-	Title     string
-	PID       int
-	CreatedAt time.Time
+	ID             int
+	Port           int
+	Title          string
+	Owner          string // User who started it, empty for terminals saved before owners were recorded
+	PID            int
+	Shell          string // Command line the terminal runs, empty for terminals saved before it was recorded
+	WorkingDir     string
+	Env            []string // Extra KEY=VALUE environment entries
+	RestartPolicy  string
+	RecordCommands bool
+	CreatedAt      time.Time
+}
+
+// TerminalExit records one exit of a supervised terminal command
+type TerminalExit struct {
+	ID         int
+	TerminalID int // active_terminals.id of the terminal
+	Command    string
+	ExitCode   int
+	Signal     string
+	Crashed    bool
+	Restarting bool
+	Runtime    time.Duration
+	Crashes    int // Crash count of the terminal including this exit
+	Restarts   int // Restart count of the terminal before this exit
+	ExitedAt   time.Time
 }
 
 type ActiveLayout struct {
@@ -18,20 +40,25 @@ type ActiveLayout struct {
 	TerminalCount int
 }
 
-func (db *DB) SaveActiveTerminal(ctx context.Context, port int, title string, pid int) (int, error) {
-	result, err := db.conn.ExecContext(ctx, `
-		INSERT INTO active_terminals (port, title, pid) VALUES (?, ?, ?)
-	`, port, title, pid)
-	if err != nil {
-		return 0, err
-	}
-	id, err := result.LastInsertId()
-	return int(id), err
+// SaveActiveTerminal records a running terminal and returns its ID
+func (db *sqlStore) SaveActiveTerminal(ctx context.Context, t *ActiveTerminal) (int, error) {
+	defer db.instrument(ctx, "save_active_terminal")()
+
+	var id int
+	err := db.conn.QueryRowContext(ctx, `
+		INSERT INTO active_terminals
+			(port, title, owner, pid, shell, working_dir, env, restart_policy, record_commands)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id
+	`, t.Port, t.Title, t.Owner, t.PID, t.Shell, t.WorkingDir, strings.Join(t.Env, "\n"),
+		t.RestartPolicy, t.RecordCommands).Scan(&id)
+	return id, err
 }
 
-func (db *DB) GetActiveTerminals(ctx context.Context) ([]*ActiveTerminal, error) {
+func (db *sqlStore) GetActiveTerminals(ctx context.Context) ([]*ActiveTerminal, error) {
+	defer db.instrument(ctx, "get_active_terminals")()
+
 	rows, err := db.conn.QueryContext(ctx, `
-		SELECT id, port, title, pid, created_at
+		SELECT id, port, title, owner, pid, shell, working_dir, env, restart_policy, record_commands, created_at
 		FROM active_terminals ORDER BY id
 	`)
 	if err != nil {
@@ -42,30 +69,43 @@ func (db *DB) GetActiveTerminals(ctx context.Context) ([]*ActiveTerminal, error)
 	var terminals []*ActiveTerminal
 	for rows.Next() {
 		t := &ActiveTerminal{}
-		if err := rows.Scan(&t.ID, &t.Port, &t.Title, &t.PID, &t.CreatedAt); err != nil {
+		var env string
+		if err := rows.Scan(&t.ID, &t.Port, &t.Title, &t.Owner, &t.PID, &t.Shell, &t.WorkingDir, &env,
+			&t.RestartPolicy, &t.RecordCommands, &t.CreatedAt); err != nil {
 			return nil, err
 		}
+		if env != "" {
+			t.Env = strings.Split(env, "\n")
+		}
 		terminals = append(terminals, t)
 	}
 	return terminals, rows.Err()
 }
 
-func (db *DB) UpdateActiveTerminalTitle(ctx context.Context, id int, title string) error {
+func (db *sqlStore) UpdateActiveTerminalTitle(ctx context.Context, id int, title string) error {
+	defer db.instrument(ctx, "update_active_terminal_title")()
+
 	_, err := db.conn.ExecContext(ctx, "UPDATE active_terminals SET title = ? WHERE id = ?", title, id)
 	return err
 }
 
-func (db *DB) DeleteActiveTerminal(ctx context.Context, id int) error {
+func (db *sqlStore) DeleteActiveTerminal(ctx context.Context, id int) error {
+	defer db.instrument(ctx, "delete_active_terminal")()
+
 	_, err := db.conn.ExecContext(ctx, "DELETE FROM active_terminals WHERE id = ?", id)
 	return err
 }
 
-func (db *DB) ClearActiveTerminals(ctx context.Context) error {
+func (db *sqlStore) ClearActiveTerminals(ctx context.Context) error {
+	defer db.instrument(ctx, "clear_active_terminals")()
+
 	_, err := db.conn.ExecContext(ctx, "DELETE FROM active_terminals")
 	return err
 }
 
-func (db *DB) GetActiveLayout(ctx context.Context) (*ActiveLayout, error) {
+func (db *sqlStore) GetActiveLayout(ctx context.Context) (*ActiveLayout, error) {
+	defer db.instrument(ctx, "get_active_layout")()
+
 	layout := &ActiveLayout{}
 	err := db.conn.QueryRowContext(ctx, `
 		SELECT layout_type, terminal_count FROM active_layout WHERE id = 1
@@ -76,10 +116,52 @@ func (db *DB) GetActiveLayout(ctx context.Context) (*ActiveLayout, error) {
 	return layout, nil
 }
 
-func (db *DB) UpdateActiveLayout(ctx context.Context, layoutType string, terminalCount int) error {
+func (db *sqlStore) UpdateActiveLayout(ctx context.Context, layoutType string, terminalCount int) error {
+	defer db.instrument(ctx, "update_active_layout")()
+
 	_, err := db.conn.ExecContext(ctx, `
 		UPDATE active_layout SET layout_type = ?, terminal_count = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = 1
 	`, layoutType, terminalCount)
 	return err
 }
+
+func (db *sqlStore) RecordTerminalExit(ctx context.Context, e TerminalExit) error {
+	defer db.instrument(ctx, "record_terminal_exit")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO terminal_exits
+			(terminal_id, command, exit_code, signal, crashed, restarting, runtime_ms, crash_count, restart_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.TerminalID, e.Command, e.ExitCode, e.Signal, e.Crashed, e.Restarting,
+		e.Runtime.Milliseconds(), e.Crashes, e.Restarts)
+	return err
+}
+
+// GetTerminalExits returns the exit history of a terminal, most recent first
+func (db *sqlStore) GetTerminalExits(ctx context.Context, terminalID int) ([]*TerminalExit, error) {
+	defer db.instrument(ctx, "get_terminal_exits")()
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, terminal_id, command, exit_code, COALESCE(signal, ''), crashed, restarting,
+			runtime_ms, crash_count, restart_count, exited_at
+		FROM terminal_exits WHERE terminal_id = ? ORDER BY id DESC
+	`, terminalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exits []*TerminalExit
+	for rows.Next() {
+		e := &TerminalExit{}
+		var runtimeMS int64
+		if err := rows.Scan(&e.ID, &e.TerminalID, &e.Command, &e.ExitCode, &e.Signal, &e.Crashed,
+			&e.Restarting, &runtimeMS, &e.Crashes, &e.Restarts, &e.ExitedAt); err != nil {
+			return nil, err
+		}
+		e.Runtime = time.Duration(runtimeMS) * time.Millisecond
+		exits = append(exits, e)
+	}
+	return exits, rows.Err()
+}
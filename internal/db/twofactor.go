@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TOTPSecret is a user's TOTP second factor
+type TOTPSecret struct {
+	Username      string
+	Secret        string // Empty until an enrollment is confirmed
+	PendingSecret string // Enrollment waiting for the user to confirm it
+	ConfirmedAt   time.Time
+	LastStep      int64 // Last time step accepted, to refuse replayed codes
+}
+
+// Enabled reports whether logins must give a code
+func (t *TOTPSecret) Enabled() bool {
+	return t != nil && t.Secret != ""
+}
+
+// SetPendingTOTP starts enrolling username with secret. Any confirmed secret
+// stays in use until the enrollment is confirmed.
+func (db *sqlStore) SetPendingTOTP(ctx context.Context, username, secret string) error {
+	defer db.instrument(ctx, "set_pending_totp")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO totp_secrets (username, pending_secret) VALUES (?, ?)
+		ON CONFLICT (username) DO UPDATE SET pending_secret = excluded.pending_secret
+	`, username, secret)
+	return err
+}
+
+// ConfirmTOTP replaces username's secret with their pending one
+func (db *sqlStore) ConfirmTOTP(ctx context.Context, username string, now time.Time) error {
+	defer db.instrument(ctx, "confirm_totp")()
+
+	res, err := db.conn.ExecContext(ctx, `
+		UPDATE totp_secrets SET secret = pending_secret, pending_secret = NULL, confirmed_at = ?, last_step = 0
+		WHERE username = ? AND pending_secret IS NOT NULL
+	`, now.UTC(), username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// GetTOTP returns username's TOTP secret, or nil if they have never enrolled
+func (db *sqlStore) GetTOTP(ctx context.Context, username string) (*TOTPSecret, error) {
+	defer db.instrument(ctx, "get_totp")()
+
+	t := &TOTPSecret{Username: username}
+	var secret, pending sql.NullString
+	var confirmedAt sql.NullTime
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT secret, pending_secret, confirmed_at, last_step FROM totp_secrets WHERE username = ?
+	`, username).Scan(&secret, &pending, &confirmedAt, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Secret, t.PendingSecret, t.ConfirmedAt = secret.String, pending.String, confirmedAt.Time
+	return t, nil
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false if
+// a code for this or a later step was accepted before.
+func (db *sqlStore) UseTOTPStep(ctx context.Context, username string, step int64) (bool, error) {
+	defer db.instrument(ctx, "use_totp_step")()
+
+	res, err := db.conn.ExecContext(ctx, `
+		UPDATE totp_secrets SET last_step = ? WHERE username = ? AND last_step < ?
+	`, step, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteTOTP removes username's TOTP secret and recovery codes
+func (db *sqlStore) DeleteTOTP(ctx context.Context, username string) error {
+	defer db.instrument(ctx, "delete_totp")()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebind := db.conn.dialect.rebind
+	if _, err := tx.ExecContext(ctx, rebind("DELETE FROM totp_secrets WHERE username = ?"), username); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, rebind("DELETE FROM recovery_codes WHERE username = ?"), username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRecoveryCodes replaces username's recovery codes with the given hashes
+func (db *sqlStore) SetRecoveryCodes(ctx context.Context, username string, hashes []string) error {
+	defer db.instrument(ctx, "set_recovery_codes")()
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rebind := db.conn.dialect.rebind
+	if _, err := tx.ExecContext(ctx, rebind("DELETE FROM recovery_codes WHERE username = ?"), username); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, rebind("INSERT INTO recovery_codes (username, code_hash) VALUES (?, ?)"), username, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode spends the recovery code with the given hash. It returns
+// false if username has no such unused code.
+func (db *sqlStore) UseRecoveryCode(ctx context.Context, username, hash string, now time.Time) (bool, error) {
+	defer db.instrument(ctx, "use_recovery_code")()
+
+	res, err := db.conn.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = ? WHERE username = ? AND code_hash = ? AND used_at IS NULL
+	`, now.UTC(), username, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes username has
+func (db *sqlStore) CountRecoveryCodes(ctx context.Context, username string) (int, error) {
+	defer db.instrument(ctx, "count_recovery_codes")()
+
+	var n int
+	err := db.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM recovery_codes WHERE username = ? AND used_at IS NULL
+	`, username).Scan(&n)
+	return n, err
+}
+
+// SetEnrollmentToken gives username a one-time enrollment token with the
+// given hash, replacing any they had
+func (db *sqlStore) SetEnrollmentToken(ctx context.Context, username, hash, createdBy string, expiresAt time.Time) error {
+	defer db.instrument(ctx, "set_enrollment_token")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO enrollment_tokens (username, token_hash, created_by, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (username) DO UPDATE SET
+			token_hash = excluded.token_hash, created_by = excluded.created_by, expires_at = excluded.expires_at
+	`, username, hash, createdBy, expiresAt.UTC())
+	return err
+}
+
+// UseEnrollmentToken spends username's enrollment token. It returns false if
+// they have no token with that hash, or it expired before now.
+func (db *sqlStore) UseEnrollmentToken(ctx context.Context, username, hash string, now time.Time) (bool, error) {
+	defer db.instrument(ctx, "use_enrollment_token")()
+
+	res, err := db.conn.ExecContext(ctx, `
+		DELETE FROM enrollment_tokens WHERE username = ? AND token_hash = ? AND expires_at > ?
+	`, username, hash, now.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// WebAuthnCredential is a registered passkey or security key
+type WebAuthnCredential struct {
+	ID              []byte
+	Username        string
+	Name            string // Chosen by the user to tell their keys apart
+	PublicKey       []byte // COSE-encoded
+	AttestationType string
+	Transports      []string // How the browser can reach it: usb, nfc, internal...
+	AAGUID          []byte   // Identifies the authenticator model
+	SignCount       uint32
+	BackupEligible  bool // Synced passkey rather than one bound to a device
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      time.Time // Zero if never used to sign in
+}
+
+// GetWebAuthnUser returns username's WebAuthn user handle, or nil if they
+// have never registered a key
+func (db *sqlStore) GetWebAuthnUser(ctx context.Context, username string) ([]byte, error) {
+	defer db.instrument(ctx, "get_webauthn_user")()
+
+	var handle []byte
+	err := db.conn.QueryRowContext(ctx, "SELECT user_handle FROM webauthn_users WHERE username = ?", username).Scan(&handle)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return handle, err
+}
+
+// EnsureWebAuthnUser returns username's WebAuthn user handle, giving them
+// handle if they have none yet
+func (db *sqlStore) EnsureWebAuthnUser(ctx context.Context, username string, handle []byte) ([]byte, error) {
+	defer db.instrument(ctx, "ensure_webauthn_user")()
+
+	if _, err := db.conn.ExecContext(ctx, `
+		INSERT INTO webauthn_users (username, user_handle) VALUES (?, ?)
+		ON CONFLICT (username) DO NOTHING
+	`, username, handle); err != nil {
+		return nil, err
+	}
+	var existing []byte
+	err := db.conn.QueryRowContext(ctx, "SELECT user_handle FROM webauthn_users WHERE username = ?", username).Scan(&existing)
+	return existing, err
+}
+
+// AddWebAuthnCredential stores a newly registered key
+func (db *sqlStore) AddWebAuthnCredential(ctx context.Context, c *WebAuthnCredential) error {
+	defer db.instrument(ctx, "add_webauthn_credential")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		INSERT INTO webauthn_credentials (id, username, name, public_key, attestation_type, transports,
+			aaguid, sign_count, backup_eligible, backup_state, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.ID, c.Username, c.Name, c.PublicKey, c.AttestationType, strings.Join(c.Transports, ","),
+		c.AAGUID, int64(c.SignCount), c.BackupEligible, c.BackupState, c.CreatedAt.UTC())
+	return err
+}
+
+// ListWebAuthnCredentials returns username's keys, oldest first
+func (db *sqlStore) ListWebAuthnCredentials(ctx context.Context, username string) ([]*WebAuthnCredential, error) {
+	defer db.instrument(ctx, "list_webauthn_credentials")()
+
+	rows, err := db.conn.QueryContext(ctx, `
+		SELECT id, username, name, public_key, attestation_type, transports, aaguid, sign_count,
+			backup_eligible, backup_state, created_at, last_used_at
+		FROM webauthn_credentials WHERE username = ? ORDER BY created_at, id
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*WebAuthnCredential
+	for rows.Next() {
+		c := &WebAuthnCredential{}
+		var transports string
+		var signCount int64
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Username, &c.Name, &c.PublicKey, &c.AttestationType, &transports, &c.AAGUID,
+			&signCount, &c.BackupEligible, &c.BackupState, &c.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if transports != "" {
+			c.Transports = strings.Split(transports, ",")
+		}
+		c.SignCount = uint32(signCount)
+		c.LastUsedAt = lastUsed.Time
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// UseWebAuthnCredential records a login with a key, and the signature
+// counter and backup state it reported
+func (db *sqlStore) UseWebAuthnCredential(ctx context.Context, id []byte, signCount uint32, backupState bool, now time.Time) error {
+	defer db.instrument(ctx, "use_webauthn_credential")()
+
+	_, err := db.conn.ExecContext(ctx, `
+		UPDATE webauthn_credentials SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE id = ?
+	`, int64(signCount), backupState, now.UTC(), id)
+	return err
+}
+
+// DeleteWebAuthnCredential removes one of username's keys. It returns
+// sql.ErrNoRows if they have no key with that ID.
+func (db *sqlStore) DeleteWebAuthnCredential(ctx context.Context, username string, id []byte) error {
+	defer db.instrument(ctx, "delete_webauthn_credential")()
+
+	res, err := db.conn.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE username = ? AND id = ?", username, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
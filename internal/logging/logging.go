@@ -0,0 +1,97 @@
+// Package logging configures structured logging with log/slog. Packages get
+// a per-component logger from Component; Setup chooses the format and level
+// for all of them, and for anything still using the standard log package.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats accepted by Setup
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// Setup makes a handler writing to w in format, dropping records below
+// level, the default for slog and the standard log package
+func Setup(w io.Writer, format, level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Component returns a logger that tags records with the component's name.
+// It writes through whatever handler is the default when each record is
+// logged, so package-level loggers created before Setup runs still follow it.
+func Component(name string) *slog.Logger {
+	return slog.New(deferredHandler{}).With("component", name)
+}
+
+// deferredHandler passes records to the default handler, applying the
+// attributes and groups added to it along the way
+type deferredHandler struct {
+	apply func(slog.Handler) slog.Handler
+}
+
+func (d deferredHandler) handler() slog.Handler {
+	h := slog.Default().Handler()
+	if d.apply != nil {
+		h = d.apply(h)
+	}
+	return h
+}
+
+func (d deferredHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (d deferredHandler) Handle(ctx context.Context, r slog.Record) error {
+	return d.handler().Handle(ctx, r)
+}
+
+func (d deferredHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return deferredHandler{apply: func(h slog.Handler) slog.Handler {
+		if d.apply != nil {
+			h = d.apply(h)
+		}
+		return h.WithAttrs(attrs)
+	}}
+}
+
+func (d deferredHandler) WithGroup(name string) slog.Handler {
+	return deferredHandler{apply: func(h slog.Handler) slog.Handler {
+		if d.apply != nil {
+			h = d.apply(h)
+		}
+		return h.WithGroup(name)
+	}}
+}
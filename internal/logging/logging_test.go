@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestComponentFollowsSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	// Created before Setup, as package-level loggers are
+	logger := Component("terminal").With("terminal", 3)
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, FormatJSON, "warn"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	logger.Info("dropped below the level")
+	logger.Warn("failed to save terminal", "err", "disk full")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 record, got %q", buf.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected JSON, got %q: %v", lines[0], err)
+	}
+	want := map[string]any{
+		"level":     "WARN",
+		"msg":       "failed to save terminal",
+		"component": "terminal",
+		"terminal":  float64(3),
+		"err":       "disk full",
+	}
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, record[k])
+		}
+	}
+}
+
+func TestSetupRoutesStandardLog(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, FormatText, "info"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	log.Printf("from a library")
+
+	if !strings.Contains(buf.String(), `level=INFO msg="from a library"`) {
+		t.Errorf("expected the standard logger to go through slog, got %q", buf.String())
+	}
+}
+
+func TestSetupRejectsBadOptions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(&buf, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if err := Setup(&buf, FormatText, "verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
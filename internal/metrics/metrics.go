@@ -0,0 +1,136 @@
+// Package metrics defines the server's Prometheus metrics. Collectors are
+// package-level so the packages being instrumented can update them without
+// having a registry passed down to them.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Registry holds every StratusShell metric plus the Go runtime and process
+// collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// TerminalSpawns counts terminal starts by outcome (success or failure)
+	TerminalSpawns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_terminal_spawns_total",
+		Help: "Terminals started, by outcome.",
+	}, []string{"outcome"})
+
+	// TerminalSpawnDuration measures how long starting a terminal takes,
+	// from port allocation until GoTTY is serving
+	TerminalSpawnDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "stratusshell_terminal_spawn_duration_seconds",
+		Help:    "Time taken to start a terminal.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	// TerminalKills counts terminals closed, by reason (user, reaped,
+	// unhealthy or shutdown)
+	TerminalKills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_terminal_kills_total",
+		Help: "Terminals closed, by reason.",
+	}, []string{"reason"})
+
+	// TerminalsActive is the number of open terminals
+	TerminalsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "stratusshell_terminals_active",
+		Help: "Terminals currently open.",
+	})
+
+	// TerminalBytes counts bytes typed into (in) and written by (out) each
+	// open terminal. Series are removed when the terminal closes.
+	TerminalBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_terminal_bytes_total",
+		Help: "Bytes of terminal input and output, by terminal.",
+	}, []string{"terminal", "direction"})
+
+	// WebSocketConnections is the number of browser connections attached to
+	// terminals
+	WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "stratusshell_websocket_connections",
+		Help: "WebSocket connections attached to terminals.",
+	})
+
+	// RateLimitRejections counts requests refused by the rate limiter, by
+	// policy and whether the client was identified by user or IP
+	RateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter, by policy and scope.",
+	}, []string{"policy", "scope"})
+
+	// IPFilterRejections counts requests refused because the client IP is
+	// in a denied range or outside every allowed one
+	IPFilterRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_ip_filter_rejections_total",
+		Help: "Requests rejected by the client IP filter, by rule.",
+	}, []string{"rule"})
+
+	// CSRFFailures counts state-changing requests refused by CSRF
+	// protection: missing, mismatch or invalid token, or a foreign origin
+	CSRFFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_csrf_failures_total",
+		Help: "Requests rejected by CSRF protection, by reason.",
+	}, []string{"reason"})
+
+	// AuthFailures counts failed logins, invalid sessions and requests
+	// refused for lack of permission
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stratusshell_auth_failures_total",
+		Help: "Authentication and authorization failures, by reason.",
+	}, []string{"reason"})
+
+	// DBQueryDuration measures database calls by operation
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stratusshell_db_query_duration_seconds",
+		Help:    "Time taken by database queries, by operation.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		TerminalSpawns,
+		TerminalSpawnDuration,
+		TerminalKills,
+		TerminalsActive,
+		TerminalBytes,
+		WebSocketConnections,
+		RateLimitRejections,
+		IPFilterRejections,
+		CSRFFailures,
+		AuthFailures,
+		DBQueryDuration,
+	)
+}
+
+// ObserveQuery starts timing a database operation; call the returned
+// function when it completes
+func ObserveQuery(operation string) func() {
+	start := time.Now()
+	return func() {
+		DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Value returns the current value of a counter or gauge
+func Value(m prometheus.Metric) float64 {
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		return 0
+	}
+	switch {
+	case out.Counter != nil:
+		return out.Counter.GetValue()
+	case out.Gauge != nil:
+		return out.Gauge.GetValue()
+	}
+	return 0
+}
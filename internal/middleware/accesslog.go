@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusWriter records the status code and size of a response. It passes
+// Flush through so streaming handlers keep working, and Unwrap lets
+// http.ResponseController reach the underlying writer.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+// NewStatusWriter wraps w; the status is 200 until WriteHeader says otherwise
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *StatusWriter) WriteHeader(code int) {
+	w.Status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.Bytes += int64(n)
+	return n, err
+}
+
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessInfo collects details about a request that are only known to inner
+// handlers, such as who it was authenticated as
+type accessInfo struct {
+	user string
+}
+
+type accessInfoKey struct{}
+
+// SetAccessUser records the authenticated user in the access log entry for
+// the request ctx belongs to
+func SetAccessUser(ctx context.Context, user string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.user = user
+	}
+}
+
+// quietPaths are polled by browsers and monitoring; successful requests to
+// them are only logged at debug level
+var quietPaths = []string{"/static/", "/health", "/healthz", "/readyz", "/metrics"}
+
+// AccessLog logs every request with its method, path, status, size, latency,
+// client IP, authenticated user and, inside a traced request, trace ID
+func AccessLog(logger *slog.Logger, proxies TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &accessInfo{}
+		sw := NewStatusWriter(w)
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)))
+
+		level := slog.LevelInfo
+		switch {
+		case sw.Status >= 500:
+			level = slog.LevelError
+		case sw.Status < 400 && isQuietPath(r.URL.Path):
+			level = slog.LevelDebug
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.Status),
+			slog.Int64("bytes", sw.Bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", proxies.ClientIP(r)),
+			slog.String("user", info.user),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+func isQuietPath(path string) bool {
+	for _, p := range quietPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	handler := AccessLog(logger, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetAccessUser(r.Context(), "alice")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+
+	tests := []struct {
+		path   string
+		logged bool
+	}{
+		{"/api/tabs", true},
+		{"/static/bundle.css", false}, // Debug only
+		{"/metrics", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !tt.logged {
+				if buf.Len() != 0 {
+					t.Errorf("expected no record at info level, got %s", buf.String())
+				}
+				return
+			}
+
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("invalid record %q: %v", buf.String(), err)
+			}
+			want := map[string]any{
+				"msg":    "request",
+				"method": "POST",
+				"path":   tt.path,
+				"status": float64(http.StatusCreated),
+				"bytes":  float64(5),
+				"ip":     "192.0.2.1",
+				"user":   "alice",
+			}
+			for k, v := range want {
+				if record[k] != v {
+					t.Errorf("expected %s=%v, got %v", k, v, record[k])
+				}
+			}
+			if _, ok := record["latency"]; !ok {
+				t.Error("expected the latency to be logged")
+			}
+		})
+	}
+}
+
+func TestStatusWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = NewStatusWriter(rec)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected StatusWriter to implement http.Flusher")
+	}
+	flusher.Flush()
+	if !rec.Flushed {
+		t.Error("expected Flush to reach the underlying writer")
+	}
+}
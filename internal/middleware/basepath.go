@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type basePathKey struct{}
+
+// NormalizeBasePath validates a URL path prefix such as "/shell" and removes
+// any trailing slash. "" and "/" both mean the root.
+func NormalizeBasePath(prefix string) (string, error) {
+	prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?#\\") {
+		return "", fmt.Errorf("invalid base path %q: expected a path like /shell", prefix)
+	}
+	for _, segment := range strings.Split(prefix[1:], "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid base path %q: expected a path like /shell", prefix)
+		}
+	}
+	return prefix, nil
+}
+
+// StripBasePath serves next under a URL path prefix, removing the prefix from
+// request paths and recording it in the context for building URLs. Requests
+// outside the prefix are not found, and the bare prefix redirects to the
+// prefix with a trailing slash.
+func StripBasePath(prefix string, next http.Handler) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			target := prefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, prefix+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, prefix))
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + path
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// BasePath returns the URL path prefix the request was served under, or ""
+// at the root
+func BasePath(ctx context.Context) string {
+	prefix, _ := ctx.Value(basePathKey{}).(string)
+	return prefix
+}
+
+// URL returns the public path of an absolute application path such as
+// "/api/tabs", adding the base path the request was served under
+func URL(ctx context.Context, path string) string {
+	return BasePath(ctx) + path
+}
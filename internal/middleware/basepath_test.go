@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"/shell", "/shell"},
+		{"/shell/", "/shell"},
+		{" /tools/shell ", "/tools/shell"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeBasePath(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeBasePath(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"shell", "/a//b", "/a/../b", "/shell?x", "/shell#x"} {
+		if _, err := NormalizeBasePath(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestStripBasePath(t *testing.T) {
+	var path, base, url string
+	handler := StripBasePath("/shell", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		base = BasePath(r.Context())
+		url = URL(r.Context(), "/login")
+	}))
+
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		path     string
+		location string
+	}{
+		{"root", "/shell/", http.StatusOK, "/", ""},
+		{"route", "/shell/api/tabs?x=1", http.StatusOK, "/api/tabs", ""},
+		{"terminal", "/shell/term/3/js/gotty.js", http.StatusOK, "/term/3/js/gotty.js", ""},
+		{"bare prefix", "/shell?user=alice", http.StatusMovedPermanently, "", "/shell/?user=alice"},
+		{"outside prefix", "/api/tabs", http.StatusNotFound, "", ""},
+		{"longer segment", "/shellfish/", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, base, url = "", "", ""
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if path != tt.path {
+				t.Errorf("expected path %q, got %q", tt.path, path)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.location {
+				t.Errorf("expected Location %q, got %q", tt.location, loc)
+			}
+			if tt.status == http.StatusOK && (base != "/shell" || url != "/shell/login") {
+				t.Errorf("expected base path /shell and URL /shell/login, got %q and %q", base, url)
+			}
+		})
+	}
+
+	// Without a prefix, URLs are unchanged
+	if got := URL(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "/login"); got != "/login" {
+		t.Errorf("expected /login at the root, got %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies lists the networks of reverse proxies whose
+// X-Forwarded-For headers are believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	return parseNetworks(values, "trusted proxy")
+}
+
+// parseNetworks parses IP addresses, as single-address networks, and CIDR
+// ranges. kind names the list in errors.
+func parseNetworks(values []string, kind string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s %q", kind, value)
+			}
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", kind, value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether ip belongs to any of networks
+func containsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether ip belongs to a trusted proxy
+func (t TrustedProxies) Contains(ip string) bool {
+	return containsIP(t, ip)
+}
+
+// remoteIP returns the IP address of the peer that sent the request
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the address of the client that made the request. When
+// the request comes through trusted proxies, X-Forwarded-For is walked from
+// the nearest hop back, and the first address that is not a trusted proxy
+// is the client. Anything further left could have been forged by the client.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !t.Contains(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// Unparseable hop: stop at the last address we could trust
+			return ip
+		}
+		ip = hops[i]
+		if !t.Contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// FromTrustedProxy reports whether the request was sent by a trusted proxy
+func (t TrustedProxies) FromTrustedProxy(r *http.Request) bool {
+	return t.Contains(remoteIP(r))
+}
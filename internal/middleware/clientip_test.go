@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+		fromTrusted  bool
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7", false},
+		{"untrusted peer cannot spoof", "203.0.113.7:5000", []string{"1.2.3.4"}, "203.0.113.7", false},
+		{"one trusted proxy", "10.0.0.1:5000", []string{"198.51.100.2"}, "198.51.100.2", true},
+		{"chain of trusted proxies", "10.0.0.1:5000", []string{"198.51.100.2, 192.168.1.1"}, "198.51.100.2", true},
+		{"forged hops left of the client", "10.0.0.1:5000", []string{"1.2.3.4, 198.51.100.2"}, "198.51.100.2", true},
+		{"multiple headers", "10.0.0.1:5000", []string{"198.51.100.2", "192.168.1.1"}, "198.51.100.2", true},
+		{"garbage hop", "10.0.0.1:5000", []string{"198.51.100.2, not-an-ip"}, "10.0.0.1", true},
+		{"only proxies", "10.0.0.1:5000", []string{"192.168.1.1"}, "192.168.1.1", true},
+		{"no header", "10.0.0.1:5000", nil, "10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, h := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if got := trusted.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+			if got := trusted.FromTrustedProxy(r); got != tt.fromTrusted {
+				t.Errorf("FromTrustedProxy() = %v, want %v", got, tt.fromTrusted)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"::1", "fd00::/8", " 127.0.0.1 ", ""}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := ParseTrustedProxies([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
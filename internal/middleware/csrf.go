@@ -1,140 +1,160 @@
 package middleware
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"net/http"
-	"sync"
-	"time"
+	"net/url"
+	"strings"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
+)
+
+// CSRFCookie is the cookie holding the CSRF token, and CSRFHeader the header
+// (or, for plain forms, the form field "csrf_token") it must be repeated in
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
 )
 
-// CSRFProtection implements CSRF token validation
+// ErrNoSession is returned by GetToken for requests without an auth session
+var ErrNoSession = errors.New("no session to bind a CSRF token to")
+
+// CSRFProtection implements signed double-submit CSRF tokens. A token is a
+// random nonce and an HMAC binding it to the auth session, so a token
+// planted in another user's cookie, or kept from an earlier session, is
+// rejected. Requests must send the token both in the cookie and in a header
+// or form field, which a cross-site page cannot read or forge.
 type CSRFProtection struct {
-	tokens map[string]time.Time // token -> expiration
-	mu     sync.RWMutex
+	secret  []byte
+	session func(*http.Request) string // ID of the request's auth session, "" if none
 }
 
-// NewCSRFProtection creates a new CSRF protection instance
-func NewCSRFProtection() *CSRFProtection {
-	csrf := &CSRFProtection{
-		tokens: make(map[string]time.Time),
-	}
-
-	// Start cleanup goroutine
-	go csrf.cleanupTokens()
+// NewCSRFProtection creates CSRF protection with a random signing key.
+// session returns the ID of the auth session a request belongs to.
+func NewCSRFProtection(session func(*http.Request) string) *CSRFProtection {
+	secret := make([]byte, 32)
+	rand.Read(secret) // Never fails; it crashes the program instead
+	return &CSRFProtection{secret: secret, session: session}
+}
 
-	return csrf
+// sign returns the MAC binding nonce to sessionID
+func (csrf *CSRFProtection) sign(sessionID, nonce string) string {
+	mac := hmac.New(sha256.New, csrf.secret)
+	mac.Write([]byte(sessionID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
 
-// generateToken creates a new CSRF token
-func (csrf *CSRFProtection) generateToken() (string, error) {
-	b := make([]byte, 32)
+// generateToken creates a new token for sessionID
+func (csrf *CSRFProtection) generateToken(sessionID string) (string, error) {
+	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	return base64.URLEncoding.EncodeToString(b), nil
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return nonce + "." + csrf.sign(sessionID, nonce), nil
+}
+
+// validToken reports whether token was issued for sessionID
+func (csrf *CSRFProtection) validToken(token, sessionID string) bool {
+	nonce, mac, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(csrf.sign(sessionID, nonce)))
 }
 
-// GetToken returns a valid CSRF token for the session
-// If a valid token exists in the cookie, it's reused; otherwise a new one is created
+// GetToken returns the CSRF token for the request's session, reusing the one
+// in its cookie if that is still valid and otherwise setting a new cookie.
+// Pages embed the token so that scripts can send it back in the header.
 func (csrf *CSRFProtection) GetToken(w http.ResponseWriter, r *http.Request) (string, error) {
-	// Check if there's already a valid token in cookie
-	cookie, err := r.Cookie("csrf_token")
-	if err == nil {
-		csrf.mu.RLock()
-		expiration, exists := csrf.tokens[cookie.Value]
-		csrf.mu.RUnlock()
-
-		if exists && time.Now().Before(expiration) {
-			return cookie.Value, nil
-		}
+	sessionID := csrf.session(r)
+	if sessionID == "" {
+		return "", ErrNoSession
 	}
 
-	// Generate new token
-	token, err := csrf.generateToken()
+	if cookie, err := r.Cookie(CSRFCookie); err == nil && csrf.validToken(cookie.Value, sessionID) {
+		return cookie.Value, nil
+	}
+
+	token, err := csrf.generateToken(sessionID)
 	if err != nil {
 		return "", err
 	}
 
-	// Store token with 24 hour expiration
-	expiration := time.Now().Add(24 * time.Hour)
-	csrf.mu.Lock()
-	csrf.tokens[token] = expiration
-	csrf.mu.Unlock()
-
-	// Set cookie
 	http.SetCookie(w, &http.Cookie{
-		Name:     "csrf_token",
+		Name:     CSRFCookie,
 		Value:    token,
-		Path:     "/",
+		Path:     URL(r.Context(), "/"),
 		HttpOnly: true,
+		Secure:   IsHTTPS(r),
 		SameSite: http.SameSiteStrictMode,
-		MaxAge:   86400, // 24 hours
+		MaxAge:   86400, // 24 hours, as long as the session
 	})
 
 	return token, nil
 }
 
-// ValidateToken checks if the provided token is valid
-func (csrf *CSRFProtection) ValidateToken(token string) bool {
-	csrf.mu.RLock()
-	defer csrf.mu.RUnlock()
-
-	expiration, exists := csrf.tokens[token]
-	if !exists {
-		return false
+// sameOrigin checks the Origin header, or failing that the Referer, against
+// the host the request was sent to. Requests with neither are allowed, as
+// some clients and privacy settings omit both; the token check still applies.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+		if source == "" {
+			return true
+		}
 	}
-
-	if time.Now().After(expiration) {
-		return false
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false // Includes "Origin: null"
 	}
-
-	return true
+	return strings.EqualFold(u.Host, r.Host)
 }
 
-// cleanupTokens removes expired tokens
-func (csrf *CSRFProtection) cleanupTokens() {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		csrf.mu.Lock()
-		now := time.Now()
-		for token, expiration := range csrf.tokens {
-			if now.After(expiration) {
-				delete(csrf.tokens, token)
-			}
-		}
-		csrf.mu.Unlock()
-	}
+// reject counts and refuses a request that failed a CSRF check
+func reject(w http.ResponseWriter, reason, message string) {
+	metrics.CSRFFailures.WithLabelValues(reason).Inc()
+	http.Error(w, message, http.StatusForbidden)
 }
 
-// Protect returns a middleware that validates CSRF tokens for state-changing requests
+// Protect returns a middleware that checks state-changing requests come
+// from this site and carry the session's CSRF token in the cookie and in
+// the X-CSRF-Token header or csrf_token form field. It must run after
+// authentication.
 func (csrf *CSRFProtection) Protect(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Only check CSRF for state-changing methods
 		if r.Method == http.MethodPost || r.Method == http.MethodPut ||
 			r.Method == http.MethodDelete || r.Method == http.MethodPatch {
 
-			// Get token from header or form
-			token := r.Header.Get("X-CSRF-Token")
+			if !sameOrigin(r) {
+				reject(w, "origin", "Cross-origin request refused")
+				return
+			}
+
+			token := r.Header.Get(CSRFHeader)
 			if token == "" {
-				// Try to get from form data (for regular form submissions)
 				token = r.FormValue("csrf_token")
 			}
-			if token == "" {
-				// Try to get from cookie for comparison
-				cookie, err := r.Cookie("csrf_token")
-				if err != nil {
-					http.Error(w, "CSRF token missing", http.StatusForbidden)
-					return
-				}
-				token = cookie.Value
+			cookie, err := r.Cookie(CSRFCookie)
+			if token == "" || err != nil {
+				reject(w, "missing", "CSRF token missing")
+				return
 			}
-
-			// Validate token
-			if !csrf.ValidateToken(token) {
-				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+				reject(w, "mismatch", "Invalid CSRF token")
+				return
+			}
+			if !csrf.validToken(token, csrf.session(r)) {
+				reject(w, "invalid", "Invalid CSRF token")
 				return
 			}
 		}
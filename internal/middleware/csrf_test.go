@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// sessionHeader stands in for the session cookie in these tests
+const sessionHeader = "X-Test-Session"
+
+func testCSRF() *CSRFProtection {
+	return NewCSRFProtection(func(r *http.Request) string { return r.Header.Get(sessionHeader) })
+}
+
+// issueToken returns the token GetToken gives session
+func issueToken(t *testing.T, csrf *CSRFProtection, session string) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(sessionHeader, session)
+	rec := httptest.NewRecorder()
+	token, err := csrf.GetToken(rec, r)
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Fatalf("expected an HttpOnly cookie holding the token, got %+v", cookies)
+	}
+	return token
+}
+
+func TestGetTokenReusesValidCookie(t *testing.T) {
+	csrf := testCSRF()
+	token := issueToken(t, csrf, "alice-session")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(sessionHeader, "alice-session")
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+	rec := httptest.NewRecorder()
+	if got, err := csrf.GetToken(rec, r); err != nil || got != token {
+		t.Errorf("expected the cookie's token to be reused, got %q, %v", got, err)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("expected no new cookie")
+	}
+
+	// A token from another session is replaced
+	r.Header.Set(sessionHeader, "bob-session")
+	if got, _ := csrf.GetToken(httptest.NewRecorder(), r); got == token {
+		t.Error("expected a new token for a different session")
+	}
+
+	r.Header.Del(sessionHeader)
+	if _, err := csrf.GetToken(httptest.NewRecorder(), r); err != ErrNoSession {
+		t.Errorf("expected ErrNoSession, got %v", err)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	csrf := testCSRF()
+	alice := issueToken(t, csrf, "alice-session")
+	bob := issueToken(t, csrf, "bob-session")
+
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		form    string
+		cookie  string
+		origin  string
+		referer string
+		want    int
+	}{
+		{name: "safe method", method: http.MethodGet, want: http.StatusOK},
+		{name: "header token", method: http.MethodPost, header: alice, cookie: alice, want: http.StatusOK},
+		{name: "form token", method: http.MethodPost, form: alice, cookie: alice, want: http.StatusOK},
+		{name: "delete", method: http.MethodDelete, header: alice, cookie: alice, want: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, header: alice, cookie: alice, origin: "http://example.com", want: http.StatusOK},
+		{name: "same referer", method: http.MethodPost, header: alice, cookie: alice, referer: "http://example.com/page", want: http.StatusOK},
+		{name: "cookie alone", method: http.MethodPost, cookie: alice, want: http.StatusForbidden},
+		{name: "header alone", method: http.MethodPost, header: alice, want: http.StatusForbidden},
+		{name: "header and cookie differ", method: http.MethodPost, header: alice, cookie: "forged.token", want: http.StatusForbidden},
+		{name: "another session's token", method: http.MethodPost, header: bob, cookie: bob, want: http.StatusForbidden},
+		{name: "forged token", method: http.MethodPost, header: "nonce.mac", cookie: "nonce.mac", want: http.StatusForbidden},
+		{name: "foreign origin", method: http.MethodPost, header: alice, cookie: alice, origin: "https://evil.example", want: http.StatusForbidden},
+		{name: "null origin", method: http.MethodPost, header: alice, cookie: alice, origin: "null", want: http.StatusForbidden},
+		{name: "foreign referer", method: http.MethodPost, header: alice, cookie: alice, referer: "https://evil.example/", want: http.StatusForbidden},
+	}
+
+	handler := csrf.Protect(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *strings.Reader
+			if tt.form != "" {
+				body = strings.NewReader(url.Values{"csrf_token": {tt.form}}.Encode())
+			} else {
+				body = strings.NewReader("")
+			}
+			r := httptest.NewRequest(tt.method, "http://example.com/api/terminals/add", body)
+			if tt.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			r.Header.Set(sessionHeader, "alice-session")
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookie})
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
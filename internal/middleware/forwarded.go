@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// firstValue returns the first entry of a comma-separated forwarded header,
+// which the proxy nearest the client added
+func firstValue(r *http.Request, header string) string {
+	value, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(value)
+}
+
+// ProxyHeaders restores the scheme and host the client used from the
+// X-Forwarded-Proto and X-Forwarded-Host headers of requests sent by trusted
+// proxies, so origin checks, secure cookies and proxied terminals see the
+// public URL. The headers of other peers are ignored.
+func ProxyHeaders(proxies TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A client can put any scheme in an absolute request URI, so only
+		// the connection and trusted proxies decide it
+		r.URL.Scheme = "http"
+		if r.TLS != nil {
+			r.URL.Scheme = "https"
+		}
+		if proxies.FromTrustedProxy(r) {
+			if proto := strings.ToLower(firstValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+				r.URL.Scheme = proto
+			}
+			if host := firstValue(r, "X-Forwarded-Host"); host != "" {
+				r.Host = host
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsHTTPS reports whether the client connected over HTTPS, directly or, as
+// restored by ProxyHeaders, through a trusted proxy
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
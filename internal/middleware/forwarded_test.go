@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+
+	var host string
+	var https bool
+	handler := ProxyHeaders(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		https = IsHTTPS(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		fwdHost    string
+		wantHost   string
+		wantHTTPS  bool
+	}{
+		{"direct", "203.0.113.7:5000", false, "", "", "localhost:8080", false},
+		{"direct TLS", "203.0.113.7:5000", true, "", "", "localhost:8080", true},
+		{"untrusted peer cannot spoof", "203.0.113.7:5000", false, "https", "evil.example", "localhost:8080", false},
+		{"trusted proxy", "10.0.0.1:5000", false, "https", "tools.example", "tools.example", true},
+		{"proxy chain uses the client's", "10.0.0.1:5000", false, "https, http", "tools.example, internal:8080", "tools.example", true},
+		{"trusted proxy over HTTP", "10.0.0.1:5000", false, "HTTP", "", "localhost:8080", false},
+		{"unknown scheme ignored", "10.0.0.1:5000", false, "gopher", "", "localhost:8080", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.fwdHost != "" {
+				r.Header.Set("X-Forwarded-Host", tt.fwdHost)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if host != tt.wantHost {
+				t.Errorf("expected host %q, got %q", tt.wantHost, host)
+			}
+			if https != tt.wantHTTPS {
+				t.Errorf("expected IsHTTPS %v, got %v", tt.wantHTTPS, https)
+			}
+		})
+	}
+
+	// An absolute request URI does not make a plain connection HTTPS
+	r := httptest.NewRequest(http.MethodGet, "https://localhost:8080/", nil)
+	r.TLS = nil
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if https {
+		t.Error("expected an https request URI over plain HTTP not to count as HTTPS")
+	}
+}
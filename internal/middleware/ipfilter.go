@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
+)
+
+// IPFilter restricts which client IPs may use the server. Denied ranges
+// always win; when allowed ranges are given, every other address is refused.
+// The client IP is taken from X-Forwarded-For only when a trusted proxy sent
+// the request.
+type IPFilter struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies TrustedProxies
+}
+
+// NewIPFilter parses the allowed and denied IP addresses and CIDR ranges. It
+// returns nil, a filter that lets everyone through, if both are empty.
+func NewIPFilter(allow, deny []string, proxies TrustedProxies) (*IPFilter, error) {
+	allowed, err := parseNetworks(allow, "allowed network")
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parseNetworks(deny, "denied network")
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	return &IPFilter{allow: allowed, deny: denied, proxies: proxies}, nil
+}
+
+// check returns why ip is refused, or "" if it is allowed
+func (f *IPFilter) check(ip string) string {
+	if containsIP(f.deny, ip) {
+		return "deny"
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, ip) {
+		return "not_allowed"
+	}
+	return ""
+}
+
+// Allowed reports whether ip may use the server
+func (f *IPFilter) Allowed(ip string) bool {
+	return f == nil || f.check(ip) == ""
+}
+
+// Filter returns a middleware that refuses requests from clients the filter
+// does not allow with 403 Forbidden
+func (f *IPFilter) Filter(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rule := f.check(f.proxies.ClientIP(r)); rule != "" {
+			metrics.IPFilterRejections.WithLabelValues(rule).Inc()
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+	f, err := NewIPFilter([]string{"192.0.2.0/24", "2001:db8::/32"}, []string{"192.0.2.66"}, proxies)
+	if err != nil {
+		t.Fatalf("failed to parse filter: %v", err)
+	}
+	handler := f.Filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         int
+	}{
+		{"allowed", "192.0.2.10:5000", "", http.StatusOK},
+		{"allowed IPv6", "[2001:db8::1]:5000", "", http.StatusOK},
+		{"denied within allowed", "192.0.2.66:5000", "", http.StatusForbidden},
+		{"not allowed", "198.51.100.1:5000", "", http.StatusForbidden},
+		{"allowed through trusted proxy", "10.0.0.1:5000", "192.0.2.10", http.StatusOK},
+		{"denied through trusted proxy", "10.0.0.1:5000", "192.0.2.66", http.StatusForbidden},
+		{"untrusted peer cannot spoof", "198.51.100.1:5000", "192.0.2.10", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	// Deny-only filters let everyone else through
+	f, _ = NewIPFilter(nil, []string{"203.0.113.0/24"}, nil)
+	if !f.Allowed("192.0.2.1") || f.Allowed("203.0.113.5") {
+		t.Error("expected a deny-only filter to refuse only denied addresses")
+	}
+
+	// No rules, no filter
+	if f, err := NewIPFilter(nil, nil, nil); f != nil || err != nil || !f.Allowed("192.0.2.1") {
+		t.Errorf("expected no filter without rules, got %v, %v", f, err)
+	}
+	if _, err := NewIPFilter([]string{"10.0.0.0/33"}, nil, nil); err == nil {
+		t.Error("expected an invalid range to be rejected")
+	}
+}
@@ -1,108 +1,167 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
 )
 
-// RateLimiter implements a simple token bucket rate limiter per IP
+// Policy is a token bucket: a client may make Limit requests at once, and
+// the bucket refills continuously at Limit per Window
+type Policy struct {
+	Name    string // Identifies the policy in metrics and the RateLimit-Policy header
+	Limit   int
+	Window  time.Duration
+	PerUser bool // Give each signed-in user one bucket, wherever they connect from
+}
+
+// rate returns how many tokens the bucket regains per second
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+// RateLimiter keeps a token bucket per policy and client. Clients are told
+// apart by IP, taken from X-Forwarded-For only when a trusted proxy sent the
+// request, or by user for PerUser policies.
 type RateLimiter struct {
-	visitors map[string]*visitor
-	mu       sync.RWMutex
-	rate     int           // requests per window
-	window   time.Duration // time window
+	proxies TrustedProxies
+	user    func(*http.Request) string // Signed-in user, "" if none
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
 }
 
-type visitor struct {
-	tokens     int
-	lastRefill time.Time
-	mu         sync.Mutex
+type bucket struct {
+	policy  Policy
+	tokens  float64
+	updated time.Time
 }
 
-// NewRateLimiter creates a new rate limiter
-// rate: number of requests allowed per window
-// window: time window duration
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
+// NewRateLimiter creates a rate limiter. user returns who a request is
+// signed in as, or "" for anonymous requests; it may be nil.
+func NewRateLimiter(proxies TrustedProxies, user func(*http.Request) string) *RateLimiter {
 	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate,
-		window:   window,
+		proxies: proxies,
+		user:    user,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
 	}
 
 	// Start cleanup goroutine
-	go rl.cleanupVisitors()
+	go rl.cleanupBuckets()
 
 	return rl
 }
 
-func (rl *RateLimiter) getVisitor(ip string) *visitor {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	v, exists := rl.visitors[ip]
-	if !exists {
-		v = &visitor{
-			tokens:     rl.rate,
-			lastRefill: time.Now(),
-		}
-		rl.visitors[ip] = v
+// refill adds the tokens earned since the bucket was last used
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.policy.Limit), b.tokens+elapsed*b.policy.rate())
+		b.updated = now
 	}
-
-	return v
 }
 
-func (rl *RateLimiter) cleanupVisitors() {
+// cleanupBuckets forgets buckets that have refilled completely, since a new
+// bucket would be identical
+func (rl *RateLimiter) cleanupBuckets() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for ip, v := range rl.visitors {
-			v.mu.Lock()
-			if now.Sub(v.lastRefill) > 10*time.Minute {
-				delete(rl.visitors, ip)
-			}
-			v.mu.Unlock()
+		rl.prune()
+	}
+}
+
+func (rl *RateLimiter) prune() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	for key, b := range rl.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.policy.Limit) {
+			delete(rl.buckets, key)
 		}
-		rl.mu.Unlock()
 	}
 }
 
-func (v *visitor) allow(rate int, window time.Duration) bool {
-	v.mu.Lock()
-	defer v.mu.Unlock()
+// decision is the outcome of taking a token from a bucket
+type decision struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // Until a token is available, if refused
+	reset      time.Duration // Until the bucket is full again
+}
 
-	now := time.Now()
+func (rl *RateLimiter) take(p Policy, key string) decision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
-	// Refill tokens based on elapsed time
-	elapsed := now.Sub(v.lastRefill)
-	if elapsed >= window {
-		v.tokens = rate
-		v.lastRefill = now
+	now := rl.now()
+	b, exists := rl.buckets[key]
+	if !exists {
+		b = &bucket{policy: p, tokens: float64(p.Limit), updated: now}
+		rl.buckets[key] = b
 	}
+	b.refill(now)
 
-	// Check if request is allowed
-	if v.tokens > 0 {
-		v.tokens--
-		return true
+	d := decision{allowed: b.tokens >= 1}
+	if d.allowed {
+		b.tokens--
+	} else {
+		d.retryAfter = seconds((1 - b.tokens) / p.rate())
 	}
+	d.remaining = int(b.tokens)
+	d.reset = seconds((float64(p.Limit) - b.tokens) / p.rate())
+	return d
+}
 
-	return false
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
 
-// Limit returns a middleware that rate limits requests per IP
-func (rl *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Get client IP
-		ip := r.RemoteAddr
+// ceilSeconds rounds d up to whole seconds for a header
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
 
-		// Get or create visitor
-		v := rl.getVisitor(ip)
+// clientKey returns the bucket key for a request under p, and whether it
+// identifies a user or an IP
+func (rl *RateLimiter) clientKey(p Policy, r *http.Request) (key, scope string) {
+	if p.PerUser && rl.user != nil {
+		if user := rl.user(r); user != "" {
+			return p.Name + "|user:" + user, "user"
+		}
+	}
+	return p.Name + "|ip:" + rl.proxies.ClientIP(r), "ip"
+}
+
+// Limit returns a middleware that applies policy p to requests. Responses
+// carry RateLimit-* headers describing the client's bucket, and rejected
+// requests get 429 with Retry-After.
+func (rl *RateLimiter) Limit(p Policy, next http.HandlerFunc) http.HandlerFunc {
+	policyHeader := fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window.Seconds()))
 
-		// Check if allowed
-		if !v.allow(rl.rate, rl.window) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, scope := rl.clientKey(p, r)
+		d := rl.take(p, key)
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", policyHeader)
+		h.Set("RateLimit-Limit", strconv.Itoa(p.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(d.reset))
+
+		if !d.allowed {
+			metrics.RateLimitRejections.WithLabelValues(p.Name, scope).Inc()
+			h.Set("Retry-After", ceilSeconds(d.retryAfter))
 			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
 			return
 		}
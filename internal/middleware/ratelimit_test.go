@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testLimiter returns a rate limiter on a clock the test controls
+func testLimiter(t *testing.T, user func(*http.Request) string) (*RateLimiter, *time.Time) {
+	t.Helper()
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := &RateLimiter{
+		proxies: proxies,
+		user:    user,
+		now:     func() time.Time { return now },
+		buckets: make(map[string]*bucket),
+	}
+	return rl, &now
+}
+
+func noop(w http.ResponseWriter, r *http.Request) {}
+
+func request(remoteAddr string, forwardedFor ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	for _, h := range forwardedFor {
+		r.Header.Add("X-Forwarded-For", h)
+	}
+	return r
+}
+
+func rejections(t *testing.T, policy, scope string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.RateLimitRejections.WithLabelValues(policy, scope).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestRateLimiterRefillsContinuously(t *testing.T) {
+	rl, now := testLimiter(t, nil)
+	policy := Policy{Name: "refill", Limit: 2, Window: 2 * time.Second}
+	handler := rl.Limit(policy, noop)
+
+	steps := []struct {
+		advance    time.Duration
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{0, http.StatusOK, "1", "1", ""},
+		{0, http.StatusOK, "0", "2", ""},
+		{0, http.StatusTooManyRequests, "0", "2", "1"},
+		{500 * time.Millisecond, http.StatusTooManyRequests, "0", "2", "1"},
+		{500 * time.Millisecond, http.StatusOK, "0", "2", ""},
+		{10 * time.Second, http.StatusOK, "1", "1", ""}, // Never more than Limit
+	}
+
+	for i, step := range steps {
+		*now = now.Add(step.advance)
+		rec := httptest.NewRecorder()
+		handler(rec, request("192.0.2.1:1000"))
+
+		if rec.Code != step.status {
+			t.Fatalf("step %d: expected status %d, got %d", i, step.status, rec.Code)
+		}
+		h := rec.Header()
+		if got := h.Get("RateLimit-Remaining"); got != step.remaining {
+			t.Errorf("step %d: expected RateLimit-Remaining %s, got %s", i, step.remaining, got)
+		}
+		if got := h.Get("RateLimit-Reset"); got != step.reset {
+			t.Errorf("step %d: expected RateLimit-Reset %s, got %s", i, step.reset, got)
+		}
+		if got := h.Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("step %d: expected Retry-After %q, got %q", i, step.retryAfter, got)
+		}
+		if h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Policy") != "2;w=2" {
+			t.Errorf("step %d: unexpected policy headers %v", i, h)
+		}
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	users := map[string]string{"192.0.2.1": "alice", "192.0.2.2": "alice", "192.0.2.3": "bob"}
+	user := func(r *http.Request) string { return users[remoteIP(r)] }
+
+	tests := []struct {
+		name   string
+		policy Policy
+		first  *http.Request
+		second *http.Request
+		shared bool
+		scope  string
+	}{
+		{
+			name:   "same IP on a new connection",
+			policy: Policy{Name: "ip-port", Limit: 1, Window: time.Minute},
+			first:  request("203.0.113.1:1000"),
+			second: request("203.0.113.1:2000"),
+			shared: true,
+			scope:  "ip",
+		},
+		{
+			name:   "clients behind a trusted proxy",
+			policy: Policy{Name: "proxied", Limit: 1, Window: time.Minute},
+			first:  request("10.0.0.1:1000", "198.51.100.1"),
+			second: request("10.0.0.1:1000", "198.51.100.2"),
+			shared: false,
+		},
+		{
+			name:   "forwarded header from an untrusted peer",
+			policy: Policy{Name: "spoofed", Limit: 1, Window: time.Minute},
+			first:  request("203.0.113.1:1000", "198.51.100.1"),
+			second: request("203.0.113.1:1000", "198.51.100.2"),
+			shared: true,
+			scope:  "ip",
+		},
+		{
+			name:   "one user on two IPs",
+			policy: Policy{Name: "user", Limit: 1, Window: time.Minute, PerUser: true},
+			first:  request("192.0.2.1:1000"),
+			second: request("192.0.2.2:1000"),
+			shared: true,
+			scope:  "user",
+		},
+		{
+			name:   "two users",
+			policy: Policy{Name: "users", Limit: 1, Window: time.Minute, PerUser: true},
+			first:  request("192.0.2.1:1000"),
+			second: request("192.0.2.3:1000"),
+			shared: false,
+		},
+		{
+			name:   "users ignored by per-IP policies",
+			policy: Policy{Name: "per-ip", Limit: 1, Window: time.Minute},
+			first:  request("192.0.2.1:1000"),
+			second: request("192.0.2.2:1000"),
+			shared: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, _ := testLimiter(t, user)
+			handler := rl.Limit(tt.policy, noop)
+
+			rec := httptest.NewRecorder()
+			handler(rec, tt.first)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected the first request to pass, got %d", rec.Code)
+			}
+
+			before := rejections(t, tt.policy.Name, tt.scope)
+			rec = httptest.NewRecorder()
+			handler(rec, tt.second)
+			if refused := rec.Code == http.StatusTooManyRequests; refused != tt.shared {
+				t.Errorf("expected shared bucket %v, got status %d", tt.shared, rec.Code)
+			}
+			if tt.shared && rejections(t, tt.policy.Name, tt.scope) != before+1 {
+				t.Errorf("expected the rejection to be counted under %s/%s", tt.policy.Name, tt.scope)
+			}
+		})
+	}
+}
+
+func TestRateLimiterPoliciesAreIndependent(t *testing.T) {
+	rl, _ := testLimiter(t, nil)
+	login := rl.Limit(Policy{Name: "login", Limit: 1, Window: time.Minute}, noop)
+	api := rl.Limit(Policy{Name: "api", Limit: 1, Window: time.Minute}, noop)
+
+	for _, step := range []struct {
+		handler http.HandlerFunc
+		status  int
+	}{
+		{login, http.StatusOK},
+		{login, http.StatusTooManyRequests},
+		{api, http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		step.handler(rec, request("192.0.2.1:1000"))
+		if rec.Code != step.status {
+			t.Errorf("expected %d, got %d", step.status, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	rl, now := testLimiter(t, nil)
+	handler := rl.Limit(Policy{Name: "prune", Limit: 10, Window: 10 * time.Second}, noop)
+	handler(httptest.NewRecorder(), request("192.0.2.1:1000"))
+	handler(httptest.NewRecorder(), request("192.0.2.2:1000"))
+	handler(httptest.NewRecorder(), request("192.0.2.2:1000"))
+
+	// After a second, 192.0.2.1 is full again but 192.0.2.2 is not
+	*now = now.Add(time.Second)
+	rl.prune()
+	if len(rl.buckets) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(rl.buckets))
+	}
+	if _, ok := rl.buckets["prune|ip:192.0.2.2"]; !ok {
+		t.Errorf("expected the partly used bucket to be kept, got %v", rl.buckets)
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// SecurityConfig configures SecurityHeaders
+type SecurityConfig struct {
+	// Disabled leaves every header to a reverse proxy. Pages then have no
+	// Content-Security-Policy, since only the server knows their nonces.
+	Disabled bool
+
+	// FrameAncestors lists other origins (such as https://portal.example.com)
+	// allowed to embed the UI in a frame. By default only terminals may be
+	// framed, and only by the UI itself.
+	FrameAncestors []string
+
+	// HSTSMaxAge sends Strict-Transport-Security on HTTPS requests; 0 disables
+	HSTSMaxAge time.Duration
+}
+
+// termPrefix is where terminals are served; they are the only pages the UI
+// frames
+const termPrefix = "/term/"
+
+// pagePolicy is the Content-Security-Policy of the UI's own pages. Scripts
+// need the request's nonce, and nothing may be inline.
+const pagePolicy = "default-src 'self'; " +
+	"script-src 'nonce-%s'; " +
+	"style-src 'self'; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"frame-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors %s"
+
+// terminalPolicy is the Content-Security-Policy of terminals proxied from
+// GoTTY, whose scripts are served from the same origin and whose terminal
+// emulator adds styles at runtime
+const terminalPolicy = "default-src 'self'; " +
+	"script-src 'self'; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors %s"
+
+// newNonce returns a random script nonce
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b) // Never fails; it crashes the program instead
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// SecurityHeaders sets a Content-Security-Policy and the other standard
+// security headers on every response. Each request gets a fresh script
+// nonce, which templ components read with templ.GetNonce.
+func SecurityHeaders(config SecurityConfig, next http.Handler) http.Handler {
+	if config.Disabled {
+		return next
+	}
+
+	ancestors := strings.Join(append([]string{"'self'"}, config.FrameAncestors...), " ")
+	pageAncestors := "'none'"
+	if len(config.FrameAncestors) > 0 {
+		pageAncestors = strings.Join(config.FrameAncestors, " ")
+	}
+	termCSP := fmt.Sprintf(terminalPolicy, ancestors)
+	hsts := "max-age=" + strconv.FormatInt(int64(config.HSTSMaxAge.Seconds()), 10)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=()")
+		if config.HSTSMaxAge > 0 && IsHTTPS(r) {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		// X-Frame-Options cannot name other origins, so it is left to the
+		// policy's frame-ancestors when any are configured
+		if strings.HasPrefix(r.URL.Path, termPrefix) {
+			h.Set("Content-Security-Policy", termCSP)
+			if len(config.FrameAncestors) == 0 {
+				h.Set("X-Frame-Options", "SAMEORIGIN")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nonce := newNonce()
+		h.Set("Content-Security-Policy", fmt.Sprintf(pagePolicy, nonce, pageAncestors))
+		if len(config.FrameAncestors) == 0 {
+			h.Set("X-Frame-Options", "DENY")
+		}
+		next.ServeHTTP(w, r.WithContext(templ.WithNonce(r.Context(), nonce)))
+	})
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+
+	var nonce string
+	handler := ProxyHeaders(proxies, SecurityHeaders(SecurityConfig{HSTSMaxAge: time.Hour},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = templ.GetNonce(r.Context())
+		})))
+
+	serve := func(r *http.Request) http.Header {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Header()
+	}
+
+	// Pages get a fresh nonce in the policy and the context
+	h := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+	csp := h.Get("Content-Security-Policy")
+	if nonce == "" || !strings.Contains(csp, "script-src 'nonce-"+nonce+"'") {
+		t.Errorf("expected the policy to allow nonce %q, got %q", nonce, csp)
+	}
+	if !strings.Contains(csp, "frame-ancestors 'none'") || strings.Contains(csp, "unsafe") {
+		t.Errorf("expected a strict policy, got %q", csp)
+	}
+	first := nonce
+	serve(httptest.NewRequest(http.MethodGet, "/", nil))
+	if nonce == first {
+		t.Error("expected a new nonce for each request")
+	}
+
+	want := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "same-origin",
+		"X-Frame-Options":        "DENY",
+	}
+	for k, v := range want {
+		if h.Get(k) != v {
+			t.Errorf("expected %s: %s, got %q", k, v, h.Get(k))
+		}
+	}
+	if h.Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS over plain HTTP")
+	}
+
+	// Terminals may be framed by the UI
+	nonce = ""
+	h = serve(httptest.NewRequest(http.MethodGet, "/term/3/", nil))
+	if csp := h.Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'self'") || !strings.Contains(csp, "script-src 'self'") {
+		t.Errorf("expected terminals to be framable by the UI, got %q", csp)
+	}
+	if h.Get("X-Frame-Options") != "SAMEORIGIN" || nonce != "" {
+		t.Errorf("unexpected terminal headers %v (nonce %q)", h, nonce)
+	}
+
+	// HSTS over HTTPS, directly or through a trusted proxy only
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       bool
+	}{
+		{"direct TLS", "192.0.2.1:1000", true, "", true},
+		{"trusted proxy", "10.0.0.1:1000", false, "https", true},
+		{"untrusted proxy", "192.0.2.1:1000", false, "https", false},
+		{"trusted proxy over HTTP", "10.0.0.1:1000", false, "http", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if got := serve(r).Get("Strict-Transport-Security"); (got == "max-age=3600") != tt.want {
+			t.Errorf("%s: unexpected Strict-Transport-Security %q", tt.name, got)
+		}
+	}
+}
+
+func TestSecurityHeadersConfig(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := SecurityHeaders(SecurityConfig{FrameAncestors: []string{"https://portal.example.com"}}, next)
+	for _, path := range []string{"/", "/term/1/"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "https://portal.example.com") {
+			t.Errorf("%s: expected the portal to be allowed to frame, got %q", path, csp)
+		}
+		if xfo := rec.Header().Get("X-Frame-Options"); xfo != "" {
+			t.Errorf("%s: expected no X-Frame-Options, got %q", path, xfo)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	SecurityHeaders(SecurityConfig{Disabled: true}, next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(rec.Header()) != 0 {
+		t.Errorf("expected no headers when disabled, got %v", rec.Header())
+	}
+}
@@ -3,7 +3,6 @@ package provision
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -36,11 +35,11 @@ type MCPServerConfig struct {
 // SetupClaudeConfig configures Claude Code settings for the user
 func (p *Provisioner) SetupClaudeConfig() error {
 	if !p.config.Claude.Enabled {
-		log.Println("Claude Code configuration is disabled, skipping...")
+		logger.Info("Claude Code configuration is disabled, skipping")
 		return nil
 	}
 
-	log.Println("Setting up Claude Code configuration...")
+	logger.Info("setting up Claude Code configuration")
 
 	homeDir, err := GetUserHomeDir(p.username)
 	if err != nil {
@@ -159,23 +158,23 @@ func (p *Provisioner) SetupClaudeConfig() error {
 		},
 	})
 
-	log.Printf("Claude Code configuration created at: %s", settingsPath)
+	logger.Info("created Claude Code configuration", "path", settingsPath)
 	return nil
 }
 
 // InstallMCPServers installs configured MCP servers as npm global packages
 func (p *Provisioner) InstallMCPServers() error {
 	if !p.config.Claude.Enabled {
-		log.Println("Claude Code is disabled, skipping MCP server installation...")
+		logger.Info("Claude Code is disabled, skipping MCP server installation")
 		return nil
 	}
 
 	if len(p.config.Claude.MCPServers) == 0 {
-		log.Println("No MCP servers configured, skipping installation...")
+		logger.Info("no MCP servers configured, skipping installation")
 		return nil
 	}
 
-	log.Println("Installing MCP servers...")
+	logger.Info("installing MCP servers")
 
 	installed := 0
 	failed := 0
@@ -183,13 +182,13 @@ func (p *Provisioner) InstallMCPServers() error {
 
 	for _, mcpServer := range p.config.Claude.MCPServers {
 		if mcpServer.Package == "" {
-			log.Printf("Skipping %s: no package specified", mcpServer.Name)
+			logger.Warn("skipping MCP server with no package", "server", mcpServer.Name)
 			continue
 		}
 
-		log.Printf("Installing MCP server: %s (%s)", mcpServer.Name, mcpServer.Package)
+		logger.Info("installing MCP server", "server", mcpServer.Name, "package", mcpServer.Package)
 		if err := p.installMCPServer(mcpServer); err != nil {
-			log.Printf("Warning: failed to install %s: %v", mcpServer.Name, err)
+			logger.Warn("failed to install MCP server", "server", mcpServer.Name, "err", err)
 			failed++
 			installErrors = append(installErrors, fmt.Sprintf("%s: %v", mcpServer.Name, err))
 		} else {
@@ -215,7 +214,7 @@ func (p *Provisioner) InstallMCPServers() error {
 		},
 	})
 
-	log.Printf("Installed %d/%d MCP servers", installed, len(p.config.Claude.MCPServers))
+	logger.Info("installed MCP servers", "installed", installed, "configured", len(p.config.Claude.MCPServers))
 	
 	if failed > 0 {
 		return fmt.Errorf("failed to install %d MCP server(s): %s", failed, strings.Join(installErrors, "; "))
@@ -0,0 +1,133 @@
+package provision
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/validation"
+)
+
+// shellIntegrationScript reports each prompt, command line, exit status and
+// working directory to the terminal using OSC 133 and OSC 7, which the server
+// records in the audit log. It does nothing outside StratusShell terminals,
+// where STRATUSSHELL is unset.
+const shellIntegrationScript = `# StratusShell shell integration (bash and zsh)
+[ -n "$STRATUSSHELL" ] || return 0
+case $- in *i*) ;; *) return 0 ;; esac
+[ -z "$__stratus_integrated" ] || return 0
+__stratus_integrated=1
+
+__stratus_osc() { printf '\033]%s\007' "$1"; }
+
+if [ -n "$ZSH_VERSION" ]; then
+    __stratus_urlencode() {
+        emulate -L zsh
+        setopt no_multibyte
+        local s=$1 c i
+        REPLY=
+        for (( i = 1; i <= $#s; i++ )); do
+            c=$s[i]
+            case $c in
+                ([a-zA-Z0-9.~_/-]) REPLY+=$c ;;
+                (*) printf -v c '%%%02X' $(( #c & 255 )); REPLY+=$c ;;
+            esac
+        done
+    }
+    __stratus_preexec() {
+        __stratus_urlencode "$1"
+        __stratus_osc "133;C;cmdline_url=$REPLY"
+    }
+    __stratus_precmd() {
+        local ret=$?
+        __stratus_osc "133;D;$ret"
+        __stratus_urlencode "$PWD"
+        __stratus_osc "7;file://$HOST$REPLY"
+        __stratus_osc "133;A"
+    }
+    autoload -Uz add-zsh-hook
+    add-zsh-hook preexec __stratus_preexec
+    add-zsh-hook precmd __stratus_precmd
+elif [ -n "$BASH_VERSION" ]; then
+    __stratus_urlencode() {
+        local LC_ALL=C s=$1 c i
+        REPLY=
+        for (( i = 0; i < ${#s}; i++ )); do
+            c=${s:i:1}
+            case $c in
+                [a-zA-Z0-9.~_/-]) REPLY+=$c ;;
+                *) printf -v c '%%%02X' "'$c"; REPLY+=$c ;;
+            esac
+        done
+    }
+    # Prints the number and text of the last history entry
+    __stratus_last() {
+        local line
+        line=$(HISTTIMEFORMAT= builtin history 1)
+        line=${line#"${line%%[![:space:]]*}"}
+        __stratus_num=${line%%[[:space:]]*}
+        __stratus_cmd=${line#"$__stratus_num"}
+        __stratus_cmd=${__stratus_cmd#"  "}
+    }
+    # Runs from PS0, in a subshell, just before a command line executes.
+    # Lines kept out of history are reported without their text.
+    __stratus_preexec() {
+        __stratus_last
+        if [ "$__stratus_num" != "$__stratus_hist" ]; then
+            __stratus_urlencode "$__stratus_cmd"
+            __stratus_osc "133;C;cmdline_url=$REPLY"
+        else
+            __stratus_osc "133;C"
+        fi
+    }
+    __stratus_precmd() {
+        local ret=$?
+        __stratus_osc "133;D;$ret"
+        __stratus_urlencode "$PWD"
+        __stratus_osc "7;file://$HOSTNAME$REPLY"
+        __stratus_last
+        __stratus_hist=$__stratus_num
+        __stratus_osc "133;A"
+    }
+    PS0="${PS0}\$(__stratus_preexec)"
+    PROMPT_COMMAND="__stratus_precmd${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+fi
+`
+
+// installShellIntegration writes the shell integration script sourced from
+// the user's shell RC file
+func (p *Provisioner) installShellIntegration() error {
+	homeDir, err := GetUserHomeDir(p.username)
+	if err != nil {
+		return err
+	}
+
+	scriptDir := filepath.Join(homeDir, ".stratusshell")
+	if err := validation.ValidateWorkingDir(scriptDir); err != nil {
+		return fmt.Errorf("invalid env directory: %w", err)
+	}
+	if err := os.MkdirAll(scriptDir, 0755); err != nil {
+		return err
+	}
+
+	scriptFile := filepath.Join(scriptDir, "shell-integration.sh")
+	err = os.WriteFile(scriptFile, []byte(shellIntegrationScript), 0644)
+	if err == nil {
+		err = ChownRecursive(scriptDir, p.username)
+	}
+
+	auditLogger.Log(audit.Entry{
+		Action:  audit.ActionToolInstall,
+		Actor:   "system",
+		Target:  p.username,
+		Outcome: audit.OutcomeFromError(err),
+		Details: map[string]interface{}{
+			"tool": "shell-integration",
+			"file": scriptFile,
+		},
+		Error: audit.ErrorString(err),
+	})
+
+	return err
+}
@@ -2,7 +2,6 @@ package provision
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,23 +12,27 @@ import (
 
 // SetupShellEnvironment configures the shell environment for the user
 func (p *Provisioner) SetupShellEnvironment() error {
-	log.Println("Setting up shell environment...")
+	logger.Info("setting up shell environment")
 
 	if p.config.Shell.Zsh {
 		if err := p.installZsh(); err != nil {
-			log.Printf("Warning: failed to install zsh: %v", err)
+			logger.Warn("failed to install zsh", "err", err)
 		}
 	}
 
 	if p.config.Shell.Tmux {
 		if err := p.installTmux(); err != nil {
-			log.Printf("Warning: failed to install tmux: %v", err)
+			logger.Warn("failed to install tmux", "err", err)
 		}
 	}
 
+	if err := p.installShellIntegration(); err != nil {
+		logger.Warn("failed to install shell integration", "err", err)
+	}
+
 	// Configure shell RC files to source stratusshell env
 	if err := p.configureShellRC(); err != nil {
-		log.Printf("Warning: failed to configure shell RC: %v", err)
+		logger.Warn("failed to configure shell RC", "err", err)
 	}
 
 	return nil
@@ -37,7 +40,7 @@ func (p *Provisioner) SetupShellEnvironment() error {
 
 // installZsh installs zsh and sets it as the default shell
 func (p *Provisioner) installZsh() error {
-	log.Println("Installing zsh...")
+	logger.Info("installing zsh")
 
 	if err := p.pm.Install("zsh"); err != nil {
 		auditLogger.Log(audit.Entry{
@@ -84,7 +87,7 @@ func (p *Provisioner) installZsh() error {
 
 // installTmux installs tmux terminal multiplexer
 func (p *Provisioner) installTmux() error {
-	log.Println("Installing tmux...")
+	logger.Info("installing tmux")
 
 	err := p.pm.Install("tmux")
 
@@ -146,10 +149,17 @@ func (p *Provisioner) configureShellRC() error {
 		return fmt.Errorf("invalid RC file directory: %w", err)
 	}
 
-	// Content to append
-	sourceCmd := "\n# StratusShell Environment\nif [ -f ~/.stratusshell/env.sh ]; then\n    source ~/.stratusshell/env.sh\nfi\n"
+	// Blocks to append, keyed by the marker that shows one is present
+	blocks := []struct{ marker, content string }{
+		{"StratusShell Environment", "\n# StratusShell Environment\nif [ -f ~/.stratusshell/env.sh ]; then\n    source ~/.stratusshell/env.sh\nfi\n"},
+		{"StratusShell Shell Integration", "\n# StratusShell Shell Integration\nif [ -f ~/.stratusshell/shell-integration.sh ]; then\n    source ~/.stratusshell/shell-integration.sh\nfi\n"},
+	}
 
-	// Check if the source command already exists
+	// Check which blocks already exist
+	sourceCmd := ""
+	for _, block := range blocks {
+		sourceCmd += block.content
+	}
 	if _, err := os.Stat(rcFile); err == nil {
 		content, err := os.ReadFile(rcFile)
 		if err != nil {
@@ -166,9 +176,16 @@ func (p *Provisioner) configureShellRC() error {
 			return err
 		}
 
+		sourceCmd = ""
+		for _, block := range blocks {
+			if !strings.Contains(string(content), block.marker) {
+				sourceCmd += block.content
+			}
+		}
+
 		// Check if already configured
-		if strings.Contains(string(content), "StratusShell Environment") {
-			log.Printf("Shell RC already configured: %s", rcFile)
+		if sourceCmd == "" {
+			logger.Info("shell RC already configured", "path", rcFile)
 			return nil
 		}
 	}
@@ -221,6 +238,6 @@ func (p *Provisioner) configureShellRC() error {
 		},
 	})
 
-	log.Printf("Configured shell RC file: %s", rcFile)
+	logger.Info("configured shell RC file", "path", rcFile)
 	return nil
 }
@@ -7,11 +7,20 @@ import (
 	"path/filepath"
 
 	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/logging"
 	"github.com/corymacd/StratusShell/internal/validation"
 )
 
 var auditLogger = audit.NewLogger()
 
+// logger reports provisioning progress
+var logger = logging.Component("provision")
+
+// SetAuditSource attaches src to every provisioning audit entry
+func SetAuditSource(src *audit.Source) {
+	auditLogger = auditLogger.WithSource(src)
+}
+
 func ConfigurePasswordlessSudo(username string) error {
 	// Validate username to prevent path traversal and injection
 	if err := validation.ValidateUsername(username); err != nil {
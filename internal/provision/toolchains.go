@@ -2,7 +2,6 @@ package provision
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,17 +12,17 @@ import (
 
 // InstallLanguageToolchains installs configured language toolchains
 func (p *Provisioner) InstallLanguageToolchains() error {
-	log.Println("Installing language toolchains...")
+	logger.Info("installing language toolchains")
 
 	if p.config.Languages.Go.Enabled {
 		if err := p.installGoToolchain(); err != nil {
-			log.Printf("Warning: failed to install Go toolchain: %v", err)
+			logger.Warn("failed to install Go toolchain", "err", err)
 		}
 	}
 
 	if p.config.Languages.Node.Enabled {
 		if err := p.installNodeToolchain(); err != nil {
-			log.Printf("Warning: failed to install Node toolchain: %v", err)
+			logger.Warn("failed to install Node toolchain", "err", err)
 		}
 	}
 
@@ -32,7 +31,7 @@ func (p *Provisioner) InstallLanguageToolchains() error {
 
 // installGoToolchain installs Go and related tools
 func (p *Provisioner) installGoToolchain() error {
-	log.Println("Installing Go toolchain...")
+	logger.Info("installing Go toolchain")
 
 	// Install Go via package manager
 	err := p.pm.Install("golang")
@@ -74,7 +73,7 @@ func (p *Provisioner) installGoToolchain() error {
 	// Install Go tools
 	for _, tool := range p.config.Languages.Go.Tools {
 		if err := p.installGoTool(tool); err != nil {
-			log.Printf("Warning: failed to install Go tool %s: %v", tool, err)
+			logger.Warn("failed to install Go tool", "tool", tool, "err", err)
 		}
 	}
 
@@ -186,7 +185,7 @@ func (p *Provisioner) installGoTool(tool string) error {
 
 // installNodeToolchain installs Node.js and related tools
 func (p *Provisioner) installNodeToolchain() error {
-	log.Println("Installing Node toolchain...")
+	logger.Info("installing Node toolchain")
 
 	homeDir, err := GetUserHomeDir(p.username)
 	if err != nil {
@@ -243,14 +242,14 @@ func (p *Provisioner) installNodeToolchain() error {
 	// Install global packages
 	for _, pkg := range p.config.Languages.Node.GlobalPackages {
 		if err := p.installNpmGlobal(pkg); err != nil {
-			log.Printf("Warning: failed to install npm package %s: %v", pkg, err)
+			logger.Warn("failed to install npm package", "package", pkg, "err", err)
 		}
 	}
 
 	// Install pnpm if configured
 	if p.config.Languages.Node.PackageManager == "pnpm" {
 		if err := p.installNpmGlobal("pnpm"); err != nil {
-			log.Printf("Warning: failed to install pnpm: %v", err)
+			logger.Warn("failed to install pnpm", "err", err)
 		}
 	}
 
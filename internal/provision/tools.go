@@ -2,7 +2,6 @@ package provision
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/corymacd/StratusShell/internal/audit"
 )
@@ -47,10 +46,10 @@ func NewProvisioner(username string, config *Config) (*Provisioner, error) {
 
 // InstallBasePackages installs base development packages
 func (p *Provisioner) InstallBasePackages() error {
-	log.Println("Installing base packages...")
+	logger.Info("installing base packages")
 
 	if len(p.config.Base) == 0 {
-		log.Println("No base packages configured")
+		logger.Info("no base packages configured")
 		return nil
 	}
 
@@ -93,7 +92,7 @@ func (p *Provisioner) InstallBasePackages() error {
 		},
 	})
 
-	log.Printf("Installed %d base packages", len(packages))
+	logger.Info("installed base packages", "count", len(packages))
 	return nil
 }
 
@@ -124,14 +123,14 @@ func (p *Provisioner) translatePackageNames(packages []string) []string {
 
 // InstallCloudTools installs cloud development tools
 func (p *Provisioner) InstallCloudTools() error {
-	log.Println("Installing cloud tools...")
+	logger.Info("installing cloud tools")
 
 	installed := 0
 	failed := 0
 
 	if p.config.Cloud.AWS {
 		if err := p.installAWSCLI(); err != nil {
-			log.Printf("Warning: failed to install AWS CLI: %v", err)
+			logger.Warn("failed to install AWS CLI", "err", err)
 			failed++
 		} else {
 			installed++
@@ -140,7 +139,7 @@ func (p *Provisioner) InstallCloudTools() error {
 
 	if p.config.Cloud.Docker {
 		if err := p.installDocker(); err != nil {
-			log.Printf("Warning: failed to install Docker: %v", err)
+			logger.Warn("failed to install Docker", "err", err)
 			failed++
 		} else {
 			installed++
@@ -149,7 +148,7 @@ func (p *Provisioner) InstallCloudTools() error {
 
 	if p.config.Cloud.Kubectl {
 		if err := p.installKubectl(); err != nil {
-			log.Printf("Warning: failed to install kubectl: %v", err)
+			logger.Warn("failed to install kubectl", "err", err)
 			failed++
 		} else {
 			installed++
@@ -158,7 +157,7 @@ func (p *Provisioner) InstallCloudTools() error {
 
 	if p.config.Cloud.Terraform {
 		if err := p.installTerraform(); err != nil {
-			log.Printf("Warning: failed to install Terraform: %v", err)
+			logger.Warn("failed to install Terraform", "err", err)
 			failed++
 		} else {
 			installed++
@@ -167,7 +166,7 @@ func (p *Provisioner) InstallCloudTools() error {
 
 	if p.config.Cloud.GCloud {
 		if err := p.installGCloud(); err != nil {
-			log.Printf("Warning: failed to install gcloud: %v", err)
+			logger.Warn("failed to install gcloud", "err", err)
 			failed++
 		} else {
 			installed++
@@ -188,13 +187,13 @@ func (p *Provisioner) InstallCloudTools() error {
 		},
 	})
 
-	log.Printf("Installed %d/%d cloud tools", installed, total)
+	logger.Info("installed cloud tools", "installed", installed, "configured", total)
 	return nil
 }
 
 // installAWSCLI installs AWS CLI
 func (p *Provisioner) installAWSCLI() error {
-	log.Println("Installing AWS CLI...")
+	logger.Info("installing AWS CLI")
 
 	// Try to install via package manager
 	err := p.pm.Install("awscli")
@@ -215,7 +214,7 @@ func (p *Provisioner) installAWSCLI() error {
 
 // installDocker installs Docker
 func (p *Provisioner) installDocker() error {
-	log.Println("Installing Docker...")
+	logger.Info("installing Docker")
 
 	// Try different package names based on package manager
 	var err error
@@ -232,10 +231,9 @@ func (p *Provisioner) installDocker() error {
 	if err == nil {
 		// Add user to docker group
 		if groupErr := AddUserToGroup(p.username, "docker"); groupErr != nil {
-			log.Printf("Warning: failed to add user %s to docker group: %v", p.username, groupErr)
-			log.Printf("Note: User %s may need to be added to docker group manually", p.username)
+			logger.Warn("failed to add user to docker group; add them manually", "user", p.username, "err", groupErr)
 		} else {
-			log.Printf("✓ User %s added to docker group (re-login required for changes to take effect)", p.username)
+			logger.Info("added user to docker group (re-login required for changes to take effect)", "user", p.username)
 		}
 	}
 
@@ -255,7 +253,7 @@ func (p *Provisioner) installDocker() error {
 
 // installKubectl installs kubectl
 func (p *Provisioner) installKubectl() error {
-	log.Println("Installing kubectl...")
+	logger.Info("installing kubectl")
 
 	err := p.pm.Install("kubectl")
 
@@ -275,7 +273,7 @@ func (p *Provisioner) installKubectl() error {
 
 // installTerraform installs Terraform
 func (p *Provisioner) installTerraform() error {
-	log.Println("Installing Terraform...")
+	logger.Info("installing Terraform")
 
 	err := p.pm.Install("terraform")
 
@@ -295,7 +293,7 @@ func (p *Provisioner) installTerraform() error {
 
 // installGCloud installs Google Cloud SDK
 func (p *Provisioner) installGCloud() error {
-	log.Println("Installing gcloud...")
+	logger.Info("installing gcloud")
 
 	err := p.pm.Install("google-cloud-sdk")
 
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/ui"
+)
+
+// auditTimeLayouts are the accepted formats for the since and until query
+// parameters. The last two are what date and datetime-local inputs send, and
+// are read in the server's local time zone.
+var auditTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04", "2006-01-02"}
+
+// parseAuditTime parses a since/until query parameter
+func parseAuditTime(value string) (time.Time, error) {
+	for _, layout := range auditTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DDTHH:MM or YYYY-MM-DD)", value)
+}
+
+// parseAuditFilter reads an audit filter from query parameters
+func parseAuditFilter(query url.Values) (audit.Filter, error) {
+	filter := audit.Filter{
+		Action:  query.Get("action"),
+		Actor:   query.Get("actor"),
+		Target:  query.Get("target"),
+		Outcome: audit.Outcome(query.Get("outcome")),
+
+		IP:        query.Get("ip"),
+		RequestID: query.Get("request_id"),
+		TraceID:   query.Get("trace_id"),
+	}
+
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = parseAuditTime(v); err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = parseAuditTime(v); err != nil {
+			return filter, err
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+
+	return filter, filter.Validate()
+}
+
+// auditQuery returns the query string for a filter, without pagination
+func auditQuery(query url.Values) url.Values {
+	q := url.Values{}
+	for _, key := range []string{"action", "actor", "target", "outcome", "ip", "request_id", "trace_id", "since", "until"} {
+		if v := query.Get(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	return q
+}
+
+// requireAuditStore reports whether the audit database is enabled, writing
+// an error response if not
+func (s *Server) requireAuditStore(w http.ResponseWriter) bool {
+	if s.auditStore == nil {
+		http.Error(w, "Audit database is disabled (see --audit-db)", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// handleAuditQuery returns audit entries matching the query parameters.
+// By default one page is returned as JSON; format=csv or format=jsonl
+// downloads every match instead.
+func (s *Server) handleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAuditStore(w) {
+		return
+	}
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		entries, total, err := s.auditStore.Query(r.Context(), filter)
+		if err != nil {
+			logger.Error("audit query failed", "err", err)
+			http.Error(w, "Failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+		limit := filter.Limit
+		if limit == 0 {
+			limit = audit.DefaultQueryLimit
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+			"total":   total,
+			"limit":   limit,
+			"offset":  filter.Offset,
+		})
+	case "csv":
+		s.exportAuditCSV(w, r, filter)
+	case "jsonl":
+		s.exportAuditJSONL(w, r, filter)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q (use json, csv or jsonl)", format), http.StatusBadRequest)
+	}
+}
+
+// auditExportName returns the download file name for an export
+func auditExportName(ext string) string {
+	return fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), ext)
+}
+
+func (s *Server) exportAuditJSONL(w http.ResponseWriter, r *http.Request, filter audit.Filter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", auditExportName("jsonl")))
+
+	enc := json.NewEncoder(w)
+	err := s.auditStore.Export(r.Context(), filter, func(entry audit.Entry) error {
+		return enc.Encode(entry)
+	})
+	if err != nil {
+		// Headers are already sent, so a truncated download is all we can do
+		logger.Error("failed to export audit log", "err", err)
+	}
+}
+
+// auditCSVHeader lists the columns of a CSV export
+var auditCSVHeader = []string{
+	"seq", "timestamp", "action", "actor", "target", "outcome", "error", "details",
+	"ip", "user_agent", "request_id", "session_id", "trace_id", "host", "sudo_user", "prev_hash", "hash",
+}
+
+func (s *Server) exportAuditCSV(w http.ResponseWriter, r *http.Request, filter audit.Filter) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", auditExportName("csv")))
+
+	cw := csv.NewWriter(w)
+	cw.Write(auditCSVHeader)
+	err := s.auditStore.Export(r.Context(), filter, func(entry audit.Entry) error {
+		src := entry.Source
+		if src == nil {
+			src = &audit.Source{}
+		}
+		return cw.Write([]string{
+			strconv.FormatUint(entry.Seq, 10),
+			entry.Timestamp.Format(time.RFC3339Nano),
+			string(entry.Action),
+			entry.Actor,
+			entry.Target,
+			string(entry.Outcome),
+			entry.Error,
+			auditDetails(entry),
+			src.IP,
+			src.UserAgent,
+			src.RequestID,
+			src.SessionID,
+			src.TraceID,
+			src.Host,
+			src.SudoUser,
+			entry.PrevHash,
+			entry.Hash,
+		})
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		logger.Error("failed to export audit log", "err", err)
+	}
+}
+
+// auditDetails returns an entry's details as compact JSON
+func auditDetails(entry audit.Entry) string {
+	if len(entry.Details) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(entry.Details)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// handleAuditPage renders the audit log viewer
+func (s *Server) handleAuditPage(w http.ResponseWriter, r *http.Request) {
+	var actions []string
+	for _, action := range audit.AllActions() {
+		actions = append(actions, string(action))
+	}
+
+	ui.AuditPage(s.getActor(r), audit.ActionCategories(), actions, s.auditStore != nil).Render(r.Context(), w)
+}
+
+// handleAuditEntries renders one page of the audit log viewer's results
+func (s *Server) handleAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAuditStore(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseAuditFilter(query)
+	if err != nil {
+		ui.AuditError(err.Error()).Render(r.Context(), w)
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = audit.DefaultQueryLimit
+	}
+
+	entries, total, err := s.auditStore.Query(r.Context(), filter)
+	if err != nil {
+		logger.Error("audit query failed", "err", err)
+		ui.AuditError("Failed to query audit log").Render(r.Context(), w)
+		return
+	}
+
+	rows := make([]ui.AuditEntryData, len(entries))
+	for i, entry := range entries {
+		src := entry.Source
+		if src == nil {
+			src = &audit.Source{}
+		}
+		rows[i] = ui.AuditEntryData{
+			Seq:       entry.Seq,
+			Timestamp: entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			Action:    string(entry.Action),
+			Actor:     entry.Actor,
+			Target:    entry.Target,
+			Outcome:   string(entry.Outcome),
+			Error:     entry.Error,
+			Details:   auditDetails(entry),
+			IP:        src.IP,
+			UserAgent: src.UserAgent,
+			RequestID: src.RequestID,
+			TraceID:   src.TraceID,
+			Host:      src.Host,
+			SudoUser:  src.SudoUser,
+		}
+	}
+
+	filterQuery := auditQuery(query)
+	page := ui.AuditResultsData{
+		Entries: rows,
+		Total:   total,
+		From:    filter.Offset + 1,
+		To:      filter.Offset + len(rows),
+	}
+	if filter.Offset > 0 {
+		page.PrevURL = auditPageURL(filterQuery, max(filter.Offset-filter.Limit, 0), filter.Limit)
+	}
+	if filter.Offset+len(rows) < total {
+		page.NextURL = auditPageURL(filterQuery, filter.Offset+filter.Limit, filter.Limit)
+	}
+	page.CSVURL = auditExportURL(filterQuery, "csv")
+	page.JSONLURL = auditExportURL(filterQuery, "jsonl")
+
+	ui.AuditResults(page).Render(r.Context(), w)
+}
+
+// auditPageURL returns the viewer results URL for a page of a filter
+func auditPageURL(filter url.Values, offset, limit int) string {
+	q := auditQuery(filter)
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(limit))
+	return "/audit/entries?" + q.Encode()
+}
+
+// auditExportURL returns the download URL for every entry matching a filter
+func auditExportURL(filter url.Values, format string) string {
+	q := auditQuery(filter)
+	q.Set("format", format)
+	return "/api/audit?" + q.Encode()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/middleware"
+)
+
+func newAuditTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	store, err := audit.NewSQLiteSink(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open audit database: %v", err)
+	}
+	al := audit.NewLogger(store)
+	t.Cleanup(func() { al.Close() })
+
+	al.LogAuthLogin("alice", audit.OutcomeSuccess, nil)
+	al.LogTerminalSpawn("alice", 1, "Terminal 1", audit.OutcomeSuccess, nil)
+	al.LogTerminalKill("bob", 1, audit.OutcomeSuccess, nil)
+
+	return &Server{
+		config:      Config{Admins: []string{"alice"}},
+		db:          database,
+		auditLogger: al,
+		auditStore:  store,
+	}
+}
+
+// auditRequest calls an admin-only handler as user
+func auditRequest(s *Server, handler http.HandlerFunc, user, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	rec := httptest.NewRecorder()
+	s.AdminMiddleware(handler)(rec, req)
+	return rec
+}
+
+func TestHandleAuditQuery(t *testing.T) {
+	s := newAuditTestServer(t)
+
+	rec := auditRequest(s, s.handleAuditQuery, "alice", "/api/audit?action=terminal&limit=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var page struct {
+		Entries []audit.Entry `json:"entries"`
+		Total   int           `json:"total"`
+		Limit   int           `json:"limit"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if page.Total != 2 || page.Limit != 1 || len(page.Entries) != 1 || page.Entries[0].Actor != "bob" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+
+	rec = auditRequest(s, s.handleAuditQuery, "alice", "/api/audit?actor=alice&format=csv")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("expected CSV, got %q", ct)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "seq" || records[2][2] != string(audit.ActionTerminalSpawn) {
+		t.Errorf("unexpected CSV export: %v", records)
+	}
+
+	rec = auditRequest(s, s.handleAuditQuery, "alice", "/api/audit?format=jsonl")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 JSONL lines, got %d", len(lines))
+	}
+	var first audit.Entry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.Seq != 1 {
+		t.Errorf("expected the oldest entry first, got %s (%v)", lines[0], err)
+	}
+}
+
+func TestHandleAuditQueryRejections(t *testing.T) {
+	s := newAuditTestServer(t)
+
+	tests := []struct {
+		name   string
+		user   string
+		target string
+		want   int
+	}{
+		{"non-admin", "bob", "/api/audit", http.StatusForbidden},
+		{"unknown action", "alice", "/api/audit?action=nope", http.StatusBadRequest},
+		{"bad time", "alice", "/api/audit?since=yesterday", http.StatusBadRequest},
+		{"bad limit", "alice", "/api/audit?limit=0", http.StatusBadRequest},
+		{"unknown format", "alice", "/api/audit?format=xml", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := auditRequest(s, s.handleAuditQuery, tt.user, tt.target)
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	// Without an audit database there is nothing to query
+	s.auditStore = nil
+	if rec := auditRequest(s, s.handleAuditQuery, "alice", "/api/audit"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without an audit database, got %d", rec.Code)
+	}
+}
+
+func TestHandleAuditEntries(t *testing.T) {
+	s := newAuditTestServer(t)
+
+	rec := auditRequest(s, s.handleAuditEntries, "alice", "/audit/entries?outcome=success&limit=2")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(body, "1-2 of 3 entries") {
+		t.Errorf("expected a page summary, got %s", body)
+	}
+	// The next page and exports keep the filter
+	if !strings.Contains(body, "/audit/entries?limit=2&amp;offset=2&amp;outcome=success") {
+		t.Errorf("expected a link to the next page, got %s", body)
+	}
+	if !strings.Contains(body, "/api/audit?format=csv&amp;outcome=success") {
+		t.Errorf("expected a filtered CSV export link, got %s", body)
+	}
+}
+
+func TestAuthMiddlewareAuditSource(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.trustedProxies, _ = middleware.ParseTrustedProxies([]string{"10.0.0.1"})
+
+	token, err := s.authManager.CreateSession("alice", RoleAdmin, true)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	session, _ := s.authManager.ValidateSession(token)
+
+	handler := s.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		s.auditFor(r).LogLayoutChange(s.getActor(r), "grid", audit.OutcomeSuccess, nil)
+	})
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		headers       map[string]string
+		wantIP        string
+		wantRequestID string // Empty for a generated ID
+	}{
+		{"direct", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Request-ID": "spoofed"}, "203.0.113.7", ""},
+		{"via proxy", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "198.51.100.2", "X-Request-ID": "proxy-42"}, "198.51.100.2", "proxy-42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("User-Agent", "test-agent")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			entries, _, err := s.auditStore.Query(context.Background(), audit.Filter{Action: string(audit.ActionLayoutChange), Limit: 1})
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("expected a layout entry, got %v (%v)", entries, err)
+			}
+			src := entries[0].Source
+			if src == nil {
+				t.Fatal("expected the entry to have a source")
+			}
+			if src.IP != tt.wantIP || src.UserAgent != "test-agent" || src.SessionID != session.ID {
+				t.Errorf("unexpected source: %+v", src)
+			}
+			if src.SessionID == token {
+				t.Error("the session token must not be logged")
+			}
+			if tt.wantRequestID != "" && src.RequestID != tt.wantRequestID {
+				t.Errorf("expected request ID %q, got %q", tt.wantRequestID, src.RequestID)
+			}
+			if src.RequestID == "" || src.RequestID == "spoofed" {
+				t.Errorf("expected a generated request ID, got %q", src.RequestID)
+			}
+			if got := rec.Header().Get("X-Request-ID"); got != src.RequestID {
+				t.Errorf("expected X-Request-ID %q in the response, got %q", src.RequestID, got)
+			}
+		})
+	}
+}
@@ -4,33 +4,59 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"log"
 	"net/http"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/middleware"
+	"github.com/corymacd/StratusShell/internal/tracing"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session" // Set by AuthMiddleware, which may have just started it
+)
 
 // Session represents an authenticated session
 type Session struct {
 	Token     string
+	ID        string // Identifies the session in logs without revealing the token
 	User      string
+	Role      Role
 	CreatedAt time.Time
 	ExpiresAt time.Time
+
+	// MustEnroll restricts the session to setting up two-factor
+	// authentication, which the server requires
+	MustEnroll bool
+	// Verified reports that the user proved who they are with a second
+	// factor, passkey or client certificate rather than only naming
+	// themselves. Unverified sessions never get more than the default role.
+	Verified bool
 }
 
 // AuthManager manages authentication sessions
 type AuthManager struct {
 	sessions map[string]*Session
 	mu       sync.RWMutex
+
+	// WebAuthn ceremonies in progress, by ID. webAuthn is nil unless
+	// passkeys are enabled.
+	webAuthn   *webauthn.WebAuthn
+	ceremonies map[string]*webAuthnCeremony
 }
 
 func NewAuthManager() *AuthManager {
 	am := &AuthManager{
-		sessions: make(map[string]*Session),
+		sessions:   make(map[string]*Session),
+		ceremonies: make(map[string]*webAuthnCeremony),
 	}
 	// Start cleanup goroutine
 	go am.cleanupExpired()
@@ -45,17 +71,25 @@ func (am *AuthManager) generateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func (am *AuthManager) CreateSession(user string) (string, error) {
+func (am *AuthManager) CreateSession(user string, role Role, verified bool) (string, error) {
 	token, err := am.generateToken()
 	if err != nil {
 		return "", err
 	}
 
+	id, err := am.generateToken()
+	if err != nil {
+		return "", err
+	}
+
 	session := &Session{
 		Token:     token,
+		ID:        id[:16],
 		User:      user,
+		Role:      role,
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(24 * time.Hour),
+		Verified:  verified,
 	}
 
 	am.mu.Lock()
@@ -84,6 +118,53 @@ func (am *AuthManager) ValidateSession(token string) (*Session, bool) {
 	return session, true
 }
 
+// SetUserRole changes the role of every session user has: to role for
+// verified sessions, and to unverified for the others
+func (am *AuthManager) SetUserRole(user string, role, unverified Role) {
+	am.updateSessions(user, func(session *Session) {
+		if session.Verified {
+			session.Role = role
+		} else {
+			session.Role = unverified
+		}
+	})
+}
+
+// SetMustEnroll restricts every session user has to setting up two-factor
+// authentication, or lifts the restriction
+func (am *AuthManager) SetMustEnroll(user string, mustEnroll bool) {
+	am.updateSessions(user, func(session *Session) {
+		session.MustEnroll = mustEnroll
+	})
+}
+
+// VerifySession marks the session with token as having proved who its user
+// is, giving it role
+func (am *AuthManager) VerifySession(token string, role Role) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if session, ok := am.sessions[token]; ok {
+		updated := *session
+		updated.Verified = true
+		updated.Role = role
+		am.sessions[token] = &updated
+	}
+}
+
+// updateSessions applies update to every session user has. Sessions are
+// replaced rather than changed, as requests may still be reading them.
+func (am *AuthManager) updateSessions(user string, update func(*Session)) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	for token, session := range am.sessions {
+		if session.User == user {
+			updated := *session
+			update(&updated)
+			am.sessions[token] = &updated
+		}
+	}
+}
+
 func (am *AuthManager) DeleteSession(token string) {
 	am.mu.Lock()
 	delete(am.sessions, token)
@@ -102,34 +183,144 @@ func (am *AuthManager) cleanupExpired() {
 				delete(am.sessions, token)
 			}
 		}
+		for id, ceremony := range am.ceremonies {
+			if now.After(ceremony.expires) {
+				delete(am.ceremonies, id)
+			}
+		}
 		am.mu.Unlock()
 	}
 }
 
+// enrollmentPath reports whether path sets up a second factor: an
+// authenticator app under /2fa, or a passkey
+func enrollmentPath(path string) bool {
+	return path == "/2fa" || strings.HasPrefix(path, "/2fa/") ||
+		path == "/passkeys" || strings.HasPrefix(path, "/passkeys/register/")
+}
+
 // AuthMiddleware checks for valid authentication
 func (s *Server) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for session cookie
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			// Redirect to login with return URL
-			http.Redirect(w, r, "/login?user="+r.URL.Query().Get("user"), http.StatusSeeOther)
-			return
+		var session *Session
+		if s.config.AuthMode == AuthModeClientCert {
+			if session = s.clientCertSession(w, r); session == nil {
+				return
+			}
+		} else {
+			// Check for session cookie
+			cookie, err := r.Cookie("session_token")
+			if err != nil {
+				// Redirect to login with return URL
+				http.Redirect(w, r, middleware.URL(r.Context(), "/login")+"?user="+r.URL.Query().Get("user"), http.StatusSeeOther)
+				return
+			}
+
+			// Validate session
+			var valid bool
+			session, valid = s.authManager.ValidateSession(cookie.Value)
+			if !valid {
+				metrics.AuthFailures.WithLabelValues("invalid_session").Inc()
+				// Redirect to login with return URL
+				http.Redirect(w, r, middleware.URL(r.Context(), "/login")+"?user="+r.URL.Query().Get("user"), http.StatusSeeOther)
+				return
+			}
 		}
 
-		// Validate session
-		session, valid := s.authManager.ValidateSession(cookie.Value)
-		if !valid {
-			// Redirect to login with return URL
-			http.Redirect(w, r, "/login?user="+r.URL.Query().Get("user"), http.StatusSeeOther)
+		middleware.SetAccessUser(r.Context(), session.User)
+
+		// Until they enroll, users the server requires a second factor of
+		// may only set one up
+		if session.MustEnroll && !enrollmentPath(r.URL.Path) {
+			http.Redirect(w, r, middleware.URL(r.Context(), "/2fa"), http.StatusSeeOther)
 			return
 		}
 
-		// Add session info to request context
-		log.Printf("Authenticated request: user=%s, path=%s", session.User, r.URL.Path)
-
-		// Add user to context for audit logging
+		// Add user and request details to context for audit logging
 		ctx := context.WithValue(r.Context(), userContextKey, session.User)
+		ctx = context.WithValue(ctx, sessionContextKey, session)
+		src := s.requestSource(r)
+		src.SessionID = session.ID
+		w.Header().Set("X-Request-ID", src.RequestID)
+		ctx = audit.NewContext(ctx, src)
 		next(w, r.WithContext(ctx))
 	}
 }
+
+// requestSession returns the request's valid session, or nil
+func (s *Server) requestSession(r *http.Request) *Session {
+	if session, ok := r.Context().Value(sessionContextKey).(*Session); ok {
+		return session
+	}
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return nil
+	}
+	session, valid := s.authManager.ValidateSession(cookie.Value)
+	if !valid {
+		return nil
+	}
+	return session
+}
+
+// sessionUser returns the user a request is signed in as, or "" if it has
+// no valid session
+func (s *Server) sessionUser(r *http.Request) string {
+	if session := s.requestSession(r); session != nil {
+		return session.User
+	}
+	return ""
+}
+
+// sessionID returns the ID of the request's session, or "" if it has no
+// valid session
+func (s *Server) sessionID(r *http.Request) string {
+	if session := s.requestSession(r); session != nil {
+		return session.ID
+	}
+	return ""
+}
+
+// usernamePattern matches the usernames accepted at login
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._@-]{0,63}$`)
+
+// requestIDPattern matches request IDs accepted from trusted proxies
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestSource describes the client behind a request for the audit log. A
+// request ID set by a trusted proxy is kept so entries can be correlated
+// with the proxy's logs.
+func (s *Server) requestSource(r *http.Request) *audit.Source {
+	requestID := ""
+	if s.trustedProxies.FromTrustedProxy(r) {
+		if id := r.Header.Get("X-Request-ID"); requestIDPattern.MatchString(id) {
+			requestID = id
+		}
+	}
+	if requestID == "" {
+		requestID = audit.NewRequestID()
+	}
+
+	return &audit.Source{
+		IP:        s.trustedProxies.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: requestID,
+		TraceID:   tracing.TraceID(r.Context()),
+	}
+}
+
+// auditFor returns an audit logger that records the request's source. Behind
+// AuthMiddleware this includes the session; otherwise it is built on demand.
+func (s *Server) auditFor(r *http.Request) *audit.Logger {
+	src, ok := audit.SourceFromContext(r.Context())
+	if !ok {
+		src = s.requestSource(r)
+	}
+	return s.auditLogger.WithSource(src)
+}
+
+// AdminMiddleware rejects requests from users who are not admins. It must
+// run after AuthMiddleware.
+func (s *Server) AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequirePermission(PermAdmin, next)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+// runBackups backs up the database every Backup.Interval until shutdown
+func (s *Server) runBackups() {
+	ticker := time.NewTicker(s.config.Backup.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if err := s.backupDatabase(context.Background()); err != nil {
+				logger.Error("scheduled database backup failed", "err", err)
+			}
+		}
+	}
+}
+
+// backupDatabase writes a timestamped backup to Backup.Dir and deletes the
+// oldest ones beyond Backup.Keep
+func (s *Server) backupDatabase(ctx context.Context) error {
+	sqlite, ok := s.db.(*db.DB)
+	if !ok {
+		return errors.New("backups are only supported for SQLite databases")
+	}
+	path := db.BackupPath(s.config.Backup.Dir, time.Now())
+	if err := sqlite.Backup(ctx, path); err != nil {
+		return err
+	}
+	logger.Info("database backed up", "path", path)
+
+	if s.config.Backup.Keep <= 0 {
+		return nil
+	}
+	removed, err := db.PruneBackups(s.config.Backup.Dir, s.config.Backup.Keep)
+	for _, old := range removed {
+		logger.Info("removed old database backup", "path", old)
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+func TestBackupDatabase(t *testing.T) {
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	backups := filepath.Join(dir, "backups")
+	s := &Server{
+		config: Config{Backup: BackupConfig{Dir: backups, Keep: 2}},
+		db:     database,
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.backupDatabase(t.Context()); err != nil {
+			t.Fatalf("backup %d failed: %v", i, err)
+		}
+	}
+
+	kept, err := db.Backups(backups)
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	if len(kept) != 2 {
+		t.Errorf("expected 2 backups to be kept, got %v", kept)
+	}
+}
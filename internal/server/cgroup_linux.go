@@ -0,0 +1,177 @@
+//go:build linux
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	cgroupMount = "/sys/fs/cgroup"
+
+	// cgroupCPUPeriod is the cpu.max period in microseconds
+	cgroupCPUPeriod = 100000
+)
+
+// cgroupManager places terminal processes in per-user cgroups that limit the
+// CPU and memory all of a user's terminals may use together. It requires a
+// cgroups v2 hierarchy in which the server's own cgroup is delegated to it
+// (for example a systemd service with Delegate=yes).
+type cgroupManager struct {
+	root        string // The server's delegated cgroup
+	cpuLimit    float64
+	memoryLimit int64
+
+	mu    sync.Mutex
+	users map[string]string // Owner to cgroup directory
+}
+
+// newCgroupManager prepares the server's cgroup for per-user child cgroups.
+// It returns nil without error when no CPU or memory limit is configured.
+func newCgroupManager(cpuLimit float64, memoryLimit int64) (*cgroupManager, error) {
+	if cpuLimit <= 0 && memoryLimit <= 0 {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(cgroupMount, "cgroup.controllers")); err != nil {
+		return nil, errors.New("cgroups v2 is not available")
+	}
+
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read own cgroup: %w", err)
+	}
+	path, ok := parseCgroupV2Path(string(self))
+	if !ok {
+		return nil, errors.New("process is not in a cgroups v2 hierarchy")
+	}
+	root := filepath.Join(cgroupMount, path)
+
+	// cgroups v2 only allows processes in leaf cgroups, so the server moves
+	// itself into a child before enabling controllers for its siblings
+	serverGroup := filepath.Join(root, "server")
+	if err := os.MkdirAll(serverGroup, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup (is it delegated?): %w", err)
+	}
+	if err := writeCgroupFile(serverGroup, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return nil, fmt.Errorf("failed to move server into its cgroup: %w", err)
+	}
+
+	available, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read available controllers: %w", err)
+	}
+	var enable []string
+	if cpuLimit > 0 {
+		enable = append(enable, "cpu")
+	}
+	if memoryLimit > 0 {
+		enable = append(enable, "memory")
+	}
+	for _, controller := range enable {
+		if !strings.Contains(" "+strings.TrimSpace(string(available))+" ", " "+controller+" ") {
+			return nil, fmt.Errorf("cgroup controller %q is not delegated", controller)
+		}
+		if err := writeCgroupFile(root, "cgroup.subtree_control", "+"+controller); err != nil {
+			return nil, fmt.Errorf("failed to enable cgroup controller %q: %w", controller, err)
+		}
+	}
+
+	return &cgroupManager{
+		root:        root,
+		cpuLimit:    cpuLimit,
+		memoryLimit: memoryLimit,
+		users:       make(map[string]string),
+	}, nil
+}
+
+// parseCgroupV2Path extracts the unified hierarchy path from /proc/self/cgroup
+func parseCgroupV2Path(contents string) (string, bool) {
+	for _, line := range strings.Split(contents, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// userCgroupName returns the name of owner's cgroup. Letters, digits, dots
+// and dashes are kept; every other byte, underscores included, is written as
+// an underscore and two hex digits, so that no two users share a cgroup and
+// its limits.
+func userCgroupName(owner string) string {
+	var b strings.Builder
+	b.WriteString("user-")
+	for i := 0; i < len(owner); i++ {
+		c := owner[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// userDir returns the cgroup for owner's terminals, creating it on first use
+func (m *cgroupManager) userDir(owner string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dir, ok := m.users[owner]; ok {
+		return dir, nil
+	}
+
+	dir := filepath.Join(m.root, userCgroupName(owner))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create user cgroup: %w", err)
+	}
+	if m.cpuLimit > 0 {
+		quota := int64(m.cpuLimit * cgroupCPUPeriod)
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			return "", fmt.Errorf("failed to set CPU limit: %w", err)
+		}
+	}
+	if m.memoryLimit > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(m.memoryLimit, 10)); err != nil {
+			return "", fmt.Errorf("failed to set memory limit: %w", err)
+		}
+	}
+
+	m.users[owner] = dir
+	return dir, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// placeInCgroup makes cmd start directly inside the cgroup at dir, so that
+// neither the command nor anything it forks ever runs outside its limits.
+// The returned function must be called once the command has started.
+func placeInCgroup(cmd *exec.Cmd, dir string) (func(), error) {
+	if dir == "" {
+		return func() {}, nil
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+
+	return func() { f.Close() }, nil
+}
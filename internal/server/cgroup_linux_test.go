@@ -0,0 +1,49 @@
+//go:build linux
+
+package server
+
+import "testing"
+
+func TestParseCgroupV2Path(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+		wantOK   bool
+	}{
+		{"unified", "0::/system.slice/stratusshell-alice.service\n", "/system.slice/stratusshell-alice.service", true},
+		{"hybrid", "12:memory:/user.slice\n0::/user.slice/user-1000.slice\n", "/user.slice/user-1000.slice", true},
+		{"v1 only", "12:memory:/user.slice\n11:cpu,cpuacct:/user.slice\n", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCgroupV2Path(tt.contents)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseCgroupV2Path() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUserCgroupName(t *testing.T) {
+	tests := map[string]string{
+		"alice":     "user-alice",
+		"a.b":       "user-a.b",
+		"a_b":       "user-a_5fb",
+		"a b":       "user-a_20b",
+		"a_20b":     "user-a_5f20b",
+		"DOMAIN\\x": "user-DOMAIN_5cx",
+	}
+	seen := map[string]string{}
+	for owner, want := range tests {
+		got := userCgroupName(owner)
+		if got != want {
+			t.Errorf("userCgroupName(%q) = %q, want %q", owner, got, want)
+		}
+		if other, ok := seen[got]; ok {
+			t.Errorf("%q and %q share cgroup %q", owner, other, got)
+		}
+		seen[got] = owner
+	}
+}
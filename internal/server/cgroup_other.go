@@ -0,0 +1,26 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// cgroupManager is only implemented on Linux
+type cgroupManager struct{}
+
+func newCgroupManager(cpuLimit float64, memoryLimit int64) (*cgroupManager, error) {
+	if cpuLimit <= 0 && memoryLimit <= 0 {
+		return nil, nil
+	}
+	return nil, errors.New("CPU and memory limits require cgroups v2 on Linux")
+}
+
+func (m *cgroupManager) userDir(owner string) (string, error) {
+	return "", nil
+}
+
+func placeInCgroup(cmd *exec.Cmd, dir string) (func(), error) {
+	return func() {}, nil
+}
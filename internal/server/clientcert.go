@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/middleware"
+)
+
+// Authentication modes
+const (
+	// AuthModeSession signs users in at /login
+	AuthModeSession = "session"
+	// AuthModeClientCert signs users in with a client certificate issued by
+	// a trusted CA, verified during the TLS handshake
+	AuthModeClientCert = "client-cert"
+)
+
+// Client certificate fields a user can be taken from
+const (
+	CertUserCommonName = "cn"
+	CertUserEmail      = "email"
+)
+
+// TLSConfig serves HTTPS directly, optionally requiring client certificates
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile holds the PEM certificates of the CAs that issue client
+	// certificates, for AuthModeClientCert
+	ClientCAFile string
+
+	// ClientCertUser selects the certificate field that names the user:
+	// CertUserCommonName (the default) or CertUserEmail
+	ClientCertUser string
+}
+
+// Enabled reports whether HTTPS is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// serverConfig loads the server certificate and, when client certificates
+// are required, the CAs that issue them
+func (c TLSConfig) serverConfig(authMode string) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("both a TLS certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if authMode == AuthModeClientCert {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// validateAuth checks that the authentication mode has what it needs
+func validateAuth(mode string, c TLSConfig) error {
+	switch mode {
+	case "", AuthModeSession:
+		if c.ClientCAFile != "" {
+			return fmt.Errorf("a client CA requires the %s auth mode", AuthModeClientCert)
+		}
+	case AuthModeClientCert:
+		if !c.Enabled() || c.ClientCAFile == "" {
+			return fmt.Errorf("the %s auth mode requires a TLS certificate, key and client CA", AuthModeClientCert)
+		}
+		switch c.ClientCertUser {
+		case "", CertUserCommonName, CertUserEmail:
+		default:
+			return fmt.Errorf("invalid client certificate user field %q: expected %s or %s", c.ClientCertUser, CertUserCommonName, CertUserEmail)
+		}
+	default:
+		return fmt.Errorf("invalid auth mode %q: expected %s or %s", mode, AuthModeSession, AuthModeClientCert)
+	}
+	return nil
+}
+
+// certUser returns the user a verified client certificate names
+func certUser(cert *x509.Certificate, field string) (string, error) {
+	user := cert.Subject.CommonName
+	if field == CertUserEmail {
+		user = ""
+		if len(cert.EmailAddresses) > 0 {
+			user = cert.EmailAddresses[0]
+		}
+	}
+	if !usernamePattern.MatchString(user) {
+		return "", fmt.Errorf("client certificate %q does not name a valid user", cert.Subject)
+	}
+	return user, nil
+}
+
+// clientCertUser returns the user named by the request's client certificate,
+// which the TLS handshake has verified against the client CAs
+func (s *Server) clientCertUser(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", errors.New("no verified client certificate")
+	}
+	return certUser(r.TLS.VerifiedChains[0][0], s.config.TLS.ClientCertUser)
+}
+
+// clientCertSession returns the session of the user the request's client
+// certificate names, starting one if the request has none. A session cookie
+// for anyone else is ignored. It responds itself and returns nil if the
+// certificate names no valid user.
+func (s *Server) clientCertSession(w http.ResponseWriter, r *http.Request) *Session {
+	user, err := s.clientCertUser(r)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("client_cert").Inc()
+		s.auditFor(r).LogAuthLogin("unknown", audit.OutcomeFailure, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	if session := s.requestSession(r); session != nil && session.User == user {
+		return session
+	}
+
+	// The certificate proves who the user is
+	role, err := s.sessionRole(r.Context(), user, true)
+	var token string
+	if err == nil {
+		token, err = s.authManager.CreateSession(user, role, true)
+	}
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("login").Inc()
+		s.auditFor(r).LogAuthLogin(user, audit.OutcomeFailure, err)
+		s.handleError(w, r, err, "Failed to create session")
+		return nil
+	}
+	session, _ := s.authManager.ValidateSession(token)
+	s.setSessionCookie(w, r, token)
+
+	src := s.requestSource(r)
+	src.SessionID = session.ID
+	s.auditLogger.WithSource(src).LogAuthLogin(user, audit.OutcomeSuccess, nil)
+	return session
+}
+
+// setSessionCookie gives the client its session token
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Path:     middleware.URL(r.Context(), "/"),
+		HttpOnly: true,
+		Secure:   middleware.IsHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   86400, // 24 hours
+	})
+}
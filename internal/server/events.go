@@ -0,0 +1,93 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies a terminal lifecycle event
+type EventType string
+
+const (
+	EventSpawned  EventType = "spawned"
+	EventKilled   EventType = "killed"
+	EventRenamed  EventType = "renamed"
+	EventExited   EventType = "exited"
+	EventBell     EventType = "bell"
+	EventActivity EventType = "activity"
+
+	// EventNotification is a desktop notification requested by a program (OSC 9/777)
+	EventNotification EventType = "notification"
+	// EventSilence is sent when output stops after a sustained burst of activity
+	EventSilence EventType = "silence"
+	// EventAlert is sent when a background tab's unseen alerts change
+	EventAlert EventType = "alert"
+	// EventNotify asks browsers to show a desktop notification
+	EventNotify EventType = "notify"
+	// EventReapWarning is sent shortly before an idle or expired terminal is closed
+	EventReapWarning EventType = "reap-warning"
+)
+
+// eventBufferSize is how many events may queue for a slow subscriber before
+// further events are dropped for it
+const eventBufferSize = 64
+
+// Event is a terminal lifecycle event published on the EventBus
+type Event struct {
+	Type       EventType              `json:"type"`
+	TerminalID int                    `json:"terminal_id"`
+	Owner      string                 `json:"-"` // Owner of the terminal, to decide who receives the event
+	Title      string                 `json:"title,omitempty"`
+	Time       time.Time              `json:"time"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans terminal events out to subscribers such as SSE connections
+type EventBus struct {
+	subscribers map[chan Event]struct{}
+	mu          sync.RWMutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving all future events and a function
+// that must be called to unsubscribe
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers an event to all subscribers without blocking.
+// Subscribers that are not keeping up miss the event.
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
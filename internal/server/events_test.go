@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBusPublish(t *testing.T) {
+	bus := NewEventBus()
+
+	first, unsubscribeFirst := bus.Subscribe()
+	second, unsubscribeSecond := bus.Subscribe()
+	defer unsubscribeSecond()
+
+	bus.Publish(Event{Type: EventSpawned, TerminalID: 1})
+
+	for _, ch := range []<-chan Event{first, second} {
+		select {
+		case e := <-ch:
+			if e.Type != EventSpawned || e.TerminalID != 1 {
+				t.Errorf("unexpected event: %+v", e)
+			}
+			if e.Time.IsZero() {
+				t.Error("expected event time to be set")
+			}
+		default:
+			t.Fatal("expected event to be delivered")
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst() // Must be safe to call twice
+	bus.Publish(Event{Type: EventKilled, TerminalID: 1})
+
+	if _, ok := <-first; ok {
+		t.Error("expected unsubscribed channel to be closed")
+	}
+	if e := <-second; e.Type != EventKilled {
+		t.Errorf("expected killed event, got %+v", e)
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	// Publishing must never block, even when nobody is reading
+	for i := 0; i < eventBufferSize*2; i++ {
+		bus.Publish(Event{Type: EventActivity, TerminalID: i})
+	}
+	if len(events) != eventBufferSize {
+		t.Errorf("expected %d buffered events, got %d", eventBufferSize, len(events))
+	}
+}
+
+func TestOutputMonitorBell(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		bell   bool
+	}{
+		{"plain bell", []string{"hello\a"}, true},
+		{"no bell", []string{"hello world"}, false},
+		{"osc title terminated by BEL", []string{"\x1b]0;my title\a$ "}, false},
+		{"osc title terminated by ST", []string{"\x1b]2;title\x1b\\\a"}, true},
+		{"osc split across chunks", []string{"\x1b]0;ti", "tle\a"}, false},
+		{"bell after csi", []string{"\x1b[1;31mred\x1b[0m\a"}, true},
+		{"sequence cancelled", []string{"\x1b]0;oops\x18\a"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &outputMonitor{}
+			bell := false
+			for _, chunk := range tt.chunks {
+				if m.scan([]byte(chunk), time.Now()).Bell {
+					bell = true
+				}
+			}
+			if bell != tt.bell {
+				t.Errorf("bell = %v, want %v", bell, tt.bell)
+			}
+		})
+	}
+}
+
+func TestOutputMonitorNotifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []notification
+	}{
+		{"osc 9", "\x1b]9;Build finished\a", []notification{{Body: "Build finished"}}},
+		{"osc 9 with ST", "\x1b]9;done\x1b\\", []notification{{Body: "done"}}},
+		{"osc 777", "\x1b]777;notify;make;All targets built\a", []notification{{Title: "make", Body: "All targets built"}}},
+		{"conemu progress", "\x1b]9;4;1;50\a", nil},
+		{"window title", "\x1b]0;vim\a", nil},
+		{"osc 777 other", "\x1b]777;precmd\a", nil},
+		{"control characters stripped", "\x1b]9;a\x01b\a", []notification{{Body: "ab"}}},
+		{"dcs is not osc", "\x1bP9;nope\x1b\\", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &outputMonitor{}
+			sig := m.scan([]byte(tt.output), time.Now())
+			if len(sig.Notifications) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", sig.Notifications, tt.want)
+			}
+			for i := range tt.want {
+				if sig.Notifications[i] != tt.want[i] {
+					t.Errorf("got %+v, want %+v", sig.Notifications[i], tt.want[i])
+				}
+			}
+			if sig.Bell {
+				t.Error("terminating BEL must not count as a bell")
+			}
+		})
+	}
+}
+
+func TestSilenceDetector(t *testing.T) {
+	silences := make(chan time.Duration, 1)
+	d := newSilenceDetector(50*time.Millisecond, 100*time.Millisecond, func(burst time.Duration) {
+		silences <- burst
+	})
+	defer d.stop()
+
+	// A short burst is not reported
+	d.output(time.Now())
+	select {
+	case <-silences:
+		t.Fatal("short burst should not be reported")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// A sustained burst is reported once output stops
+	start := time.Now()
+	for time.Since(start) < 150*time.Millisecond {
+		d.output(time.Now())
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case burst := <-silences:
+		if burst < 100*time.Millisecond {
+			t.Errorf("expected burst of at least 100ms, got %s", burst)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected silence to be reported")
+	}
+}
+
+func TestTerminalAlerts(t *testing.T) {
+	tm := NewTerminalManager(nil, nil, ResourceLimits{})
+	active := &Terminal{ID: 1, Owner: "alice"}
+	background := &Terminal{ID: 2, Owner: "alice"}
+	tm.terminals[1] = active
+	tm.terminals[2] = background
+	tm.activeTabs["alice"] = 1
+
+	events, unsubscribe := tm.Events().Subscribe()
+	defer unsubscribe()
+
+	tm.raiseAlert(active, func(a *terminalAlerts) { a.Bell = true })
+	if active.Alerts().Bell {
+		t.Error("active tab should not collect alerts")
+	}
+
+	tm.raiseAlert(background, func(a *terminalAlerts) { a.Bell = true })
+	tm.raiseAlert(background, func(a *terminalAlerts) { a.Bell = true })
+	if !background.Alerts().Bell {
+		t.Error("expected background tab to have a bell alert")
+	}
+	if len(events) != 1 {
+		t.Errorf("expected 1 alert event for an unchanged alert, got %d", len(events))
+	}
+
+	// Another user viewing alice's terminal neither clears nor holds back
+	// her alerts, nor changes her tab
+	tm.SetActiveTabID("bob", 2)
+	if !background.Alerts().Bell {
+		t.Error("expected bob viewing the tab to leave alice's alert")
+	}
+	tm.raiseAlert(background, func(a *terminalAlerts) { a.Done = true })
+	if !background.Alerts().Done {
+		t.Error("expected alerts while only bob views the tab")
+	}
+	if id := tm.GetActiveTabID("alice"); id != 1 {
+		t.Errorf("expected alice to still have tab 1 open, got %d", id)
+	}
+
+	tm.SetActiveTabID("alice", 2)
+	if background.Alerts() != (terminalAlerts{}) {
+		t.Errorf("expected alerts to be cleared when viewed, got %+v", background.Alerts())
+	}
+}
+
+func TestOutputMonitorActivity(t *testing.T) {
+	m := &outputMonitor{}
+	now := time.Now()
+
+	if !m.scan([]byte("a"), now).Activity {
+		t.Error("expected first output to be activity")
+	}
+	if m.scan([]byte("b"), now.Add(time.Second)).Activity {
+		t.Error("expected activity to be throttled")
+	}
+	if !m.scan([]byte("c"), now.Add(activityInterval)).Activity {
+		t.Error("expected activity after the throttle interval")
+	}
+}
+
+func TestHandleEventsStream(t *testing.T) {
+	s := &Server{
+		terminalManager: NewTerminalManager(nil, nil, ResourceLimits{}),
+		authManager:     NewAuthManager(),
+		shutdown:        make(chan struct{}),
+	}
+	token, err := s.authManager.CreateSession("alice", RoleDeveloper, true)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event stream, got %q", ct)
+	}
+
+	// The handler subscribes before writing headers, so this is not lost
+	// Only events for terminals alice may watch are sent
+	s.terminalManager.Events().Publish(Event{Type: EventRenamed, TerminalID: 2, Owner: "bob", Title: "hidden"})
+	s.terminalManager.Events().Publish(Event{Type: EventRenamed, TerminalID: 3, Owner: "alice", Title: "logs"})
+
+	reader := bufio.NewReader(resp.Body)
+	var name, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read stream: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			name = v
+		}
+		if v, ok := strings.CutPrefix(line, "data: "); ok {
+			data = v
+		}
+	}
+
+	if name != string(EventRenamed) {
+		t.Errorf("expected event name %q, got %q", EventRenamed, name)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		t.Fatalf("invalid event data %q: %v", data, err)
+	}
+	if e.TerminalID != 3 || e.Title != "logs" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
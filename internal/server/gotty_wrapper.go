@@ -3,11 +3,12 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 
-	"github.com/sorenisanerd/gotty/backend/localcommand"
+	"github.com/corymacd/StratusShell/internal/tracing"
 	"github.com/sorenisanerd/gotty/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GoTTYServer wraps a GoTTY server instance
@@ -17,8 +18,12 @@ type GoTTYServer struct {
 	cancelFunc context.CancelFunc
 }
 
-// NewGoTTYServer creates and starts a new GoTTY server
-func NewGoTTYServer(ctx context.Context, port int, credential, title, shell, workingDir string) (*GoTTYServer, error) {
+// NewGoTTYServer creates and starts a new GoTTY server. Connections are
+// served by factory, which attaches them to the terminal's process.
+func NewGoTTYServer(ctx context.Context, port int, credential, title string, factory server.Factory) (*GoTTYServer, error) {
+	_, span := tracing.Tracer().Start(ctx, "NewGoTTYServer", trace.WithAttributes(attribute.Int("server.port", port)))
+	defer span.End()
+
 	// Create options for GoTTY
 	options := &server.Options{
 		Address:          "localhost",
@@ -29,6 +34,7 @@ func NewGoTTYServer(ctx context.Context, port int, credential, title, shell, wor
 		TitleFormat:      title,
 		EnableBasicAuth:  false,
 		Credential:       "",
+		PassHeaders:      true, // The supervisor reads readOnlyHeader
 	}
 
 	// Parse credential for basic auth
@@ -37,28 +43,12 @@ func NewGoTTYServer(ctx context.Context, port int, credential, title, shell, wor
 		options.EnableBasicAuth = true
 	}
 
-	// Build command with working directory
-	command := shell
-	var args []string
-	if workingDir != "" {
-		// Wrap shell to cd into working directory first
-		command = "sh"
-		args = []string{"-c", fmt.Sprintf("cd %s && exec %s", workingDir, shell)}
-	}
-
-	// Create factory for local command
-	// Note: GoTTY's NewFactory requires a non-nil Options struct (even if empty)
-	// to avoid nil pointer dereference in the library. Using &localcommand.Options{}
-	// provides default values (CloseSignal=1/SIGHUP, CloseTimeout=-1).
-	factory, err := localcommand.NewFactory(command, args, &localcommand.Options{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create command factory: %w", err)
-	}
-
 	// Create server
 	srv, err := server.New(factory, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gotty server: %w", err)
+		err = fmt.Errorf("failed to create gotty server: %w", err)
+		tracing.RecordError(span, err)
+		return nil, err
 	}
 
 	// Create context for server lifecycle
@@ -68,7 +58,7 @@ func NewGoTTYServer(ctx context.Context, port int, credential, title, shell, wor
 	go func() {
 		if err := srv.Run(serverCtx, server.WithGracefullContext(serverCtx)); err != nil {
 			if err != http.ErrServerClosed && serverCtx.Err() == nil {
-				log.Printf("GoTTY server error on port %d: %v", port, err)
+				terminalLogger.Error("GoTTY server error", "port", port, "err", err)
 			}
 		}
 	}()
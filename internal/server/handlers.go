@@ -1,17 +1,28 @@
 package server
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/middleware"
 	"github.com/corymacd/StratusShell/internal/ui"
 	"github.com/corymacd/StratusShell/internal/validation"
 )
 
+// sseHeartbeatInterval is how often idle event streams are pinged
+const sseHeartbeatInterval = 25 * time.Second
+
 // getActor extracts the authenticated user from request context
 func (s *Server) getActor(r *http.Request) string {
 	if user, ok := r.Context().Value(userContextKey).(string); ok {
@@ -21,23 +32,41 @@ func (s *Server) getActor(r *http.Request) string {
 }
 
 func (s *Server) handleGetLayout(w http.ResponseWriter, r *http.Request) {
-	terminals := s.terminalManager.GetTerminals()
+	terminals := s.visibleTerminals(r)
 	layout, err := s.db.GetActiveLayout(r.Context())
 	if err != nil {
 		s.handleError(w, r, err, "Failed to get layout")
 		return
 	}
 
-	// Convert to template data
+	ui.TerminalContainer(terminalData(terminals), layout.LayoutType).Render(r.Context(), w)
+}
+
+// terminalData converts terminals to template data
+func terminalData(terminals []*Terminal) []ui.TerminalData {
 	termData := make([]ui.TerminalData, len(terminals))
 	for i, t := range terminals {
+		stats := t.Stats()
+		alerts := t.Alerts()
 		termData[i] = ui.TerminalData{
-			ID:    t.ID,
-			Title: t.Title,
+			ID:       t.ID,
+			Title:    t.Title,
+			Status:   string(stats.Status),
+			ExitCode: stats.ExitCode,
+			Restarts: stats.Restarts,
+			Crashes:  stats.Crashes,
+			Notify:   t.Notify(),
+			Activity: alerts.Activity,
+			Bell:     alerts.Bell,
+			Done:     alerts.Done,
+			Message:  alerts.Message,
+		}
+		if reapAt, reason := t.ReapWarning(); !reapAt.IsZero() {
+			termData[i].ClosingAt = reapAt.Format("15:04:05")
+			termData[i].ClosingReason = reason
 		}
 	}
-
-	ui.TerminalContainer(termData, layout.LayoutType).Render(r.Context(), w)
+	return termData
 }
 
 func (s *Server) handleLayoutHorizontal(w http.ResponseWriter, r *http.Request) {
@@ -57,18 +86,18 @@ func (s *Server) applyLayoutAndRespond(w http.ResponseWriter, r *http.Request, l
 
 	// Validate layout type
 	if err := validation.ValidateLayoutType(layoutType); err != nil {
-		s.auditLogger.LogLayoutChange(actor, layoutType, audit.OutcomeFailure, err)
+		s.auditFor(r).LogLayoutChange(actor, layoutType, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Invalid layout type")
 		return
 	}
 
-	if err := s.terminalManager.ApplyLayout(layoutType); err != nil {
-		s.auditLogger.LogLayoutChange(actor, layoutType, audit.OutcomeFailure, err)
+	if err := s.terminalManager.ApplyLayout(r.Context(), actor, layoutType); err != nil {
+		s.auditFor(r).LogLayoutChange(actor, layoutType, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to apply layout")
 		return
 	}
 
-	s.auditLogger.LogLayoutChange(actor, layoutType, audit.OutcomeSuccess, nil)
+	s.auditFor(r).LogLayoutChange(actor, layoutType, audit.OutcomeSuccess, nil)
 	s.handleGetLayout(w, r)
 }
 
@@ -77,14 +106,14 @@ func (s *Server) handleAddTerminal(w http.ResponseWriter, r *http.Request) {
 	terminals := s.terminalManager.GetTerminals()
 	title := fmt.Sprintf("Terminal %d", len(terminals)+1)
 
-	terminal, err := s.terminalManager.SpawnTerminal(title, "/bin/bash", "")
+	terminal, err := s.terminalManager.SpawnTerminal(r.Context(), actor, title, CommandSpec{})
 	if err != nil {
-		s.auditLogger.LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
+		s.auditFor(r).LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to add terminal")
 		return
 	}
 
-	s.auditLogger.LogTerminalSpawn(actor, terminal.ID, title, audit.OutcomeSuccess, nil)
+	s.auditFor(r).LogTerminalSpawn(actor, terminal.ID, title, audit.OutcomeSuccess, nil)
 	s.handleGetLayout(w, r)
 }
 
@@ -112,19 +141,55 @@ func (s *Server) handleTerminalAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	terminal, ok := s.terminalManager.GetTerminal(id)
+	if !ok {
+		http.Error(w, "Terminal not found", http.StatusNotFound)
+		return
+	}
+	// Every action, including turning notifications on or off for everyone
+	// watching, needs to be able to type into the terminal
+	if !s.authorizeTerminal(w, r, terminal, true) {
+		return
+	}
+
 	switch r.Method {
 	case http.MethodDelete:
-		if err := s.terminalManager.KillTerminal(id); err != nil {
-			s.auditLogger.LogTerminalKill(actor, id, audit.OutcomeFailure, err)
+		if err := s.terminalManager.KillTerminal(r.Context(), id); err != nil {
+			s.auditFor(r).LogTerminalKill(actor, id, audit.OutcomeFailure, err)
 			s.handleError(w, r, err, "Failed to delete terminal")
 			return
 		}
-		s.auditLogger.LogTerminalKill(actor, id, audit.OutcomeSuccess, nil)
+		s.auditFor(r).LogTerminalKill(actor, id, audit.OutcomeSuccess, nil)
 		
 		// Return updated tab container for tab-based UI
 		s.handleGetTabs(w, r)
 
 	case http.MethodPost:
+		if len(parts) > 1 && parts[1] == "restart" {
+			if err := s.terminalManager.RestartTerminal(id); err != nil {
+				s.auditFor(r).LogTerminalRestart(actor, id, string(terminal.Restart), 0, audit.OutcomeFailure, err)
+				s.handleError(w, r, err, "Failed to restart terminal")
+				return
+			}
+			s.auditFor(r).LogTerminalRestart(actor, id, string(terminal.Restart), 0, audit.OutcomeSuccess, nil)
+			s.handleGetTabs(w, r)
+			return
+		}
+
+		if len(parts) > 1 && parts[1] == "notify" {
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				http.Error(w, "Invalid value for enabled", http.StatusBadRequest)
+				return
+			}
+			if err := s.terminalManager.SetNotify(id, enabled); err != nil {
+				http.Error(w, "Terminal not found", http.StatusNotFound)
+				return
+			}
+			s.handleGetTabBar(w, r)
+			return
+		}
+
 		if len(parts) > 1 && parts[1] == "rename" {
 			// Parse form data
 			if err := r.ParseForm(); err != nil {
@@ -135,28 +200,18 @@ func (s *Server) handleTerminalAction(w http.ResponseWriter, r *http.Request) {
 
 			// Validate title
 			if err := validation.ValidateTerminalTitle(newTitle); err != nil {
-				s.auditLogger.LogTerminalRename(actor, id, "", newTitle, audit.OutcomeFailure, err)
+				s.auditFor(r).LogTerminalRename(actor, id, "", newTitle, audit.OutcomeFailure, err)
 				s.handleError(w, r, err, "Invalid terminal title")
 				return
 			}
 
-			terminal, ok := s.terminalManager.GetTerminal(id)
-			if !ok {
+			oldTitle, err := s.terminalManager.RenameTerminal(r.Context(), id, newTitle)
+			if err != nil {
 				http.Error(w, "Terminal not found", http.StatusNotFound)
 				return
 			}
 
-			oldTitle := terminal.Title
-			terminal.Title = newTitle
-
-			// Persist title change to database
-			if terminal.DBID > 0 {
-				if err := s.db.UpdateActiveTerminalTitle(r.Context(), terminal.DBID, newTitle); err != nil {
-					log.Printf("Warning: failed to update terminal title in db: %v", err)
-				}
-			}
-
-			s.auditLogger.LogTerminalRename(actor, id, oldTitle, newTitle, audit.OutcomeSuccess, nil)
+			s.auditFor(r).LogTerminalRename(actor, id, oldTitle, newTitle, audit.OutcomeSuccess, nil)
 			w.WriteHeader(http.StatusOK)
 		}
 	}
@@ -177,34 +232,49 @@ func (s *Server) handleSaveSession(w http.ResponseWriter, r *http.Request) {
 
 	// Validate inputs
 	if err := validation.ValidateSessionName(name); err != nil {
-		s.auditLogger.LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
+		s.auditFor(r).LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Invalid session name")
 		return
 	}
 
 	if err := validation.ValidateSessionDescription(description); err != nil {
-		s.auditLogger.LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
+		s.auditFor(r).LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Invalid session description")
 		return
 	}
 
-	// Create session
-	sessionID, err := s.db.CreateSession(r.Context(), name, description)
+	// Create session with the layout currently in use
+	layoutType := ""
+	if layout, err := s.db.GetActiveLayout(r.Context()); err == nil {
+		layoutType = layout.LayoutType
+	} else {
+		logger.Warn("failed to get layout for session", "err", err)
+	}
+	sessionID, err := s.db.CreateSession(r.Context(), actor, name, description, layoutType)
 	if err != nil {
-		s.auditLogger.LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
+		s.auditFor(r).LogSessionCreate(actor, -1, name, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to save session")
 		return
 	}
 
-	// Save all current terminals
-	terminals := s.terminalManager.GetTerminals()
+	// Save the user's terminals; the shell column holds the full command line
+	terminals := s.visibleTerminals(r)
 	for i, t := range terminals {
-		if err := s.db.SaveSessionTerminal(r.Context(), sessionID, i, t.Title, t.Shell, t.WorkingDir); err != nil {
-			log.Printf("Warning: failed to save terminal %d: %v", t.ID, err)
+		st := &db.SessionTerminal{
+			SessionID:     sessionID,
+			TerminalIndex: i,
+			Title:         t.Title,
+			Shell:         formatCommandLine(t.Command),
+			WorkingDir:    t.WorkingDir,
+			Env:           t.Env,
+			RestartPolicy: string(t.Restart),
+		}
+		if err := s.db.SaveSessionTerminal(r.Context(), st); err != nil {
+			logger.Warn("failed to save terminal", "terminal", t.ID, "err", err)
 		}
 	}
 
-	s.auditLogger.LogSessionCreate(actor, sessionID, name, audit.OutcomeSuccess, nil)
+	s.auditFor(r).LogSessionCreate(actor, sessionID, name, audit.OutcomeSuccess, nil)
 	ui.SuccessMessage("Session saved successfully").Render(r.Context(), w)
 }
 
@@ -215,13 +285,17 @@ func (s *Server) handleListSessionsModal(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	sessionData := make([]ui.SessionData, len(sessions))
-	for i, sess := range sessions {
-		sessionData[i] = ui.SessionData{
+	user, role := s.getActor(r), s.requestRole(r)
+	sessionData := []ui.SessionData{}
+	for _, sess := range sessions {
+		if !sessionAccess(user, role, sess.Owner) {
+			continue
+		}
+		sessionData = append(sessionData, ui.SessionData{
 			ID:          sess.ID,
 			Name:        sess.Name,
 			Description: sess.Description,
-		}
+		})
 	}
 
 	ui.LoadSessionModal(sessionData).Render(r.Context(), w)
@@ -240,33 +314,65 @@ func (s *Server) handleLoadSession(w http.ResponseWriter, r *http.Request) {
 
 	// Validate session ID
 	if err := validation.ValidateSessionID(sessionID); err != nil {
-		s.auditLogger.LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
+		s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Invalid session ID")
 		return
 	}
 
+	// Users may only load their own sessions
+	user, role := s.getActor(r), s.requestRole(r)
+	saved, err := s.db.GetSession(r.Context(), sessionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !sessionAccess(user, role, saved.Owner)) {
+		s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, errors.New("session not found"))
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
+		s.handleError(w, r, err, "Failed to load session")
+		return
+	}
+
 	// Get session terminals
 	sessionTerminals, err := s.db.GetSessionTerminals(r.Context(), sessionID)
 	if err != nil {
-		s.auditLogger.LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
+		s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to load session")
 		return
 	}
 
-	// Store old terminals to be killed later
-	oldTerminals := s.terminalManager.GetTerminals()
+	// Saved sessions are checked like new terminals before anything starts
+	specs := make([]CommandSpec, len(sessionTerminals))
+	for i, st := range sessionTerminals {
+		spec, err := s.sessionCommandSpec(st)
+		if err != nil {
+			s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
+			s.handleError(w, r, err, "Invalid terminal in session")
+			return
+		}
+		specs[i] = spec
+	}
+
+	// Store the user's old terminals to be killed later. Other users' and
+	// shared terminals keep running, whatever the user may manage.
+	var oldTerminals []*Terminal
+	for _, t := range s.terminalManager.GetTerminals() {
+		if t.Owner == user {
+			oldTerminals = append(oldTerminals, t)
+		}
+	}
 
 	// Spawn new terminals from session first (transactional approach)
 	newTerminals := make([]*Terminal, 0, len(sessionTerminals))
-	for _, st := range sessionTerminals {
-		term, err := s.terminalManager.SpawnTerminal(st.Title, st.Shell, st.WorkingDir)
+	for i, st := range sessionTerminals {
+		term, err := s.terminalManager.SpawnTerminal(r.Context(), actor, st.Title, specs[i])
 		if err != nil {
-			log.Printf("Error: failed to spawn terminal for session: %v", err)
+			logger.Error("failed to spawn terminal for session", "session", sessionID, "err", err)
 			// Rollback: clean up any terminals that were successfully spawned
 			for _, t := range newTerminals {
-				s.terminalManager.KillTerminal(t.ID)
+				s.terminalManager.KillTerminal(r.Context(), t.ID)
 			}
-			s.auditLogger.LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
+			s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeFailure, err)
 			s.handleError(w, r, err, "Failed to spawn new terminals for session")
 			return
 		}
@@ -275,59 +381,198 @@ func (s *Server) handleLoadSession(w http.ResponseWriter, r *http.Request) {
 
 	// Now that new terminals are ready, kill old ones
 	for _, t := range oldTerminals {
-		if err := s.terminalManager.KillTerminal(t.ID); err != nil {
-			log.Printf("Warning: failed to kill old terminal %d: %v", t.ID, err)
+		if err := s.terminalManager.KillTerminal(r.Context(), t.ID); err != nil {
+			logger.Warn("failed to kill old terminal", "terminal", t.ID, "err", err)
 		}
 	}
 
-	// Update layout: determine layout based on terminal count
-	layoutType := "horizontal"
-	if len(sessionTerminals) > 2 {
-		layoutType = "grid"
+	// Update layout: use the session's own, or one based on terminal count
+	layoutType, err := s.db.GetSessionLayoutType(r.Context(), sessionID)
+	if err != nil {
+		logger.Warn("failed to get session layout", "session", sessionID, "err", err)
+	}
+	if layoutType == "" {
+		layoutType = "horizontal"
+		if len(sessionTerminals) > 2 {
+			layoutType = "grid"
+		}
 	}
 	s.db.UpdateActiveLayout(r.Context(), layoutType, len(sessionTerminals))
 
-	s.auditLogger.LogSessionLoad(actor, sessionID, audit.OutcomeSuccess, nil)
+	s.auditFor(r).LogSessionLoad(actor, sessionID, audit.OutcomeSuccess, nil)
 	s.handleGetLayout(w, r)
 }
 
+// sessionCommandSpec builds the CommandSpec of a saved terminal, validating it
+// as parseCommandSpec does the add-terminal form
+func (s *Server) sessionCommandSpec(st *db.SessionTerminal) (CommandSpec, error) {
+	return s.savedCommandSpec(st.Shell, st.WorkingDir, st.Env, st.RestartPolicy)
+}
+
+// savedCommandSpec builds a CommandSpec from the command line, working
+// directory, environment and restart policy stored for a terminal
+func (s *Server) savedCommandSpec(shell, workingDir string, env []string, restartPolicy string) (CommandSpec, error) {
+	var spec CommandSpec
+
+	argv, err := parseCommandLine(shell)
+	if err != nil {
+		// Older sessions stored a bare shell path
+		argv = []string{shell}
+	}
+	if err := validation.ValidateCommand(argv); err != nil {
+		return spec, err
+	}
+	spec.Argv = argv
+
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if err := validation.ValidateEnvVar(name, value, s.config.AllowedEnv); err != nil {
+			return spec, err
+		}
+		spec.Env = append(spec.Env, kv)
+	}
+
+	if restartPolicy != "" {
+		if err := validation.ValidateRestartPolicy(restartPolicy); err != nil {
+			return spec, err
+		}
+		spec.Restart = RestartPolicy(restartPolicy)
+	}
+
+	if err := validation.ValidateWorkingDir(workingDir); err != nil {
+		return spec, err
+	}
+	if workingDir != "" {
+		spec.WorkingDir = filepath.Clean(workingDir)
+	}
+
+	return spec, nil
+}
+
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	// Client certificates sign users in as they reach the UI
+	if s.config.AuthMode == AuthModeClientCert {
+		http.Redirect(w, r, middleware.URL(r.Context(), "/"), http.StatusSeeOther)
+		return
+	}
+
 	// Get current system user
 	user := r.URL.Query().Get("user")
 	if user == "" {
 		user = "anonymous"
 	}
+	ip := s.trustedProxies.ClientIP(r)
+	if !s.checkLogin(w, r, user, ip) {
+		return
+	}
+
+	// Users who have enrolled must also give a code, which proves who they
+	// are
+	ok, enrolled := s.checkSecondFactor(w, r, user, ip)
+	if !ok {
+		return
+	}
+
+	if !s.signIn(w, r, user, ip, enrolled) {
+		return
+	}
+
+	// Users without a second factor must enroll before anything else
+	if s.config.Require2FA && !enrolled {
+		s.authManager.SetMustEnroll(user, true)
+		http.Redirect(w, r, middleware.URL(r.Context(), "/2fa"), http.StatusSeeOther)
+		return
+	}
+
+	// Redirect to home
+	http.Redirect(w, r, middleware.URL(r.Context(), "/"), http.StatusSeeOther)
+}
+
+// checkLogin refuses malformed usernames and locked out users and clients,
+// before anything else is checked. It responds itself and returns false if
+// the login may not go ahead.
+func (s *Server) checkLogin(w http.ResponseWriter, r *http.Request, user, ip string) bool {
+	// Malformed usernames are only counted against the client's IP
+	var userErr error
+	guardUser := user
+	if !usernamePattern.MatchString(user) {
+		userErr = fmt.Errorf("invalid username %q", user)
+		guardUser = ""
+	}
+
+	// Refuse locked out users and clients before anything else
+	until, err := s.loginGuard.Check(r.Context(), guardUser, ip)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to check login lockout")
+		return false
+	}
+	if !until.IsZero() {
+		metrics.AuthFailures.WithLabelValues("locked").Inc()
+		s.auditFor(r).LogAuthLogin(user, audit.OutcomeFailure, ErrLoginLocked)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+		http.Error(w, "Too many failed logins. Please try again later.", http.StatusTooManyRequests)
+		return false
+	}
+
+	if userErr != nil {
+		metrics.AuthFailures.WithLabelValues("invalid_user").Inc()
+		s.auditFor(r).LogAuthLogin(user, audit.OutcomeFailure, userErr)
+		s.recordLoginFailure(r, guardUser, ip)
+		http.Error(w, "Invalid username", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// signIn starts a session for user and gives the client its cookie.
+// verified reports that the user proved who they are, rather than only
+// naming themselves, which their role may depend on. It responds itself
+// and returns false on failure.
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, user, ip string, verified bool) bool {
+	role, err := s.sessionRole(r.Context(), user, verified)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("login").Inc()
+		s.auditFor(r).LogAuthLogin(user, audit.OutcomeFailure, err)
+		s.handleError(w, r, err, "Failed to create session")
+		return false
+	}
 
 	// Create session
-	token, err := s.authManager.CreateSession(user)
+	token, err := s.authManager.CreateSession(user, role, verified)
 	if err != nil {
-		s.auditLogger.LogAuthLogin(user, audit.OutcomeFailure, err)
+		metrics.AuthFailures.WithLabelValues("login").Inc()
+		s.auditFor(r).LogAuthLogin(user, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to create session")
-		return
+		return false
 	}
 
 	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   86400, // 24 hours
-	})
-
-	s.auditLogger.LogAuthLogin(user, audit.OutcomeSuccess, nil)
+	s.setSessionCookie(w, r, token)
 
-	// Redirect to home
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// Record which session the login created
+	src := s.requestSource(r)
+	if session, ok := s.authManager.ValidateSession(token); ok {
+		src.SessionID = session.ID
+	}
+	s.auditLogger.WithSource(src).LogAuthLogin(user, audit.OutcomeSuccess, nil)
+	if err := s.loginGuard.Succeed(r.Context(), user); err != nil {
+		logger.Warn("failed to clear login failures", "user", user, "err", err)
+	}
+	return true
 }
 
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	actor := s.getActor(r)
+	src := s.requestSource(r)
 
 	// Get session cookie
 	cookie, err := r.Cookie("session_token")
 	if err == nil {
+		// Logout is not behind AuthMiddleware, so identify the user here
+		if session, ok := s.authManager.ValidateSession(cookie.Value); ok {
+			actor = session.User
+			src.SessionID = session.ID
+		}
 		s.authManager.DeleteSession(cookie.Value)
 	}
 
@@ -335,32 +580,117 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    "",
-		Path:     "/",
+		Path:     middleware.URL(r.Context(), "/"),
 		HttpOnly: true,
 		MaxAge:   -1,
 	})
 
-	s.auditLogger.LogAuthLogout(actor, audit.OutcomeSuccess)
+	s.auditLogger.WithSource(src).LogAuthLogout(actor, audit.OutcomeSuccess)
 
 	// Redirect to login
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	http.Redirect(w, r, middleware.URL(r.Context(), "/login"), http.StatusSeeOther)
 }
 
 // handleGetTabs returns the tab container with all terminals
 func (s *Server) handleGetTabs(w http.ResponseWriter, r *http.Request) {
-	terminals := s.terminalManager.GetTerminals()
-	activeTabID := s.terminalManager.GetActiveTabID()
+	terminals := s.visibleTerminals(r)
 
-	// Convert to template data
-	termData := make([]ui.TerminalData, len(terminals))
-	for i, t := range terminals {
-		termData[i] = ui.TerminalData{
-			ID:    t.ID,
-			Title: t.Title,
+	ui.TabContainer(terminalData(terminals), s.activeTab(r, terminals)).Render(r.Context(), w)
+}
+
+// handleGetTabBar returns just the tab bar, leaving the active terminal untouched
+func (s *Server) handleGetTabBar(w http.ResponseWriter, r *http.Request) {
+	terminals := s.visibleTerminals(r)
+
+	ui.TabBar(terminalData(terminals), s.activeTab(r, terminals)).Render(r.Context(), w)
+}
+
+// activeTab returns the terminal the request's user has open, falling back
+// to the first of the visible terminals, or 0 if there are none
+func (s *Server) activeTab(r *http.Request, visible []*Terminal) int {
+	id := s.terminalManager.GetActiveTabID(s.getActor(r))
+	for _, t := range visible {
+		if t.ID == id {
+			return id
 		}
 	}
+	if len(visible) > 0 {
+		return visible[0].ID
+	}
+	return 0
+}
 
-	ui.TabContainer(termData, activeTabID).Render(r.Context(), w)
+// handleEvents streams terminal lifecycle events as Server-Sent Events.
+// Each event is sent with its type as the SSE event name and the Event as
+// JSON data. Only events for terminals the user may watch are sent. The
+// stream ends when the client disconnects, the session
+// expires or the server shuts down.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	session, valid := s.authManager.ValidateSession(cookie.Value)
+	if !valid {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	events, unsubscribe := s.terminalManager.Events().Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-heartbeat.C:
+			// Pick up role changes along with the session's expiry
+			if session, valid = s.authManager.ValidateSession(cookie.Value); !valid {
+				return
+			}
+			// Comment lines keep idle connections open through proxies
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if view, _ := terminalAccess(session.User, session.Role, event.Owner); !view {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Warn("failed to encode event", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
 }
 
 // handleSwitchTab switches the active tab
@@ -374,37 +704,114 @@ func (s *Server) handleSwitchTab(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate terminal exists
-	if _, ok := s.terminalManager.GetTerminal(terminalID); !ok {
+	terminal, ok := s.terminalManager.GetTerminal(terminalID)
+	if !ok {
 		http.Error(w, "Terminal not found", http.StatusNotFound)
 		return
 	}
+	if !s.authorizeTerminal(w, r, terminal, false) {
+		return
+	}
 
 	// Set as active tab
-	s.terminalManager.SetActiveTabID(terminalID)
+	s.terminalManager.SetActiveTabID(s.getActor(r), terminalID)
 
 	// Return just the terminal iframe
 	ui.ActiveTerminal(terminalID).Render(r.Context(), w)
 }
 
-// handleAddTerminalTab adds a new terminal and returns the updated tab container
+// handleNewTerminalModal renders the form for starting a terminal with a custom command
+func (s *Server) handleNewTerminalModal(w http.ResponseWriter, r *http.Request) {
+	ui.NewTerminalModal().Render(r.Context(), w)
+}
+
+// handleAddTerminalTab adds a new terminal and returns the updated tab container.
+// The optional form fields title, command, env (KEY=VALUE lines), working_dir
+// and restart_policy customize what the terminal runs, and record_commands
+// records its command lines in the audit log.
 func (s *Server) handleAddTerminalTab(w http.ResponseWriter, r *http.Request) {
 	actor := s.getActor(r)
-	terminals := s.terminalManager.GetTerminals()
-	title := fmt.Sprintf("Terminal %d", len(terminals)+1)
+	if err := r.ParseForm(); err != nil {
+		s.handleError(w, r, err, "Failed to parse form")
+		return
+	}
+
+	title := validation.SanitizeString(r.FormValue("title"))
+	if title == "" {
+		terminals := s.terminalManager.GetTerminals()
+		title = fmt.Sprintf("Terminal %d", len(terminals)+1)
+	}
+	if err := validation.ValidateTerminalTitle(title); err != nil {
+		s.auditFor(r).LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
+		s.handleError(w, r, err, "Invalid terminal title")
+		return
+	}
+
+	spec, err := s.parseCommandSpec(r)
+	if err != nil {
+		s.auditFor(r).LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
+		s.handleError(w, r, err, err.Error())
+		return
+	}
 
-	terminal, err := s.terminalManager.SpawnTerminal(title, "/bin/bash", "")
+	terminal, err := s.terminalManager.SpawnTerminal(r.Context(), actor, title, spec)
 	if err != nil {
-		s.auditLogger.LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
+		s.auditFor(r).LogTerminalSpawn(actor, -1, title, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to add terminal")
 		return
 	}
 
-	s.auditLogger.LogTerminalSpawn(actor, terminal.ID, title, audit.OutcomeSuccess, nil)
-	
+	s.auditFor(r).LogTerminalSpawn(actor, terminal.ID, title, audit.OutcomeSuccess, nil)
+
 	// Return updated tab container
 	s.handleGetTabs(w, r)
 }
 
+// parseCommandSpec builds and validates a CommandSpec from the add-terminal form
+func (s *Server) parseCommandSpec(r *http.Request) (CommandSpec, error) {
+	var spec CommandSpec
+
+	argv, err := parseCommandLine(r.FormValue("command"))
+	if err != nil {
+		return spec, &validation.ValidationError{Field: "command", Message: err.Error()}
+	}
+	if err := validation.ValidateCommand(argv); err != nil {
+		return spec, err
+	}
+	spec.Argv = argv
+
+	vars, err := parseEnvLines(r.FormValue("env"))
+	if err != nil {
+		return spec, &validation.ValidationError{Field: "env", Message: err.Error()}
+	}
+	for _, kv := range vars {
+		if err := validation.ValidateEnvVar(kv[0], kv[1], s.config.AllowedEnv); err != nil {
+			return spec, err
+		}
+		spec.Env = append(spec.Env, kv[0]+"="+kv[1])
+	}
+
+	policy := r.FormValue("restart_policy")
+	if policy != "" {
+		if err := validation.ValidateRestartPolicy(policy); err != nil {
+			return spec, err
+		}
+		spec.Restart = RestartPolicy(policy)
+	}
+
+	spec.RecordCommands = r.FormValue("record_commands") != ""
+
+	workingDir := strings.TrimSpace(r.FormValue("working_dir"))
+	if err := validation.ValidateWorkingDir(workingDir); err != nil {
+		return spec, err
+	}
+	if workingDir != "" {
+		spec.WorkingDir = filepath.Clean(workingDir)
+	}
+
+	return spec, nil
+}
+
 // handleDeleteTerminalTab deletes a terminal and returns the updated tab container
 func (s *Server) handleDeleteTerminalTab(w http.ResponseWriter, r *http.Request) {
 	actor := s.getActor(r)
@@ -423,20 +830,29 @@ func (s *Server) handleDeleteTerminalTab(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.terminalManager.KillTerminal(id); err != nil {
-		s.auditLogger.LogTerminalKill(actor, id, audit.OutcomeFailure, err)
+	terminal, ok := s.terminalManager.GetTerminal(id)
+	if !ok {
+		http.Error(w, "Terminal not found", http.StatusNotFound)
+		return
+	}
+	if !s.authorizeTerminal(w, r, terminal, true) {
+		return
+	}
+
+	if err := s.terminalManager.KillTerminal(r.Context(), id); err != nil {
+		s.auditFor(r).LogTerminalKill(actor, id, audit.OutcomeFailure, err)
 		s.handleError(w, r, err, "Failed to delete terminal")
 		return
 	}
 	
-	s.auditLogger.LogTerminalKill(actor, id, audit.OutcomeSuccess, nil)
+	s.auditFor(r).LogTerminalKill(actor, id, audit.OutcomeSuccess, nil)
 
 	// Return updated tab container
 	s.handleGetTabs(w, r)
 }
 
 func (s *Server) handleError(w http.ResponseWriter, r *http.Request, err error, userMsg string) {
-	log.Printf("Error: %v", err)
+	logger.ErrorContext(r.Context(), userMsg, "err", err, "path", r.URL.Path)
 	w.Header().Set("HX-Retarget", "#modal")
 	w.Header().Set("HX-Reswap", "innerHTML")
 	w.WriteHeader(http.StatusInternalServerError)
@@ -1,9 +1,20 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
+
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // HealthStatus represents the health check response
@@ -19,18 +30,16 @@ var serverStartTime = time.Now()
 
 // handleHealth returns the health status of the server
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	dbErr := s.db.Ping()
 	status := HealthStatus{
 		Status:            "healthy",
 		Timestamp:         time.Now(),
 		ActiveTerminals:   len(s.terminalManager.GetTerminals()),
-		DatabaseConnected: true,
+		DatabaseConnected: dbErr == nil,
 		UptimeSeconds:     int64(time.Since(serverStartTime).Seconds()),
 	}
-
-	// Check database connection
-	if err := s.db.Ping(); err != nil {
+	if dbErr != nil {
 		status.Status = "unhealthy"
-		status.DatabaseConnected = false
 	}
 
 	// Set status code based on health
@@ -39,38 +48,255 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		statusCode = http.StatusServiceUnavailable
 	}
 
+	writeJSON(w, statusCode, status)
+}
+
+// handleLiveness reports that the server is running and able to answer
+// requests. It deliberately checks nothing else, so a failing dependency
+// makes the server unready rather than getting it restarted.
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"uptime_seconds": int64(time.Since(serverStartTime).Seconds()),
+	})
+}
+
+const (
+	// minFreeDisk is the free space below which the database directory fails the readiness check
+	minFreeDisk = 64 << 20
+
+	// lowFreeDisk is the free space below which the database directory is reported as a warning
+	lowFreeDisk = 512 << 20
+
+	// ptyWarnRatio is the share of the system's PTYs in use above which the PTY check warns
+	ptyWarnRatio = 0.9
+
+	// terminalProbeTimeout is how long a terminal's GoTTY backend has to accept a connection
+	terminalProbeTimeout = 2 * time.Second
+)
+
+// Check states. Only a failed check makes the server unready.
+const (
+	CheckOK      = "ok"
+	CheckWarn    = "warn"
+	CheckFail    = "fail"
+	CheckSkipped = "skipped"
+)
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// TerminalCheck is the result of probing one terminal's GoTTY backend
+type TerminalCheck struct {
+	ID    int    `json:"id"`
+	Port  int    `json:"port"`
+	Alive bool   `json:"alive"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessStatus is the readiness check response
+type ReadinessStatus struct {
+	Status    string                 `json:"status"`
+	Timestamp time.Time              `json:"timestamp"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+// handleReadiness reports whether the server can take new work: the
+// database accepts writes, its directory has free space, PTYs are available
+// and each terminal's GoTTY backend is accepting connections. Terminals
+// whose backend is gone are marked for the reaper to close; they are
+// reported but do not make the server unready. Only admins are shown each
+// check's message and details, which name paths, ports and terminals.
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	status := ReadinessStatus{
+		Status:    "ready",
+		Timestamp: time.Now(),
+		Checks: map[string]CheckResult{
+			"database":  runCheck(func() CheckResult { return s.checkDatabase(r.Context()) }),
+			"disk":      runCheck(s.checkDisk),
+			"pty":       runCheck(checkPTYs),
+			"terminals": runCheck(s.checkTerminals),
+		},
+	}
+
+	select {
+	case <-s.shutdown:
+		status.Checks["server"] = CheckResult{Status: CheckFail, Message: "shutting down"}
+	default:
+	}
+
+	statusCode := http.StatusOK
+	for _, check := range status.Checks {
+		if check.Status == CheckFail {
+			status.Status = "not_ready"
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
+
+	if session := s.requestSession(r); session == nil || session.Role != RoleAdmin || session.MustEnroll {
+		for name, check := range status.Checks {
+			check.Message, check.Details = "", nil
+			status.Checks[name] = check
+		}
+	}
+
+	writeJSON(w, statusCode, status)
+}
+
+// runCheck runs check and records how long it took
+func runCheck(check func() CheckResult) CheckResult {
+	start := time.Now()
+	result := check()
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result
+}
+
+// checkDatabase confirms the database accepts writes, not just connections
+func (s *Server) checkDatabase(ctx context.Context) CheckResult {
+	if err := s.db.CheckWritable(ctx); err != nil {
+		return CheckResult{Status: CheckFail, Message: err.Error()}
+	}
+	return CheckResult{Status: CheckOK}
+}
+
+// checkDisk checks the free space where a SQLite database lives
+func (s *Server) checkDisk() CheckResult {
+	sqlite, ok := s.db.(*db.DB)
+	if !ok {
+		return CheckResult{Status: CheckSkipped, Message: "database is not a local file"}
+	}
+	dir := filepath.Dir(sqlite.Path())
+	free, err := diskFree(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return CheckResult{Status: CheckSkipped, Message: "not supported on this platform"}
+	}
+	if err != nil {
+		return CheckResult{Status: CheckFail, Message: err.Error()}
+	}
+
+	result := CheckResult{Status: CheckOK, Details: map[string]interface{}{
+		"path":       dir,
+		"free_bytes": free,
+	}}
+	switch {
+	case free < minFreeDisk:
+		result.Status = CheckFail
+		result.Message = "database directory is out of space"
+	case free < lowFreeDisk:
+		result.Status = CheckWarn
+		result.Message = "database directory is low on space"
+	}
+	return result
+}
+
+// checkPTYs checks how many of the system's pseudo-terminals are in use.
+// Every terminal needs one, so none left means none can be started.
+func checkPTYs() CheckResult {
+	inUse, limit, err := ptyUsage()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return CheckResult{Status: CheckSkipped, Message: "not supported on this platform"}
+	}
+	if err != nil {
+		return CheckResult{Status: CheckFail, Message: err.Error()}
+	}
+
+	result := CheckResult{Status: CheckOK, Details: map[string]interface{}{
+		"in_use": inUse,
+		"max":    limit,
+	}}
+	switch {
+	case inUse >= limit:
+		result.Status = CheckFail
+		result.Message = "PTY limit reached"
+	case float64(inUse) >= float64(limit)*ptyWarnRatio:
+		result.Status = CheckWarn
+		result.Message = "PTY limit nearly reached"
+	}
+	return result
+}
+
+// checkTerminals probes each terminal's GoTTY backend, marking the ones
+// that no longer accept connections for cleanup
+func (s *Server) checkTerminals() CheckResult {
+	terminals := s.terminalManager.GetTerminals()
+	sort.Slice(terminals, func(i, j int) bool { return terminals[i].ID < terminals[j].ID })
+
+	result := CheckResult{Status: CheckOK}
+	checks := make([]TerminalCheck, 0, len(terminals))
+	dead := 0
+	for _, terminal := range terminals {
+		check := TerminalCheck{ID: terminal.ID, Port: terminal.Port, Alive: true}
+		if err := probeTerminal(terminal); err != nil {
+			check.Alive = false
+			check.Error = err.Error()
+			dead++
+			if terminal.markUnhealthy() {
+				terminalLogger.Warn("terminal backend is not accepting connections, marked for cleanup", "terminal", terminal.ID, "port", terminal.Port, "err", err)
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	if dead > 0 {
+		result.Status = CheckWarn
+		result.Message = fmt.Sprintf("%d of %d terminals are not accepting connections and will be closed", dead, len(terminals))
+	}
+	result.Details = map[string]interface{}{"terminals": checks}
+	return result
+}
+
+// probeTerminal connects to a terminal's GoTTY backend
+func probeTerminal(terminal *Terminal) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(terminal.Port)), terminalProbeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(status)
+	json.NewEncoder(w).Encode(v)
 }
 
-// MetricsStatus represents basic metrics
+// MetricsStatus is the JSON form of the headline metrics
 type MetricsStatus struct {
 	TotalTerminalsSpawned int       `json:"total_terminals_spawned"`
 	ActiveTerminals       int       `json:"active_terminals"`
 	TotalSessions         int       `json:"total_sessions"`
+	WebSocketConnections  int       `json:"websocket_connections"`
 	UptimeSeconds         int64     `json:"uptime_seconds"`
 	Timestamp             time.Time `json:"timestamp"`
 }
 
-// handleMetrics returns basic metrics
+// handleMetrics serves metrics in the Prometheus text format, or the
+// headline figures as JSON with ?format=json
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	// Get total sessions from database
-	sessions, err := s.db.GetAllSessions(r.Context())
-	totalSessions := 0
-	if err == nil {
-		totalSessions = len(sessions)
+	if r.URL.Query().Get("format") != "json" {
+		promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		return
 	}
 
-	metrics := MetricsStatus{
-		TotalTerminalsSpawned: s.terminalManager.GetNextID() - 1, // nextID starts at 1
+	totalSessions, err := s.db.CountSessions(r.Context())
+	if err != nil {
+		logger.Warn("failed to count sessions", "err", err)
+	}
+
+	status := MetricsStatus{
+		TotalTerminalsSpawned: int(metrics.Value(metrics.TerminalSpawns.WithLabelValues("success"))),
 		ActiveTerminals:       len(s.terminalManager.GetTerminals()),
 		TotalSessions:         totalSessions,
+		WebSocketConnections:  int(metrics.Value(metrics.WebSocketConnections)),
 		UptimeSeconds:         int64(time.Since(serverStartTime).Seconds()),
 		Timestamp:             time.Now(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(metrics)
+	writeJSON(w, http.StatusOK, status)
 }
@@ -0,0 +1,45 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskFree returns the space available to unprivileged users on the
+// filesystem holding path
+func diskFree(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem: %w", err)
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// ptyUsage returns how many pseudo-terminals are allocated and the kernel's limit
+func ptyUsage() (inUse, limit int, err error) {
+	if inUse, err = readProcInt("/proc/sys/kernel/pty/nr"); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = readProcInt("/proc/sys/kernel/pty/max"); err != nil {
+		return 0, 0, err
+	}
+	return inUse, limit, nil
+}
+
+func readProcInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return n, nil
+}
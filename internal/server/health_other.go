@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// diskFree is only implemented on Linux
+func diskFree(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// ptyUsage is only implemented on Linux
+func ptyUsage() (inUse, limit int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	if _, err := database.CreateSession(t.Context(), "alice", "dev", "", ""); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	s := &Server{
+		db:              database,
+		terminalManager: NewTerminalManager(database, nil, ResourceLimits{MaxTerminalsPerUser: 1}),
+	}
+
+	// A refused spawn is counted as a failure
+	failures := metrics.Value(metrics.TerminalSpawns.WithLabelValues("failure"))
+	s.terminalManager.terminals[1] = &Terminal{ID: 1, Owner: "alice"}
+	if _, err := s.terminalManager.SpawnTerminal(t.Context(), "alice", "second", CommandSpec{}); err == nil {
+		t.Fatal("expected the spawn to be refused")
+	}
+	if got := metrics.Value(metrics.TerminalSpawns.WithLabelValues("failure")); got != failures+1 {
+		t.Errorf("expected %v spawn failures, got %v", failures+1, got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected the Prometheus text format, got %q", ct)
+	}
+	body := rec.Body.String()
+	for _, name := range []string{
+		`stratusshell_terminal_spawns_total{outcome="failure"}`,
+		"stratusshell_db_query_duration_seconds_bucket",
+		`operation="create_session"`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected %s in the exposition", name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics?format=json", nil))
+	var status MetricsStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if status.TotalSessions != 1 || status.ActiveTerminals != 1 {
+		t.Errorf("unexpected metrics: %+v", status)
+	}
+}
+
+func TestHandleReadiness(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	tm := NewTerminalManager(database, nil, ResourceLimits{})
+	defer tm.Shutdown()
+	s := &Server{db: database, terminalManager: tm}
+
+	live, err := tm.SpawnTerminal(t.Context(), "alice", "live", CommandSpec{Argv: []string{"/bin/cat"}})
+	if err != nil {
+		t.Fatalf("failed to spawn terminal: %v", err)
+	}
+	dead, err := tm.SpawnTerminal(t.Context(), "alice", "dead", CommandSpec{Argv: []string{"/bin/cat"}})
+	if err != nil {
+		t.Fatalf("failed to spawn terminal: %v", err)
+	}
+
+	// GoTTY starts listening, and releases its listener after Stop, in the background
+	waitFor := func(terminal *Terminal, alive bool) {
+		for deadline := time.Now().Add(5 * time.Second); (probeTerminal(terminal) == nil) != alive; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for terminal %d alive=%v", terminal.ID, alive)
+			}
+		}
+	}
+	waitFor(live, true)
+	waitFor(dead, true)
+	dead.GoTTYServer.Stop()
+	waitFor(dead, false)
+
+	// Only admins are shown the details
+	s.authManager = NewAuthManager()
+	token, err := s.authManager.CreateSession("alice", RoleAdmin, true)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+
+	rec := httptest.NewRecorder()
+	s.handleReadiness(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with a dead terminal, got %d: %s", rec.Code, rec.Body)
+	}
+	var status ReadinessStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if status.Status != "ready" {
+		t.Errorf("expected ready, got %q", status.Status)
+	}
+	for _, name := range []string{"database", "disk", "pty"} {
+		if got := status.Checks[name].Status; got != CheckOK && got != CheckSkipped {
+			t.Errorf("expected the %s check to pass, got %+v", name, status.Checks[name])
+		}
+	}
+	if status.Checks["terminals"].Status != CheckWarn {
+		t.Fatalf("expected the terminals check to warn, got %+v", status.Checks["terminals"])
+	}
+	var terminals []TerminalCheck
+	data, _ := json.Marshal(status.Checks["terminals"].Details["terminals"])
+	if err := json.Unmarshal(data, &terminals); err != nil {
+		t.Fatalf("invalid terminal details: %v", err)
+	}
+	want := []TerminalCheck{{ID: live.ID, Port: live.Port, Alive: true}, {ID: dead.ID, Port: dead.Port}}
+	if len(terminals) != len(want) {
+		t.Fatalf("expected %d terminal results, got %+v", len(want), terminals)
+	}
+	for i, got := range terminals {
+		if got.ID != want[i].ID || got.Port != want[i].Port || got.Alive != want[i].Alive {
+			t.Errorf("terminal %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+	if live.unhealthy.Load() || !dead.unhealthy.Load() {
+		t.Error("expected only the dead terminal to be marked for cleanup")
+	}
+
+	// Anyone else only sees each check's status
+	rec = httptest.NewRecorder()
+	s.handleReadiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for name, check := range status.Checks {
+		if check.Message != "" || check.Details != nil {
+			t.Errorf("expected no message or details for the %s check, got %+v", name, check)
+		}
+	}
+	if status.Checks["terminals"].Status != CheckWarn {
+		t.Errorf("expected the terminals check to still warn, got %+v", status.Checks["terminals"])
+	}
+
+	// The reaper closes the dead terminal and leaves the live one alone
+	tm.reap(time.Now())
+	if _, ok := tm.GetTerminal(dead.ID); ok {
+		t.Error("expected the dead terminal to be closed")
+	}
+	if _, ok := tm.GetTerminal(live.ID); !ok {
+		t.Error("expected the live terminal to be kept")
+	}
+}
+
+func TestHandleReadinessDatabaseFailure(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	database.Close()
+
+	s := &Server{db: database, terminalManager: NewTerminalManager(database, nil, ResourceLimits{})}
+	defer s.terminalManager.Shutdown()
+
+	rec := httptest.NewRecorder()
+	s.handleReadiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	var status ReadinessStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if status.Status != "not_ready" || status.Checks["database"].Status != CheckFail {
+		t.Errorf("expected a failed database check, got %+v", status)
+	}
+
+	// Liveness does not depend on the database
+	rec = httptest.NewRecorder()
+	s.handleLiveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected liveness to pass, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResourceLimits bounds how long terminals live and how much each user may use.
+// Zero values disable the corresponding limit.
+type ResourceLimits struct {
+	// IdleTimeout closes terminals with no input and no output for this long
+	IdleTimeout time.Duration
+	// MaxLifetime closes terminals this long after they were started
+	MaxLifetime time.Duration
+
+	// MaxTerminalsPerUser caps how many terminals one user may have open
+	MaxTerminalsPerUser int
+	// CPULimit is the number of CPUs a user's terminals may use together (cgroups v2)
+	CPULimit float64
+	// MemoryLimit is the memory in bytes a user's terminals may use together (cgroups v2)
+	MemoryLimit int64
+}
+
+// ParseByteSize parses sizes such as "512M", "2G" or "1073741824".
+// Suffixes are binary (K = 1024) and may be followed by "i" and/or "B".
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	multiplier := int64(1)
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(upper, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"512M", 512 << 20, false},
+		{"2G", 2 << 30, false},
+		{"2GiB", 2 << 30, false},
+		{"1.5g", 3 << 29, false},
+		{"64kb", 64 << 10, false},
+		{"G", 0, true},
+		{"-1G", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestReapSchedule(t *testing.T) {
+	created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	lastActivity := created.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		limits     ResourceLimits
+		wantOK     bool
+		wantReason string
+		wantAt     time.Time
+		wantWarnAt time.Time
+	}{
+		{
+			name:   "no limits",
+			limits: ResourceLimits{},
+		},
+		{
+			name:       "idle timeout",
+			limits:     ResourceLimits{IdleTimeout: 30 * time.Minute},
+			wantOK:     true,
+			wantReason: ReapIdle,
+			wantAt:     lastActivity.Add(30 * time.Minute),
+			wantWarnAt: lastActivity.Add(29 * time.Minute),
+		},
+		{
+			name:       "lifetime comes first",
+			limits:     ResourceLimits{IdleTimeout: 30 * time.Minute, MaxLifetime: 80 * time.Minute},
+			wantOK:     true,
+			wantReason: ReapLifetime,
+			wantAt:     created.Add(80 * time.Minute),
+			wantWarnAt: created.Add(79 * time.Minute),
+		},
+		{
+			name:       "short timeout gets a short warning",
+			limits:     ResourceLimits{IdleTimeout: 40 * time.Second},
+			wantOK:     true,
+			wantReason: ReapIdle,
+			wantAt:     lastActivity.Add(40 * time.Second),
+			wantWarnAt: lastActivity.Add(20 * time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, ok := tt.limits.reapScheduleFor(created, lastActivity)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if sched.Reason != tt.wantReason || !sched.Deadline.Equal(tt.wantAt) || !sched.WarnAt.Equal(tt.wantWarnAt) {
+				t.Errorf("got %+v, want reason %s deadline %s warning %s", sched, tt.wantReason, tt.wantAt, tt.wantWarnAt)
+			}
+		})
+	}
+}
+
+func TestReapWarning(t *testing.T) {
+	tm := NewTerminalManager(nil, nil, ResourceLimits{IdleTimeout: 10 * time.Minute})
+	defer close(tm.done) // Stops the background reaper; reap is called directly below
+
+	sup, err := startSupervisor(CommandSpec{Argv: []string{"/bin/cat"}}, supervisorHooks{})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	terminal := &Terminal{ID: 1, Owner: "alice", CreatedAt: time.Now(), process: sup}
+	tm.terminals[1] = terminal
+
+	events, unsubscribe := tm.Events().Subscribe()
+	defer unsubscribe()
+
+	lastActivity := sup.Stats().LastActivity
+
+	// Inside the warning period: the user is warned once
+	tm.reap(lastActivity.Add(9*time.Minute + 30*time.Second))
+	tm.reap(lastActivity.Add(9*time.Minute + 45*time.Second))
+	reapAt, reason := terminal.ReapWarning()
+	if reapAt.IsZero() || reason != ReapIdle {
+		t.Fatalf("expected idle reap warning, got %v %q", reapAt, reason)
+	}
+	warnings := 0
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventReapWarning {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("expected 1 reap warning, got %d", warnings)
+	}
+
+	// Activity moves the deadline and clears the warning
+	tm.reap(lastActivity.Add(time.Minute))
+	if reapAt, _ := terminal.ReapWarning(); !reapAt.IsZero() {
+		t.Errorf("expected warning to be cleared, got %v", reapAt)
+	}
+}
+
+func TestSpawnTerminalUserQuota(t *testing.T) {
+	tm := NewTerminalManager(nil, nil, ResourceLimits{MaxTerminalsPerUser: 1})
+	tm.terminals[1] = &Terminal{ID: 1, Owner: "alice"}
+
+	_, err := tm.SpawnTerminal(t.Context(), "alice", "second", CommandSpec{})
+	if err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Errorf("expected quota error, got %v", err)
+	}
+}
+
+func TestSpawnTerminalCountsPendingSpawns(t *testing.T) {
+	tm := NewTerminalManager(nil, nil, ResourceLimits{MaxTerminalsPerUser: 1})
+
+	// A terminal alice is still starting takes her only spot
+	tm.pending["alice"] = 1
+	_, err := tm.SpawnTerminal(t.Context(), "alice", "second", CommandSpec{})
+	if err == nil || !strings.Contains(err.Error(), "quota") {
+		t.Errorf("expected quota error, got %v", err)
+	}
+
+	// And counts towards the server's limit for everyone else
+	tm.maxTerminals = 1
+	_, err = tm.SpawnTerminal(t.Context(), "bob", "first", CommandSpec{})
+	if err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("expected maximum terminals error, got %v", err)
+	}
+	delete(tm.pending, "alice")
+
+	// A spawn that fails gives its spot back
+	_, err = tm.SpawnTerminal(t.Context(), "bob", "broken", CommandSpec{Argv: []string{"/nonexistent/command"}})
+	if err == nil {
+		t.Fatal("expected the spawn to fail")
+	}
+	if n := tm.pending["bob"]; n != 0 {
+		t.Errorf("expected no pending spawns, got %d", n)
+	}
+}
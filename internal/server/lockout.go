@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+// LockoutPolicy configures how repeated failed logins are locked out. Once a
+// username or client IP reaches Threshold consecutive failures, it is locked
+// out for Duration, doubling with every further failure up to Max. Threshold
+// 0 disables lockouts.
+type LockoutPolicy struct {
+	Threshold int
+	Duration  time.Duration
+	Max       time.Duration
+}
+
+// lockoutForgetAfter is how long after the last failure a counter starts again
+const lockoutForgetAfter = 24 * time.Hour
+
+// ErrLoginLocked is returned for logins refused because of a lockout
+var ErrLoginLocked = errors.New("too many failed logins")
+
+// lockoutDuration returns how long to lock out after the given number of
+// consecutive failures, or 0 for none
+func (p LockoutPolicy) lockoutDuration(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+	d := p.Duration
+	for i := p.Threshold; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// LoginGuard tracks failed logins per username and per client IP in the
+// database, so lockouts survive a restart
+type LoginGuard struct {
+	store  db.LoginFailureStore
+	policy LockoutPolicy
+	now    func() time.Time
+}
+
+// NewLoginGuard creates a login guard
+func NewLoginGuard(store db.LoginFailureStore, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{store: store, policy: policy, now: time.Now}
+}
+
+// loginSubjects returns the counters a login attempt affects. The username
+// is left out if it is empty, so malformed names are only counted per IP.
+func loginSubjects(user, ip string) [][2]string {
+	subjects := [][2]string{{db.LoginScopeIP, ip}}
+	if user != "" {
+		subjects = append(subjects, [2]string{db.LoginScopeUser, user})
+	}
+	return subjects
+}
+
+// Check returns when the lockout on user or ip ends, or the zero time if
+// neither is locked out
+func (g *LoginGuard) Check(ctx context.Context, user, ip string) (time.Time, error) {
+	if g.policy.Threshold <= 0 {
+		return time.Time{}, nil
+	}
+	now := g.now()
+	var until time.Time
+	for _, s := range loginSubjects(user, ip) {
+		f, err := g.store.GetLoginFailure(ctx, s[0], s[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to check lockout: %w", err)
+		}
+		if f != nil && f.Locked(now) && f.LockedUntil.After(until) {
+			until = f.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+// Fail records a failed login for user and ip, locking out any that reach
+// the threshold, and returns the counters it locked out
+func (g *LoginGuard) Fail(ctx context.Context, user, ip string) ([]*db.LoginFailure, error) {
+	if g.policy.Threshold <= 0 {
+		return nil, nil
+	}
+	now := g.now()
+	var locked []*db.LoginFailure
+	for _, s := range loginSubjects(user, ip) {
+		failures, err := g.store.RecordLoginFailure(ctx, s[0], s[1], now, now.Add(-lockoutForgetAfter))
+		if err != nil {
+			return locked, fmt.Errorf("failed to record login failure: %w", err)
+		}
+		d := g.policy.lockoutDuration(failures)
+		if d == 0 {
+			continue
+		}
+		until := now.Add(d)
+		if err := g.store.LockLogin(ctx, s[0], s[1], until); err != nil {
+			return locked, fmt.Errorf("failed to lock out %s: %w", s[1], err)
+		}
+		locked = append(locked, &db.LoginFailure{
+			Scope:       s[0],
+			Subject:     s[1],
+			Failures:    failures,
+			LastFailure: now,
+			LockedUntil: until,
+		})
+	}
+	return locked, nil
+}
+
+// Succeed clears the failures of user after a successful login. The
+// client's IP keeps its count, which lapses by itself: otherwise a client
+// could wipe its failures by signing in as another user between guesses.
+func (g *LoginGuard) Succeed(ctx context.Context, user string) error {
+	if g.policy.Threshold <= 0 || user == "" {
+		return nil
+	}
+	if err := g.store.ClearLoginFailures(ctx, db.LoginScopeUser, user); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+// Lockouts returns the usernames and IPs locked out now
+func (g *LoginGuard) Lockouts(ctx context.Context) ([]*db.LoginFailure, error) {
+	all, err := g.store.GetLoginFailures(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := g.now()
+	var locked []*db.LoginFailure
+	for _, f := range all {
+		if f.Locked(now) {
+			locked = append(locked, f)
+		}
+	}
+	return locked, nil
+}
+
+// ParseLockoutTarget splits a "user:<name>" or "ip:<address>" target
+func ParseLockoutTarget(target string) (scope, subject string, err error) {
+	scope, subject, ok := strings.Cut(target, ":")
+	if !ok || subject == "" || (scope != db.LoginScopeUser && scope != db.LoginScopeIP) {
+		return "", "", fmt.Errorf("invalid lockout %q: expected user:<name> or ip:<address>", target)
+	}
+	return scope, subject, nil
+}
+
+// recordLoginFailure counts a failed login towards lockouts, recording any
+// lockout it causes in the audit log
+func (s *Server) recordLoginFailure(r *http.Request, user, ip string) {
+	locked, err := s.loginGuard.Fail(r.Context(), user, ip)
+	if err != nil {
+		logger.Warn("failed to record login failure", "err", err)
+	}
+	for _, f := range locked {
+		logger.Warn("login locked out", "scope", f.Scope, "subject", f.Subject, "failures", f.Failures, "until", f.LockedUntil)
+		s.auditFor(r).LogAuthLockout(f.Scope+":"+f.Subject, f.Failures, f.LockedUntil)
+	}
+}
+
+// Lockout is a locked out username or IP in the lockouts API
+type Lockout struct {
+	Target      string    `json:"target"` // user:<name> or ip:<address>
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// handleLockouts lists current lockouts as JSON
+func (s *Server) handleLockouts(w http.ResponseWriter, r *http.Request) {
+	locked, err := s.loginGuard.Lockouts(r.Context())
+	if err != nil {
+		logger.Error("failed to list lockouts", "err", err)
+		http.Error(w, "Failed to list lockouts", http.StatusInternalServerError)
+		return
+	}
+	lockouts := []Lockout{}
+	for _, f := range locked {
+		lockouts = append(lockouts, Lockout{
+			Target:      f.Scope + ":" + f.Subject,
+			Failures:    f.Failures,
+			LastFailure: f.LastFailure,
+			LockedUntil: f.LockedUntil,
+		})
+	}
+	writeJSON(w, http.StatusOK, lockouts)
+}
+
+// handleUnlock lifts the lockout named by the target form value and clears
+// its failures
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	target := r.FormValue("target")
+	scope, subject, err := ParseLockoutTarget(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.ClearLoginFailures(r.Context(), scope, subject)
+	s.auditFor(r).LogAuthUnlock(actor, target, audit.OutcomeFromError(err), err)
+	if err != nil {
+		logger.Error("failed to unlock", "target", target, "err", err)
+		http.Error(w, "Failed to unlock", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	p := LockoutPolicy{Threshold: 3, Duration: time.Minute, Max: 5 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.lockoutDuration(tt.failures); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+	if got := (LockoutPolicy{}).lockoutDuration(100); got != 0 {
+		t.Errorf("expected no lockout when disabled, got %v", got)
+	}
+}
+
+func TestLoginGuardPerUser(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	g := NewLoginGuard(database, LockoutPolicy{Threshold: 2, Duration: time.Minute, Max: time.Hour})
+	g.now = func() time.Time { return now }
+
+	// Failures for one user from different IPs add up
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		locked, err := g.Fail(ctx, "alice", ip)
+		if err != nil {
+			t.Fatalf("failed to record failure: %v", err)
+		}
+		if wantLocked := i == 1; (len(locked) == 1) != wantLocked {
+			t.Errorf("failure %d: unexpected lockouts %+v", i, locked)
+		}
+	}
+
+	until, err := g.Check(ctx, "alice", "198.51.100.1")
+	if err != nil || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected alice to be locked out from anywhere, got %v, %v", until, err)
+	}
+	if until, _ := g.Check(ctx, "bob", "192.0.2.1"); !until.IsZero() {
+		t.Errorf("expected other users on the same IP to be allowed, got %v", until)
+	}
+
+	// The lockout expires, and a success starts the count again
+	now = now.Add(time.Minute)
+	if until, _ := g.Check(ctx, "alice", "198.51.100.1"); !until.IsZero() {
+		t.Errorf("expected the lockout to have expired, got %v", until)
+	}
+	if err := g.Succeed(ctx, "alice"); err != nil {
+		t.Fatalf("failed to record success: %v", err)
+	}
+	if locked, _ := g.Fail(ctx, "alice", "198.51.100.1"); len(locked) != 0 {
+		t.Errorf("expected a fresh count after success, got %+v", locked)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	dir := t.TempDir()
+	database, err := db.Open(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+	store, err := audit.NewSQLiteSink(filepath.Join(dir, "audit.db"))
+	if err != nil {
+		t.Fatalf("failed to open audit database: %v", err)
+	}
+	al := audit.NewLogger(store)
+	defer al.Close()
+
+	policy := LockoutPolicy{Threshold: 3, Duration: time.Minute, Max: time.Hour}
+	newServer := func() *Server {
+		return &Server{
+			config:      Config{Admins: []string{"alice"}},
+			db:          database,
+			authManager: NewAuthManager(),
+			loginGuard:  NewLoginGuard(database, policy),
+			auditLogger: al,
+		}
+	}
+	s := newServer()
+
+	login := func(s *Server, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/login?user="+url.QueryEscape(user), nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		rec := httptest.NewRecorder()
+		s.handleLogin(rec, req)
+		return rec
+	}
+
+	// Malformed usernames count against the client's IP
+	for i := 0; i < 3; i++ {
+		if rec := login(s, "../../etc"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("attempt %d: expected 400, got %d", i, rec.Code)
+		}
+	}
+
+	// The lockout survives a restart
+	s = newServer()
+	rec := login(s, "alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the IP to be locked out, got %d", rec.Code)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra == "" || ra == "0" {
+		t.Errorf("expected Retry-After, got %q", ra)
+	}
+
+	entries, _, err := store.Query(context.Background(), audit.Filter{Action: string(audit.ActionAuthLockout)})
+	if err != nil || len(entries) != 1 || entries[0].Target != "ip:203.0.113.9" || entries[0].Details["failures"] != float64(3) {
+		t.Fatalf("expected one lockout entry, got %+v, %v", entries, err)
+	}
+
+	// Admins can list and lift lockouts
+	rec = auditRequest(s, s.handleLockouts, "alice", "/api/lockouts")
+	var lockouts []Lockout
+	if err := json.NewDecoder(rec.Body).Decode(&lockouts); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Target != "ip:203.0.113.9" || lockouts[0].Failures != 3 {
+		t.Fatalf("unexpected lockouts: %+v", lockouts)
+	}
+
+	for _, tt := range []struct {
+		user, target string
+		want         int
+	}{
+		{"bob", "ip:203.0.113.9", http.StatusForbidden},
+		{"alice", "203.0.113.9", http.StatusBadRequest},
+		{"alice", "ip:203.0.113.9", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/lockouts/unlock", strings.NewReader("target="+url.QueryEscape(tt.target)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, tt.user))
+		rec := httptest.NewRecorder()
+		s.AdminMiddleware(s.handleUnlock)(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s unlocking %s: expected %d, got %d", tt.user, tt.target, tt.want, rec.Code)
+		}
+	}
+
+	if rec := login(s, "alice"); rec.Code != http.StatusSeeOther {
+		t.Errorf("expected login to work after unlocking, got %d", rec.Code)
+	}
+	entries, _, _ = store.Query(context.Background(), audit.Filter{Action: string(audit.ActionAuthUnlock)})
+	if len(entries) != 1 || entries[0].Actor != "alice" || entries[0].Outcome != audit.OutcomeSuccess {
+		t.Errorf("expected one unlock entry, got %+v", entries)
+	}
+}
+
+func TestLoginLockoutSurvivesOtherLogins(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	s := &Server{
+		db:          database,
+		authManager: NewAuthManager(),
+		loginGuard:  NewLoginGuard(database, LockoutPolicy{Threshold: 3, Duration: time.Minute, Max: time.Hour}),
+		auditLogger: audit.NewLogger(),
+	}
+	login := func(user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/login?user="+url.QueryEscape(user), nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		rec := httptest.NewRecorder()
+		s.handleLogin(rec, req)
+		return rec.Code
+	}
+
+	// Signing in as a throwaway user between guesses does not reset the
+	// client's count
+	for i, user := range []string{"../1", "../2", "throwaway", "../3"} {
+		want := http.StatusBadRequest
+		if user == "throwaway" {
+			want = http.StatusSeeOther
+		}
+		if code := login(user); code != want {
+			t.Fatalf("attempt %d as %s: expected %d, got %d", i, user, want, code)
+		}
+	}
+	if code := login("throwaway"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the IP to be locked out, got %d", code)
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// activityInterval limits how often activity events are published per terminal
+	activityInterval = 5 * time.Second
+
+	// Output followed by silenceThreshold of quiet is reported as finished,
+	// provided it kept going for at least minActivityBurst (so that echoing
+	// keystrokes or short command output doesn't count)
+	silenceThreshold = 10 * time.Second
+	minActivityBurst = 5 * time.Second
+
+	// maxOSCLength caps how much of an OSC sequence is buffered for parsing
+	maxOSCLength = 4096
+
+	// maxNotificationLength caps notification titles and bodies, in runes
+	maxNotificationLength = 256
+)
+
+// escState is the position of outputMonitor within an escape sequence
+type escState int
+
+const (
+	escGround    escState = iota
+	escEscape             // After ESC
+	escCSI                // Inside ESC [ ... final byte
+	escString             // Inside OSC/DCS/APC/PM/SOS, terminated by BEL or ST
+	escStringEsc          // ESC seen inside a string, possibly the start of ST
+)
+
+// outputMonitor scans a terminal's output stream for bells, desktop
+// notification requests, shell integration marks and activity. BEL bytes that terminate OSC sequences
+// (such as window title updates) are not bells, so the monitor tracks just
+// enough escape sequence state to tell them apart. It is only used from the
+// supervisor's pump goroutine.
+type outputMonitor struct {
+	state        escState
+	osc          []byte // Payload of the OSC sequence being parsed
+	inOSC        bool   // The current string sequence is an OSC
+	lastActivity time.Time
+}
+
+// notification is a desktop notification requested by a program through
+// OSC 9 (iTerm2/ConEmu) or OSC 777 (urxvt/VTE)
+type notification struct {
+	Title string
+	Body  string
+}
+
+// outputSignals is what a chunk of output contained
+type outputSignals struct {
+	Bell          bool
+	Notifications []notification
+	Activity      bool // Output after at least activityInterval since the last activity event
+	Shell         []shellMark
+}
+
+// scan processes a chunk of output
+func (m *outputMonitor) scan(data []byte, now time.Time) outputSignals {
+	var sig outputSignals
+
+	for _, b := range data {
+		switch m.state {
+		case escGround:
+			switch b {
+			case 0x07:
+				sig.Bell = true
+			case 0x1b:
+				m.state = escEscape
+			}
+		case escEscape:
+			switch b {
+			case '[':
+				m.state = escCSI
+			case ']':
+				m.state = escString
+				m.inOSC = true
+				m.osc = m.osc[:0]
+			case 'P', 'X', '^', '_':
+				m.state = escString
+				m.inOSC = false
+			default:
+				m.state = escGround
+			}
+		case escCSI:
+			if b >= 0x40 && b <= 0x7e {
+				m.state = escGround
+			}
+		case escString:
+			switch b {
+			case 0x07:
+				m.state = escGround
+				m.endString(&sig)
+			case 0x1b:
+				m.state = escStringEsc
+			default:
+				if m.inOSC && len(m.osc) < maxOSCLength {
+					m.osc = append(m.osc, b)
+				}
+			}
+		case escStringEsc:
+			if b == '\\' {
+				m.state = escGround
+				m.endString(&sig)
+			} else {
+				m.state = escString
+			}
+		}
+
+		// CAN and SUB abort any sequence in progress
+		if b == 0x18 || b == 0x1a {
+			m.state = escGround
+			m.inOSC = false
+		}
+	}
+
+	if len(data) > 0 && now.Sub(m.lastActivity) >= activityInterval {
+		m.lastActivity = now
+		sig.Activity = true
+	}
+
+	return sig
+}
+
+// endString handles a completed string sequence
+func (m *outputMonitor) endString(sig *outputSignals) {
+	if !m.inOSC {
+		return
+	}
+	m.inOSC = false
+	payload := string(m.osc)
+	if n, ok := parseNotification(payload); ok {
+		sig.Notifications = append(sig.Notifications, n)
+	} else if mark, ok := parseShellMark(payload); ok {
+		sig.Shell = append(sig.Shell, mark)
+	}
+}
+
+// parseNotification parses the payload of an OSC 9 or OSC 777 notification
+func parseNotification(payload string) (notification, bool) {
+	code, rest, _ := strings.Cut(payload, ";")
+	switch code {
+	case "9":
+		// ConEmu reuses OSC 9 with numeric subcommands (progress bars,
+		// working directory reports); those are not notifications
+		if sub, _, _ := strings.Cut(rest, ";"); sub != "" && strings.Trim(sub, "0123456789") == "" {
+			return notification{}, false
+		}
+		n := notification{Body: cleanNotificationText(rest)}
+		return n, n.Body != ""
+	case "777":
+		// OSC 777 ; notify ; title ; body
+		kind, rest, _ := strings.Cut(rest, ";")
+		if kind != "notify" {
+			return notification{}, false
+		}
+		title, body, _ := strings.Cut(rest, ";")
+		n := notification{Title: cleanNotificationText(title), Body: cleanNotificationText(body)}
+		return n, n.Title != "" || n.Body != ""
+	}
+	return notification{}, false
+}
+
+// cleanNotificationText strips control characters and invalid UTF-8 and
+// truncates text supplied by a program
+func cleanNotificationText(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if r < 32 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) > maxNotificationLength {
+		s = string([]rune(s)[:maxNotificationLength]) + "…"
+	}
+	return s
+}
+
+// silenceDetector reports when a terminal goes quiet after a sustained burst
+// of output, which usually means a long-running command has finished
+type silenceDetector struct {
+	threshold time.Duration
+	minBurst  time.Duration
+	onSilence func(burst time.Duration)
+
+	mu         sync.Mutex
+	timer      *time.Timer
+	burstStart time.Time
+	lastOutput time.Time
+	stopped    bool
+}
+
+func newSilenceDetector(threshold, minBurst time.Duration, onSilence func(burst time.Duration)) *silenceDetector {
+	return &silenceDetector{
+		threshold: threshold,
+		minBurst:  minBurst,
+		onSilence: onSilence,
+	}
+}
+
+// output records that the terminal produced output
+func (d *silenceDetector) output(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return
+	}
+	if d.burstStart.IsZero() {
+		d.burstStart = now
+	}
+	d.lastOutput = now
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.threshold, d.fire)
+	} else {
+		d.timer.Reset(d.threshold)
+	}
+}
+
+func (d *silenceDetector) fire() {
+	d.mu.Lock()
+	// Output may have arrived while the timer was firing
+	if d.stopped || d.burstStart.IsZero() || time.Since(d.lastOutput) < d.threshold {
+		d.mu.Unlock()
+		return
+	}
+	burst := d.lastOutput.Sub(d.burstStart)
+	d.burstStart = time.Time{}
+	d.mu.Unlock()
+
+	if burst >= d.minBurst {
+		d.onSilence(burst)
+	}
+}
+
+// stop cancels any pending silence report
+func (d *silenceDetector) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptyCloseTimeout is how long a command gets to exit after SIGHUP before it is killed
+const ptyCloseTimeout = 5 * time.Second
+
+// ptyCommand is a process attached to a pseudo-terminal
+type ptyCommand struct {
+	argv      []string
+	cmd       *exec.Cmd
+	pty       *os.File
+	startedAt time.Time
+	exited    chan struct{}
+}
+
+// startPTYCommand starts the command directly (no shell wrapper) with its own
+// working directory and environment
+func startPTYCommand(spec CommandSpec) (*ptyCommand, error) {
+	// exec.Command resolves bare program names through PATH
+	cmd := exec.Command(spec.Argv[0], spec.Argv[1:]...)
+	cmd.Dir = spec.WorkingDir
+	// STRATUSSHELL turns on the shell integration installed by the provisioner
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color", "STRATUSSHELL=1")
+	cmd.Env = append(cmd.Env, spec.Env...)
+
+	release, err := placeInCgroup(cmd, spec.cgroupDir)
+	if err != nil {
+		return nil, err
+	}
+	f, err := pty.Start(cmd)
+	release()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command %q: %w", spec.Argv[0], err)
+	}
+
+	p := &ptyCommand{
+		argv:      spec.Argv,
+		cmd:       cmd,
+		pty:       f,
+		startedAt: time.Now(),
+		exited:    make(chan struct{}),
+	}
+
+	// Close the PTY once the process exits so pending reads return EOF
+	go func() {
+		cmd.Wait()
+		f.Close()
+		close(p.exited)
+	}()
+
+	return p, nil
+}
+
+func (p *ptyCommand) Read(b []byte) (int, error) {
+	return p.pty.Read(b)
+}
+
+func (p *ptyCommand) Write(b []byte) (int, error) {
+	return p.pty.Write(b)
+}
+
+// Close hangs up the process, killing it if it does not exit in time
+func (p *ptyCommand) Close() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+	p.cmd.Process.Signal(syscall.SIGHUP)
+
+	select {
+	case <-p.exited:
+	case <-time.After(ptyCloseTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return nil
+}
+
+// Pid returns the process ID of the command
+func (p *ptyCommand) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// exitStatus returns the exit code and, if the process was killed by a
+// signal, the signal name. It must only be called after the process exited.
+func (p *ptyCommand) exitStatus() (int, string) {
+	state := p.cmd.ProcessState
+	if state == nil {
+		return -1, ""
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, ws.Signal().String()
+	}
+	return state.ExitCode(), ""
+}
+
+func (p *ptyCommand) ResizeTerminal(columns int, rows int) error {
+	return pty.Setsize(p.pty, &pty.Winsize{
+		Rows: uint16(rows),
+		Cols: uint16(columns),
+	})
+}
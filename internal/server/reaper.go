@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// reapInterval is how often terminals are checked against the idle timeout and max lifetime
+	reapInterval = 15 * time.Second
+
+	// reapWarningPeriod is how long before closing a terminal the user is warned
+	reapWarningPeriod = 1 * time.Minute
+)
+
+// Reasons a terminal is reaped
+const (
+	ReapIdle      = "idle"
+	ReapLifetime  = "lifetime"
+	ReapUnhealthy = "unhealthy"
+)
+
+// reapSchedule is when a terminal will be closed by the reaper and why
+type reapSchedule struct {
+	Deadline time.Time
+	WarnAt   time.Time
+	Reason   string
+}
+
+// reapScheduleFor returns the earliest deadline the limits impose on a
+// terminal, or false if neither the idle timeout nor the max lifetime applies
+func (l ResourceLimits) reapScheduleFor(createdAt, lastActivity time.Time) (reapSchedule, bool) {
+	var sched reapSchedule
+	var timeout time.Duration
+
+	if l.IdleTimeout > 0 {
+		sched = reapSchedule{Deadline: lastActivity.Add(l.IdleTimeout), Reason: ReapIdle}
+		timeout = l.IdleTimeout
+	}
+	if l.MaxLifetime > 0 {
+		deadline := createdAt.Add(l.MaxLifetime)
+		if sched.Deadline.IsZero() || deadline.Before(sched.Deadline) {
+			sched = reapSchedule{Deadline: deadline, Reason: ReapLifetime}
+			timeout = l.MaxLifetime
+		}
+	}
+	if sched.Deadline.IsZero() {
+		return reapSchedule{}, false
+	}
+
+	// Short timeouts get a proportionally short warning
+	warning := reapWarningPeriod
+	if timeout/2 < warning {
+		warning = timeout / 2
+	}
+	sched.WarnAt = sched.Deadline.Add(-warning)
+
+	return sched, true
+}
+
+// runReaper periodically closes idle, expired and unhealthy terminals until Shutdown
+func (tm *TerminalManager) runReaper() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tm.done:
+			return
+		case now := <-ticker.C:
+			tm.reap(now)
+		}
+	}
+}
+
+// reap warns about terminals that are about to be closed and closes those
+// past their deadline or marked unhealthy
+func (tm *TerminalManager) reap(now time.Time) {
+	for _, terminal := range tm.GetTerminals() {
+		if terminal.unhealthy.Load() {
+			tm.reapUnhealthy(terminal, now)
+			continue
+		}
+
+		stats := terminal.Stats()
+		sched, ok := tm.limits.reapScheduleFor(terminal.CreatedAt, stats.LastActivity)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !now.Before(sched.Deadline):
+			err := tm.killTerminal(context.Background(), terminal.ID, "reaped")
+			if err != nil {
+				terminalLogger.Warn("failed to reap terminal", "terminal", terminal.ID, "err", err)
+			}
+			tm.auditLogger.LogTerminalReap(terminal.ID, terminal.Owner, sched.Reason, now.Sub(terminal.CreatedAt), now.Sub(stats.LastActivity), err)
+
+		case !now.Before(sched.WarnAt):
+			if terminal.setReapWarning(sched.Deadline, sched.Reason) {
+				tm.warnReap(terminal, sched, now)
+			}
+
+		default:
+			// Activity resumed after a warning
+			if terminal.setReapWarning(time.Time{}, "") {
+				tm.events.Publish(Event{Type: EventAlert, TerminalID: terminal.ID, Owner: terminal.Owner})
+			}
+		}
+	}
+}
+
+// reapUnhealthy closes a terminal the readiness check found dead, once it
+// is confirmed to still not be accepting connections
+func (tm *TerminalManager) reapUnhealthy(terminal *Terminal, now time.Time) {
+	if err := probeTerminal(terminal); err == nil {
+		terminal.unhealthy.Store(false)
+		return
+	}
+
+	stats := terminal.Stats()
+	err := tm.killTerminal(context.Background(), terminal.ID, ReapUnhealthy)
+	if err != nil {
+		terminalLogger.Warn("failed to close unhealthy terminal", "terminal", terminal.ID, "err", err)
+	}
+	tm.auditLogger.LogTerminalReap(terminal.ID, terminal.Owner, ReapUnhealthy, now.Sub(terminal.CreatedAt), now.Sub(stats.LastActivity), err)
+}
+
+// warnReap tells the user a terminal is about to be closed, in the browser
+// and in the terminal itself
+func (tm *TerminalManager) warnReap(terminal *Terminal, sched reapSchedule, now time.Time) {
+	remaining := sched.Deadline.Sub(now).Round(time.Second)
+
+	var message string
+	if sched.Reason == ReapIdle {
+		message = fmt.Sprintf("Idle terminal will be closed in %s unless there is input or output", remaining)
+	} else {
+		message = fmt.Sprintf("Terminal reached its maximum lifetime and will be closed in %s", remaining)
+	}
+
+	terminal.process.notice("[" + message + "]")
+	tm.events.Publish(Event{Type: EventReapWarning, TerminalID: terminal.ID, Owner: terminal.Owner, Title: tm.terminalTitle(terminal), Data: map[string]interface{}{
+		"reason":   sched.Reason,
+		"deadline": sched.Deadline,
+	}})
+	tm.notify(terminal, tm.terminalTitle(terminal), message)
+}
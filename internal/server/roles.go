@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/metrics"
+)
+
+// Role is what a user is allowed to do
+type Role string
+
+const (
+	// RoleAdmin can do everything, including using other users' terminals
+	// and viewing the audit log
+	RoleAdmin Role = "admin"
+	// RoleDeveloper starts and manages terminals of their own
+	RoleDeveloper Role = "developer"
+	// RoleViewer can only watch terminals, without typing into them
+	RoleViewer Role = "viewer"
+)
+
+// DefaultRole is given to users with no role assigned unless
+// Config.DefaultRole says otherwise
+const DefaultRole = RoleDeveloper
+
+// Permission is an action a role may be allowed
+type Permission string
+
+const (
+	PermAdmin          Permission = "admin"           // Audit log, lockouts and roles
+	PermTerminalsAll   Permission = "terminals.all"   // Use and manage every user's terminals
+	PermTerminalsWrite Permission = "terminals.write" // Start, control and type into terminals
+	PermTerminalsView  Permission = "terminals.view"  // Watch terminals
+)
+
+// rolePermissions lists what each role may do
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:     {PermAdmin, PermTerminalsAll, PermTerminalsWrite, PermTerminalsView},
+	RoleDeveloper: {PermTerminalsWrite, PermTerminalsView},
+	RoleViewer:    {PermTerminalsView},
+}
+
+// systemOwner owns the terminals the server starts itself, which are shared
+// by every user
+const systemOwner = "system"
+
+// ParseRole checks that name is a known role
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("unknown role %q: expected admin, developer or viewer", name)
+	}
+	return role, nil
+}
+
+// Can reports whether the role has permission p
+func (r Role) Can(p Permission) bool {
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// terminalAccess reports whether user, with role, may watch and type into a
+// terminal started by owner. Users only see their own and shared terminals
+// unless they may use everyone's.
+func terminalAccess(user string, role Role, owner string) (view, write bool) {
+	if role.Can(PermTerminalsAll) {
+		return true, true
+	}
+	if owner != user && owner != systemOwner {
+		return false, false
+	}
+	return role.Can(PermTerminalsView), role.Can(PermTerminalsWrite)
+}
+
+// sessionAccess reports whether user, with role, may list and load a saved
+// session owned by owner. Sessions saved before owners were recorded are
+// left to admins.
+func sessionAccess(user string, role Role, owner string) bool {
+	return owner == user || (owner == "" && role.Can(PermAdmin))
+}
+
+// userRole returns the role of user: admin for the configured admins,
+// otherwise the role assigned in the database or the default role
+func (s *Server) userRole(ctx context.Context, user string) (Role, error) {
+	for _, admin := range s.config.Admins {
+		if admin == user {
+			return RoleAdmin, nil
+		}
+	}
+	name, err := s.db.GetUserRole(ctx, user)
+	if err != nil {
+		return "", fmt.Errorf("failed to get role of %s: %w", user, err)
+	}
+	if name != "" {
+		return ParseRole(name)
+	}
+	return s.defaultRole()
+}
+
+// defaultRole returns the role of users with none assigned
+func (s *Server) defaultRole() (Role, error) {
+	if s.config.DefaultRole != "" {
+		return ParseRole(s.config.DefaultRole)
+	}
+	return DefaultRole, nil
+}
+
+// sessionRole returns the role a session of user gets. Users who only
+// named themselves, without proving who they are, get their role but no
+// more than the default role, and are never admins: anyone can claim a
+// username at /login.
+func (s *Server) sessionRole(ctx context.Context, user string, verified bool) (Role, error) {
+	role, err := s.userRole(ctx, user)
+	if err != nil || verified {
+		return role, err
+	}
+	return s.unverifiedRole(role)
+}
+
+// unverifiedRole caps role for sessions of users who have not proved who
+// they are
+func (s *Server) unverifiedRole(role Role) (Role, error) {
+	limit, err := s.defaultRole()
+	if err != nil {
+		return "", err
+	}
+	if limit == RoleAdmin {
+		limit = RoleDeveloper
+	}
+	if role == RoleAdmin || (role == RoleDeveloper && limit == RoleViewer) {
+		return limit, nil
+	}
+	return role, nil
+}
+
+// requestRole returns the role of the user a request is signed in as. It
+// is carried by the session, and looked up if there is none.
+func (s *Server) requestRole(r *http.Request) Role {
+	if session := s.requestSession(r); session != nil && session.Role != "" {
+		return session.Role
+	}
+	role, err := s.userRole(r.Context(), s.getActor(r))
+	if err != nil {
+		logger.Warn("failed to get role", "err", err)
+		return RoleViewer
+	}
+	return role
+}
+
+// deny refuses a request the user's role does not allow, recording it in the
+// audit log
+func (s *Server) deny(w http.ResponseWriter, r *http.Request, role Role, perm Permission, target string) {
+	metrics.AuthFailures.WithLabelValues("forbidden").Inc()
+	s.auditFor(r).LogAuthDenied(s.getActor(r), string(role), string(perm), target)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
+
+// RequirePermission rejects requests from users whose role lacks perm. It
+// must run after AuthMiddleware.
+func (s *Server) RequirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if role := s.requestRole(r); !role.Can(perm) {
+			s.deny(w, r, role, perm, r.URL.Path)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// terminalTarget names a terminal in audit entries
+func terminalTarget(id int) string {
+	return "terminal:" + strconv.Itoa(id)
+}
+
+// authorizeTerminal checks that the request's user may watch the terminal,
+// and type into it if write is set. It responds itself and returns false if
+// they may not.
+func (s *Server) authorizeTerminal(w http.ResponseWriter, r *http.Request, terminal *Terminal, write bool) bool {
+	role := s.requestRole(r)
+	canView, canWrite := terminalAccess(s.getActor(r), role, terminal.Owner)
+	switch {
+	case !canView:
+		s.deny(w, r, role, PermTerminalsView, terminalTarget(terminal.ID))
+		return false
+	case write && !canWrite:
+		s.deny(w, r, role, PermTerminalsWrite, terminalTarget(terminal.ID))
+		return false
+	}
+	return true
+}
+
+// visibleTerminals returns the terminals the request's user may watch
+func (s *Server) visibleTerminals(r *http.Request) []*Terminal {
+	user, role := s.getActor(r), s.requestRole(r)
+	var visible []*Terminal
+	for _, t := range s.terminalManager.GetTerminals() {
+		if view, _ := terminalAccess(user, role, t.Owner); view {
+			visible = append(visible, t)
+		}
+	}
+	return visible
+}
+
+// UserRoleInfo is a user's assigned role in the roles API
+type UserRoleInfo struct {
+	User      string    `json:"user"`
+	Role      string    `json:"role"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// handleRoles lists assigned roles as JSON on GET. On POST it assigns the
+// role form value to user, or removes their role if it is empty so they get
+// the default.
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		assigned, err := s.db.GetUserRoles(r.Context())
+		if err != nil {
+			logger.Error("failed to list roles", "err", err)
+			http.Error(w, "Failed to list roles", http.StatusInternalServerError)
+			return
+		}
+		roles := []UserRoleInfo{}
+		for _, ur := range assigned {
+			roles = append(roles, UserRoleInfo{
+				User:      ur.Username,
+				Role:      ur.Role,
+				UpdatedBy: ur.UpdatedBy,
+				UpdatedAt: ur.UpdatedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, roles)
+
+	case http.MethodPost:
+		s.setRole(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// setRole assigns or removes a user's role and updates their sessions
+func (s *Server) setRole(w http.ResponseWriter, r *http.Request) {
+	actor := s.getActor(r)
+	user := r.FormValue("user")
+	if !usernamePattern.MatchString(user) {
+		http.Error(w, fmt.Sprintf("invalid username %q", user), http.StatusBadRequest)
+		return
+	}
+	var newRole Role
+	if name := r.FormValue("role"); name != "" {
+		role, err := ParseRole(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newRole = role
+	}
+
+	oldRole, err := s.db.GetUserRole(r.Context(), user)
+	if err == nil {
+		if newRole == "" {
+			err = s.db.DeleteUserRole(r.Context(), user)
+		} else {
+			err = s.db.SetUserRole(r.Context(), user, string(newRole), actor, time.Now())
+		}
+	}
+	s.auditFor(r).LogAuthRole(actor, user, oldRole, string(newRole), audit.OutcomeFromError(err), err)
+	if err != nil {
+		logger.Error("failed to set role", "user", user, "err", err)
+		http.Error(w, "Failed to set role", http.StatusInternalServerError)
+		return
+	}
+
+	// Signed in users get their new role straight away
+	role, err := s.userRole(r.Context(), user)
+	var unverified Role
+	if err == nil {
+		unverified, err = s.unverifiedRole(role)
+	}
+	if err != nil {
+		logger.Warn("failed to get role", "user", user, "err", err)
+		role, unverified = RoleViewer, RoleViewer
+	}
+	s.authManager.SetUserRole(user, role, unverified)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+)
+
+func TestTerminalAccess(t *testing.T) {
+	tests := []struct {
+		user  string
+		role  Role
+		owner string
+		view  bool
+		write bool
+	}{
+		{"alice", RoleAdmin, "bob", true, true},
+		{"bob", RoleDeveloper, "bob", true, true},
+		{"bob", RoleDeveloper, systemOwner, true, true},
+		{"bob", RoleDeveloper, "alice", false, false},
+		{"carol", RoleViewer, "carol", true, false},
+		{"carol", RoleViewer, systemOwner, true, false},
+		{"carol", RoleViewer, "bob", false, false},
+		{"dave", Role("superuser"), "dave", false, false},
+	}
+
+	for _, tt := range tests {
+		view, write := terminalAccess(tt.user, tt.role, tt.owner)
+		if view != tt.view || write != tt.write {
+			t.Errorf("%s (%s) on %s's terminal: expected view=%v write=%v, got %v %v",
+				tt.user, tt.role, tt.owner, tt.view, tt.write, view, write)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, name := range []string{"admin", "developer", "viewer"} {
+		if role, err := ParseRole(name); err != nil || string(role) != name {
+			t.Errorf("ParseRole(%q) = %q, %v", name, role, err)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("expected an unknown role to be rejected")
+	}
+}
+
+// roleRequest calls handler as user, with form values if set
+func roleRequest(s *Server, handler http.HandlerFunc, method, target, user string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestHandleRoles(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+
+	token, err := s.authManager.CreateSession("bob", RoleDeveloper, true)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	roles := s.AdminMiddleware(s.handleRoles)
+	for _, tt := range []struct {
+		user, target, role string
+		want               int
+	}{
+		{"bob", "bob", "admin", http.StatusForbidden},
+		{"alice", "../bob", "viewer", http.StatusBadRequest},
+		{"alice", "bob", "root", http.StatusBadRequest},
+		{"alice", "bob", "viewer", http.StatusNoContent},
+	} {
+		rec := roleRequest(s, roles, http.MethodPost, "/api/roles", tt.user, url.Values{"user": {tt.target}, "role": {tt.role}})
+		if rec.Code != tt.want {
+			t.Errorf("%s making %s %s: expected %d, got %d", tt.user, tt.target, tt.role, tt.want, rec.Code)
+		}
+	}
+
+	// bob's session picks up the new role
+	if session, _ := s.authManager.ValidateSession(token); session.Role != RoleViewer {
+		t.Errorf("expected bob's session to be a viewer, got %q", session.Role)
+	}
+
+	rec := roleRequest(s, roles, http.MethodGet, "/api/roles", "alice", nil)
+	var list []UserRoleInfo
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(list) != 1 || list[0].User != "bob" || list[0].Role != "viewer" || list[0].UpdatedBy != "alice" {
+		t.Errorf("unexpected roles: %+v", list)
+	}
+
+	// Removing the role restores the default
+	roleRequest(s, roles, http.MethodPost, "/api/roles", "alice", url.Values{"user": {"bob"}})
+	if role, err := s.userRole(context.Background(), "bob"); err != nil || role != DefaultRole {
+		t.Errorf("expected the default role, got %q, %v", role, err)
+	}
+
+	entries, _, err := s.auditStore.Query(context.Background(), audit.Filter{Action: string(audit.ActionAuthRole)})
+	if err != nil || len(entries) != 2 || entries[1].Details["new_role"] != "viewer" {
+		t.Errorf("expected two role entries, got %+v, %v", entries, err)
+	}
+	entries, _, err = s.auditStore.Query(context.Background(), audit.Filter{Action: string(audit.ActionAuthDenied)})
+	if err != nil || len(entries) != 1 || entries[0].Actor != "bob" || entries[0].Details["permission"] != string(PermAdmin) {
+		t.Errorf("expected one denial, got %+v, %v", entries, err)
+	}
+}
+
+func TestLoginRoleNeedsProof(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.loginGuard = NewLoginGuard(s.db, LockoutPolicy{Threshold: 10, Duration: time.Minute, Max: time.Hour})
+	s.config.DefaultRole = "viewer"
+	ctx := context.Background()
+	if err := s.db.SetUserRole(ctx, "bob", "developer", "alice", time.Now()); err != nil {
+		t.Fatalf("failed to set role: %v", err)
+	}
+
+	login := func(user string) *Session {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/login?user="+user, nil)
+		rec := httptest.NewRecorder()
+		s.handleLogin(rec, req)
+		token := strings.TrimPrefix(strings.Split(rec.Header().Get("Set-Cookie"), ";")[0], "session_token=")
+		session, ok := s.authManager.ValidateSession(token)
+		if !ok {
+			t.Fatalf("expected %s to be signed in, got %d", user, rec.Code)
+		}
+		return session
+	}
+
+	// Anyone can claim to be alice, so naming her gives no more than the
+	// default role
+	var carol *Session
+	for _, user := range []string{"alice", "bob", "carol"} {
+		session := login(user)
+		if session.Role != RoleViewer || session.Verified {
+			t.Errorf("%s: expected an unverified viewer session, got %+v", user, session)
+		}
+		carol = session
+	}
+
+	// Role changes keep the cap
+	roles := s.AdminMiddleware(s.handleRoles)
+	if rec := roleRequest(s, roles, http.MethodPost, "/api/roles", "alice", url.Values{"user": {"carol"}, "role": {"admin"}}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the role to be set, got %d", rec.Code)
+	}
+	if session, _ := s.authManager.ValidateSession(carol.Token); session.Role != RoleViewer {
+		t.Errorf("expected carol's unverified session to stay a viewer, got %q", session.Role)
+	}
+
+	// Proving who they are gives users their own role
+	if role, err := s.sessionRole(ctx, "alice", true); err != nil || role != RoleAdmin {
+		t.Errorf("expected a verified alice to be an admin, got %q, %v", role, err)
+	}
+
+	// Even an admin default role isn't given to unverified users
+	s.config.DefaultRole = "admin"
+	if role, err := s.unverifiedRole(RoleAdmin); err != nil || role != RoleDeveloper {
+		t.Errorf("expected unverified users to be developers at most, got %q, %v", role, err)
+	}
+}
+
+func TestSessionOwners(t *testing.T) {
+	s := newAuditTestServer(t)
+	ctx := context.Background()
+	ids := map[string]int{}
+	for owner, name := range map[string]string{"alice": "alice-work", "bob": "bob-work", "": "old-work"} {
+		id, err := s.db.CreateSession(ctx, owner, name, "", "")
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		ids[name] = id
+	}
+
+	// Users only see their own sessions; admins also see those without an owner
+	for user, want := range map[string]map[string]bool{
+		"alice": {"alice-work": true, "old-work": true},
+		"bob":   {"bob-work": true},
+	} {
+		body := roleRequest(s, s.handleListSessionsModal, http.MethodGet, "/api/sessions/modal", user, nil).Body.String()
+		for name := range ids {
+			if listed := strings.Contains(body, name); listed != want[name] {
+				t.Errorf("%s: expected %s listed = %v, got %v", user, name, want[name], listed)
+			}
+		}
+	}
+
+	for _, name := range []string{"alice-work", "old-work"} {
+		target := "/api/session/load/" + strconv.Itoa(ids[name])
+		if rec := roleRequest(s, s.handleLoadSession, http.MethodPost, target, "bob", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected bob to be refused %s, got %d", name, rec.Code)
+		}
+	}
+}
+
+func TestTerminalActionPermissions(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.terminalManager = NewTerminalManager(s.db, s.auditLogger, ResourceLimits{})
+	s.terminalManager.terminals[4] = &Terminal{ID: 4, Owner: "alice"}
+	s.terminalManager.terminals[5] = &Terminal{ID: 5, Owner: systemOwner}
+	if err := s.db.SetUserRole(context.Background(), "carol", string(RoleViewer), "alice", time.Now()); err != nil {
+		t.Fatalf("failed to set role: %v", err)
+	}
+
+	// An invalid value for enabled is only noticed once access is granted
+	for _, tt := range []struct {
+		user, method, target string
+		want                 int
+	}{
+		{"bob", http.MethodDelete, "/api/terminal/4", http.StatusForbidden},
+		{"bob", http.MethodPost, "/api/terminal/4/notify", http.StatusForbidden},
+		{"bob", http.MethodPost, "/api/terminal/9/rename", http.StatusNotFound},
+		{"bob", http.MethodPost, "/api/terminal/5/notify", http.StatusBadRequest},
+		{"alice", http.MethodPost, "/api/terminal/4/notify", http.StatusBadRequest},
+		// Notifications are the terminal's, not the viewer's, to change
+		{"carol", http.MethodPost, "/api/terminal/5/notify", http.StatusForbidden},
+	} {
+		rec := roleRequest(s, s.handleTerminalAction, tt.method, tt.target, tt.user, url.Values{"enabled": {"maybe"}})
+		if rec.Code != tt.want {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.user, tt.method, tt.target, tt.want, rec.Code)
+		}
+	}
+
+	entries, _, err := s.auditStore.Query(context.Background(), audit.Filter{Action: string(audit.ActionAuthDenied)})
+	if err != nil || len(entries) != 3 || entries[2].Target != "terminal:4" || entries[0].Target != "terminal:5" || entries[0].Details["permission"] != string(PermTerminalsWrite) {
+		t.Errorf("expected three denials, got %+v, %v", entries, err)
+	}
+
+	// Only terminals bob may watch are listed
+	req := httptest.NewRequest(http.MethodGet, "/api/tabs", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+	visible := s.visibleTerminals(req)
+	if len(visible) != 1 || visible[0].ID != 5 {
+		t.Errorf("expected only the shared terminal, got %+v", visible)
+	}
+
+	// A tab bob may not watch is never shown as open
+	s.terminalManager.SetActiveTabID("bob", 4)
+	if id := s.activeTab(req, visible); id != 5 {
+		t.Errorf("expected bob's first visible terminal to be open, got %d", id)
+	}
+}
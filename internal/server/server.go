@@ -2,9 +2,10 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -17,61 +18,270 @@ import (
 
 	"github.com/corymacd/StratusShell/internal/audit"
 	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/logging"
 	"github.com/corymacd/StratusShell/internal/middleware"
+	"github.com/corymacd/StratusShell/internal/tracing"
 	"github.com/corymacd/StratusShell/internal/ui"
 )
 
+// Config holds the settings for the web UI server
+type Config struct {
+	Port   int
+	DBPath string // SQLite file, or a postgres:// URL
+
+	// AllowedEnv lists normally-rejected environment variables (such as
+	// LD_PRELOAD) that terminals are nevertheless allowed to set
+	AllowedEnv []string
+
+	// Limits configures idle reaping, maximum lifetime and per-user quotas
+	Limits ResourceLimits
+
+	Audit AuditConfig
+
+	Backup BackupConfig
+
+	// Admins lists users who are always admins, whatever role they have
+	// been assigned
+	Admins []string
+
+	// DefaultRole is the role of users with none assigned: admin,
+	// developer or viewer (developer if empty)
+	DefaultRole string
+
+	// WebAuthn configures signing in with passkeys and security keys
+	WebAuthn WebAuthnConfig
+
+	// Require2FA makes users set up two-factor authentication before they
+	// can use anything else. Client certificate logins are exempt.
+	Require2FA bool
+
+	// Lockout locks out usernames and client IPs after repeated failed logins
+	Lockout LockoutPolicy
+
+	// AuthMode is how users sign in: AuthModeSession (the default) or
+	// AuthModeClientCert
+	AuthMode string
+
+	// TLS serves HTTPS directly instead of relying on a reverse proxy
+	TLS TLSConfig
+
+	// AllowCIDRs, if set, are the only client IPs and ranges allowed to
+	// connect; DenyCIDRs are refused even if allowed
+	AllowCIDRs []string
+	DenyCIDRs  []string
+
+	// TrustedProxies lists the addresses and CIDR ranges of reverse proxies
+	// whose X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and
+	// X-Request-ID headers are believed
+	TrustedProxies []string
+
+	// BasePath serves the UI under a URL path prefix such as /shell, for
+	// reverse proxies that host it alongside other applications
+	BasePath string
+
+	// Security configures the Content-Security-Policy and other security
+	// headers
+	Security middleware.SecurityConfig
+
+	Tracing tracing.Config
+}
+
+// AuditConfig selects where audit entries are written. With no sink
+// configured they go to the process log.
+type AuditConfig struct {
+	File       string // JSONL file, rotated at MaxSize keeping MaxBackups old files
+	MaxSize    int64
+	MaxBackups int
+	DBPath     string   // SQLite database
+	Syslog     bool     // Local syslog daemon
+	Commands   bool     // Record command lines in every terminal
+	Redact     []string // Extra patterns removed from recorded command lines
+}
+
+// BackupConfig schedules backups of the database. Interval 0 disables them.
+type BackupConfig struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int // Newest backups kept in Dir; 0 keeps all
+}
+
+// openAuditSinks opens the configured audit sinks
+func openAuditSinks(config AuditConfig) ([]audit.Sink, error) {
+	var sinks []audit.Sink
+	closeAll := func() {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+	}
+
+	if config.File != "" {
+		sink, err := audit.NewFileSink(config.File, config.MaxSize, config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if config.DBPath != "" {
+		sink, err := audit.NewSQLiteSink(config.DBPath)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if config.Syslog {
+		sink, err := audit.NewSyslogSink("stratusshell-audit")
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// Rate limit policies. Login attempts are limited tightly per IP; signed-in
+// users get a bucket of their own, with plenty of room for the many
+// requests a terminal page and its event stream make. Readiness probes do
+// real work, so they get a per-IP bucket sized for an orchestrator's polling.
+var (
+	loginRateLimit    = middleware.Policy{Name: "login", Limit: 10, Window: time.Minute}
+	probeRateLimit    = middleware.Policy{Name: "probe", Limit: 60, Window: time.Minute}
+	apiRateLimit      = middleware.Policy{Name: "api", Limit: 100, Window: time.Minute, PerUser: true}
+	terminalRateLimit = middleware.Policy{Name: "terminal", Limit: 1000, Window: time.Minute, PerUser: true}
+)
+
+// Per-component loggers: terminalLogger covers the terminal lifecycle and
+// logger everything else the server does
+var (
+	logger         = logging.Component("server")
+	terminalLogger = logging.Component("terminal")
+)
+
 type Server struct {
-	port            int
-	db              *db.DB
+	config          Config
+	db              db.Store
 	terminalManager *TerminalManager
 	authManager     *AuthManager
+	loginGuard      *LoginGuard
 	auditLogger     *audit.Logger
+	auditStore      *audit.SQLiteSink // Queryable audit database, nil if disabled
 	rateLimiter     *middleware.RateLimiter
 	csrfProtection  *middleware.CSRFProtection
+	trustedProxies  middleware.TrustedProxies
 	httpServer      *http.Server
+	shutdown        chan struct{} // Closed on shutdown to end long-lived event streams
+	stopTracing     func(context.Context) error
 }
 
-func NewServer(port int, dbPath string) (*Server, error) {
+func NewServer(config Config) (*Server, error) {
+	basePath, err := middleware.NormalizeBasePath(config.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := middleware.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	ipFilter, err := middleware.NewIPFilter(config.AllowCIDRs, config.DenyCIDRs, trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if config.DefaultRole != "" {
+		if _, err := ParseRole(config.DefaultRole); err != nil {
+			return nil, fmt.Errorf("invalid default role: %w", err)
+		}
+	}
+	if err := validateAuth(config.AuthMode, config.TLS); err != nil {
+		return nil, err
+	}
+	config.WebAuthn = config.WebAuthn.withDefaults(config.Port, config.TLS.Enabled())
+	if err := config.WebAuthn.validate(); err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if config.TLS.Enabled() {
+		if tlsConfig, err = config.TLS.serverConfig(config.AuthMode); err != nil {
+			return nil, err
+		}
+	}
+	redactor, err := audit.NewRedactor(config.Audit.Redact)
+	if err != nil {
+		return nil, err
+	}
+	if config.Backup.Interval > 0 && db.IsPostgresDSN(config.DBPath) {
+		return nil, errors.New("scheduled backups are only supported for SQLite databases; back up PostgreSQL with pg_dump")
+	}
+	stopTracing, err := tracing.Setup(context.Background(), config.Tracing)
+	if err != nil {
+		return nil, err
+	}
+
 	// Open database
-	database, err := db.Open(dbPath)
+	database, err := db.OpenStore(config.DBPath)
 	if err != nil {
+		stopTracing(context.Background())
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	// Create audit logger
+	sinks, err := openAuditSinks(config.Audit)
+	if err != nil {
+		database.Close()
+		stopTracing(context.Background())
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	al := audit.NewLogger(sinks...)
+	var store *audit.SQLiteSink
+	for _, sink := range sinks {
+		if sqlite, ok := sink.(*audit.SQLiteSink); ok {
+			store = sqlite
+		}
+	}
+
 	// Create terminal manager
-	tm := NewTerminalManager(database)
+	tm := NewTerminalManager(database, al, config.Limits)
+	tm.SetCommandAuditing(config.Audit.Commands, redactor)
 
 	// Create auth manager
 	am := NewAuthManager()
-
-	// Create audit logger
-	al := audit.NewLogger()
-
-	// Create rate limiter: 100 requests per minute per IP
-	rl := middleware.NewRateLimiter(100, time.Minute)
-
-	// Create CSRF protection
-	csrf := middleware.NewCSRFProtection()
+	if err := am.EnableWebAuthn(config.WebAuthn); err != nil {
+		logger.Warn("passkeys are disabled", "err", err)
+	}
 
 	s := &Server{
-		port:            port,
+		config:          config,
 		db:              database,
 		terminalManager: tm,
 		authManager:     am,
+		loginGuard:      NewLoginGuard(database, config.Lockout),
 		auditLogger:     al,
-		rateLimiter:     rl,
-		csrfProtection:  csrf,
+		auditStore:      store,
+		trustedProxies:  trustedProxies,
+		shutdown:        make(chan struct{}),
+		stopTracing:     stopTracing,
 	}
+	s.rateLimiter = middleware.NewRateLimiter(trustedProxies, s.sessionUser)
+	s.csrfProtection = middleware.NewCSRFProtection(s.sessionID)
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
 	s.setupRoutes(mux)
 
+	var handler http.Handler = middleware.SecurityHeaders(config.Security, mux)
+	handler = ipFilter.Filter(handler)
+	handler = middleware.AccessLog(logging.Component("http"), s.trustedProxies, handler)
+	handler = middleware.StripBasePath(basePath, handler)
+	handler = middleware.ProxyHeaders(s.trustedProxies, handler)
 	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:      fmt.Sprintf(":%d", config.Port),
+		Handler:   tracing.Middleware(handler),
+		TLSConfig: tlsConfig,
 	}
+	s.httpServer.RegisterOnShutdown(func() {
+		close(s.shutdown)
+	})
 
 	return s, nil
 }
@@ -82,48 +292,100 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 
 	// Health and metrics - public
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/healthz", s.handleLiveness)
+	mux.HandleFunc("/readyz", s.rateLimiter.Limit(probeRateLimit, s.handleReadiness))
 	mux.HandleFunc("/metrics", s.handleMetrics)
 
-	// Auth routes - public with rate limiting
-	mux.HandleFunc("/login", s.rateLimiter.Limit(s.handleLogin))
-	mux.HandleFunc("/logout", s.rateLimiter.Limit(s.handleLogout))
+	// Auth routes - public with tight, per-IP rate limiting
+	mux.HandleFunc("/login", s.rateLimiter.Limit(loginRateLimit, s.handleLogin))
+	mux.HandleFunc("/logout", s.rateLimiter.Limit(apiRateLimit, s.handleLogout))
 
 	// Terminal proxy - requires auth + rate limiting
-	mux.HandleFunc("/term/", s.rateLimiter.Limit(s.AuthMiddleware(s.handleTerminalProxy)))
+	mux.HandleFunc("/term/", s.rateLimiter.Limit(terminalRateLimit, s.AuthMiddleware(s.handleTerminalProxy)))
 
 	// Main page - requires auth + rate limiting
-	mux.HandleFunc("/", s.rateLimiter.Limit(s.AuthMiddleware(s.handleIndex)))
+	mux.HandleFunc("/", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handleIndex)))
 
 	// Tab-based API routes - new primary interface
-	mux.HandleFunc("/api/tabs", s.rateLimiter.Limit(s.AuthMiddleware(s.handleGetTabs)))
-	mux.HandleFunc("/api/tabs/switch/", s.rateLimiter.Limit(s.AuthMiddleware(s.handleSwitchTab)))
-	mux.HandleFunc("/api/terminals/add", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleAddTerminalTab))))
-	mux.HandleFunc("/api/terminal/", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleTerminalAction))))
-
-	// Legacy layout API routes - kept for backward compatibility
-	mux.HandleFunc("/api/layout", s.rateLimiter.Limit(s.AuthMiddleware(s.handleGetLayout)))
-	mux.HandleFunc("/api/layout/horizontal", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleLayoutHorizontal))))
-	mux.HandleFunc("/api/layout/vertical", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleLayoutVertical))))
-	mux.HandleFunc("/api/layout/grid", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleLayoutGrid))))
+	mux.HandleFunc("/api/tabs", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handleGetTabs)))
+	mux.HandleFunc("/api/tabs/bar", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handleGetTabBar)))
+	mux.HandleFunc("/api/tabs/switch/", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleSwitchTab))))
+	mux.HandleFunc("/api/terminals/new-modal", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.handleNewTerminalModal))))
+	mux.HandleFunc("/api/terminals/add", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.csrfProtection.Protect(s.handleAddTerminalTab)))))
+	mux.HandleFunc("/api/terminal/", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleTerminalAction))))
+
+	// Terminal lifecycle event stream (Server-Sent Events)
+	mux.HandleFunc("/api/events", s.rateLimiter.Limit(terminalRateLimit, s.AuthMiddleware(s.handleEvents)))
+
+	// Legacy layout API routes - kept for backward compatibility. Changing
+	// the layout starts and kills terminals of every user, so only admins may.
+	mux.HandleFunc("/api/layout", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handleGetLayout)))
+	mux.HandleFunc("/api/layout/horizontal", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsAll, s.csrfProtection.Protect(s.handleLayoutHorizontal)))))
+	mux.HandleFunc("/api/layout/vertical", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsAll, s.csrfProtection.Protect(s.handleLayoutVertical)))))
+	mux.HandleFunc("/api/layout/grid", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsAll, s.csrfProtection.Protect(s.handleLayoutGrid)))))
 
 	// Session API routes
-	mux.HandleFunc("/api/session/save-modal", s.rateLimiter.Limit(s.AuthMiddleware(s.handleSaveSessionModal)))
-	mux.HandleFunc("/api/session/save", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleSaveSession))))
-	mux.HandleFunc("/api/session/list-modal", s.rateLimiter.Limit(s.AuthMiddleware(s.handleListSessionsModal)))
-	mux.HandleFunc("/api/session/load/", s.rateLimiter.Limit(s.AuthMiddleware(s.csrfProtection.Protect(s.handleLoadSession))))
+	mux.HandleFunc("/api/session/save-modal", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.handleSaveSessionModal))))
+	mux.HandleFunc("/api/session/save", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.csrfProtection.Protect(s.handleSaveSession)))))
+	mux.HandleFunc("/api/session/list-modal", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.handleListSessionsModal))))
+	mux.HandleFunc("/api/session/load/", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.RequirePermission(PermTerminalsWrite, s.csrfProtection.Protect(s.handleLoadSession)))))
+
+	// Audit log viewer and query API - admins only
+	mux.HandleFunc("/audit", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.handleAuditPage))))
+	mux.HandleFunc("/audit/entries", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.handleAuditEntries))))
+	mux.HandleFunc("/api/audit", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.handleAuditQuery))))
+
+	// Login lockouts - admins only
+	mux.HandleFunc("/api/lockouts", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.handleLockouts))))
+	mux.HandleFunc("/api/lockouts/unlock", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.csrfProtection.Protect(s.handleUnlock)))))
+
+	// User roles - admins only
+	mux.HandleFunc("/api/roles", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.csrfProtection.Protect(s.handleRoles)))))
+
+	// Two-factor authentication. Users may only manage their own; admins
+	// may reset anyone's.
+	mux.HandleFunc("/2fa", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handleTwoFactorPage)))
+	mux.HandleFunc("/2fa/enroll", s.rateLimiter.Limit(loginRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleTOTPEnroll))))
+	mux.HandleFunc("/2fa/confirm", s.rateLimiter.Limit(loginRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleTOTPConfirm))))
+	mux.HandleFunc("/2fa/recovery-codes", s.rateLimiter.Limit(loginRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleRecoveryCodes))))
+	mux.HandleFunc("/2fa/token", s.rateLimiter.Limit(loginRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handleEnrollmentTokenRedeem))))
+	mux.HandleFunc("/api/2fa/enroll-token", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.csrfProtection.Protect(s.handleEnrollmentTokenIssue)))))
+	mux.HandleFunc("/api/2fa/reset", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.AdminMiddleware(s.csrfProtection.Protect(s.handleTwoFactorReset)))))
+
+	// Passkeys and security keys. Users manage their own; signing in with
+	// one is public, and rate limited like other logins.
+	mux.HandleFunc("/login/passkey", s.rateLimiter.Limit(loginRateLimit, s.handlePasskeyLoginPage))
+	mux.HandleFunc("/login/passkey/begin", s.rateLimiter.Limit(loginRateLimit, s.handlePasskeyLoginBegin))
+	mux.HandleFunc("/login/passkey/finish", s.rateLimiter.Limit(loginRateLimit, s.handlePasskeyLoginFinish))
+	mux.HandleFunc("/passkeys", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.handlePasskeysPage)))
+	mux.HandleFunc("/passkeys/register/begin", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handlePasskeyRegisterBegin))))
+	mux.HandleFunc("/passkeys/register/finish", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handlePasskeyRegisterFinish))))
+	mux.HandleFunc("/passkeys/", s.rateLimiter.Limit(apiRateLimit, s.AuthMiddleware(s.csrfProtection.Protect(s.handlePasskeyRevoke))))
 }
 
 func (s *Server) Run() error {
 	// Restore terminals from DB
 	if err := s.restoreTerminals(); err != nil {
-		log.Printf("Warning: failed to restore terminals: %v", err)
+		logger.Warn("failed to restore terminals", "err", err)
+	}
+
+	if s.config.Backup.Interval > 0 {
+		go s.runBackups()
 	}
 
 	// Start HTTP server in goroutine
 	go func() {
-		log.Printf("Starting server on http://localhost:%d", s.port)
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			logger.Info("starting server", "url", fmt.Sprintf("https://localhost:%d", s.config.Port), "auth", s.config.AuthMode)
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			logger.Info("starting server", "url", fmt.Sprintf("http://localhost:%d", s.config.Port))
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("server error", "err", err)
+			os.Exit(1)
 		}
 	}()
 
@@ -132,7 +394,7 @@ func (s *Server) Run() error {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 
-	log.Println("Shutting down gracefully...")
+	logger.Info("shutting down gracefully")
 	return s.Shutdown()
 }
 
@@ -142,17 +404,27 @@ func (s *Server) Shutdown() error {
 	defer cancel()
 
 	if err := s.httpServer.Shutdown(ctx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+		logger.Error("HTTP server shutdown error", "err", err)
 	}
 
 	// Kill all terminals
 	if err := s.terminalManager.Shutdown(); err != nil {
-		log.Printf("Terminal manager shutdown error: %v", err)
+		logger.Error("terminal manager shutdown error", "err", err)
 	}
 
 	// Close database
 	if err := s.db.Close(); err != nil {
-		log.Printf("Database close error: %v", err)
+		logger.Error("database close error", "err", err)
+	}
+
+	// Close audit log last so everything above is recorded
+	if err := s.auditLogger.Close(); err != nil {
+		logger.Error("audit log close error", "err", err)
+	}
+
+	// Flush spans still waiting to be exported
+	if err := s.stopTracing(ctx); err != nil {
+		logger.Error("tracing shutdown error", "err", err)
 	}
 
 	return nil
@@ -161,9 +433,38 @@ func (s *Server) Shutdown() error {
 func (s *Server) restoreTerminals() error {
 	ctx := context.Background()
 
-	// Clean up stale terminal records from previous crashes
+	// Terminals of the last run are started again for the users who started
+	// them, before their stale records are cleaned up
+	previous, err := s.db.GetActiveTerminals(ctx)
+	if err != nil {
+		logger.Warn("failed to get previous terminals", "err", err)
+	}
 	if err := s.db.ClearActiveTerminals(ctx); err != nil {
-		log.Printf("Warning: failed to clear stale terminal records: %v", err)
+		logger.Warn("failed to clear stale terminal records", "err", err)
+	}
+
+	if len(previous) > 0 {
+		for _, t := range previous {
+			owner := t.Owner
+			if owner == "" {
+				owner = systemOwner
+			}
+			var spec CommandSpec
+			if t.Shell != "" {
+				// Stored commands are checked like new terminals' before
+				// they run again
+				spec, err = s.savedCommandSpec(t.Shell, t.WorkingDir, t.Env, t.RestartPolicy)
+				if err != nil {
+					logger.Warn("dropped terminal with an invalid command", "title", t.Title, "owner", owner, "err", err)
+					continue
+				}
+				spec.RecordCommands = t.RecordCommands
+			}
+			if _, err := s.terminalManager.SpawnTerminal(ctx, owner, t.Title, spec); err != nil {
+				logger.Warn("failed to restore terminal", "title", t.Title, "owner", owner, "err", err)
+			}
+		}
+		return nil
 	}
 
 	layout, err := s.db.GetActiveLayout(ctx)
@@ -171,7 +472,7 @@ func (s *Server) restoreTerminals() error {
 		return err
 	}
 
-	return s.terminalManager.ApplyLayout(layout.LayoutType)
+	return s.terminalManager.ApplyLayout(ctx, systemOwner, layout.LayoutType)
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -186,8 +487,15 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		user = "unknown"
 	}
 
+	// Pages carry the CSRF token for htmx to send with each request
+	token, err := s.csrfProtection.GetToken(w, r)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to create CSRF token")
+		return
+	}
+
 	// Render layout
-	ui.Layout(user).Render(r.Context(), w)
+	ui.Layout(user, s.requestRole(r).Can(PermAdmin), token).Render(r.Context(), w)
 }
 
 func (s *Server) handleTerminalProxy(w http.ResponseWriter, r *http.Request) {
@@ -211,6 +519,10 @@ func (s *Server) handleTerminalProxy(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Terminal not found", http.StatusNotFound)
 		return
 	}
+	if !s.authorizeTerminal(w, r, terminal, false) {
+		return
+	}
+	_, write := terminalAccess(s.getActor(r), s.requestRole(r), terminal.Owner)
 
 	// Create reverse proxy to localhost:{port}
 	target, err := url.Parse(fmt.Sprintf("http://localhost:%d", terminal.Port))
@@ -228,6 +540,12 @@ func (s *Server) handleTerminalProxy(w http.ResponseWriter, r *http.Request) {
 		originalDirector(req)
 		// Add Basic Auth using the terminal's credential
 		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(terminal.Credential)))
+		// The supervisor drops input from read-only connections; clients
+		// cannot set this themselves
+		req.Header.Del(readOnlyHeader)
+		if !write {
+			req.Header.Set(readOnlyHeader, "1")
+		}
 	}
 
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/term/%d", terminalID))
@@ -0,0 +1,546 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/sorenisanerd/gotty/server"
+)
+
+// RestartPolicy controls what happens when a terminal's command exits
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
+)
+
+// ProcessStatus is the lifecycle state of a terminal's command
+type ProcessStatus string
+
+const (
+	StatusRunning    ProcessStatus = "running"
+	StatusRestarting ProcessStatus = "restarting"
+	StatusExited     ProcessStatus = "exited"  // Stopped after a successful exit
+	StatusCrashed    ProcessStatus = "crashed" // Stopped after a failure
+)
+
+const (
+	// Restart backoff doubles from restartBackoffMin up to restartBackoffMax
+	restartBackoffMin = 1 * time.Second
+	restartBackoffMax = 1 * time.Minute
+
+	// A command that ran at least this long resets the backoff
+	stableRunTime = 30 * time.Second
+
+	// scrollbackSize is how much recent output is replayed to new connections
+	scrollbackSize = 64 * 1024
+
+	// attachmentBuffer is how many output chunks may queue for a slow client
+	// before it is disconnected (it will reconnect and get the scrollback)
+	attachmentBuffer = 256
+)
+
+// ExitInfo describes one exit of a terminal's command
+type ExitInfo struct {
+	ExitCode   int
+	Signal     string // Set when the process was killed by a signal
+	Runtime    time.Duration
+	Crashed    bool          // Non-zero exit, signal, or failure to start
+	Restarting bool          // Whether the supervisor will start the command again
+	Delay      time.Duration // Backoff before the restart
+	Err        error         // Start failure, if the command could not be started
+}
+
+// ProcessStats is a snapshot of a supervised command
+type ProcessStats struct {
+	Status       ProcessStatus
+	Policy       RestartPolicy
+	PID          int
+	ExitCode     int // Exit code of the last exit
+	Restarts     int
+	Crashes      int
+	LastActivity time.Time // Last input or output
+}
+
+// supervisorHooks are callbacks invoked from the supervisor goroutine
+type supervisorHooks struct {
+	OnExit   func(ExitInfo) // Every time the command exits
+	OnOutput func([]byte)   // For every chunk of command output
+
+	// OnInput is called with input before it is written to the command;
+	// hidden reports that the command is reading a password or similar
+	OnInput func(data []byte, hidden bool)
+}
+
+// supervisor owns a terminal's long-running command. The process lives
+// independently of browser connections: GoTTY connections attach to it
+// (it implements server.Factory), and it is restarted according to its
+// restart policy when it exits.
+type supervisor struct {
+	spec   CommandSpec
+	policy RestartPolicy
+	hooks  supervisorHooks
+
+	mu         sync.Mutex
+	proc       *ptyCommand
+	status     ProcessStatus
+	exitCode   int
+	restarts   int
+	crashes    int
+	failures   int // Consecutive failures, drives the backoff
+	scrollback []byte
+	clients    map[*attachment]struct{}
+	cols, rows int
+	lastIO     time.Time // Last input or output, for idle detection
+	manual     bool      // A manual restart of the running process was requested
+	startErr   error     // Why the last restart attempt failed
+	stopped    bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// startSupervisor starts spec and supervises it until Stop is called
+func startSupervisor(spec CommandSpec, hooks supervisorHooks) (*supervisor, error) {
+	spec = spec.withDefaults()
+
+	proc, err := startPTYCommand(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &supervisor{
+		spec:    spec,
+		policy:  spec.Restart,
+		hooks:   hooks,
+		proc:    proc,
+		status:  StatusRunning,
+		lastIO:  time.Now(),
+		clients: make(map[*attachment]struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// run pumps output from the current process and handles its exits
+func (s *supervisor) run() {
+	defer close(s.done)
+
+	for {
+		s.mu.Lock()
+		proc := s.proc
+		s.mu.Unlock()
+
+		if proc != nil {
+			s.pump(proc)
+			<-proc.exited
+		}
+
+		if !s.handleExit(proc) {
+			return
+		}
+	}
+}
+
+// pump copies process output to the scrollback and all attached clients
+func (s *supervisor) pump(proc *ptyCommand) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := proc.Read(buf)
+		if n > 0 {
+			s.touch()
+			s.broadcast(buf[:n])
+			if s.hooks.OnOutput != nil {
+				s.hooks.OnOutput(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleExit records an exit, then waits for and performs a restart.
+// It returns false once the supervisor has been stopped.
+func (s *supervisor) handleExit(proc *ptyCommand) bool {
+	info := ExitInfo{ExitCode: -1, Crashed: true}
+	if proc != nil {
+		info.ExitCode, info.Signal = proc.exitStatus()
+		info.Runtime = time.Since(proc.startedAt)
+		info.Crashed = info.ExitCode != 0
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return false
+	}
+
+	if proc == nil {
+		info.Err = s.startErr
+		s.startErr = nil
+	}
+
+	manual := s.manual
+	s.manual = false
+	s.proc = nil
+	s.exitCode = info.ExitCode
+	if info.Crashed && !manual {
+		s.crashes++
+	}
+	if info.Runtime >= stableRunTime || !info.Crashed {
+		s.failures = 0
+	}
+	if info.Crashed {
+		s.failures++
+	}
+
+	switch {
+	case manual:
+		info.Restarting = true
+	case s.policy == RestartAlways:
+		info.Restarting = true
+	case s.policy == RestartOnFailure:
+		info.Restarting = info.Crashed
+	}
+	if info.Restarting && !manual {
+		info.Delay = backoff(s.failures)
+	}
+
+	if info.Restarting {
+		s.status = StatusRestarting
+	} else if info.Crashed {
+		s.status = StatusCrashed
+	} else {
+		s.status = StatusExited
+	}
+	s.mu.Unlock()
+
+	if !manual {
+		s.notice(exitMessage(info))
+		if s.hooks.OnExit != nil {
+			s.hooks.OnExit(info)
+		}
+	}
+
+	// Wait for the backoff to elapse, or indefinitely if no restart is due,
+	// until a manual restart or stop wakes us up
+	var timer <-chan time.Time
+	if info.Restarting {
+		timer = time.After(info.Delay)
+	}
+	select {
+	case <-timer:
+	case <-s.wake:
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return false
+	}
+	s.mu.Unlock()
+
+	return s.respawn()
+}
+
+// respawn starts the command again. A start failure is handled like a crash
+// by the next iteration of run.
+func (s *supervisor) respawn() bool {
+	proc, err := startPTYCommand(s.spec)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		if proc != nil {
+			go proc.Close()
+		}
+		return false
+	}
+
+	if err != nil {
+		terminalLogger.Warn("failed to restart command", "command", s.spec.Argv[0], "err", err)
+		s.startErr = err
+		return true
+	}
+
+	if s.cols > 0 && s.rows > 0 {
+		proc.ResizeTerminal(s.cols, s.rows)
+	}
+	s.proc = proc
+	s.status = StatusRunning
+	s.restarts++
+	return true
+}
+
+// backoff returns the restart delay after the given number of consecutive failures
+func backoff(failures int) time.Duration {
+	if failures <= 1 {
+		return restartBackoffMin
+	}
+	delay := restartBackoffMin
+	for i := 1; i < failures && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
+
+func exitMessage(info ExitInfo) string {
+	reason := fmt.Sprintf("exited with code %d", info.ExitCode)
+	if info.Signal != "" {
+		reason = "killed by " + info.Signal
+	}
+	if info.Err != nil {
+		reason = "failed to start: " + info.Err.Error()
+	}
+	if info.Restarting {
+		return fmt.Sprintf("[process %s, restarting in %s]", reason, info.Delay)
+	}
+	return fmt.Sprintf("[process %s]", reason)
+}
+
+// notice writes a status line into the terminal output
+func (s *supervisor) notice(msg string) {
+	s.broadcast([]byte("\r\n\x1b[2m" + msg + "\x1b[0m\r\n"))
+}
+
+// broadcast appends output to the scrollback and queues it for every client
+func (s *supervisor) broadcast(data []byte) {
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrollback = append(s.scrollback, chunk...)
+	if over := len(s.scrollback) - scrollbackSize; over > 0 {
+		s.scrollback = s.scrollback[over:]
+	}
+
+	for a := range s.clients {
+		select {
+		case a.out <- chunk:
+		default:
+			// Client can't keep up; drop it rather than block the terminal
+			s.removeClientLocked(a)
+		}
+	}
+}
+
+// Restart restarts the command immediately, regardless of its policy
+func (s *supervisor) Restart() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	proc := s.proc
+	if proc != nil {
+		s.manual = true
+	}
+	s.mu.Unlock()
+
+	if proc != nil {
+		// The exit is picked up by run, which restarts without backoff
+		go proc.Close()
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop terminates the command and disconnects all clients
+func (s *supervisor) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	proc := s.proc
+	for a := range s.clients {
+		s.removeClientLocked(a)
+	}
+	s.mu.Unlock()
+
+	if proc != nil {
+		proc.Close()
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	<-s.done
+}
+
+// Stats returns a snapshot of the supervised command
+func (s *supervisor) Stats() ProcessStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := ProcessStats{
+		Status:       s.status,
+		Policy:       s.policy,
+		ExitCode:     s.exitCode,
+		Restarts:     s.restarts,
+		Crashes:      s.crashes,
+		LastActivity: s.lastIO,
+	}
+	if s.proc != nil {
+		stats.PID = s.proc.Pid()
+	}
+	return stats
+}
+
+// touch records input or output
+func (s *supervisor) touch() {
+	s.mu.Lock()
+	s.lastIO = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *supervisor) write(b []byte) (int, error) {
+	s.mu.Lock()
+	proc := s.proc
+	s.lastIO = time.Now()
+	s.mu.Unlock()
+
+	if proc == nil {
+		// Input typed while the command is down is discarded
+		return len(b), nil
+	}
+	if s.hooks.OnInput != nil {
+		s.hooks.OnInput(b, inputHidden(proc.pty))
+	}
+	return proc.Write(b)
+}
+
+func (s *supervisor) resize(cols, rows int) error {
+	s.mu.Lock()
+	s.cols, s.rows = cols, rows
+	proc := s.proc
+	s.mu.Unlock()
+
+	if proc == nil {
+		return nil
+	}
+	return proc.ResizeTerminal(cols, rows)
+}
+
+// Name implements server.Factory
+func (s *supervisor) Name() string {
+	return "stratusshell supervisor"
+}
+
+// readOnlyHeader is set by the terminal proxy on connections that may watch
+// the terminal but not type into it
+const readOnlyHeader = "X-Stratusshell-Read-Only"
+
+// New implements server.Factory by attaching the connecting client to the
+// running command instead of starting a new process. Clients connected with
+// readOnlyHeader set only receive output.
+func (s *supervisor) New(params map[string][]string, headers map[string][]string) (server.Slave, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil, fmt.Errorf("terminal has been stopped")
+	}
+
+	a := &attachment{
+		sup:      s,
+		out:      make(chan []byte, attachmentBuffer),
+		readOnly: http.Header(headers).Get(readOnlyHeader) != "",
+	}
+	if len(s.scrollback) > 0 {
+		replay := make([]byte, len(s.scrollback))
+		copy(replay, s.scrollback)
+		a.out <- replay
+	}
+	s.clients[a] = struct{}{}
+	metrics.WebSocketConnections.Inc()
+
+	return a, nil
+}
+
+func (s *supervisor) detach(a *attachment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[a]; ok {
+		s.removeClientLocked(a)
+	}
+}
+
+// removeClientLocked disconnects a client; s.mu must be held
+func (s *supervisor) removeClientLocked(a *attachment) {
+	delete(s.clients, a)
+	close(a.out)
+	metrics.WebSocketConnections.Dec()
+}
+
+// attachment is one client connection to a supervised command
+type attachment struct {
+	sup      *supervisor
+	out      chan []byte
+	pending  []byte
+	readOnly bool // Input and resizes are ignored
+}
+
+func (a *attachment) Read(b []byte) (int, error) {
+	if len(a.pending) == 0 {
+		chunk, ok := <-a.out
+		if !ok {
+			return 0, io.EOF
+		}
+		a.pending = chunk
+	}
+	n := copy(b, a.pending)
+	a.pending = a.pending[n:]
+	return n, nil
+}
+
+func (a *attachment) Write(b []byte) (int, error) {
+	if a.readOnly {
+		return len(b), nil
+	}
+	return a.sup.write(b)
+}
+
+// Close detaches the client; the command keeps running
+func (a *attachment) Close() error {
+	a.sup.detach(a)
+	return nil
+}
+
+func (a *attachment) WindowTitleVariables() map[string]interface{} {
+	stats := a.sup.Stats()
+	return map[string]interface{}{
+		"command": a.sup.spec.Argv[0],
+		"argv":    a.sup.spec.Argv[1:],
+		"pid":     stats.PID,
+	}
+}
+
+func (a *attachment) ResizeTerminal(columns int, rows int) error {
+	if a.readOnly {
+		return nil
+	}
+	return a.sup.resize(columns, rows)
+}
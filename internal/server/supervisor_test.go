@@ -0,0 +1,204 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitForExit(t *testing.T, exits <-chan ExitInfo) ExitInfo {
+	t.Helper()
+	select {
+	case info := <-exits:
+		return info
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for command to exit")
+		return ExitInfo{}
+	}
+}
+
+func TestSupervisorNeverRestart(t *testing.T) {
+	exits := make(chan ExitInfo, 4)
+	sup, err := startSupervisor(CommandSpec{
+		Argv:    []string{"/bin/sh", "-c", "exit 3"},
+		Restart: RestartNever,
+	}, supervisorHooks{OnExit: func(info ExitInfo) { exits <- info }})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	info := waitForExit(t, exits)
+	if info.ExitCode != 3 || !info.Crashed || info.Restarting {
+		t.Errorf("unexpected exit info: %+v", info)
+	}
+
+	stats := sup.Stats()
+	if stats.Status != StatusCrashed {
+		t.Errorf("expected status %q, got %q", StatusCrashed, stats.Status)
+	}
+	if stats.Crashes != 1 {
+		t.Errorf("expected 1 crash, got %d", stats.Crashes)
+	}
+}
+
+func TestSupervisorOnFailureRestart(t *testing.T) {
+	exits := make(chan ExitInfo, 4)
+	sup, err := startSupervisor(CommandSpec{
+		Argv:    []string{"/bin/sh", "-c", "exit 1"},
+		Restart: RestartOnFailure,
+	}, supervisorHooks{OnExit: func(info ExitInfo) { exits <- info }})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	first := waitForExit(t, exits)
+	if !first.Restarting || first.Delay != restartBackoffMin {
+		t.Errorf("expected restart after %s, got %+v", restartBackoffMin, first)
+	}
+
+	// The second consecutive failure doubles the backoff
+	second := waitForExit(t, exits)
+	if second.Delay != 2*restartBackoffMin {
+		t.Errorf("expected backoff %s, got %s", 2*restartBackoffMin, second.Delay)
+	}
+	if restarts := sup.Stats().Restarts; restarts < 1 {
+		t.Errorf("expected at least 1 restart, got %d", restarts)
+	}
+}
+
+func TestSupervisorOnFailureCleanExit(t *testing.T) {
+	exits := make(chan ExitInfo, 4)
+	sup, err := startSupervisor(CommandSpec{
+		Argv:    []string{"/bin/true"},
+		Restart: RestartOnFailure,
+	}, supervisorHooks{OnExit: func(info ExitInfo) { exits <- info }})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	info := waitForExit(t, exits)
+	if info.Crashed || info.Restarting {
+		t.Errorf("clean exit should not restart: %+v", info)
+	}
+	if status := sup.Stats().Status; status != StatusExited {
+		t.Errorf("expected status %q, got %q", StatusExited, status)
+	}
+}
+
+func TestSupervisorAttachReceivesOutput(t *testing.T) {
+	sup, err := startSupervisor(CommandSpec{
+		Argv: []string{"/bin/cat"},
+	}, supervisorHooks{})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	slave, err := sup.New(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	defer slave.Close()
+
+	if _, err := slave.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	var out strings.Builder
+	buf := make([]byte, 1024)
+	deadline := time.After(5 * time.Second)
+	for !strings.Contains(out.String(), "hello") {
+		read := make(chan int, 1)
+		go func() {
+			n, _ := slave.Read(buf)
+			read <- n
+		}()
+		select {
+		case n := <-read:
+			out.Write(buf[:n])
+		case <-deadline:
+			t.Fatalf("timed out waiting for output, got %q", out.String())
+		}
+	}
+
+	// A second client gets the scrollback replayed
+	late, err := sup.New(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to attach second client: %v", err)
+	}
+	defer late.Close()
+	n, _ := late.Read(buf)
+	if !strings.Contains(string(buf[:n]), "hello") {
+		t.Errorf("expected scrollback replay, got %q", buf[:n])
+	}
+}
+
+func TestSupervisorReadOnlyAttach(t *testing.T) {
+	sup, err := startSupervisor(CommandSpec{
+		Argv: []string{"/bin/cat"},
+	}, supervisorHooks{})
+	if err != nil {
+		t.Fatalf("failed to start supervisor: %v", err)
+	}
+	defer sup.Stop()
+
+	viewer, err := sup.New(nil, map[string][]string{readOnlyHeader: {"1"}})
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	defer viewer.Close()
+	writer, err := sup.New(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	defer writer.Close()
+
+	if _, err := viewer.Write([]byte("ignored\n")); err != nil {
+		t.Fatalf("read-only write failed: %v", err)
+	}
+	if _, err := writer.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// The viewer sees output, but its own input never reached the command
+	var out strings.Builder
+	buf := make([]byte, 1024)
+	deadline := time.After(5 * time.Second)
+	for !strings.Contains(out.String(), "hello") {
+		read := make(chan int, 1)
+		go func() {
+			n, _ := viewer.Read(buf)
+			read <- n
+		}()
+		select {
+		case n := <-read:
+			out.Write(buf[:n])
+		case <-deadline:
+			t.Fatalf("timed out waiting for output, got %q", out.String())
+		}
+	}
+	if strings.Contains(out.String(), "ignored") {
+		t.Errorf("expected read-only input to be dropped, got %q", out.String())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, restartBackoffMin},
+		{1, restartBackoffMin},
+		{2, 2 * restartBackoffMin},
+		{3, 4 * restartBackoffMin},
+		{100, restartBackoffMax},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
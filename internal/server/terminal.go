@@ -6,11 +6,19 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/corymacd/StratusShell/internal/audit"
 	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Terminal struct {
@@ -18,32 +26,150 @@ type Terminal struct {
 	DBID        int // Database primary key
 	Port        int
 	Title       string
-	Shell       string
+	Owner       string   // User who started the terminal, for per-user quotas
+	Command     []string // argv the terminal runs
+	Env         []string // Extra KEY=VALUE environment entries
 	WorkingDir  string
+	Restart     RestartPolicy
+	Recording   bool   // Command lines are recorded in the audit log
 	Credential  string // GoTTY authentication credential
 	GoTTYServer *GoTTYServer
 	CreatedAt   time.Time
+
+	process  *supervisor
+	silence  *silenceDetector
+	commands *commandRecorder // nil unless Recording
+
+	attentionMu sync.Mutex
+	notify      bool           // Desktop notifications on completion are enabled
+	alerts      terminalAlerts // Unseen while the tab was in the background
+	reapAt      time.Time      // When the reaper will close the terminal, once warned
+	reapReason  string
+
+	unhealthy atomic.Bool // GoTTY backend stopped accepting connections; the reaper closes it
+}
+
+// terminalAlerts are the events a background tab has had since it was last viewed
+type terminalAlerts struct {
+	Activity bool
+	Bell     bool
+	Done     bool   // Output stopped after a burst of activity
+	Message  string // Latest notification sent by the program
+}
+
+// Stats returns the state of the terminal's command
+func (t *Terminal) Stats() ProcessStats {
+	return t.process.Stats()
+}
+
+// markUnhealthy flags the terminal for the reaper to close, reporting
+// whether it was newly marked
+func (t *Terminal) markUnhealthy() bool {
+	return t.unhealthy.CompareAndSwap(false, true)
+}
+
+// Notify reports whether desktop notifications are enabled for the terminal
+func (t *Terminal) Notify() bool {
+	t.attentionMu.Lock()
+	defer t.attentionMu.Unlock()
+	return t.notify
+}
+
+// Alerts returns the terminal's unseen alerts
+func (t *Terminal) Alerts() terminalAlerts {
+	t.attentionMu.Lock()
+	defer t.attentionMu.Unlock()
+	return t.alerts
+}
+
+// ReapWarning returns when and why the terminal is about to be closed by the
+// reaper, or a zero time if it is not
+func (t *Terminal) ReapWarning() (time.Time, string) {
+	t.attentionMu.Lock()
+	defer t.attentionMu.Unlock()
+	return t.reapAt, t.reapReason
+}
+
+// setReapWarning records an upcoming reap (or clears it with a zero time) and
+// reports whether anything changed
+func (t *Terminal) setReapWarning(at time.Time, reason string) bool {
+	t.attentionMu.Lock()
+	defer t.attentionMu.Unlock()
+	if t.reapAt.Equal(at) && t.reapReason == reason {
+		return false
+	}
+	t.reapAt, t.reapReason = at, reason
+	return true
+}
+
+// updateAlerts applies update to the terminal's alerts and reports whether they changed
+func (t *Terminal) updateAlerts(update func(*terminalAlerts)) bool {
+	t.attentionMu.Lock()
+	defer t.attentionMu.Unlock()
+	before := t.alerts
+	update(&t.alerts)
+	return t.alerts != before
 }
 
 type TerminalManager struct {
 	terminals    map[int]*Terminal
 	portPool     *PortPool
-	db           *db.DB
+	db           db.Store
+	auditLogger  *audit.Logger
+	events       *EventBus
+	limits       ResourceLimits
+	cgroups      *cgroupManager // nil unless CPU or memory limits are enforced
+	recordAll    bool           // Record commands in every terminal, not only those that ask
+	redactor     *audit.Redactor
+	done         chan struct{}
+	shutdownOnce sync.Once
 	mu           sync.RWMutex
 	nextID       int
 	maxTerminals int
-	activeTabID  int // Track the currently active tab
+	pending      map[string]int // Terminals still starting, by owner, which count towards the limits
+	activeTabs   map[string]int // The tab each user has open, by user
 }
 
-func NewTerminalManager(db *db.DB) *TerminalManager {
-	return &TerminalManager{
+func NewTerminalManager(db db.Store, auditLogger *audit.Logger, limits ResourceLimits) *TerminalManager {
+	tm := &TerminalManager{
 		terminals:    make(map[int]*Terminal),
+		pending:      make(map[string]int),
+		activeTabs:   make(map[string]int),
 		portPool:     NewPortPool(0, 0), // Use ephemeral ports
 		db:           db,
+		auditLogger:  auditLogger,
+		events:       NewEventBus(),
+		limits:       limits,
+		done:         make(chan struct{}),
 		nextID:       1,
 		maxTerminals: 10, // Maximum 10 concurrent terminals
-		activeTabID:  0,  // No active tab initially
 	}
+
+	cgroups, err := newCgroupManager(limits.CPULimit, limits.MemoryLimit)
+	if err != nil {
+		terminalLogger.Warn("CPU and memory limits will not be enforced", "err", err)
+	}
+	tm.cgroups = cgroups
+
+	go tm.runReaper()
+
+	return tm
+}
+
+// SetCommandAuditing configures how terminal command lines are recorded.
+// With all set, commands are recorded in every terminal; otherwise only in
+// terminals started with RecordCommands. Secrets matching redactor are
+// removed first.
+func (tm *TerminalManager) SetCommandAuditing(all bool, redactor *audit.Redactor) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.recordAll = all
+	tm.redactor = redactor
+}
+
+// Events returns the bus terminal lifecycle events are published on
+func (tm *TerminalManager) Events() *EventBus {
+	return tm.events
 }
 
 // generateCredential creates a random credential for GoTTY authentication
@@ -58,19 +184,61 @@ func generateCredential() (string, error) {
 	return fmt.Sprintf("%s:%s", username, password), nil
 }
 
-func (tm *TerminalManager) SpawnTerminal(title, shell, workingDir string) (*Terminal, error) {
-	// First check if we've reached the maximum without holding the lock for long operations
+// SpawnTerminal starts a terminal for owner running spec; an empty spec runs the default shell
+func (tm *TerminalManager) SpawnTerminal(ctx context.Context, owner, title string, spec CommandSpec) (*Terminal, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "TerminalManager.SpawnTerminal", trace.WithAttributes(
+		attribute.String("terminal.owner", owner),
+	))
+	defer span.End()
+
+	start := time.Now()
+	terminal, err := tm.spawnTerminal(ctx, owner, title, spec)
+	if err != nil {
+		tracing.RecordError(span, err)
+		metrics.TerminalSpawns.WithLabelValues("failure").Inc()
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("terminal.id", terminal.ID), attribute.Int("terminal.port", terminal.Port))
+	metrics.TerminalSpawns.WithLabelValues("success").Inc()
+	metrics.TerminalSpawnDuration.Observe(time.Since(start).Seconds())
+	return terminal, nil
+}
+
+func (tm *TerminalManager) spawnTerminal(ctx context.Context, owner, title string, spec CommandSpec) (*Terminal, error) {
+	spec = spec.withDefaults()
+
+	// Check the limits and reserve a spot without holding the lock for long
+	// operations. Terminals still starting count, so that concurrent spawns
+	// cannot all pass the check.
 	tm.mu.Lock()
-	if len(tm.terminals) >= tm.maxTerminals {
+	if len(tm.terminals)+tm.pendingLocked() >= tm.maxTerminals {
 		tm.mu.Unlock()
 		return nil, fmt.Errorf("maximum number of terminals (%d) reached", tm.maxTerminals)
 	}
-	// Reserve a spot by incrementing count
+	if limit := tm.limits.MaxTerminalsPerUser; limit > 0 && tm.countOwnedLocked(owner)+tm.pending[owner] >= limit {
+		tm.mu.Unlock()
+		return nil, fmt.Errorf("terminal quota of %d per user reached", limit)
+	}
+	tm.pending[owner]++
 	terminalID := tm.nextID
 	tm.nextID++
+	recording := spec.RecordCommands || tm.recordAll
 	tm.mu.Unlock()
 
+	// Give the spot back unless the terminal is added
+	added := false
+	defer func() {
+		if !added {
+			tm.mu.Lock()
+			tm.releasePendingLocked(owner)
+			tm.mu.Unlock()
+		}
+	}()
+
+	_, allocSpan := tracing.Tracer().Start(ctx, "PortPool.Allocate")
 	port, err := tm.portPool.Allocate()
+	tracing.RecordError(allocSpan, err)
+	allocSpan.End()
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate port: %w", err)
 	}
@@ -82,79 +250,160 @@ func (tm *TerminalManager) SpawnTerminal(title, shell, workingDir string) (*Term
 		return nil, fmt.Errorf("failed to generate credential: %w", err)
 	}
 
-	// Create GoTTY server using library
-	ctx := context.Background()
-	gottyServer, err := NewGoTTYServer(ctx, port, credential, title, shell, workingDir)
+	if tm.cgroups != nil {
+		dir, err := tm.cgroups.userDir(owner)
+		if err != nil {
+			tm.portPool.Release(port)
+			return nil, err
+		}
+		spec.cgroupDir = dir
+	}
+
+	terminal := &Terminal{
+		ID:         terminalID,
+		Port:       port,
+		Title:      title,
+		Owner:      owner,
+		Command:    spec.Argv,
+		Env:        spec.Env,
+		WorkingDir: spec.WorkingDir,
+		Restart:    spec.Restart,
+		Recording:  recording,
+		Credential: credential,
+		CreatedAt:  time.Now(),
+	}
+
+	// Start the command under supervision; it outlives browser connections
+	monitor := &outputMonitor{}
+	terminal.silence = newSilenceDetector(silenceThreshold, minActivityBurst, func(burst time.Duration) {
+		tm.handleSilence(terminal, burst)
+	})
+	if recording {
+		terminal.commands = newCommandRecorder(func(cmd capturedCommand) {
+			tm.logCommand(terminal, cmd)
+		})
+	}
+	bytesIn := metrics.TerminalBytes.WithLabelValues(strconv.Itoa(terminalID), "in")
+	bytesOut := metrics.TerminalBytes.WithLabelValues(strconv.Itoa(terminalID), "out")
+	hooks := supervisorHooks{
+		OnExit: func(info ExitInfo) {
+			tm.handleExit(terminal, info)
+		},
+		OnOutput: func(data []byte) {
+			bytesOut.Add(float64(len(data)))
+			tm.handleOutput(terminal, monitor, data)
+		},
+		OnInput: func(data []byte, hidden bool) {
+			bytesIn.Add(float64(len(data)))
+			if terminal.commands != nil {
+				terminal.commands.input(data, hidden)
+			}
+		},
+	}
+	// Only the program is traced: its arguments may carry secrets, which the
+	// audit log redacts but trace exporters would not
+	_, startSpan := tracing.Tracer().Start(ctx, "startSupervisor", trace.WithAttributes(
+		attribute.String("process.command", spec.Argv[0]),
+	))
+	process, err := startSupervisor(spec, hooks)
+	tracing.RecordError(startSpan, err)
+	startSpan.End()
 	if err != nil {
+		terminal.silence.stop()
 		tm.portPool.Release(port)
-		return nil, fmt.Errorf("failed to start gotty server: %w", err)
+		deleteTerminalMetrics(terminalID)
+		return nil, err
+	}
+	terminal.process = process
+	if recording {
+		process.notice("[commands run in this terminal are recorded in the audit log]")
 	}
 
-	terminal := &Terminal{
-		ID:          terminalID,
-		Port:        port,
-		Title:       title,
-		Shell:       shell,
-		WorkingDir:  workingDir,
-		Credential:  credential,
-		GoTTYServer: gottyServer,
-		CreatedAt:   time.Now(),
-	}
-
-	// Save to database (PID is 0 since we're using library, not external process)
-	dbID, err := tm.db.SaveActiveTerminal(ctx, terminal.Port, terminal.Title, 0)
+	// Create GoTTY server using library. The terminal outlives the request
+	// that started it, so only the trace is carried over from here on.
+	ctx = context.WithoutCancel(ctx)
+	gottyServer, err := NewGoTTYServer(ctx, port, credential, title, process)
+	if err != nil {
+		process.Stop()
+		terminal.silence.stop()
+		tm.portPool.Release(port)
+		deleteTerminalMetrics(terminalID)
+		return nil, fmt.Errorf("failed to start gotty server: %w", err)
+	}
+	terminal.GoTTYServer = gottyServer
+
+	// Save to database
+	dbID, err := tm.db.SaveActiveTerminal(ctx, &db.ActiveTerminal{
+		Port:           terminal.Port,
+		Title:          terminal.Title,
+		Owner:          terminal.Owner,
+		PID:            process.Stats().PID,
+		Shell:          formatCommandLine(terminal.Command),
+		WorkingDir:     terminal.WorkingDir,
+		Env:            terminal.Env,
+		RestartPolicy:  string(terminal.Restart),
+		RecordCommands: terminal.Recording,
+	})
 	if err != nil {
-		log.Printf("Warning: failed to save terminal to db: %v", err)
-	} else {
-		terminal.DBID = dbID
+		terminalLogger.Warn("failed to save terminal to db", "terminal", terminal.ID, "err", err)
 	}
 
 	// Now hold the lock to add terminal and update active tab atomically
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-	
+	terminal.DBID = dbID
+	tm.releasePendingLocked(owner)
+	added = true
 	tm.terminals[terminal.ID] = terminal
-	// Set as active tab if it's the first terminal or no active tab
-	if tm.activeTabID == 0 || len(tm.terminals) == 1 {
-		tm.activeTabID = terminal.ID
+	// Open it for its owner if they have no tab open
+	if _, ok := tm.activeTabs[owner]; !ok && owner != systemOwner {
+		tm.activeTabs[owner] = terminal.ID
 	}
+	metrics.TerminalsActive.Set(float64(len(tm.terminals)))
+	tm.mu.Unlock()
+
+	tm.events.Publish(Event{Type: EventSpawned, TerminalID: terminal.ID, Owner: terminal.Owner, Title: title})
 
 	return terminal, nil
 }
 
-func (tm *TerminalManager) KillTerminal(id int) error {
+func (tm *TerminalManager) KillTerminal(ctx context.Context, id int) error {
+	return tm.killTerminal(ctx, id, "user")
+}
+
+// killTerminal closes a terminal, counting it under reason in the metrics
+func (tm *TerminalManager) killTerminal(ctx context.Context, id int, reason string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "TerminalManager.KillTerminal", trace.WithAttributes(
+		attribute.Int("terminal.id", id),
+		attribute.String("terminal.kill_reason", reason),
+	))
+	defer span.End()
+
 	tm.mu.Lock()
 	terminal, exists := tm.terminals[id]
 	if !exists {
 		tm.mu.Unlock()
-		return errors.New("terminal not found")
+		err := errors.New("terminal not found")
+		tracing.RecordError(span, err)
+		return err
 	}
 	delete(tm.terminals, id)
-	
-	// If we're closing the active tab, switch to another tab deterministically
-	if tm.activeTabID == id {
-		// Find the terminal with the next highest ID, or the lowest if none exists
-		nextID := 0
-		lowestID := 0
-		for tid := range tm.terminals {
-			if tid > id && (nextID == 0 || tid < nextID) {
-				nextID = tid
-			}
-			if lowestID == 0 || tid < lowestID {
-				lowestID = tid
-			}
-		}
-		if nextID != 0 {
-			tm.activeTabID = nextID
-		} else {
-			tm.activeTabID = lowestID
+	// Users who had it open fall back to their first terminal
+	for user, tab := range tm.activeTabs {
+		if tab == id {
+			delete(tm.activeTabs, user)
 		}
 	}
+	metrics.TerminalsActive.Set(float64(len(tm.terminals)))
 	tm.mu.Unlock()
 
-	// Stop GoTTY server gracefully
+	// Stop GoTTY server gracefully, then the command itself
 	if err := terminal.GoTTYServer.Stop(); err != nil {
-		log.Printf("Warning: error stopping GoTTY server: %v", err)
+		terminalLogger.Warn("error stopping GoTTY server", "terminal", id, "err", err)
+	}
+	terminal.process.Stop()
+	terminal.silence.stop()
+	if terminal.commands != nil {
+		terminal.commands.flush()
 	}
 
 	// Release port
@@ -162,11 +411,229 @@ func (tm *TerminalManager) KillTerminal(id int) error {
 
 	// Remove from database using the correct database ID
 	if terminal.DBID > 0 {
-		if err := tm.db.DeleteActiveTerminal(context.Background(), terminal.DBID); err != nil {
-			log.Printf("Warning: failed to delete terminal from db: %v", err)
+		if err := tm.db.DeleteActiveTerminal(ctx, terminal.DBID); err != nil {
+			terminalLogger.Warn("failed to delete terminal from db", "terminal", id, "err", err)
+		}
+	}
+
+	metrics.TerminalKills.WithLabelValues(reason).Inc()
+	deleteTerminalMetrics(id)
+
+	tm.events.Publish(Event{Type: EventKilled, TerminalID: id, Owner: terminal.Owner, Title: terminal.Title})
+
+	return nil
+}
+
+// deleteTerminalMetrics drops the per-terminal series of a closed terminal
+func deleteTerminalMetrics(id int) {
+	metrics.TerminalBytes.DeleteLabelValues(strconv.Itoa(id), "in")
+	metrics.TerminalBytes.DeleteLabelValues(strconv.Itoa(id), "out")
+}
+
+// RenameTerminal changes a terminal's title and returns the previous one
+func (tm *TerminalManager) RenameTerminal(ctx context.Context, id int, title string) (string, error) {
+	tm.mu.Lock()
+	terminal, ok := tm.terminals[id]
+	if !ok {
+		tm.mu.Unlock()
+		return "", errors.New("terminal not found")
+	}
+	oldTitle := terminal.Title
+	terminal.Title = title
+	dbID := terminal.DBID
+	tm.mu.Unlock()
+
+	// Persist title change to database
+	if dbID > 0 {
+		if err := tm.db.UpdateActiveTerminalTitle(ctx, dbID, title); err != nil {
+			terminalLogger.Warn("failed to update terminal title in db", "terminal", id, "err", err)
+		}
+	}
+
+	tm.events.Publish(Event{Type: EventRenamed, TerminalID: id, Owner: terminal.Owner, Title: title, Data: map[string]interface{}{
+		"old_title": oldTitle,
+	}})
+
+	return oldTitle, nil
+}
+
+// countOwnedLocked counts the terminals owned by owner; tm.mu must be held
+func (tm *TerminalManager) countOwnedLocked(owner string) int {
+	count := 0
+	for _, t := range tm.terminals {
+		if t.Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+// pendingLocked counts the terminals still starting; tm.mu must be held
+func (tm *TerminalManager) pendingLocked() int {
+	count := 0
+	for _, n := range tm.pending {
+		count += n
+	}
+	return count
+}
+
+// releasePendingLocked gives back a spot reserved for owner; tm.mu must be held
+func (tm *TerminalManager) releasePendingLocked(owner string) {
+	if tm.pending[owner]--; tm.pending[owner] <= 0 {
+		delete(tm.pending, owner)
+	}
+}
+
+// RestartTerminal restarts a terminal's command immediately, regardless of its restart policy
+func (tm *TerminalManager) RestartTerminal(id int) error {
+	terminal, ok := tm.GetTerminal(id)
+	if !ok {
+		return errors.New("terminal not found")
+	}
+	terminal.process.Restart()
+	return nil
+}
+
+// handleExit records an exit of a terminal's command in the database and audit log
+func (tm *TerminalManager) handleExit(terminal *Terminal, info ExitInfo) {
+	stats := terminal.Stats()
+	if terminal.commands != nil {
+		terminal.commands.flush()
+	}
+
+	tm.mu.RLock()
+	dbID := terminal.DBID
+	title := terminal.Title
+	tm.mu.RUnlock()
+
+	if dbID > 0 {
+		err := tm.db.RecordTerminalExit(context.Background(), db.TerminalExit{
+			TerminalID: dbID,
+			Command:    formatCommandLine(terminal.Command),
+			ExitCode:   info.ExitCode,
+			Signal:     info.Signal,
+			Crashed:    info.Crashed,
+			Restarting: info.Restarting,
+			Runtime:    info.Runtime,
+			Crashes:    stats.Crashes,
+			Restarts:   stats.Restarts,
+		})
+		if err != nil {
+			terminalLogger.Warn("failed to record terminal exit", "terminal", terminal.ID, "err", err)
+		}
+	}
+
+	tm.auditLogger.LogTerminalExit(terminal.ID, info.ExitCode, info.Signal, stats.Crashes, info.Err)
+	if info.Restarting {
+		tm.auditLogger.LogTerminalRestart("system", terminal.ID, string(terminal.Restart), info.Delay, audit.OutcomeSuccess, nil)
+	}
+
+	data := map[string]interface{}{
+		"exit_code":  info.ExitCode,
+		"crashed":    info.Crashed,
+		"restarting": info.Restarting,
+	}
+	if info.Signal != "" {
+		data["signal"] = info.Signal
+	}
+	tm.events.Publish(Event{Type: EventExited, TerminalID: terminal.ID, Owner: terminal.Owner, Title: title, Data: data})
+
+	if terminal.Notify() {
+		tm.notify(terminal, title, strings.Trim(exitMessage(info), "[]"))
+	}
+}
+
+// handleOutput publishes events for bells, notifications and activity in a
+// chunk of terminal output, and passes shell integration marks on to the
+// command recorder
+func (tm *TerminalManager) handleOutput(terminal *Terminal, monitor *outputMonitor, data []byte) {
+	now := time.Now()
+	sig := monitor.scan(data, now)
+	terminal.silence.output(now)
+
+	if terminal.commands != nil && len(sig.Shell) > 0 {
+		terminal.commands.output(sig.Shell, now)
+	}
+
+	if sig.Bell {
+		tm.events.Publish(Event{Type: EventBell, TerminalID: terminal.ID, Owner: terminal.Owner})
+		tm.raiseAlert(terminal, func(a *terminalAlerts) { a.Bell = true })
+		if terminal.Notify() {
+			tm.notify(terminal, tm.terminalTitle(terminal), "Bell")
 		}
 	}
 
+	for _, n := range sig.Notifications {
+		tm.events.Publish(Event{Type: EventNotification, TerminalID: terminal.ID, Owner: terminal.Owner, Data: map[string]interface{}{
+			"title": n.Title,
+			"body":  n.Body,
+		}})
+		message := n.Body
+		if message == "" {
+			message = n.Title
+		}
+		tm.raiseAlert(terminal, func(a *terminalAlerts) { a.Message = message })
+
+		// Programs ask for these explicitly, so they don't depend on the toggle
+		title := n.Title
+		if title == "" {
+			title = tm.terminalTitle(terminal)
+		}
+		tm.notify(terminal, title, n.Body)
+	}
+
+	if sig.Activity {
+		tm.events.Publish(Event{Type: EventActivity, TerminalID: terminal.ID, Owner: terminal.Owner})
+		tm.raiseAlert(terminal, func(a *terminalAlerts) { a.Activity = true })
+	}
+}
+
+// handleSilence is called when a terminal goes quiet after a burst of output
+func (tm *TerminalManager) handleSilence(terminal *Terminal, burst time.Duration) {
+	tm.events.Publish(Event{Type: EventSilence, TerminalID: terminal.ID, Owner: terminal.Owner, Data: map[string]interface{}{
+		"burst_seconds": int(burst.Seconds()),
+	}})
+	tm.raiseAlert(terminal, func(a *terminalAlerts) { a.Done = true })
+
+	if terminal.Notify() {
+		tm.notify(terminal, tm.terminalTitle(terminal), fmt.Sprintf("Output stopped after %s", burst.Round(time.Second)))
+	}
+}
+
+// raiseAlert records an alert for a terminal, unless it is open in the tab
+// of a user the alert is for
+func (tm *TerminalManager) raiseAlert(terminal *Terminal, update func(*terminalAlerts)) {
+	if tm.watched(terminal) {
+		return
+	}
+	if terminal.updateAlerts(update) {
+		tm.events.Publish(Event{Type: EventAlert, TerminalID: terminal.ID, Owner: terminal.Owner})
+	}
+}
+
+// notify asks connected browsers to show a desktop notification
+func (tm *TerminalManager) notify(terminal *Terminal, title, body string) {
+	tm.events.Publish(Event{Type: EventNotify, TerminalID: terminal.ID, Owner: terminal.Owner, Title: tm.terminalTitle(terminal), Data: map[string]interface{}{
+		"title": title,
+		"body":  body,
+	}})
+}
+
+func (tm *TerminalManager) terminalTitle(terminal *Terminal) string {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return terminal.Title
+}
+
+// SetNotify enables or disables desktop notifications for a terminal
+func (tm *TerminalManager) SetNotify(id int, enabled bool) error {
+	terminal, ok := tm.GetTerminal(id)
+	if !ok {
+		return errors.New("terminal not found")
+	}
+	terminal.attentionMu.Lock()
+	terminal.notify = enabled
+	terminal.attentionMu.Unlock()
 	return nil
 }
 
@@ -178,6 +645,10 @@ func (tm *TerminalManager) GetTerminals() []*Terminal {
 	for _, t := range tm.terminals {
 		terminals = append(terminals, t)
 	}
+	// Keep tabs in creation order
+	sort.Slice(terminals, func(i, j int) bool {
+		return terminals[i].ID < terminals[j].ID
+	})
 	return terminals
 }
 
@@ -188,16 +659,42 @@ func (tm *TerminalManager) GetTerminal(id int) (*Terminal, bool) {
 	return t, ok
 }
 
-func (tm *TerminalManager) GetActiveTabID() int {
+// GetActiveTabID returns the terminal user has open, or 0 if none is
+func (tm *TerminalManager) GetActiveTabID(user string) int {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
-	return tm.activeTabID
+	return tm.activeTabs[user]
 }
 
-func (tm *TerminalManager) SetActiveTabID(id int) {
+// SetActiveTabID switches user's active tab. Viewing a terminal clears its
+// alerts if they are for the user.
+func (tm *TerminalManager) SetActiveTabID(user string, id int) {
 	tm.mu.Lock()
-	defer tm.mu.Unlock()
-	tm.activeTabID = id
+	tm.activeTabs[user] = id
+	terminal, ok := tm.terminals[id]
+	tm.mu.Unlock()
+
+	if ok && alertsFor(terminal, user) && terminal.updateAlerts(func(a *terminalAlerts) { *a = terminalAlerts{} }) {
+		tm.events.Publish(Event{Type: EventAlert, TerminalID: id, Owner: terminal.Owner})
+	}
+}
+
+// alertsFor reports whether a terminal's alerts are for user: those of
+// shared terminals are for everyone, the others only for their owner
+func alertsFor(terminal *Terminal, user string) bool {
+	return terminal.Owner == user || terminal.Owner == systemOwner
+}
+
+// watched reports whether a user the terminal's alerts are for has it open
+func (tm *TerminalManager) watched(terminal *Terminal) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	for user, id := range tm.activeTabs {
+		if id == terminal.ID && alertsFor(terminal, user) {
+			return true
+		}
+	}
+	return false
 }
 
 func (tm *TerminalManager) GetNextID() int {
@@ -207,6 +704,10 @@ func (tm *TerminalManager) GetNextID() int {
 }
 
 func (tm *TerminalManager) Shutdown() error {
+	tm.shutdownOnce.Do(func() {
+		close(tm.done)
+	})
+
 	tm.mu.Lock()
 	terminals := make([]*Terminal, 0, len(tm.terminals))
 	for _, t := range tm.terminals {
@@ -216,26 +717,23 @@ func (tm *TerminalManager) Shutdown() error {
 
 	// Kill all terminals
 	for _, terminal := range terminals {
-		if err := tm.KillTerminal(terminal.ID); err != nil {
-			log.Printf("Error killing terminal %d: %v", terminal.ID, err)
+		if err := tm.killTerminal(context.Background(), terminal.ID, "shutdown"); err != nil {
+			terminalLogger.Error("failed to kill terminal", "terminal", terminal.ID, "err", err)
 		}
 	}
 
 	return nil
 }
 
-func (tm *TerminalManager) ApplyLayout(layoutType string) error {
+// ApplyLayout spawns or kills terminals to match layoutType; new terminals belong to owner
+func (tm *TerminalManager) ApplyLayout(ctx context.Context, owner, layoutType string) error {
 	targetCount := tm.getTerminalCountForLayout(layoutType)
 	currentCount := len(tm.terminals)
 
 	if targetCount > currentCount {
 		// Spawn additional terminals
 		for i := currentCount; i < targetCount; i++ {
-			_, err := tm.SpawnTerminal(
-				fmt.Sprintf("Terminal %d", i+1),
-				"/bin/bash",
-				"",
-			)
+			_, err := tm.SpawnTerminal(ctx, owner, fmt.Sprintf("Terminal %d", i+1), CommandSpec{})
 			if err != nil {
 				return fmt.Errorf("failed to spawn terminal: %w", err)
 			}
@@ -244,14 +742,14 @@ func (tm *TerminalManager) ApplyLayout(layoutType string) error {
 		// Kill excess terminals
 		terminals := tm.GetTerminals()
 		for i := targetCount; i < len(terminals); i++ {
-			if err := tm.KillTerminal(terminals[i].ID); err != nil {
-				log.Printf("Error killing excess terminal: %v", err)
+			if err := tm.KillTerminal(ctx, terminals[i].ID); err != nil {
+				terminalLogger.Error("failed to kill excess terminal", "terminal", terminals[i].ID, "err", err)
 			}
 		}
 	}
 
 	// Update layout in DB
-	if err := tm.db.UpdateActiveLayout(context.Background(), layoutType, targetCount); err != nil {
+	if err := tm.db.UpdateActiveLayout(ctx, layoutType, targetCount); err != nil {
 		return fmt.Errorf("failed to update layout in db: %w", err)
 	}
 
@@ -270,3 +768,13 @@ func (tm *TerminalManager) getTerminalCountForLayout(layoutType string) int {
 		return 2
 	}
 }
+
+// logCommand records a command line run in a terminal, with secrets redacted
+func (tm *TerminalManager) logCommand(terminal *Terminal, cmd capturedCommand) {
+	tm.mu.RLock()
+	redactor := tm.redactor
+	tm.mu.RUnlock()
+
+	tm.auditLogger.LogTerminalCommand(terminal.Owner, terminal.ID, redactor.Redact(cmd.Command),
+		cmd.Cwd, cmd.ExitCode, cmd.Duration, cmd.Capture, cmd.Inexact)
+}
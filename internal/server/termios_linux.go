@@ -0,0 +1,29 @@
+//go:build linux
+
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// inputHidden reports whether the terminal is reading lines without echoing
+// them, which is how programs ask for passwords. Line editors such as
+// readline also turn echo off, but they read keys one at a time
+// (non-canonical mode) and draw the line themselves.
+func inputHidden(f *os.File) bool {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var termios *unix.Termios
+	conn.Control(func(fd uintptr) {
+		termios, err = unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	})
+	if err != nil || termios == nil {
+		return false
+	}
+	return termios.Lflag&unix.ECHO == 0 && termios.Lflag&unix.ICANON != 0
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import "os"
+
+// inputHidden cannot inspect the terminal mode on this platform. Input
+// reconstruction then cannot tell passwords apart, so callers should rely on
+// shell integration instead.
+func inputHidden(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,546 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/middleware"
+	"github.com/corymacd/StratusShell/internal/ui"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer = "StratusShell"
+	totpPeriod = 30 // Seconds each code is valid for
+	totpSkew   = 1  // Steps either side of now also accepted, for clock drift
+
+	// recoveryCodeCount is how many recovery codes users are given at a time
+	recoveryCodeCount = 10
+
+	// enrollmentTokenTTL is how long users have to redeem an enrollment token
+	enrollmentTokenTTL = 24 * time.Hour
+
+	// errEnrollmentTokenFirst tells sessions that have not proved who their
+	// user is how to go on
+	errEnrollmentTokenFirst = "Enter the enrollment token an admin gave you on the two-factor page first."
+)
+
+// Ways of passing the second factor, as recorded in the audit log
+const (
+	secondFactorTOTP     = "totp"
+	secondFactorRecovery = "recovery_code"
+	secondFactorToken    = "enrollment_token"
+)
+
+var totpOptions = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var (
+	// ErrInvalidCode is returned for wrong, reused or spent codes
+	ErrInvalidCode = errors.New("invalid two-factor code")
+	// ErrNoEnrollment is returned when confirming without having enrolled
+	ErrNoEnrollment = errors.New("no two-factor enrollment to confirm")
+	// ErrInvalidEnrollmentToken is returned for wrong, spent or expired
+	// enrollment tokens
+	ErrInvalidEnrollmentToken = errors.New("invalid enrollment token")
+	// ErrNotVerified is returned when a session that only named its user
+	// tries to change their second factors
+	ErrNotVerified = errors.New("session has not proved who its user is")
+)
+
+// totpStep returns the time step a TOTP code from secret was generated for,
+// checking totpSkew steps either side of now
+func totpStep(secret, code string, now time.Time) (int64, bool) {
+	step := now.Unix() / totpPeriod
+	for i := step - totpSkew; i <= step+totpSkew; i++ {
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(i*totpPeriod, 0), totpOptions)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// isTOTPCode reports whether code looks like a TOTP code rather than a
+// recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != otp.DigitsSix.Length() {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecoveryCodes returns recoveryCodeCount random recovery codes, such as
+// "3f9a2-c41d0", and the hashes to store for them
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code or enrollment token for storage,
+// ignoring case, spaces and dashes. Codes are random enough that a fast hash
+// will do.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySecondFactor checks a code from user's authenticator or, failing
+// that, one of their recovery codes, which is then spent. It returns which
+// of the two the code was.
+func (s *Server) verifySecondFactor(ctx context.Context, secret *db.TOTPSecret, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		step, ok := totpStep(secret.Secret, code, time.Now())
+		if !ok {
+			return secondFactorTOTP, ErrInvalidCode
+		}
+		// Each code only works once
+		fresh, err := s.db.UseTOTPStep(ctx, secret.Username, step)
+		if err != nil {
+			return secondFactorTOTP, err
+		}
+		if !fresh {
+			return secondFactorTOTP, ErrInvalidCode
+		}
+		return secondFactorTOTP, nil
+	}
+
+	ok, err := s.db.UseRecoveryCode(ctx, secret.Username, hashRecoveryCode(code), time.Now())
+	if err != nil {
+		return secondFactorRecovery, err
+	}
+	if !ok {
+		return secondFactorRecovery, ErrInvalidCode
+	}
+	return secondFactorRecovery, nil
+}
+
+// checkSecondFactor asks users who have enrolled for a code at login, and
+// sends those whose only second factor is a passkey to sign in with it. The
+// code must be posted, so that it stays out of URLs and access logs. It
+// responds itself and returns false unless a valid code was given or none
+// is needed. enrolled reports whether the user has a second factor.
+func (s *Server) checkSecondFactor(w http.ResponseWriter, r *http.Request, user, ip string) (ok, enrolled bool) {
+	secret, err := s.db.GetTOTP(r.Context(), user)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to check two-factor authentication")
+		return false, false
+	}
+	passkeys, err := s.hasPasskeys(r, user)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to check two-factor authentication")
+		return false, false
+	}
+	if !secret.Enabled() {
+		if !passkeys {
+			return true, false
+		}
+		http.Redirect(w, r, middleware.URL(r.Context(), "/login/passkey")+"?user="+url.QueryEscape(user), http.StatusSeeOther)
+		return false, true
+	}
+
+	code := r.PostFormValue("code")
+	if code == "" {
+		ui.TwoFactorLoginPage(user, "", passkeys).Render(r.Context(), w)
+		return false, true
+	}
+
+	method, err := s.verifySecondFactor(r.Context(), secret, code)
+	s.auditFor(r).LogAuth2FAVerify(user, method, audit.OutcomeFromError(err), err)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("2fa").Inc()
+		s.recordLoginFailure(r, user, ip)
+		w.WriteHeader(http.StatusUnauthorized)
+		ui.TwoFactorLoginPage(user, "Invalid code. Please try again.", passkeys).Render(r.Context(), w)
+		return false, true
+	}
+	return true, true
+}
+
+// secondFactorEnrolled reports whether user has a second factor to sign in
+// with: an authenticator app or a passkey
+func (s *Server) secondFactorEnrolled(r *http.Request, user string) (bool, error) {
+	secret, err := s.db.GetTOTP(r.Context(), user)
+	if err != nil || secret.Enabled() {
+		return secret.Enabled(), err
+	}
+	return s.hasPasskeys(r, user)
+}
+
+// twoFactorStatus returns user's two-factor status for the UI
+func (s *Server) twoFactorStatus(r *http.Request, user string) (ui.TwoFactorData, error) {
+	var status ui.TwoFactorData
+	if session := s.requestSession(r); session != nil {
+		status.MustEnroll = session.MustEnroll
+		status.Verified = session.Verified
+	}
+	secret, err := s.db.GetTOTP(r.Context(), user)
+	if err != nil || !secret.Enabled() {
+		return status, err
+	}
+	status.Enabled = true
+	status.RecoveryCodes, err = s.db.CountRecoveryCodes(r.Context(), user)
+	return status, err
+}
+
+// handleTwoFactorPage shows the user's two-factor status and settings
+func (s *Server) handleTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	user := s.getActor(r)
+	status, err := s.twoFactorStatus(r, user)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to get two-factor status")
+		return
+	}
+	token, err := s.csrfProtection.GetToken(w, r)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to create CSRF token")
+		return
+	}
+	ui.TwoFactorPage(user, status, token).Render(r.Context(), w)
+}
+
+// twoFactorError reports a failed two-factor step to the page
+func twoFactorError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.WriteHeader(status)
+	ui.TwoFactorError(message).Render(r.Context(), w)
+}
+
+// handleTOTPEnroll creates a TOTP secret for the user and shows it as a QR
+// code. Users who already have one must give a current code to replace it.
+// The new secret is only used once confirmed.
+func (s *Server) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	if !s.sessionVerified(r) {
+		s.auditFor(r).LogAuth2FAEnroll(actor, audit.OutcomeFailure, ErrNotVerified)
+		twoFactorError(w, r, http.StatusForbidden, errEnrollmentTokenFirst)
+		return
+	}
+
+	current, err := s.db.GetTOTP(r.Context(), actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to get two-factor status")
+		return
+	}
+	if current.Enabled() {
+		if _, err := s.verifySecondFactor(r.Context(), current, r.FormValue("code")); err != nil {
+			s.auditFor(r).LogAuth2FAEnroll(actor, audit.OutcomeFailure, err)
+			twoFactorError(w, r, http.StatusForbidden, "Enter a current code to move to a new authenticator.")
+			return
+		}
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: actor,
+		Period:      totpPeriod,
+		Digits:      totpOptions.Digits,
+		Algorithm:   totpOptions.Algorithm,
+	})
+	if err == nil {
+		err = s.db.SetPendingTOTP(r.Context(), actor, key.Secret())
+	}
+	var qrCode string
+	if err == nil {
+		qrCode, err = qrCodeDataURL(key)
+	}
+	s.auditFor(r).LogAuth2FAEnroll(actor, audit.OutcomeFromError(err), err)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to start two-factor enrollment")
+		return
+	}
+
+	ui.TOTPEnroll(ui.TOTPEnrollData{QRCode: qrCode, Secret: key.Secret()}).Render(r.Context(), w)
+}
+
+// qrCodeDataURL renders the key's otpauth:// URL as a PNG data: URL
+func qrCodeDataURL(key *otp.Key) (string, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// handleTOTPConfirm turns on the enrolled secret once the user gives a code
+// from it, and shows their new recovery codes
+func (s *Server) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+
+	secret, err := s.db.GetTOTP(r.Context(), actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to get two-factor status")
+		return
+	}
+	if secret == nil || secret.PendingSecret == "" {
+		s.auditFor(r).LogAuth2FAEnable(actor, audit.OutcomeFailure, ErrNoEnrollment)
+		twoFactorError(w, r, http.StatusBadRequest, "Set up an authenticator first.")
+		return
+	}
+	step, ok := totpStep(secret.PendingSecret, strings.TrimSpace(r.FormValue("code")), time.Now())
+	if !ok {
+		s.auditFor(r).LogAuth2FAEnable(actor, audit.OutcomeFailure, ErrInvalidCode)
+		twoFactorError(w, r, http.StatusBadRequest, "Invalid code. Check your authenticator's clock and try again.")
+		return
+	}
+
+	err = s.db.ConfirmTOTP(r.Context(), actor, time.Now())
+	if err == nil {
+		// The code just given may not be used again to sign in
+		_, err = s.db.UseTOTPStep(r.Context(), actor, step)
+	}
+	s.auditFor(r).LogAuth2FAEnable(actor, audit.OutcomeFromError(err), err)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to turn on two-factor authentication")
+		return
+	}
+	s.authManager.SetMustEnroll(actor, false)
+
+	codes, err := s.replaceRecoveryCodes(r, actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to create recovery codes")
+		return
+	}
+	ui.RecoveryCodes(codes).Render(r.Context(), w)
+}
+
+// handleRecoveryCodes replaces the user's recovery codes once they give a
+// current code
+func (s *Server) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+
+	secret, err := s.db.GetTOTP(r.Context(), actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to get two-factor status")
+		return
+	}
+	if !secret.Enabled() {
+		twoFactorError(w, r, http.StatusBadRequest, "Two-factor authentication is off.")
+		return
+	}
+	if _, err := s.verifySecondFactor(r.Context(), secret, r.FormValue("code")); err != nil {
+		s.auditFor(r).LogAuth2FARecoveryCodes(actor, 0, audit.OutcomeFailure, err)
+		twoFactorError(w, r, http.StatusForbidden, "Enter a current code to create new recovery codes.")
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(r, actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to create recovery codes")
+		return
+	}
+	ui.RecoveryCodes(codes).Render(r.Context(), w)
+}
+
+// replaceRecoveryCodes gives user a new set of recovery codes, recording it
+// in the audit log
+func (s *Server) replaceRecoveryCodes(r *http.Request, user string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = s.db.SetRecoveryCodes(r.Context(), user, hashes)
+	}
+	s.auditFor(r).LogAuth2FARecoveryCodes(user, len(codes), audit.OutcomeFromError(err), err)
+	return codes, err
+}
+
+// handleTwoFactorReset removes the second factors of the user form value,
+// their authenticator and passkeys, for users who have lost them or had a
+// key stolen. If the server requires two-factor authentication, they must
+// enroll again.
+func (s *Server) handleTwoFactorReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	user := r.FormValue("user")
+	if !usernamePattern.MatchString(user) {
+		http.Error(w, fmt.Sprintf("invalid username %q", user), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.db.ListWebAuthnCredentials(r.Context(), user)
+	for _, key := range keys {
+		err = s.db.DeleteWebAuthnCredential(r.Context(), user, key.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Revoked meanwhile
+			continue
+		}
+		s.auditFor(r).LogAuthWebAuthnRevoke(actor, user, key.Name, audit.OutcomeFromError(err), err)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.db.DeleteTOTP(r.Context(), user)
+	}
+	s.auditFor(r).LogAuth2FAReset(actor, user, audit.OutcomeFromError(err), err)
+	if err != nil {
+		logger.Error("failed to reset two-factor authentication", "user", user, "err", err)
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if s.config.Require2FA {
+		s.authManager.SetMustEnroll(user, true)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionVerified reports whether the request's session proved who its user
+// is. Only such sessions may change the user's second factors: otherwise
+// anyone could name a user who has none at /login, enroll an authenticator
+// of their own and take over the account. Other sessions must redeem an
+// enrollment token first.
+func (s *Server) sessionVerified(r *http.Request) bool {
+	session := s.requestSession(r)
+	return session != nil && session.Verified
+}
+
+// IssueEnrollmentToken gives user a one-time token, replacing any they had,
+// which lets a session that only named them set up their first second
+// factor. Only the token's hash is stored. It returns the token and when it
+// expires.
+func IssueEnrollmentToken(ctx context.Context, store db.TwoFactorStore, user, issuedBy string, now time.Time) (string, time.Time, error) {
+	if !usernamePattern.MatchString(user) {
+		return "", time.Time{}, fmt.Errorf("invalid username %q", user)
+	}
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	token = token[:5] + "-" + token[5:10] + "-" + token[10:15] + "-" + token[15:]
+	expires := now.Add(enrollmentTokenTTL)
+	if err := store.SetEnrollmentToken(ctx, user, hashRecoveryCode(token), issuedBy, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// EnrollmentTokenInfo is a new enrollment token in the API
+type EnrollmentTokenInfo struct {
+	User      string    `json:"user"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleEnrollmentTokenIssue gives the user form value an enrollment token,
+// for an admin to pass on to them out of band
+func (s *Server) handleEnrollmentTokenIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	user := r.FormValue("user")
+	if !usernamePattern.MatchString(user) {
+		http.Error(w, fmt.Sprintf("invalid username %q", user), http.StatusBadRequest)
+		return
+	}
+
+	token, expires, err := IssueEnrollmentToken(r.Context(), s.db, user, actor, time.Now())
+	s.auditFor(r).LogAuth2FAEnrollToken(actor, user, audit.OutcomeFromError(err), err)
+	if err != nil {
+		logger.Error("failed to issue enrollment token", "user", user, "err", err)
+		http.Error(w, "Failed to issue enrollment token", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, EnrollmentTokenInfo{User: user, Token: token, ExpiresAt: expires})
+}
+
+// handleEnrollmentTokenRedeem spends the enrollment token an admin gave the
+// user, marking their session as having proved who they are so that they
+// can set up a second factor. Wrong tokens count towards login lockout.
+func (s *Server) handleEnrollmentTokenRedeem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := s.requestSession(r)
+	if session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	actor := session.User
+	ip := s.trustedProxies.ClientIP(r)
+	if !s.checkLogin(w, r, actor, ip) {
+		return
+	}
+
+	ok, err := s.db.UseEnrollmentToken(r.Context(), actor, hashRecoveryCode(r.FormValue("token")), time.Now())
+	if err == nil && !ok {
+		err = ErrInvalidEnrollmentToken
+	}
+	var role Role
+	if err == nil {
+		role, err = s.sessionRole(r.Context(), actor, true)
+	}
+	s.auditFor(r).LogAuth2FAVerify(actor, secondFactorToken, audit.OutcomeFromError(err), err)
+	if errors.Is(err, ErrInvalidEnrollmentToken) {
+		metrics.AuthFailures.WithLabelValues("2fa").Inc()
+		s.recordLoginFailure(r, actor, ip)
+		twoFactorError(w, r, http.StatusUnauthorized, "Invalid or expired enrollment token.")
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, err, "Failed to check enrollment token")
+		return
+	}
+
+	s.authManager.VerifySession(session.Token, role)
+	ui.EnrollmentTokenAccepted().Render(r.Context(), w)
+}
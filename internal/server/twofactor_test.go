@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestTOTPStep(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	now := time.Unix(1700000000, 0)
+
+	for _, offset := range []time.Duration{-totpPeriod * time.Second, 0, totpPeriod * time.Second} {
+		code, err := totp.GenerateCodeCustom(secret, now.Add(offset), totpOptions)
+		if err != nil {
+			t.Fatalf("failed to generate code: %v", err)
+		}
+		step, ok := totpStep(secret, code, now)
+		if want := now.Add(offset).Unix() / totpPeriod; !ok || step != want {
+			t.Errorf("offset %v: expected step %d, got %d, %v", offset, want, step, ok)
+		}
+	}
+
+	code, _ := totp.GenerateCodeCustom(secret, now.Add(5*time.Minute), totpOptions)
+	if _, ok := totpStep(secret, code, now); ok {
+		t.Error("expected a code from the future to be refused")
+	}
+}
+
+func TestRecoveryCodeHash(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("failed to create recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+	if hashes[0] == codes[0] || strings.Contains(hashes[0], strings.ReplaceAll(codes[0], "-", "")) {
+		t.Errorf("expected the code to be hashed, got %q", hashes[0])
+	}
+	typed := " " + strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")) + " "
+	if hashRecoveryCode(typed) != hashes[0] {
+		t.Error("expected case, spaces and dashes to be ignored")
+	}
+}
+
+// asVerified calls handler with a session for the request's user that
+// proved who they are, as after signing in with a second factor
+func asVerified(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := r.Context().Value(userContextKey).(string)
+		session := &Session{User: user, Verified: true}
+		handler(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, session)))
+	}
+}
+
+var recoveryCodePattern = regexp.MustCompile(`[0-9a-f]{5}-[0-9a-f]{5}`)
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.loginGuard = NewLoginGuard(s.db, LockoutPolicy{Threshold: 10, Duration: time.Minute, Max: time.Hour})
+	ctx := context.Background()
+
+	// Confirming before enrolling fails
+	if rec := roleRequest(s, asVerified(s.handleTOTPConfirm), http.MethodPost, "/2fa/confirm", "bob", url.Values{"code": {"123456"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without an enrollment, got %d", rec.Code)
+	}
+
+	rec := roleRequest(s, asVerified(s.handleTOTPEnroll), http.MethodPost, "/2fa/enroll", "bob", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "data:image/png;base64,") {
+		t.Fatalf("expected a QR code, got %d", rec.Code)
+	}
+	pending, err := s.db.GetTOTP(ctx, "bob")
+	if err != nil || pending.Enabled() || pending.PendingSecret == "" {
+		t.Fatalf("expected a pending secret, got %+v, %v", pending, err)
+	}
+	secret := pending.PendingSecret
+
+	now := time.Now()
+	code, _ := totp.GenerateCode(secret, now)
+	rec = roleRequest(s, asVerified(s.handleTOTPConfirm), http.MethodPost, "/2fa/confirm", "bob", url.Values{"code": {code}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the enrollment to be confirmed, got %d", rec.Code)
+	}
+	codes := recoveryCodePattern.FindAllString(rec.Body.String(), -1)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %v", recoveryCodeCount, codes)
+	}
+
+	login := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login?user=bob", strings.NewReader(url.Values{"code": {code}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "203.0.113.9:4000"
+		rec := httptest.NewRecorder()
+		s.handleLogin(rec, req)
+		return rec
+	}
+
+	// Without a code, the code form is shown and no session is created
+	rec = login("")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="code"`) || rec.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("expected the code form, got %d", rec.Code)
+	}
+
+	// The code used to confirm can't be used again
+	if rec := login(code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a replayed code to be refused, got %d", rec.Code)
+	}
+
+	next, _ := totp.GenerateCode(secret, now.Add(totpPeriod*time.Second))
+	if rec := login(next); rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected a fresh code to sign in, got %d", rec.Code)
+	}
+	if rec := login(next); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a code to work once, got %d", rec.Code)
+	}
+
+	// Recovery codes work once each
+	if rec := login(strings.ToUpper(codes[0])); rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected a recovery code to sign in, got %d", rec.Code)
+	}
+	if rec := login(codes[0]); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a spent recovery code to be refused, got %d", rec.Code)
+	}
+	if n, err := s.db.CountRecoveryCodes(ctx, "bob"); err != nil || n != recoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes left, got %d, %v", recoveryCodeCount-1, n, err)
+	}
+
+	// Replacing the authenticator needs a current code
+	if rec := roleRequest(s, asVerified(s.handleTOTPEnroll), http.MethodPost, "/2fa/enroll", "bob", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected re-enrolling without a code to be refused, got %d", rec.Code)
+	}
+
+	// Only admins may reset it
+	reset := s.AdminMiddleware(s.handleTwoFactorReset)
+	if rec := roleRequest(s, reset, http.MethodPost, "/api/2fa/reset", "bob", url.Values{"user": {"bob"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("expected bob to be refused, got %d", rec.Code)
+	}
+	s.config.Require2FA = true
+	if rec := roleRequest(s, reset, http.MethodPost, "/api/2fa/reset", "alice", url.Values{"user": {"bob"}}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the reset to succeed, got %d", rec.Code)
+	}
+	if secret, err := s.db.GetTOTP(ctx, "bob"); err != nil || secret != nil {
+		t.Errorf("expected no secret after reset, got %+v, %v", secret, err)
+	}
+
+	// With two-factor authentication required, bob must enroll again
+	rec = login("")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/2fa" {
+		t.Fatalf("expected a redirect to enroll, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	token := strings.TrimPrefix(strings.Split(rec.Header().Get("Set-Cookie"), ";")[0], "session_token=")
+	if session, ok := s.authManager.ValidateSession(token); !ok || !session.MustEnroll {
+		t.Errorf("expected the session to need enrollment, got %+v", session)
+	}
+
+	for action, want := range map[audit.ActionType]int{
+		audit.ActionAuth2FAEnroll:        2,
+		audit.ActionAuth2FAEnable:        2,
+		audit.ActionAuth2FARecoveryCodes: 1,
+		audit.ActionAuth2FAVerify:        5,
+		audit.ActionAuth2FAReset:         1,
+	} {
+		entries, _, err := s.auditStore.Query(ctx, audit.Filter{Action: string(action)})
+		if err != nil || len(entries) != want {
+			t.Errorf("expected %d %s entries, got %d, %v", want, action, len(entries), err)
+		}
+	}
+}
+
+func TestTwoFactorResetPasskeys(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.loginGuard = NewLoginGuard(s.db, LockoutPolicy{Threshold: 10, Duration: time.Minute, Max: time.Hour})
+	if err := s.authManager.EnableWebAuthn(WebAuthnConfig{Origins: []string{"https://shell.example.com"}}.withDefaults(8080, true)); err != nil {
+		t.Fatalf("failed to enable WebAuthn: %v", err)
+	}
+	s.config.Require2FA = true
+	ctx := context.Background()
+
+	// bob's only second factor is a passkey
+	for i, name := range []string{"Laptop", "YubiKey"} {
+		err := s.db.AddWebAuthnCredential(ctx, &db.WebAuthnCredential{
+			ID:        []byte{byte(i), 0xff},
+			Username:  "bob",
+			Name:      name,
+			PublicKey: []byte("key"),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login?user=bob", nil)
+		req.RemoteAddr = "203.0.113.9:4000"
+		rec := httptest.NewRecorder()
+		s.handleLogin(rec, req)
+		return rec
+	}
+	if rec := login(); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/passkey?user=bob" {
+		t.Fatalf("expected to be sent to sign in with a passkey, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Having lost it, bob is reset by an admin
+	reset := s.AdminMiddleware(s.handleTwoFactorReset)
+	if rec := roleRequest(s, reset, http.MethodPost, "/api/2fa/reset", "alice", url.Values{"user": {"bob"}}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the reset to succeed, got %d", rec.Code)
+	}
+	if keys, err := s.db.ListWebAuthnCredentials(ctx, "bob"); err != nil || len(keys) != 0 {
+		t.Errorf("expected no passkeys after reset, got %+v, %v", keys, err)
+	}
+
+	// bob can sign in again, but must enroll before anything else
+	rec := login()
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/2fa" {
+		t.Fatalf("expected a redirect to enroll, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	token := strings.TrimPrefix(strings.Split(rec.Header().Get("Set-Cookie"), ";")[0], "session_token=")
+	if session, ok := s.authManager.ValidateSession(token); !ok || !session.MustEnroll {
+		t.Errorf("expected the session to need enrollment, got %+v", session)
+	}
+
+	entries, _, err := s.auditStore.Query(ctx, audit.Filter{Action: string(audit.ActionAuthWebAuthnRevoke)})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected both passkeys' removal to be recorded, got %+v, %v", entries, err)
+	}
+	for _, entry := range entries {
+		if entry.Actor != "alice" || entry.Target != "user:bob" || entry.Outcome != audit.OutcomeSuccess {
+			t.Errorf("expected alice to have revoked bob's passkey, got %+v", entry)
+		}
+	}
+}
+
+func TestEnrollmentToken(t *testing.T) {
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.loginGuard = NewLoginGuard(s.db, LockoutPolicy{Threshold: 10, Duration: time.Minute, Max: time.Hour})
+	s.config.DefaultRole = "viewer"
+	ctx := context.Background()
+	if err := s.db.SetUserRole(ctx, "bob", "developer", "alice", time.Now()); err != nil {
+		t.Fatalf("failed to set role: %v", err)
+	}
+
+	// Anyone can sign in as bob by name
+	rec := httptest.NewRecorder()
+	s.handleLogin(rec, httptest.NewRequest(http.MethodGet, "/login?user=bob", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %d cookies", len(cookies))
+	}
+	asBob := func(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "203.0.113.9:4000"
+		req.AddCookie(cookies[0])
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, "bob"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// ...but can't set up a second factor for him without a token
+	if rec := asBob(s.handleTOTPEnroll, "/2fa/enroll", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected enrolling without a token to be refused, got %d", rec.Code)
+	}
+
+	// Only admins issue tokens
+	issue := s.AdminMiddleware(s.handleEnrollmentTokenIssue)
+	if rec := roleRequest(s, issue, http.MethodPost, "/api/2fa/enroll-token", "bob", url.Values{"user": {"bob"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("expected bob to be refused, got %d", rec.Code)
+	}
+	rec = roleRequest(s, issue, http.MethodPost, "/api/2fa/enroll-token", "alice", url.Values{"user": {"bob"}})
+	var info EnrollmentTokenInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected a token, got %d, %v", rec.Code, err)
+	}
+	if info.User != "bob" || info.Token == "" || time.Until(info.ExpiresAt) < enrollmentTokenTTL-time.Minute {
+		t.Errorf("unexpected token: %+v", info)
+	}
+
+	if rec := asBob(s.handleEnrollmentTokenRedeem, "/2fa/token", url.Values{"token": {"00000-00000-00000-00000"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong token to be refused, got %d", rec.Code)
+	}
+	if f, err := s.db.GetLoginFailure(ctx, db.LoginScopeUser, "bob"); err != nil || f == nil || f.Failures != 1 {
+		t.Errorf("expected the wrong token to count as a failed login, got %+v, %v", f, err)
+	}
+	if rec := asBob(s.handleEnrollmentTokenRedeem, "/2fa/token", url.Values{"token": {strings.ToUpper(info.Token)}}); rec.Code != http.StatusOK {
+		t.Fatalf("expected the token to be accepted, got %d", rec.Code)
+	}
+	if session, _ := s.authManager.ValidateSession(cookies[0].Value); !session.Verified || session.Role != RoleDeveloper {
+		t.Errorf("expected a verified developer session, got %+v", session)
+	}
+	if rec := asBob(s.handleEnrollmentTokenRedeem, "/2fa/token", url.Values{"token": {info.Token}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a spent token to be refused, got %d", rec.Code)
+	}
+	if rec := asBob(s.handleTOTPEnroll, "/2fa/enroll", nil); rec.Code != http.StatusOK {
+		t.Errorf("expected enrolling to be allowed, got %d", rec.Code)
+	}
+
+	for action, want := range map[audit.ActionType]int{
+		audit.ActionAuth2FAEnrollToken: 1,
+		audit.ActionAuth2FAVerify:      3,
+		audit.ActionAuth2FAEnroll:      2,
+	} {
+		entries, _, err := s.auditStore.Query(ctx, audit.Filter{Action: string(action)})
+		if err != nil || len(entries) != want {
+			t.Errorf("expected %d %s entries, got %d, %v", want, action, len(entries), err)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package server
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/corymacd/StratusShell/internal/db"
+	"github.com/corymacd/StratusShell/internal/metrics"
+	"github.com/corymacd/StratusShell/internal/middleware"
+	"github.com/corymacd/StratusShell/internal/ui"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnConfig configures signing in with passkeys and security keys
+type WebAuthnConfig struct {
+	// Origins are the URLs the UI is reached at, such as
+	// https://shell.example.com (default: http://localhost on the server's
+	// port, or https with TLS)
+	Origins []string
+
+	// RPID is the domain keys are registered for (default: the host of the
+	// first origin). Keys only work on it and its subdomains.
+	RPID string
+}
+
+const (
+	// webAuthnTimeout is how long users have to finish a ceremony
+	webAuthnTimeout = 5 * time.Minute
+
+	// ceremonyCookie identifies the client's ceremony in progress
+	ceremonyCookie = "webauthn_ceremony"
+
+	// maxKeyNameLength caps the names users give their keys
+	maxKeyNameLength = 64
+
+	// maxCredentialSize caps the JSON clients send back from a ceremony
+	maxCredentialSize = 64 << 10
+)
+
+var (
+	// ErrWebAuthnDisabled is returned when passkeys are not configured
+	ErrWebAuthnDisabled = errors.New("passkeys are not configured")
+	// ErrNoCeremony is returned when finishing a ceremony that was never
+	// started, has expired or belongs to someone else
+	ErrNoCeremony = errors.New("no passkey ceremony in progress")
+	// ErrNoKeys is returned when signing in as a user with no keys
+	ErrNoKeys = errors.New("no passkeys registered")
+	// ErrKeyRegistered is returned when registering a key twice
+	ErrKeyRegistered = errors.New("passkey already registered")
+	// ErrClonedKey is returned when a key's signature counter goes
+	// backwards, a sign that it has been copied
+	ErrClonedKey = errors.New("passkey signature counter went backwards")
+)
+
+// webAuthnCeremony is a registration or login waiting for the client's
+// response
+type webAuthnCeremony struct {
+	user         string
+	registration bool
+	data         webauthn.SessionData
+	expires      time.Time
+}
+
+// webAuthnUser is a user and their keys, as the webauthn package sees them
+type webAuthnUser struct {
+	name   string
+	handle []byte
+	keys   []*db.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte          { return u.handle }
+func (u *webAuthnUser) WebAuthnName() string        { return u.name }
+func (u *webAuthnUser) WebAuthnDisplayName() string { return u.name }
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.keys))
+	for i, key := range u.keys {
+		transports := make([]protocol.AuthenticatorTransport, len(key.Transports))
+		for j, t := range key.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		creds[i] = webauthn.Credential{
+			ID:              key.ID,
+			PublicKey:       key.PublicKey,
+			AttestationType: key.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: key.BackupEligible,
+				BackupState:    key.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    key.AAGUID,
+				SignCount: key.SignCount,
+			},
+		}
+	}
+	return creds
+}
+
+// key returns the user's key with the given ID, or nil
+func (u *webAuthnUser) key(id []byte) *db.WebAuthnCredential {
+	for _, key := range u.keys {
+		if string(key.ID) == string(id) {
+			return key
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in the origin of a server on port and the RPID
+func (c WebAuthnConfig) withDefaults(port int, tls bool) WebAuthnConfig {
+	if len(c.Origins) == 0 {
+		scheme := "http"
+		if tls {
+			scheme = "https"
+		}
+		c.Origins = []string{fmt.Sprintf("%s://localhost:%d", scheme, port)}
+	}
+	if c.RPID == "" {
+		if origin, err := url.Parse(c.Origins[0]); err == nil {
+			c.RPID = origin.Hostname()
+		}
+	}
+	return c
+}
+
+// validate checks the origins are URLs and the RPID is set
+func (c WebAuthnConfig) validate() error {
+	if len(c.Origins) == 0 {
+		return errors.New("at least one WebAuthn origin is required")
+	}
+	for _, o := range c.Origins {
+		origin, err := url.Parse(o)
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" {
+			return fmt.Errorf("invalid WebAuthn origin %q", o)
+		}
+	}
+	if c.RPID == "" {
+		return errors.New("a WebAuthn relying party ID is required")
+	}
+	return nil
+}
+
+// EnableWebAuthn lets users register passkeys and security keys and sign in
+// with them
+func (am *AuthManager) EnableWebAuthn(config WebAuthnConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: "StratusShell",
+		RPOrigins:     config.Origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		AttestationPreference: protocol.PreferNoAttestation,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnTimeout, TimeoutUVD: webAuthnTimeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnTimeout, TimeoutUVD: webAuthnTimeout},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	am.mu.Lock()
+	am.webAuthn = wa
+	am.mu.Unlock()
+	return nil
+}
+
+// WebAuthnEnabled reports whether passkeys may be used
+func (am *AuthManager) WebAuthnEnabled() bool {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return am.webAuthn != nil
+}
+
+// BeginRegistration starts registering a new key for user. It returns the
+// options for the browser and the ID of the ceremony.
+func (am *AuthManager) BeginRegistration(user *webAuthnUser) (*protocol.CredentialCreation, string, error) {
+	wa := am.webAuthnConfig()
+	if wa == nil {
+		return nil, "", ErrWebAuthnDisabled
+	}
+	// Keys already registered are not registered twice
+	exclude := webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()
+	creation, data, err := wa.BeginRegistration(user, webauthn.WithExclusions(exclude))
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := am.startCeremony(user.name, true, data)
+	return creation, id, err
+}
+
+// FinishRegistration checks the browser's response to registration ceremony
+// id and returns the new key
+func (am *AuthManager) FinishRegistration(id string, user *webAuthnUser, r *http.Request) (*webauthn.Credential, error) {
+	wa := am.webAuthnConfig()
+	if wa == nil {
+		return nil, ErrWebAuthnDisabled
+	}
+	data, err := am.takeCeremony(id, user.name, true)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(http.MaxBytesReader(nil, r.Body, maxCredentialSize))
+	if err != nil {
+		return nil, err
+	}
+	return wa.CreateCredential(user, *data, parsed)
+}
+
+// BeginLogin starts signing user in with one of their keys. It returns the
+// options for the browser and the ID of the ceremony.
+func (am *AuthManager) BeginLogin(user *webAuthnUser) (*protocol.CredentialAssertion, string, error) {
+	wa := am.webAuthnConfig()
+	if wa == nil {
+		return nil, "", ErrWebAuthnDisabled
+	}
+	if len(user.keys) == 0 {
+		return nil, "", ErrNoKeys
+	}
+	assertion, data, err := wa.BeginLogin(user)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := am.startCeremony(user.name, false, data)
+	return assertion, id, err
+}
+
+// FinishLogin checks the browser's response to login ceremony id and
+// returns the key that signed it, with its new signature counter
+func (am *AuthManager) FinishLogin(id string, user *webAuthnUser, r *http.Request) (*webauthn.Credential, error) {
+	wa := am.webAuthnConfig()
+	if wa == nil {
+		return nil, ErrWebAuthnDisabled
+	}
+	data, err := am.takeCeremony(id, user.name, false)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(http.MaxBytesReader(nil, r.Body, maxCredentialSize))
+	if err != nil {
+		return nil, err
+	}
+	cred, err := wa.ValidateLogin(user, *data, parsed)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrClonedKey
+	}
+	return cred, nil
+}
+
+func (am *AuthManager) webAuthnConfig() *webauthn.WebAuthn {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return am.webAuthn
+}
+
+// startCeremony remembers a ceremony until the browser responds
+func (am *AuthManager) startCeremony(user string, registration bool, data *webauthn.SessionData) (string, error) {
+	id, err := am.generateToken()
+	if err != nil {
+		return "", err
+	}
+	am.mu.Lock()
+	am.ceremonies[id] = &webAuthnCeremony{
+		user:         user,
+		registration: registration,
+		data:         *data,
+		expires:      time.Now().Add(webAuthnTimeout),
+	}
+	am.mu.Unlock()
+	return id, nil
+}
+
+// takeCeremony ends ceremony id, which must be of the given kind and for
+// user. Each ceremony can only be finished once, whatever the outcome.
+func (am *AuthManager) takeCeremony(id, user string, registration bool) (*webauthn.SessionData, error) {
+	am.mu.Lock()
+	c, ok := am.ceremonies[id]
+	delete(am.ceremonies, id)
+	am.mu.Unlock()
+
+	if !ok || c.user != user || c.registration != registration || time.Now().After(c.expires) {
+		return nil, ErrNoCeremony
+	}
+	return &c.data, nil
+}
+
+// setCeremonyCookie tells the client which ceremony it is taking part in
+func setCeremonyCookie(w http.ResponseWriter, r *http.Request, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    id,
+		Path:     middleware.URL(r.Context(), "/"),
+		HttpOnly: true,
+		Secure:   middleware.IsHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(webAuthnTimeout.Seconds()),
+	})
+}
+
+// ceremonyID returns the ID of the client's ceremony and clears its cookie
+func ceremonyID(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(ceremonyCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    "",
+		Path:     middleware.URL(r.Context(), "/"),
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+	return cookie.Value
+}
+
+// webAuthnUser loads user's handle and keys. With create set, users who
+// have never registered a key are given a handle.
+func (s *Server) webAuthnUser(r *http.Request, user string, create bool) (*webAuthnUser, error) {
+	handle, err := s.db.GetWebAuthnUser(r.Context(), user)
+	if err != nil {
+		return nil, err
+	}
+	if handle == nil && create {
+		handle = make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			return nil, err
+		}
+		if handle, err = s.db.EnsureWebAuthnUser(r.Context(), user, handle); err != nil {
+			return nil, err
+		}
+	}
+	keys, err := s.db.ListWebAuthnCredentials(r.Context(), user)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{name: user, handle: handle, keys: keys}, nil
+}
+
+// hasPasskeys reports whether user can sign in with a passkey
+func (s *Server) hasPasskeys(r *http.Request, user string) (bool, error) {
+	if !s.authManager.WebAuthnEnabled() {
+		return false, nil
+	}
+	keys, err := s.db.ListWebAuthnCredentials(r.Context(), user)
+	return len(keys) > 0, err
+}
+
+// encodeKeyID encodes a key ID for URLs
+func encodeKeyID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// passkeyInfo describes keys for the UI
+func passkeyInfo(keys []*db.WebAuthnCredential) []ui.PasskeyInfo {
+	info := make([]ui.PasskeyInfo, len(keys))
+	for i, key := range keys {
+		info[i] = ui.PasskeyInfo{
+			ID:         encodeKeyID(key.ID),
+			Name:       key.Name,
+			Synced:     key.BackupEligible,
+			CreatedAt:  key.CreatedAt,
+			LastUsedAt: key.LastUsedAt,
+		}
+	}
+	return info
+}
+
+// handlePasskeysPage lists the user's keys and lets them add and revoke them
+func (s *Server) handlePasskeysPage(w http.ResponseWriter, r *http.Request) {
+	user := s.getActor(r)
+	keys, err := s.db.ListWebAuthnCredentials(r.Context(), user)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to list passkeys")
+		return
+	}
+	token, err := s.csrfProtection.GetToken(w, r)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to create CSRF token")
+		return
+	}
+	ui.PasskeysPage(user, passkeyInfo(keys), s.authManager.WebAuthnEnabled(), s.sessionVerified(r), token).Render(r.Context(), w)
+}
+
+// handlePasskeyRegisterBegin starts registering a key for the user
+func (s *Server) handlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authManager.WebAuthnEnabled() {
+		http.Error(w, ErrWebAuthnDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	actor := s.getActor(r)
+	if !s.sessionVerified(r) {
+		s.auditFor(r).LogAuthWebAuthnRegister(actor, "", audit.OutcomeFailure, ErrNotVerified)
+		http.Error(w, errEnrollmentTokenFirst, http.StatusForbidden)
+		return
+	}
+
+	user, err := s.webAuthnUser(r, actor, true)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to start passkey registration")
+		return
+	}
+	creation, id, err := s.authManager.BeginRegistration(user)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to start passkey registration")
+		return
+	}
+	setCeremonyCookie(w, r, id)
+	writeJSON(w, http.StatusOK, creation)
+}
+
+// handlePasskeyRegisterFinish stores the key the browser created, under the
+// name query parameter
+func (s *Server) handlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxKeyNameLength {
+		http.Error(w, fmt.Sprintf("Passkey names may be at most %d characters", maxKeyNameLength), http.StatusBadRequest)
+		return
+	}
+	if !s.sessionVerified(r) {
+		s.auditFor(r).LogAuthWebAuthnRegister(actor, name, audit.OutcomeFailure, ErrNotVerified)
+		http.Error(w, errEnrollmentTokenFirst, http.StatusForbidden)
+		return
+	}
+
+	user, err := s.webAuthnUser(r, actor, false)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to register passkey")
+		return
+	}
+	cred, err := s.authManager.FinishRegistration(ceremonyID(w, r), user, r)
+	if err == nil && user.key(cred.ID) != nil {
+		err = ErrKeyRegistered
+	}
+	if err != nil {
+		s.auditFor(r).LogAuthWebAuthnRegister(actor, name, audit.OutcomeFailure, err)
+		http.Error(w, "Passkey registration failed", http.StatusBadRequest)
+		return
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+	key := &db.WebAuthnCredential{
+		ID:              cred.ID,
+		Username:        actor,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	err = s.db.AddWebAuthnCredential(r.Context(), key)
+	s.auditFor(r).LogAuthWebAuthnRegister(actor, name, audit.OutcomeFromError(err), err)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to register passkey")
+		return
+	}
+	// A passkey is a second factor, so the user may now do everything else
+	s.authManager.SetMustEnroll(actor, false)
+	writeJSON(w, http.StatusCreated, passkeyInfo([]*db.WebAuthnCredential{key})[0])
+}
+
+// handlePasskeyRevoke removes one of the user's keys: DELETE /passkeys/{id}
+func (s *Server) handlePasskeyRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor := s.getActor(r)
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/passkeys/"))
+	if err != nil || len(id) == 0 {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+	if !s.sessionVerified(r) {
+		s.auditFor(r).LogAuthWebAuthnRevoke(actor, actor, "", audit.OutcomeFailure, ErrNotVerified)
+		http.Error(w, errEnrollmentTokenFirst, http.StatusForbidden)
+		return
+	}
+
+	user, err := s.webAuthnUser(r, actor, false)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to revoke passkey")
+		return
+	}
+	key := user.key(id)
+	if key == nil {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	err = s.db.DeleteWebAuthnCredential(r.Context(), actor, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	s.auditFor(r).LogAuthWebAuthnRevoke(actor, actor, key.Name, audit.OutcomeFromError(err), err)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to revoke passkey")
+		return
+	}
+
+	keys, err := s.db.ListWebAuthnCredentials(r.Context(), actor)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to list passkeys")
+		return
+	}
+	if s.config.Require2FA {
+		// Users who removed their last second factor must set up another
+		enrolled, err := s.secondFactorEnrolled(r, actor)
+		if err != nil {
+			s.handleError(w, r, err, "Failed to check two-factor authentication")
+			return
+		}
+		if !enrolled {
+			s.authManager.SetMustEnroll(actor, true)
+		}
+	}
+	ui.PasskeyList(passkeyInfo(keys)).Render(r.Context(), w)
+}
+
+// handlePasskeyLoginPage offers to sign the user query parameter in with a
+// passkey
+func (s *Server) handlePasskeyLoginPage(w http.ResponseWriter, r *http.Request) {
+	if s.config.AuthMode == AuthModeClientCert {
+		http.Redirect(w, r, middleware.URL(r.Context(), "/"), http.StatusSeeOther)
+		return
+	}
+	user := r.URL.Query().Get("user")
+	if !usernamePattern.MatchString(user) {
+		http.Error(w, "Invalid username", http.StatusBadRequest)
+		return
+	}
+	ui.PasskeyLoginPage(user, s.authManager.WebAuthnEnabled()).Render(r.Context(), w)
+}
+
+// handlePasskeyLoginBegin starts signing the user query parameter in with
+// one of their keys
+func (s *Server) handlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.AuthMode == AuthModeClientCert || !s.authManager.WebAuthnEnabled() {
+		http.Error(w, ErrWebAuthnDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	user := r.URL.Query().Get("user")
+	ip := s.trustedProxies.ClientIP(r)
+	if !s.checkLogin(w, r, user, ip) {
+		return
+	}
+
+	waUser, err := s.webAuthnUser(r, user, false)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to start passkey login")
+		return
+	}
+	assertion, id, err := s.authManager.BeginLogin(waUser)
+	if errors.Is(err, ErrNoKeys) {
+		http.Error(w, "No passkeys are registered for this user", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, err, "Failed to start passkey login")
+		return
+	}
+	setCeremonyCookie(w, r, id)
+	writeJSON(w, http.StatusOK, assertion)
+}
+
+// handlePasskeyLoginFinish signs the user in once the browser's response is
+// checked. A passkey proves both who the user is and that they have the
+// device, so no second factor is asked for.
+func (s *Server) handlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.AuthMode == AuthModeClientCert {
+		http.Error(w, ErrWebAuthnDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	user := r.URL.Query().Get("user")
+	ip := s.trustedProxies.ClientIP(r)
+	if !s.checkLogin(w, r, user, ip) {
+		return
+	}
+
+	waUser, err := s.webAuthnUser(r, user, false)
+	if err != nil {
+		s.handleError(w, r, err, "Failed to check passkey")
+		return
+	}
+	cred, err := s.authManager.FinishLogin(ceremonyID(w, r), waUser, r)
+	if err == nil {
+		err = s.db.UseWebAuthnCredential(r.Context(), cred.ID, cred.Authenticator.SignCount, cred.Flags.BackupState, time.Now())
+	}
+	var name string
+	if cred != nil {
+		if key := waUser.key(cred.ID); key != nil {
+			name = key.Name
+		}
+	}
+	s.auditFor(r).LogAuthWebAuthnLogin(user, name, audit.OutcomeFromError(err), err)
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("webauthn").Inc()
+		s.recordLoginFailure(r, user, ip)
+		http.Error(w, "Passkey login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if !s.signIn(w, r, user, ip, true) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": middleware.URL(r.Context(), "/")})
+}
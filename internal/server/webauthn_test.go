@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/corymacd/StratusShell/internal/audit"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+// softAuthenticator is a software security key: it creates and signs with
+// ECDSA P-256 credentials the way a browser and hardware key would
+type softAuthenticator struct {
+	t       *testing.T
+	origin  string
+	id      []byte
+	key     *ecdsa.PrivateKey
+	handle  []byte
+	counter uint32
+}
+
+// Ceremony options, as the browser reads them
+type creationOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		ExcludeCredentials []struct {
+			ID string `json:"id"`
+		} `json:"excludeCredentials"`
+	} `json:"publicKey"`
+}
+
+type assertionOptions struct {
+	PublicKey struct {
+		Challenge        string `json:"challenge"`
+		RPID             string `json:"rpId"`
+		AllowCredentials []struct {
+			ID string `json:"id"`
+		} `json:"allowCredentials"`
+	} `json:"publicKey"`
+}
+
+var b64 = base64.RawURLEncoding
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{t: t, origin: origin, id: id, key: key}
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	return data
+}
+
+// authData builds authenticator data: the RP ID hash, flags (user present
+// and verified) and signature counter, then attested credential data if
+// attested is set
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	var buf bytes.Buffer
+	buf.Write(rpIDHash[:])
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.counter)
+	if attested {
+		buf.Write(make([]byte, 16)) // AAGUID
+		binary.Write(&buf, binary.BigEndian, uint16(len(a.id)))
+		buf.Write(a.id)
+		cose, err := webauthncbor.Marshal(map[int]interface{}{
+			1:  2,  // kty: EC2
+			3:  -7, // alg: ES256
+			-1: 1,  // crv: P-256
+			-2: a.key.X.FillBytes(make([]byte, 32)),
+			-3: a.key.Y.FillBytes(make([]byte, 32)),
+		})
+		if err != nil {
+			a.t.Fatalf("failed to encode public key: %v", err)
+		}
+		buf.Write(cose)
+	}
+	return buf.Bytes()
+}
+
+// create answers a registration ceremony
+func (a *softAuthenticator) create(options creationOptions) []byte {
+	a.handle, _ = b64.DecodeString(options.PublicKey.User.ID)
+	authData := a.authData(options.PublicKey.RP.ID, true)
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatalf("failed to encode attestation: %v", err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", options.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+			"transports":        []string{"usb"},
+		},
+	})
+	return body
+}
+
+// get answers a login ceremony, signing with the next counter value
+func (a *softAuthenticator) get(options assertionOptions) []byte {
+	a.counter++
+	authData := a.authData(options.PublicKey.RPID, false)
+	clientData := a.clientData("webauthn.get", options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("failed to sign: %v", err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(sig),
+			"userHandle":        b64.EncodeToString(a.handle),
+		},
+	})
+	return body
+}
+
+// ceremonyRequest calls handler as user ("" for none) with body, sending
+// cookies set by an earlier response
+func ceremonyRequest(handler http.HandlerFunc, method, target, user string, body []byte, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "203.0.113.9:4000"
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if user != "" {
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestWebAuthnConfig(t *testing.T) {
+	c := WebAuthnConfig{}.withDefaults(8080, false)
+	if len(c.Origins) != 1 || c.Origins[0] != "http://localhost:8080" || c.RPID != "localhost" {
+		t.Errorf("unexpected defaults: %+v", c)
+	}
+	c = WebAuthnConfig{Origins: []string{"https://shell.example.com"}}.withDefaults(8080, false)
+	if c.RPID != "shell.example.com" {
+		t.Errorf("expected the origin's host, got %q", c.RPID)
+	}
+	if err := (WebAuthnConfig{Origins: []string{"shell.example.com"}, RPID: "example.com"}).validate(); err == nil {
+		t.Error("expected an origin without a scheme to be rejected")
+	}
+}
+
+func TestWebAuthn(t *testing.T) {
+	const origin = "https://shell.example.com"
+	s := newAuditTestServer(t)
+	s.authManager = NewAuthManager()
+	s.loginGuard = NewLoginGuard(s.db, LockoutPolicy{Threshold: 10, Duration: time.Minute, Max: time.Hour})
+	if err := s.authManager.EnableWebAuthn(WebAuthnConfig{Origins: []string{origin}}.withDefaults(8080, true)); err != nil {
+		t.Fatalf("failed to enable WebAuthn: %v", err)
+	}
+	ctx := context.Background()
+	key := newSoftAuthenticator(t, origin)
+
+	register := func(user, name string, a *softAuthenticator) (*httptest.ResponseRecorder, creationOptions) {
+		t.Helper()
+		rec := ceremonyRequest(asVerified(s.handlePasskeyRegisterBegin), http.MethodPost, "/passkeys/register/begin", user, nil, nil)
+		var options creationOptions
+		if err := json.NewDecoder(rec.Body).Decode(&options); err != nil {
+			t.Fatalf("invalid creation options: %v", err)
+		}
+		return ceremonyRequest(asVerified(s.handlePasskeyRegisterFinish), http.MethodPost, "/passkeys/register/finish?name="+name, user,
+			a.create(options), rec.Result().Cookies()), options
+	}
+
+	login := func(user string, a *softAuthenticator) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := ceremonyRequest(s.handlePasskeyLoginBegin, http.MethodPost, "/login/passkey/begin?user="+user, "", nil, nil)
+		if rec.Code != http.StatusOK {
+			return rec
+		}
+		var options assertionOptions
+		if err := json.NewDecoder(rec.Body).Decode(&options); err != nil {
+			t.Fatalf("invalid assertion options: %v", err)
+		}
+		return ceremonyRequest(s.handlePasskeyLoginFinish, http.MethodPost, "/login/passkey/finish?user="+user, "",
+			a.get(options), rec.Result().Cookies())
+	}
+
+	// Sessions that only named their user can't add or revoke keys
+	if rec := ceremonyRequest(s.handlePasskeyRegisterBegin, http.MethodPost, "/passkeys/register/begin", "bob", nil, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expected an unverified session to be refused, got %d", rec.Code)
+	}
+
+	// Users with no keys can't sign in with one
+	if rec := login("bob", key); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without keys, got %d", rec.Code)
+	}
+
+	// With two-factor authentication required, a session that must enroll
+	// may add a passkey but do nothing else
+	s.config.Require2FA = true
+	token, err := s.authManager.CreateSession("bob", RoleDeveloper, true)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	s.authManager.SetMustEnroll("bob", true)
+	sessionCookie := []*http.Cookie{{Name: "session_token", Value: token}}
+	if rec := ceremonyRequest(s.AuthMiddleware(s.handlePasskeyRegisterBegin), http.MethodPost, "/passkeys/register/begin", "", nil, sessionCookie); rec.Code != http.StatusOK {
+		t.Errorf("expected a session that must enroll to add a passkey, got %d", rec.Code)
+	}
+	if rec := ceremonyRequest(s.AuthMiddleware(s.handleIndex), http.MethodGet, "/", "", nil, sessionCookie); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/2fa" {
+		t.Errorf("expected a session that must enroll to be sent to /2fa, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec, options := register("bob", "YubiKey", key)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the key to be registered, got %d: %s", rec.Code, rec.Body)
+	}
+	if session, ok := s.authManager.ValidateSession(token); !ok || session.MustEnroll {
+		t.Errorf("expected adding a passkey to finish enrolling, got %+v", session)
+	}
+
+	// A passkey is a second factor, so naming the user at /login is not
+	// enough
+	req := httptest.NewRequest(http.MethodPost, "/login?user=bob", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	rec = httptest.NewRecorder()
+	s.handleLogin(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/passkey?user=bob" || rec.Header().Get("Set-Cookie") != "" {
+		t.Errorf("expected to be sent to sign in with the passkey, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if options.PublicKey.RP.ID != "shell.example.com" {
+		t.Errorf("unexpected RP ID %q", options.PublicKey.RP.ID)
+	}
+
+	// The same key can't be registered twice, and the browser is told so
+	rec, options = register("bob", "Again", key)
+	if len(options.PublicKey.ExcludeCredentials) != 1 || options.PublicKey.ExcludeCredentials[0].ID != b64.EncodeToString(key.id) {
+		t.Errorf("expected the key to be excluded, got %+v", options.PublicKey.ExcludeCredentials)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected registering a key twice to fail, got %d", rec.Code)
+	}
+
+	// A ceremony can only be finished once
+	rec = ceremonyRequest(asVerified(s.handlePasskeyRegisterBegin), http.MethodPost, "/passkeys/register/begin", "bob", nil, nil)
+	json.NewDecoder(rec.Body).Decode(&options)
+	cookies := rec.Result().Cookies()
+	other := newSoftAuthenticator(t, origin)
+	body := other.create(options)
+	if rec := ceremonyRequest(asVerified(s.handlePasskeyRegisterFinish), http.MethodPost, "/passkeys/register/finish", "carol", body, cookies); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected another user's ceremony to be refused, got %d", rec.Code)
+	}
+	if rec := ceremonyRequest(asVerified(s.handlePasskeyRegisterFinish), http.MethodPost, "/passkeys/register/finish", "bob", body, cookies); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a spent ceremony to be refused, got %d", rec.Code)
+	}
+
+	// Signing in
+	rec = login("bob", key)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected to sign in, got %d: %s", rec.Code, rec.Body)
+	}
+	var session *Session
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session_token" {
+			session, _ = s.authManager.ValidateSession(c.Value)
+		}
+	}
+	if session == nil || session.User != "bob" {
+		t.Fatalf("expected a session for bob, got %+v", session)
+	}
+	keys, err := s.db.ListWebAuthnCredentials(ctx, "bob")
+	if err != nil || len(keys) != 1 || keys[0].Name != "YubiKey" || keys[0].SignCount != 1 || keys[0].LastUsedAt.IsZero() {
+		t.Fatalf("expected the key's use to be recorded, got %+v, %v", keys, err)
+	}
+
+	// Someone else's key, a copied key and the wrong origin are refused
+	if rec := login("bob", other); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unregistered key to be refused, got %d", rec.Code)
+	}
+	key.counter = 0
+	if rec := login("bob", key); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a counter going backwards to be refused, got %d", rec.Code)
+	}
+	key.counter = 10
+	key.origin = "https://evil.example.com"
+	if rec := login("bob", key); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the wrong origin to be refused, got %d", rec.Code)
+	}
+	key.origin = origin
+
+	// Revoking
+	id := b64.EncodeToString(key.id)
+	if rec := ceremonyRequest(asVerified(s.handlePasskeyRevoke), http.MethodDelete, "/passkeys/"+id, "alice", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected another user's key to be left alone, got %d", rec.Code)
+	}
+	if rec := ceremonyRequest(asVerified(s.handlePasskeyRevoke), http.MethodDelete, "/passkeys/"+id, "bob", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected the key to be revoked, got %d", rec.Code)
+	}
+	if rec := login("bob", key); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a revoked key to be refused, got %d", rec.Code)
+	}
+	if session, ok := s.authManager.ValidateSession(token); !ok || !session.MustEnroll {
+		t.Errorf("expected revoking the last passkey to require enrolling again, got %+v", session)
+	}
+
+	for action, want := range map[audit.ActionType]int{
+		audit.ActionAuthWebAuthnRegister: 5,
+		audit.ActionAuthWebAuthnLogin:    4,
+		audit.ActionAuthWebAuthnRevoke:   1,
+	} {
+		entries, _, err := s.auditStore.Query(ctx, audit.Filter{Action: string(action)})
+		if err != nil || len(entries) != want {
+			t.Errorf("expected %d %s entries, got %d, %v", want, action, len(entries), err)
+		}
+	}
+	entries, _, err := s.auditStore.Query(ctx, audit.Filter{Action: string(audit.ActionAuthWebAuthnLogin), Outcome: audit.OutcomeSuccess})
+	if err != nil || len(entries) != 1 || entries[0].Details["name"] != "YubiKey" {
+		t.Errorf("expected one successful passkey login, got %+v, %v", entries, err)
+	}
+}
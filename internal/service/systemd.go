@@ -9,8 +9,13 @@ import (
 	"path/filepath"
 	"regexp"
 	"text/template"
+
+	"github.com/corymacd/StratusShell/internal/logging"
 )
 
+// logger reports service installation and removal
+var logger = logging.Component("service")
+
 const serviceTemplate = `[Unit]
 Description=StratusShell for {{.User}}
 After=network.target
@@ -24,6 +29,8 @@ Restart=always
 RestartSec=10
 StandardOutput=journal
 StandardError=journal
+# Lets the server place terminals in per-user cgroups (--cpu-limit, --memory-limit)
+Delegate=cpu memory
 
 [Install]
 WantedBy=multi-user.target
@@ -142,6 +149,7 @@ func InstallSystemdService(username string, port int) error {
 	if err := tmpl.Execute(f, config); err != nil {
 		return fmt.Errorf("failed to write service file: %w", err)
 	}
+	logger.Info("wrote systemd unit", "path", servicePath, "user", username, "port", port)
 
 	// Reload systemd
 	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
@@ -157,6 +165,7 @@ func InstallSystemdService(username string, port int) error {
 	if err := exec.Command("systemctl", "start", serviceName).Run(); err != nil {
 		return fmt.Errorf("failed to start service: %w", err)
 	}
+	logger.Info("started service", "service", serviceName)
 
 	return nil
 }
@@ -165,17 +174,21 @@ func UninstallSystemdService(username string) error {
 	serviceName := getServiceName(username)
 	servicePath := getServicePath(serviceName)
 
-	// Stop service
-	exec.Command("systemctl", "stop", serviceName).Run()
-
-	// Disable service
-	exec.Command("systemctl", "disable", serviceName).Run()
-
-	// Remove service file
-	os.Remove(servicePath)
-
-	// Reload systemd
-	exec.Command("systemctl", "daemon-reload").Run()
+	// Removal carries on past failures so a half-installed service can
+	// still be cleaned up
+	if err := exec.Command("systemctl", "stop", serviceName).Run(); err != nil {
+		logger.Warn("failed to stop service", "service", serviceName, "err", err)
+	}
+	if err := exec.Command("systemctl", "disable", serviceName).Run(); err != nil {
+		logger.Warn("failed to disable service", "service", serviceName, "err", err)
+	}
+	if err := os.Remove(servicePath); err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to remove systemd unit", "path", servicePath, "err", err)
+	}
+	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+		logger.Warn("failed to reload systemd", "err", err)
+	}
+	logger.Info("removed service", "service", serviceName)
 
 	return nil
 }
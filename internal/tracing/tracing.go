@@ -0,0 +1,130 @@
+// Package tracing sets up OpenTelemetry tracing for the server. Spans are
+// created through Tracer; until Setup installs an exporter they are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted by Config.Exporter
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"   // OTLP over HTTP
+	ExporterStdout = "stdout" // JSON spans on standard output
+	ExporterFile   = "file"   // JSON spans appended to Config.File
+)
+
+// Config selects where spans are sent
+type Config struct {
+	Exporter    string
+	Endpoint    string  // OTLP collector host:port; OTEL_EXPORTER_OTLP_* variables apply if empty
+	Insecure    bool    // Send OTLP over plain HTTP
+	File        string  // For ExporterFile
+	SampleRatio float64 // Fraction of new traces recorded, 0 < ratio <= 1
+}
+
+// Tracer returns the tracer used for all StratusShell spans
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/corymacd/StratusShell")
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch config.Exporter {
+	case "", ExporterNone:
+		return noop, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("the file trace exporter needs a file")
+		}
+		f, ferr := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if ferr != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", ferr)
+		}
+		closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want %s)", config.Exporter,
+			strings.Join([]string{ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile}, ", "))
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "stratusshell"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if cerr := closer.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+// TraceID returns the ID of the trace ctx belongs to, or "" if it is not
+// being traced
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// RecordError marks span as failed with err, if err is not nil
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
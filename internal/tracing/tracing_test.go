@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterFile}); err == nil {
+		t.Error("expected an error for the file exporter without a file")
+	}
+}
+
+func TestMiddlewareFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %v", err)
+	}
+
+	var handlerTraceID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/terminal/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerTraceID = TraceID(r.Context())
+		_, span := Tracer().Start(r.Context(), "child")
+		span.End()
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	const parent = "0af7651916cd43dd8448eb211c80319c"
+	req := httptest.NewRequest(http.MethodGet, "/api/terminal/3", nil)
+	req.Header.Set("traceparent", "00-"+parent+"-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	Middleware(mux).ServeHTTP(rec, req)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracing: %v", err)
+	}
+	if handlerTraceID != parent {
+		t.Errorf("expected the handler to continue trace %s, got %q", parent, handlerTraceID)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open trace file: %v", err)
+	}
+	defer f.Close()
+
+	spans := map[string]string{} // name -> trace ID
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var span struct {
+			Name        string
+			SpanContext struct{ TraceID string }
+		}
+		if err := dec.Decode(&span); err != nil {
+			t.Fatalf("invalid span JSON: %v", err)
+		}
+		spans[span.Name] = span.SpanContext.TraceID
+	}
+
+	for _, name := range []string{"GET /api/terminal/{id}", "child"} {
+		if id, ok := spans[name]; !ok || id != parent {
+			t.Errorf("expected span %q in trace %s, got %v", name, parent, spans)
+		}
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if id := TraceID(context.Background()); id != "" {
+		t.Errorf("expected no trace ID, got %q", id)
+	}
+}
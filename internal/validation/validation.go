@@ -55,6 +55,28 @@ var (
 	// NPM package name: alphanumeric, dashes, slashes, dots, @-prefix for scoped packages
 	// Examples: "package", "@scope/package", "@scope/package-name"
 	npmPackageRegex = regexp.MustCompile(`^(@[a-z0-9-~][a-z0-9-._~]*/)?[a-z0-9-~][a-z0-9-._~]*$`)
+
+	// Environment variable name: letters, digits, underscores, not starting with a digit
+	envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+	// Environment variables that change how binaries are loaded or how shells
+	// start up. Setting them on a terminal is rejected unless explicitly allowed.
+	dangerousEnvVars = map[string]struct{}{
+		"LD_PRELOAD": {}, "LD_LIBRARY_PATH": {}, "LD_AUDIT": {},
+		"DYLD_INSERT_LIBRARIES": {}, "DYLD_LIBRARY_PATH": {},
+		"BASH_ENV": {}, "ENV": {}, "SHELLOPTS": {}, "BASHOPTS": {},
+		"PROMPT_COMMAND": {}, "PS4": {}, "IFS": {}, "ZDOTDIR": {},
+		"NODE_OPTIONS": {}, "PYTHONSTARTUP": {}, "PERL5OPT": {},
+		"GCONV_PATH": {}, "HOSTALIASES": {},
+	}
+
+	// Prefixes of dynamic loader variables that are always treated as dangerous
+	dangerousEnvPrefixes = []string{"LD_", "DYLD_"}
+
+	// Command limits for terminal startup commands
+	maxCommandArgs   = 64
+	maxCommandArgLen = 4096
+	maxEnvValueLen   = 4096
 )
 
 // ValidationError represents a validation failure
@@ -206,6 +228,109 @@ func ValidateShell(shell string) error {
 	}
 }
 
+// ValidateCommand validates an argv-style terminal startup command.
+// The command is executed directly (never through a shell), so arguments may
+// contain any characters except NUL.
+func ValidateCommand(argv []string) error {
+	if len(argv) == 0 {
+		return nil // Empty is allowed - will use default shell
+	}
+
+	if len(argv) > maxCommandArgs {
+		return &ValidationError{
+			Field:   "command",
+			Message: fmt.Sprintf("command cannot have more than %d arguments", maxCommandArgs),
+		}
+	}
+
+	if strings.TrimSpace(argv[0]) == "" {
+		return &ValidationError{Field: "command", Message: "command name cannot be empty"}
+	}
+
+	for _, arg := range argv {
+		if len(arg) > maxCommandArgLen {
+			return &ValidationError{
+				Field:   "command",
+				Message: fmt.Sprintf("command arguments cannot exceed %d characters", maxCommandArgLen),
+			}
+		}
+		if strings.ContainsRune(arg, 0) {
+			return &ValidationError{Field: "command", Message: "command cannot contain NUL bytes"}
+		}
+	}
+
+	// A program given as a path must be absolute and free of traversal
+	if strings.Contains(argv[0], "/") {
+		if strings.Contains(argv[0], "..") {
+			return &ValidationError{
+				Field:   "command",
+				Message: "command path cannot contain '..'",
+			}
+		}
+		if !filepath.IsAbs(argv[0]) {
+			return &ValidationError{
+				Field:   "command",
+				Message: "command path must be absolute or a bare program name",
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateEnvVar validates an environment variable for a terminal.
+// Names in the dangerous list (e.g. LD_PRELOAD) are rejected unless they
+// appear in allowed.
+func ValidateEnvVar(name, value string, allowed []string) error {
+	if !envNameRegex.MatchString(name) {
+		return &ValidationError{
+			Field:   "env",
+			Message: fmt.Sprintf("invalid environment variable name %q", name),
+		}
+	}
+
+	if len(value) > maxEnvValueLen {
+		return &ValidationError{
+			Field:   "env",
+			Message: fmt.Sprintf("value of %s cannot exceed %d characters", name, maxEnvValueLen),
+		}
+	}
+
+	if strings.ContainsRune(value, 0) {
+		return &ValidationError{
+			Field:   "env",
+			Message: fmt.Sprintf("value of %s cannot contain NUL bytes", name),
+		}
+	}
+
+	if IsDangerousEnvVar(name) {
+		for _, a := range allowed {
+			if a == name {
+				return nil
+			}
+		}
+		return &ValidationError{
+			Field:   "env",
+			Message: fmt.Sprintf("environment variable %s is not allowed", name),
+		}
+	}
+
+	return nil
+}
+
+// IsDangerousEnvVar reports whether name can alter program loading or shell startup
+func IsDangerousEnvVar(name string) bool {
+	if _, ok := dangerousEnvVars[name]; ok {
+		return true
+	}
+	for _, prefix := range dangerousEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidateTerminalID validates terminal ID
 func ValidateTerminalID(id int) error {
 	if id < 0 {
@@ -246,6 +371,22 @@ func ValidateLayoutType(layoutType string) error {
 	}
 }
 
+// ValidateRestartPolicy validates a terminal restart policy
+func ValidateRestartPolicy(policy string) error {
+	validPolicies := []string{"never", "on-failure", "always"}
+
+	for _, valid := range validPolicies {
+		if policy == valid {
+			return nil
+		}
+	}
+
+	return &ValidationError{
+		Field:   "restart_policy",
+		Message: fmt.Sprintf("restart policy must be one of: %s", strings.Join(validPolicies, ", ")),
+	}
+}
+
 // SanitizeString removes potentially dangerous characters from strings
 func SanitizeString(s string) string {
 	// Remove control characters except newline and tab
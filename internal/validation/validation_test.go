@@ -159,3 +159,62 @@ func TestValidateNpmPackage(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		argv    []string
+		wantErr bool
+	}{
+		{name: "empty uses default shell", argv: nil, wantErr: false},
+		{name: "bare program name", argv: []string{"npm", "run", "dev"}, wantErr: false},
+		{name: "absolute path", argv: []string{"/bin/bash", "-l"}, wantErr: false},
+		{name: "shell metacharacters are plain arguments", argv: []string{"echo", "a; rm -rf /"}, wantErr: false},
+		{name: "empty program", argv: []string{"", "x"}, wantErr: true},
+		{name: "relative path", argv: []string{"bin/server"}, wantErr: true},
+		{name: "path traversal", argv: []string{"/usr/../bin/sh"}, wantErr: true},
+		{name: "NUL byte", argv: []string{"echo", "a\x00b"}, wantErr: true},
+		{name: "too many arguments", argv: make([]string, 65), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCommand(tt.argv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCommand(%q) error = %v, wantErr %v", tt.argv, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEnvVar(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		allowed []string
+		wantErr bool
+	}{
+		{name: "plain variable", key: "NODE_ENV", value: "development", wantErr: false},
+		{name: "empty value", key: "DEBUG", value: "", wantErr: false},
+		{name: "lowercase name", key: "http_proxy", value: "http://proxy:3128", wantErr: false},
+		{name: "name starting with digit", key: "1FOO", value: "x", wantErr: true},
+		{name: "name with dash", key: "MY-VAR", value: "x", wantErr: true},
+		{name: "name with equals", key: "A=B", value: "x", wantErr: true},
+		{name: "NUL in value", key: "FOO", value: "a\x00b", wantErr: true},
+		{name: "LD_PRELOAD rejected", key: "LD_PRELOAD", value: "/tmp/evil.so", wantErr: true},
+		{name: "LD_ prefix rejected", key: "LD_BIND_NOW", value: "1", wantErr: true},
+		{name: "BASH_ENV rejected", key: "BASH_ENV", value: "/tmp/x", wantErr: true},
+		{name: "LD_PRELOAD explicitly allowed", key: "LD_PRELOAD", value: "/usr/lib/libfoo.so", allowed: []string{"LD_PRELOAD"}, wantErr: false},
+		{name: "allow list is exact", key: "LD_AUDIT", value: "x", allowed: []string{"LD_PRELOAD"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEnvVar(tt.key, tt.value, tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEnvVar(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+		})
+	}
+}
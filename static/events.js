@@ -0,0 +1,32 @@
+// Live terminal updates from the /api/events Server-Sent Events stream. The
+// element with data-events-url opens the stream; each event is dispatched as
+// sse:<type> on the elements whose hx-trigger names it, so htmx refreshes
+// them, and on the document with the parsed event as its detail.
+(function () {
+	// Event types the UI reacts to; the server sends others too
+	var types = ['spawned', 'killed', 'renamed', 'exited', 'alert', 'notify', 'reap-warning'];
+
+	var root = document.querySelector('[data-events-url]');
+	if (!root || !window.EventSource) {
+		return;
+	}
+
+	// The browser reconnects by itself, after the delay the server asks for
+	var source = new EventSource(root.dataset.eventsUrl);
+	types.forEach(function (type) {
+		source.addEventListener(type, function (message) {
+			var name = 'sse:' + type;
+			document.querySelectorAll('[hx-trigger*="' + name + '"]').forEach(function (el) {
+				htmx.trigger(el, name);
+			});
+
+			var event;
+			try {
+				event = JSON.parse(message.data);
+			} catch (e) {
+				return;
+			}
+			document.dispatchEvent(new CustomEvent(name, { detail: event }));
+		});
+	});
+})();
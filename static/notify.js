@@ -0,0 +1,46 @@
+// Desktop notifications for terminal events.
+//
+// The server sends "notify" events over the /api/events SSE stream when a
+// program requests a notification (OSC 9/777) or a terminal with
+// "notify on completion" enabled finishes, exits or rings the bell.
+// events.js dispatches them on the document, and we turn them into browser
+// notifications here.
+(function () {
+	// The URL prefix the UI is served under behind a reverse proxy, if any
+	var basePathMeta = document.querySelector('meta[name="base-path"]');
+	var basePath = basePathMeta ? basePathMeta.content : '';
+
+	// Called when enabling a terminal's notify toggle; browsers only allow
+	// asking for permission in response to a user action
+	window.stratusRequestNotifications = function () {
+		if ('Notification' in window && Notification.permission === 'default') {
+			Notification.requestPermission();
+		}
+	};
+
+	document.addEventListener('sse:notify', function (evt) {
+		var event = evt.detail;
+		if (!('Notification' in window) || Notification.permission !== 'granted') {
+			return;
+		}
+
+		// Nothing to tell if the user is looking at this terminal right now
+		if (!document.hidden && document.getElementById('terminal-' + event.terminal_id)) {
+			return;
+		}
+
+		var data = event.data || {};
+		var n = new Notification(data.title || event.title || 'StratusShell', {
+			body: data.body || '',
+			tag: 'stratusshell-terminal-' + event.terminal_id,
+		});
+		n.onclick = function () {
+			window.focus();
+			htmx.ajax('POST', basePath + '/api/tabs/switch/' + event.terminal_id, {
+				target: '#active-terminal',
+				swap: 'innerHTML',
+			});
+			n.close();
+		};
+	});
+})();
@@ -0,0 +1,55 @@
+// Behaviour for the UI's markup. It lives here rather than in inline event
+// handlers, which the Content-Security-Policy forbids. Elements opt in with
+// data attributes:
+//
+//   data-close-modal            Clicking it closes the open modal. On the
+//                               modal backdrop, clicks inside the box don't.
+//   data-close-on-success       Closes the modal once its request succeeds
+//   data-stop-propagation       Clicks don't reach the enclosing tab
+//   data-request-notifications  Clicking asks for notification permission
+//   data-swap-errors            4xx responses to its requests are swapped in
+//                               like successful ones, to show their message
+(function () {
+	function closeModal() {
+		document.getElementById('modal').innerHTML = '';
+	}
+
+	document.addEventListener('click', function (evt) {
+		var closer = evt.target.closest('[data-close-modal]');
+		if (!closer) {
+			return;
+		}
+		var box = evt.target.closest('.modal-box');
+		if (box && !box.contains(closer)) {
+			return;
+		}
+		closeModal();
+	});
+
+	document.addEventListener('htmx:beforeSwap', function (evt) {
+		var status = evt.detail.xhr.status;
+		if (status >= 400 && status < 500 && evt.detail.elt.closest('[data-swap-errors]')) {
+			evt.detail.shouldSwap = true;
+			evt.detail.isError = false;
+		}
+	});
+
+	document.addEventListener('htmx:afterRequest', function (evt) {
+		if (evt.detail.successful && evt.detail.elt.matches('[data-close-on-success]')) {
+			closeModal();
+		}
+	});
+
+	// The tab itself switches tabs on click, so its buttons need listeners of
+	// their own to stop the click first
+	htmx.onLoad(function (content) {
+		content.querySelectorAll('[data-stop-propagation]').forEach(function (el) {
+			el.addEventListener('click', function (evt) {
+				evt.stopPropagation();
+				if (el.hasAttribute('data-request-notifications')) {
+					window.stratusRequestNotifications();
+				}
+			});
+		});
+	});
+})();
@@ -0,0 +1,140 @@
+// Passkey registration and sign-in. The server sends WebAuthn options as JSON
+// with binary fields base64url-encoded; we decode them for the browser's
+// WebAuthn API and encode its response the same way. Elements opt in with
+// data attributes:
+//
+//   data-passkey-register  Clicking registers a new passkey, named by the
+//                          #passkey-name input, then reloads the page
+//   data-passkey-login     Clicking signs in with a passkey
+//   data-begin-url         Where the ceremony's options are fetched from
+//   data-finish-url        Where the browser's response is sent
+//   data-passkey-status    Shows progress and errors
+(function () {
+	function decode(value) {
+		var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+		var binary = atob(base64 + '==='.slice((base64.length + 3) % 4));
+		var bytes = new Uint8Array(binary.length);
+		for (var i = 0; i < binary.length; i++) {
+			bytes[i] = binary.charCodeAt(i);
+		}
+		return bytes.buffer;
+	}
+
+	function encode(buffer) {
+		if (!buffer) {
+			return undefined;
+		}
+		var bytes = new Uint8Array(buffer);
+		var binary = '';
+		for (var i = 0; i < bytes.length; i++) {
+			binary += String.fromCharCode(bytes[i]);
+		}
+		return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+	}
+
+	function decodeDescriptors(list) {
+		return (list || []).map(function (c) {
+			return Object.assign({}, c, { id: decode(c.id) });
+		});
+	}
+
+	function showStatus(message, isError) {
+		document.querySelectorAll('[data-passkey-status]').forEach(function (el) {
+			el.className = message ? 'alert text-sm ' + (isError ? 'alert-error' : 'alert-info') : '';
+			el.textContent = message;
+		});
+	}
+
+	function post(url, body) {
+		var headers = { 'Content-Type': 'application/json' };
+		var csrf = document.querySelector('meta[name="csrf-token"]');
+		if (csrf) {
+			headers['X-CSRF-Token'] = csrf.content;
+		}
+		return fetch(url, {
+			method: 'POST',
+			headers: headers,
+			credentials: 'same-origin',
+			body: body ? JSON.stringify(body) : undefined,
+		}).then(function (res) {
+			if (!res.ok) {
+				return res.text().then(function (text) {
+					throw new Error(text.trim() || res.statusText);
+				});
+			}
+			return res.json();
+		});
+	}
+
+	function register(button) {
+		var name = document.getElementById('passkey-name');
+		var finishURL = button.dataset.finishUrl + '?name=' + encodeURIComponent(name ? name.value : '');
+		showStatus('Follow your browser\'s prompts to create the passkey…');
+		return post(button.dataset.beginUrl).then(function (options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = decode(publicKey.challenge);
+			publicKey.user.id = decode(publicKey.user.id);
+			publicKey.excludeCredentials = decodeDescriptors(publicKey.excludeCredentials);
+			return navigator.credentials.create({ publicKey: publicKey });
+		}).then(function (cred) {
+			return post(finishURL, {
+				id: cred.id,
+				rawId: encode(cred.rawId),
+				type: cred.type,
+				authenticatorAttachment: cred.authenticatorAttachment,
+				clientExtensionResults: cred.getClientExtensionResults(),
+				response: {
+					clientDataJSON: encode(cred.response.clientDataJSON),
+					attestationObject: encode(cred.response.attestationObject),
+					transports: cred.response.getTransports ? cred.response.getTransports() : [],
+				},
+			});
+		}).then(function () {
+			window.location.reload();
+		});
+	}
+
+	function login(button) {
+		showStatus('Follow your browser\'s prompts to use your passkey…');
+		return post(button.dataset.beginUrl).then(function (options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = decode(publicKey.challenge);
+			publicKey.allowCredentials = decodeDescriptors(publicKey.allowCredentials);
+			return navigator.credentials.get({ publicKey: publicKey });
+		}).then(function (cred) {
+			return post(button.dataset.finishUrl, {
+				id: cred.id,
+				rawId: encode(cred.rawId),
+				type: cred.type,
+				authenticatorAttachment: cred.authenticatorAttachment,
+				clientExtensionResults: cred.getClientExtensionResults(),
+				response: {
+					clientDataJSON: encode(cred.response.clientDataJSON),
+					authenticatorData: encode(cred.response.authenticatorData),
+					signature: encode(cred.response.signature),
+					userHandle: encode(cred.response.userHandle),
+				},
+			});
+		}).then(function (result) {
+			window.location.href = result.redirect;
+		});
+	}
+
+	document.addEventListener('click', function (evt) {
+		var button = evt.target.closest('[data-passkey-register], [data-passkey-login]');
+		if (!button) {
+			return;
+		}
+		if (!window.PublicKeyCredential) {
+			showStatus('This browser does not support passkeys.', true);
+			return;
+		}
+		button.disabled = true;
+		var ceremony = button.hasAttribute('data-passkey-register') ? register : login;
+		ceremony(button).catch(function (err) {
+			showStatus(err.message || String(err), true);
+		}).finally(function () {
+			button.disabled = false;
+		});
+	});
+})();